@@ -0,0 +1,106 @@
+// Copyright 2020 Oracle and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/acabrele/docker-machine-driver-outscale/driver/outscale"
+	"github.com/docker/machine/libmachine/drivers/plugin"
+	"github.com/docker/machine/libmachine/version"
+)
+
+func main() {
+	if len(os.Args) == 2 {
+		switch os.Args[1] {
+		case "version", "--version", "-v":
+			fmt.Printf("docker-machine-driver-outscale version %s (libmachine API version %d)\n", outscale.Version, version.APIVersion)
+			return
+		case "rancher-schema":
+			schema, err := outscale.NewRancherSchema(outscale.NewDriver("", "").GetCreateFlags()).JSON()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Println(string(schema))
+			return
+		}
+	}
+
+	if len(os.Args) == 3 && os.Args[1] == "terraform-import" {
+		if err := terraformImport(os.Args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) == 3 && os.Args[1] == "provider-state" {
+		if err := providerState(os.Args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "validate-credentials" {
+		if err := outscale.ValidateCredentialsCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	plugin.RegisterDriver(outscale.NewDriver("", ""))
+}
+
+// terraformImport prints the resources of the machine whose config.json is
+// at configPath, along with their terraform import commands
+func terraformImport(configPath string) error {
+	config, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	export, err := outscale.NewTerraformExport(config)
+	if err != nil {
+		return err
+	}
+	out, err := export.JSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// providerState prints the state the API reports for the instance of the
+// machine whose config.json is at configPath
+func providerState(configPath string) error {
+	config, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	report, err := outscale.NewProviderStateReport(config)
+	if err != nil {
+		return err
+	}
+	out, err := report.JSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
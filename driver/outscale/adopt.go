@@ -0,0 +1,110 @@
+package outscale
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+)
+
+var (
+	errorAdoptWithoutSSHKey     = errors.New("--outscale-existing-instance-id requires --outscale-ssh-keypath or --outscale-ssh-agent to connect to the instance")
+	errorAdoptWithLaunchOptions = errors.New("--outscale-existing-instance-id can't be combined with --outscale-create-net, --outscale-create-subnet, --outscale-nic-id or --outscale-zones")
+)
+
+// checkAdoptedInstance looks up the --outscale-existing-instance-id VM and
+// takes its network, security groups and key pair as the machine ones,
+// instead of the checks of a launch
+func (d *Driver) checkAdoptedInstance() error {
+	instances, err := d.describeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(d.AdoptInstanceId)},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to look up instance %s: %s", d.AdoptInstanceId, err)
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("instance %s does not exist", d.AdoptInstanceId)
+	}
+	inst := instances[0]
+
+	switch name := aws.StringValue(inst.State.Name); name {
+	case ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopped:
+	default:
+		return fmt.Errorf("instance %s is %s, it can't be adopted", d.AdoptInstanceId, name)
+	}
+	if name, managed := d.managedMachineName(inst); managed && name != d.resourceName() {
+		return fmt.Errorf("instance %s is already managed as machine %s", d.AdoptInstanceId, name)
+	}
+
+	d.VpcId = aws.StringValue(inst.VpcId)
+	d.SubnetId = aws.StringValue(inst.SubnetId)
+	d.InstanceType = aws.StringValue(inst.InstanceType)
+	d.AMI = aws.StringValue(inst.ImageId)
+	if inst.Placement != nil {
+		d.Zone = aws.StringValue(inst.Placement.AvailabilityZone)
+		if d.Endpoint == "" {
+			d.Zone = strings.TrimPrefix(d.Zone, d.Region)
+		}
+	}
+
+	d.SecurityGroupId = ""
+	d.SecurityGroupIds = nil
+	for _, group := range inst.SecurityGroups {
+		d.SecurityGroupIds = append(d.SecurityGroupIds, aws.StringValue(group.GroupId))
+	}
+
+	if keyName := aws.StringValue(inst.KeyName); keyName != "" {
+		d.KeyName = keyName
+		d.ExistingKey = true
+	}
+
+	log.Infof("Adopting instance %s (%s in subnet %s, security groups %s)", d.AdoptInstanceId, d.InstanceType, d.SubnetId, strings.Join(d.SecurityGroupIds, ", "))
+	return nil
+}
+
+// adoptInstance makes the machine manage the adopted instance, starting it
+// if it is stopped. The external IP it already has is kept on removal.
+func (d *Driver) adoptInstance() (*ec2.Instance, error) {
+	d.InstanceId = d.AdoptInstanceId
+	inst, err := d.getInstance()
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up instance %s to adopt: %s", d.AdoptInstanceId, err)
+	}
+
+	switch instanceState(inst) {
+	case state.Stopped:
+		log.Infof("Starting adopted instance %s", d.InstanceId)
+		err := d.getAPI().StartInstance(d.InstanceId)
+		d.instanceCache.forget()
+		if err != nil {
+			return nil, err
+		}
+	case state.Starting, state.Running:
+	default:
+		return nil, fmt.Errorf("instance %s to adopt is %s", d.InstanceId, aws.StringValue(inst.State.Name))
+	}
+
+	if err := d.findExistingAddress(); err != nil {
+		return nil, fmt.Errorf("Error looking up external IP: %s", err)
+	}
+	d.ExistingPublicIp = d.AllocationId != ""
+	return inst, nil
+}
+
+// managedMachineName returns the Name tag of an instance carrying the
+// cluster tag of the machines, which some machine already manages
+func (d *Driver) managedMachineName(inst *ec2.Instance) (name string, managed bool) {
+	for _, tag := range inst.Tags {
+		switch aws.StringValue(tag.Key) {
+		case "Name":
+			name = aws.StringValue(tag.Value)
+		case d.clusterTagKey():
+			managed = true
+		}
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package outscale
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+const amazonec2Prefix = "amazonec2-"
+
+// amazonec2Aliases maps the amazonec2 driver flags accepted as deprecated
+// aliases of the outscale flags of the same suffix to the environment
+// variables amazonec2 reads them from
+var amazonec2Aliases = map[string]string{
+	"access-key":                 "AWS_ACCESS_KEY_ID",
+	"secret-key":                 "AWS_SECRET_ACCESS_KEY",
+	"session-token":              "AWS_SESSION_TOKEN",
+	"ami":                        "AWS_AMI",
+	"region":                     "AWS_DEFAULT_REGION",
+	"vpc-id":                     "AWS_VPC_ID",
+	"zone":                       "AWS_ZONE",
+	"subnet-id":                  "AWS_SUBNET_ID",
+	"security-group":             "AWS_SECURITY_GROUP",
+	"open-port":                  "",
+	"tags":                       "AWS_TAGS",
+	"instance-type":              "AWS_INSTANCE_TYPE",
+	"device-name":                "AWS_DEVICE_NAME",
+	"root-size":                  "AWS_ROOT_SIZE",
+	"volume-type":                "AWS_VOLUME_TYPE",
+	"iam-instance-profile":       "AWS_INSTANCE_PROFILE",
+	"ssh-user":                   "AWS_SSH_USER",
+	"private-address-only":       "",
+	"use-private-address":        "",
+	"use-ebs-optimized-instance": "",
+	"ssh-keypath":                "AWS_SSH_KEYPATH",
+	"keypair-name":               "AWS_KEYPAIR_NAME",
+	"retries":                    "",
+	"insecure-transport":         "AWS_INSECURE_TRANSPORT",
+	"endpoint":                   "AWS_ENDPOINT",
+	"userdata":                   "AWS_USERDATA",
+}
+
+// amazonec2Alias returns the amazonec2 alias of an outscale flag
+func amazonec2Alias(name string) (string, string, bool) {
+	suffix := strings.TrimPrefix(name, driverName+"-")
+	envVar, ok := amazonec2Aliases[suffix]
+	return amazonec2Prefix + suffix, envVar, ok
+}
+
+// amazonec2AliasFlags declares the amazonec2 aliases of flags, without
+// default so that withAmazonec2Aliases can tell when they are used
+func amazonec2AliasFlags(flags []mcnflag.Flag) []mcnflag.Flag {
+	aliases := []mcnflag.Flag{}
+	for _, flag := range flags {
+		alias, envVar, ok := amazonec2Alias(flag.String())
+		if !ok {
+			continue
+		}
+		usage := "Deprecated, use --" + flag.String()
+		switch flag.(type) {
+		case mcnflag.StringFlag:
+			aliases = append(aliases, mcnflag.StringFlag{Name: alias, Usage: usage, EnvVar: envVar})
+		case mcnflag.IntFlag:
+			aliases = append(aliases, mcnflag.IntFlag{Name: alias, Usage: usage, EnvVar: envVar})
+		case mcnflag.BoolFlag:
+			aliases = append(aliases, mcnflag.BoolFlag{Name: alias, Usage: usage, EnvVar: envVar})
+		case mcnflag.StringSliceFlag:
+			aliases = append(aliases, mcnflag.StringSliceFlag{Name: alias, Usage: usage, EnvVar: envVar})
+		}
+	}
+	return aliases
+}
+
+// aliasedOptions falls back to the amazonec2 alias of the outscale flags left
+// unset or to their default
+type aliasedOptions struct {
+	drivers.DriverOptions
+	defaults map[string]interface{}
+	warned   map[string]bool
+}
+
+// withAmazonec2Aliases wraps the options of the flags so that the amazonec2
+// aliases apply
+func withAmazonec2Aliases(options drivers.DriverOptions, flags []mcnflag.Flag) drivers.DriverOptions {
+	defaults := map[string]interface{}{}
+	for _, flag := range flags {
+		defaults[flag.String()] = flag.Default()
+	}
+	return &aliasedOptions{DriverOptions: options, defaults: defaults, warned: map[string]bool{}}
+}
+
+// alias returns the amazonec2 alias of key, warning the first time it is used
+func (o *aliasedOptions) alias(key string, used func(alias string) bool) (string, bool) {
+	alias, _, ok := amazonec2Alias(key)
+	if !ok || !used(alias) {
+		return "", false
+	}
+	if !o.warned[alias] {
+		log.Warnf("--%s is deprecated, use --%s", alias, key)
+		o.warned[alias] = true
+	}
+	return alias, true
+}
+
+func (o *aliasedOptions) String(key string) string {
+	value := o.DriverOptions.String(key)
+	if value != "" && value != o.defaults[key] {
+		return value
+	}
+	if alias, ok := o.alias(key, func(alias string) bool { return o.DriverOptions.String(alias) != "" }); ok {
+		return o.DriverOptions.String(alias)
+	}
+	return value
+}
+
+func (o *aliasedOptions) StringSlice(key string) []string {
+	value := o.DriverOptions.StringSlice(key)
+	if len(value) > 0 && !reflect.DeepEqual(value, o.defaults[key]) {
+		return value
+	}
+	if alias, ok := o.alias(key, func(alias string) bool { return len(o.DriverOptions.StringSlice(alias)) > 0 }); ok {
+		return o.DriverOptions.StringSlice(alias)
+	}
+	return value
+}
+
+func (o *aliasedOptions) Int(key string) int {
+	value := o.DriverOptions.Int(key)
+	if value != 0 && value != o.defaults[key] {
+		return value
+	}
+	if alias, ok := o.alias(key, func(alias string) bool { return o.DriverOptions.Int(alias) != 0 }); ok {
+		return o.DriverOptions.Int(alias)
+	}
+	return value
+}
+
+func (o *aliasedOptions) Bool(key string) bool {
+	if o.DriverOptions.Bool(key) {
+		return true
+	}
+	if _, ok := o.alias(key, func(alias string) bool { return o.DriverOptions.Bool(alias) }); ok {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package outscale
+
+import (
+	"testing"
+
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmazonec2AliasFlags(t *testing.T) {
+	flags := NewTestDriver().GetCreateFlags()
+
+	var secretKey mcnflag.Flag
+	for _, flag := range flags {
+		if flag.String() == "amazonec2-secret-key" {
+			secretKey = flag
+		}
+	}
+	assert.Equal(t, mcnflag.StringFlag{Name: "amazonec2-secret-key", Usage: "Deprecated, use --outscale-secret-key", EnvVar: "AWS_SECRET_ACCESS_KEY"}, secretKey)
+}
+
+func TestAmazonec2Aliases(t *testing.T) {
+	options := withAmazonec2Aliases(&commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":         []string{ipRange},
+			"outscale-instance-type":        defaultInstanceType,
+			"amazonec2-instance-type":       "t2.medium",
+			"outscale-region":               "eu-west-2",
+			"amazonec2-region":              "us-east-2",
+			"amazonec2-root-size":           50,
+			"amazonec2-use-private-address": true,
+			"amazonec2-security-group":      []string{"web"},
+		},
+	}, NewTestDriver().GetCreateFlags())
+
+	assert.Equal(t, "t2.medium", options.String("outscale-instance-type"))
+	assert.Equal(t, "eu-west-2", options.String("outscale-region"))
+	assert.Equal(t, 50, options.Int("outscale-root-size"))
+	assert.True(t, options.Bool("outscale-use-private-address"))
+	assert.Equal(t, []string{"web"}, options.StringSlice("outscale-security-group"))
+	assert.Equal(t, "", options.String("outscale-vpc-name"))
+}
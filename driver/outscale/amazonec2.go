@@ -11,12 +11,15 @@ import (
 	mrand "math/rand"
 	"net"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	// "github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/docker/machine/drivers/driverutil"
@@ -32,6 +35,7 @@ import (
 const (
 	driverName                  = "outscale"
 	ipRange                     = "0.0.0.0/0"
+	ipv6Range                   = "::/0"
 	machineSecurityGroupName    = "rancher-nodes"
 	machineTag                  = "rancher-nodes"
 	defaultAmiId                = "ami-e90bc65c" //CentOS-8-2021.02.04-0 
@@ -41,7 +45,22 @@ const (
 	defaultVolumeType           = "gp2"
 	defaultZone                 = "us-east-2a"
 	defaultSecurityGroup        = machineSecurityGroupName
+	defaultSecurityGroupDesc    = "Rancher Nodes"
 	defaultSSHUser              = "outscale"
+	defaultAPIRateBurst         = 5
+	defaultSSHConnectTimeout    = 10
+	defaultSSHRetries           = 60
+	sshRetryInterval            = 3 * time.Second
+	expiresAtTag                = "expires-at"
+	defaultNetName              = "default"
+	createdByTag                = "created-by"
+	removedAtTag                = "removed-at"
+	removeModeTerminate         = "terminate"
+	removeModeStop              = "stop"
+	instanceStateQuarantine     = "quarantine"
+	nodeRoleEtcd                = "etcd"
+	nodeRoleControlPlane        = "controlplane"
+	nodeRoleWorker              = "worker"
 	charset                     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
 
@@ -69,18 +88,46 @@ var (
 	errorNoVPCIdFound                    = errors.New("Outscale driver requires the --outscale-vpc-id option")
 	errorNoSubnetsFound                  = errors.New("The desired subnet could not be located in this region. Is '--outscale-subnet-id' or OS_SUBNET_ID configured correctly?")
 	errorReadingUserData                 = errors.New("unable to read --outscale-userdata file")
+	errorDisableSSLWithoutCustomEndpoint = errors.New("using --outscale-insecure-transport also requires --outscale-endpoint")
+	errorCustomEndpointRequired          = errors.New("--outscale-region " + customEndpointRegion + " requires --outscale-endpoint")
+	errorSSHAgentWithKeyPath             = errors.New("--outscale-ssh-agent and --outscale-ssh-keypath are mutually exclusive")
+	errorPublicDNSWithPrivateDNS         = errors.New("--outscale-use-public-dns and --outscale-use-private-dns are mutually exclusive")
+	errorSecondaryPrivateIPCountWithList = errors.New("--outscale-secondary-private-ip-count and --outscale-secondary-private-ip are mutually exclusive")
+	errorCreateSubnetWithSubnet          = errors.New("--outscale-create-subnet can't be combined with --outscale-subnet-id, --outscale-subnet-name, --outscale-subnet-filter, --outscale-subnet-map or --outscale-nic-id")
+	errorZonesWithSubnet                 = errors.New("--outscale-zones can't be combined with --outscale-subnet-id, --outscale-nic-id, --outscale-extra-nic or --outscale-create-subnet, the subnet must follow the zone")
+	errorNicIdWithInterfaceOptions       = errors.New("--outscale-nic-id can't be combined with --outscale-ipv6 or secondary private IPs, configure them on the network interface")
+	errorIPv6AddressWithoutIPv6          = errors.New("using --outscale-use-ipv6-address also requires --outscale-ipv6")
+	errorPruneWithoutSync                = errors.New("--outscale-prune-default-rules requires --outscale-sync-security-group")
+	errorPublicIpWithPrivateOnly         = errors.New("--outscale-private-address-only can't be combined with --outscale-public-ip, --outscale-public-ip-pool, --outscale-force-public-ip or --outscale-release-ip-on-stop")
+	errorPublicIpWithPool                = errors.New("--outscale-public-ip and --outscale-public-ip-pool are mutually exclusive")
+	errorIamInstanceProfileWithOAPI      = errors.New("--outscale-iam-instance-profile is only supported with --outscale-api=" + apiFCU + ", CreateVms doesn't take an instance profile")
+	errorIPv6WithOAPI                    = errors.New("--outscale-ipv6 is only supported with --outscale-api=" + apiFCU + ", CreateVms doesn't request IPv6 addresses")
+	errorMissingAllowedCidr              = errors.New("the generated security group rules without CIDRs of their own require --outscale-allowed-cidr, give it " + ipRange + " to open them to the whole Internet")
+	errorImplicitSecurityGroup           = errors.New("--outscale-explicit-security-group requires --outscale-security-group to name existing groups other than the default nodes group (" + defaultSecurityGroup + " or --outscale-nodes-security-group)")
 )
 
 type Driver struct {
 	*drivers.BaseDriver
 	clientFactory         func() Ec2Client
+	apiFactory            func() OutscaleAPI
+	lbuClientFactory      func() LbuClient
 	awsCredentialsFactory func() awsCredentials
+	instanceCache         instanceCache
+	clientMu              sync.Mutex
+	client                Ec2Client
+	clientKey             string
+	api                   OutscaleAPI
+	apiKey                string
 	Id                    string
 	AccessKey             string
 	SecretKey             string
 	SessionToken          string
 	Region                string
 	AMI                   string
+	AMIName               string
+	AMITags               []string
+	AMIOwner              string
+	AMICacheTTL           string
 	SSHKeyID              int
 	// ExistingKey keeps track of whether the key was created by us or we used an existing one. If an existing one was used, we shouldn't delete it when the machine is deleted.
 	ExistingKey      bool
@@ -88,33 +135,176 @@ type Driver struct {
 	InstanceId       string
 	InstanceType     string
 	PrivateIPAddress string
+	// AdoptInstanceId is an existing VM the machine manages instead of
+	// launching one
+	AdoptInstanceId string
 
 	SecurityGroupId  string
 	SecurityGroupIds []string
-
-	SecurityGroupName  string
-	SecurityGroupNames []string
+	// ExistingSecurityGroupIds are the --outscale-security-group-id groups,
+	// given by ID rather than by name
+	ExistingSecurityGroupIds []string
+
+	SecurityGroupName    string
+	SecurityGroupNames   []string
+	PrimarySecurityGroup string
+	// ExplicitSecurityGroup forbids the implicit rancher-nodes group and the
+	// creation of missing groups
+	ExplicitSecurityGroup bool
+	// ReconcileSecurityGroups adds, when the instance is started or first
+	// reached over SSH, e.g. to be provisioned again, the rules its groups
+	// are missing since creation
+	ReconcileSecurityGroups bool
+	// SyncSecurityGroup revokes the rules the driver wouldn't generate from
+	// the machine own group, and the stale generated ones from rancher-nodes
+	SyncSecurityGroup bool
+	// PruneDefaultRules has the sync revoke the unmarked rules of the older
+	// driver versions opening 22, 2376 and 6443 to the whole Internet
+	PruneDefaultRules bool
+	// SecurityGroupPerMachine replaces rancher-nodes with a group named after
+	// the machine, MachineSecurityGroupId, deleted with it. The machines of
+	// the cluster also join ClusterSecurityGroupId, their peers rules source.
+	SecurityGroupPerMachine bool
+	MachineSecurityGroupId  string
+	ClusterSecurityGroupId  string
+	// DeleteSecurityGroup deletes on removal the groups the driver created
+	// that no other instance uses
+	DeleteSecurityGroup bool
+	// SecurityGroupRules is the preset of the rules generated in the nodes
+	// group
+	SecurityGroupRules string
+	// NodesSecurityGroup and SecurityGroupDescription replace the
+	// rancher-nodes name and the Rancher Nodes description of the nodes group
+	NodesSecurityGroup       string
+	SecurityGroupDescription string
+	// SecurityGroupPropagationTimeout bounds the wait for the authorized
+	// rules to be visible before launching the instance
+	SecurityGroupPropagationTimeout string
+	// SecurityGroupTagKey and SecurityGroupTagValue mark the groups managed
+	// by the driver, the value defaulting to the docker-machine version
+	SecurityGroupTagKey   string
+	SecurityGroupTagValue string
+	// KeyPairCreated and SecurityGroupsConfigured mark the Create steps
+	// already done, an adopted instance bringing its key pair
+	KeyPairCreated           bool
+	SecurityGroupsConfigured bool
 
 	OpenPorts               []string
+	NodeRoles               []string
+	SSHSourceCidrs          []string
+	DockerSourceCidrs       []string
+	AllowedCidrs            []string
 	Tags                    string
 	ReservationId           string
 	DeviceName              string
 	RootSize                int64
 	VolumeType              string
+	VolumeIops              int64
+	ExtraVolumes            []string
+	EphemeralDevices        []string
+	// ExtraVolumeIds are the --outscale-extra-volume volumes, deleted on
+	// removal
+	ExtraVolumeIds          []string
+	// RetainedVolumeIds are the --outscale-extra-volume volumes created with
+	// delete-on-termination=false, kept on removal
+	RetainedVolumeIds       []string
 	IamInstanceProfile      string
 	VpcId                   string
+	VpcName                 string
+	VpcTags                 []string
 	SubnetId                string
+	SubnetMap               string
+	SubnetName              string
+	SubnetFilters           []string
+	SubnetPickFirst         bool
+	CreateNet               bool
+	NetCIDR                 string
+	// CreateDedicatedSubnet creates a subnet for the machine, SubnetCreated
+	// telling it was created so that it is deleted with the machine
+	CreateDedicatedSubnet   bool
+	SubnetCreated           bool
 	Zone                    string
+	Zones                   []string
 	keyPath                 string
 	PrivateIPOnly           bool
+	CheckNatRoute           bool
 	UsePrivateIP            bool
+	UsePublicDNS            bool
+	UsePrivateDNS           bool
+	IPv6                    bool
+	UseIPv6Address          bool
+	SecondaryPrivateIPCount int
+	SecondaryPrivateIPs     []string
+	ExtraNICs               []string
+	NicId                   string
+	DisableSourceDestCheck  bool
 	UseEbsOptimizedInstance bool
 	SSHPrivateKeyPath       string
+	SSHAgent                bool
+	SSHAgentIdentity        string
+	BastionHost             string
+	BastionUser             string
+	BastionKeyPath          string
 	RetryCount              int
+	RetryMaxElapsed         string
+	RetryMaxDelay           string
+	APITimeout              string
+	APILogLevel             string
+	retryStart              time.Time
 	Endpoint                string
+	// API is the Outscale API the instance lifecycle goes through, fcu or
+	// oapi (signed for OAPIEndpoint)
+	API                     string
+	LbuEndpoint             string
+	OAPIEndpoint            string
 	DisableSSL              bool
+	MinTLSVersion           string
+	HTTPProxy               string
+	HTTPSProxy              string
+	CABundle                string
 	UserDataFile            string
+	UserAgentSuffix         string
+	FakeAPI                 bool
+	APIRateLimit            int
+	APIRateBurst            int
+	RegistryCAFile          string
+	RegistryHost            string
+	DockerDaemonConfig      string
+	SSHConnectTimeout       int
+	SSHRetries              int
+	ExpireAfter             string
+	ExpiresAt               string
+	SnapshotOnRemove        bool
+	KeepPublicIp            bool
+	// ReusePublicIp is the external IP or allocation ID given with
+	// --outscale-public-ip. ExistingPublicIp tells the machine uses it, so
+	// that it is not released when the machine is removed.
+	ReusePublicIp           string
+	ExistingPublicIp        bool
+	PublicIpPool            string
+	ForcePublicIp           bool
+	ReleaseIpOnStop         bool
+	RemoveMode              string
+	RemoveSnapshotId        string
+	// LoadBalancers are the LBUs the instance is deregistered from before
+	// removal, waiting up to LoadBalancerDrainTimeout for its connections
+	// to drain. RegisterLoadBalancers, also part of them, are the ones the
+	// driver registers it with
+	LoadBalancers            []string
+	RegisterLoadBalancers    []string
+	LoadBalancerDrainTimeout string
+
+	// DNSRecordName and DNSRecordValue are the A record created through
+	// DNSWebhook, deleted on removal
+	DNSWebhook      string
+	DNSWebhookToken string
+	DNSZone         string
+	DNSName         string
+	DNSRecordName   string
+	DNSRecordValue  string
+
 	bdmList                 []*ec2.BlockDeviceMapping
+	amiArchitecture         string
 	// Metadata Options
 	HttpEndpoint string
 	HttpTokens   string
@@ -123,6 +313,24 @@ type Driver struct {
 	AllocationId  string
 	PublicIp      string
 	AssociationId string
+	// IPCheckedAt is when the stored IP was last checked against the API
+	IPCheckedAt time.Time
+	// ProviderState is the last instance state reported by the API, more
+	// precise than the libmachine state. It is saved with the machine by the
+	// docker-machine commands saving it, the provider-state subcommand of
+	// the driver binary asking the API for the current one.
+	ProviderState       string
+	ProviderStateReason string
+
+	bastion        *bastionTunnel
+	requestedRules map[string]map[string]*ec2.IpPermission
+	// securityGroupsReconciled is set once the groups were configured or
+	// reconciled by the running command
+	securityGroupsReconciled bool
+
+	// LogToFile tees the driver logs into the machine directory
+	LogToFile  bool
+	machineLog io.Writer
 }
 
 type clientFactory interface {
@@ -130,7 +338,7 @@ type clientFactory interface {
 }
 
 func (d *Driver) GetCreateFlags() []mcnflag.Flag {
-	return []mcnflag.Flag{
+	flags := []mcnflag.Flag{
 		mcnflag.StringFlag{
 			Name:   "outscale-access-key",
 			Usage:  "Outscale Access Key",
@@ -152,6 +360,27 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultAmiId,
 			EnvVar: "OS_AMI",
 		},
+		mcnflag.StringFlag{
+			Name:   "outscale-ami-name",
+			Usage:  "Use the newest machine image whose name matches this filter (wildcards allowed) instead of --outscale-ami",
+			EnvVar: "OS_AMI_NAME",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-ami-tag",
+			Usage: "Use the newest machine image carrying this tag (key=value, repeatable) instead of --outscale-ami",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-ami-owner",
+			Usage:  "Owner of the images matched by --outscale-ami-name and --outscale-ami-tag: self, an account ID or an alias like Outscale",
+			Value:  defaultAMIOwner,
+			EnvVar: "OS_AMI_OWNER",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-ami-cache-ttl",
+			Usage:  "How long an image resolved by filter is reused by the machines of the store (0 to disable)",
+			Value:  defaultAMICacheTTL,
+			EnvVar: "OS_AMI_CACHE_TTL",
+		},
 		mcnflag.StringFlag{
 			Name:   "outscale-region",
 			Usage:  "Outscale region",
@@ -163,26 +392,166 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Outscale VPC id",
 			EnvVar: "OS_VPC_ID",
 		},
+		mcnflag.StringFlag{
+			Name:   "outscale-vpc-name",
+			Usage:  "Outscale VPC name (Name tag), alternative to --outscale-vpc-id",
+			EnvVar: "OS_VPC_NAME",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-vpc-tag",
+			Usage: "Outscale VPC tag (key=value, repeatable), alternative to --outscale-vpc-id",
+		},
 		mcnflag.StringFlag{
 			Name:   "outscale-zone",
 			Usage:  "Outscale zone for instance (i.e. a,b,c,d,e)",
 			Value:  defaultZone,
 			EnvVar: "OS_ZONE",
 		},
+		mcnflag.StringFlag{
+			Name:   "outscale-zones",
+			Usage:  "Zones to retry the launch in, in order, when --outscale-zone is out of capacity (e.g. b,c)",
+			EnvVar: "OS_ZONES",
+		},
 		mcnflag.StringFlag{
 			Name:   "outscale-subnet-id",
 			Usage:  "Outscale VPC subnet id",
 			EnvVar: "OS_SUBNET_ID",
 		},
+		mcnflag.StringFlag{
+			Name:   "outscale-subnet-name",
+			Usage:  "Outscale subnet name (Name tag) in the VPC and zone, alternative to --outscale-subnet-id",
+			EnvVar: "OS_SUBNET_NAME",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-subnet-filter",
+			Usage: "Filter the subnet of the VPC and zone must match, as name=value (e.g. tag:Tier=nodes), can be repeated",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-subnet-pick-first",
+			Usage:  "Pick the first subnet when --outscale-subnet-filter matches several",
+			EnvVar: "OS_SUBNET_PICK_FIRST",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-subnet-map",
+			Usage:  "Subnet to use for each zone, picked according to --outscale-zone (e.g. a=subnet-111,b=subnet-222)",
+			EnvVar: "OS_SUBNET_MAP",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-create-net",
+			Usage:  "Create a Net with an Internet service, and a subnet in the zone, when the account has none",
+			EnvVar: "OS_CREATE_NET",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-create-subnet",
+			Usage:  "Create a subnet dedicated to the machine in the free space of the Net, deleted on remove",
+			EnvVar: "OS_CREATE_SUBNET",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-net-cidr",
+			Usage:  "CIDR of the Net created with --outscale-create-net",
+			Value:  defaultNetCIDR,
+			EnvVar: "OS_NET_CIDR",
+		},
 		mcnflag.StringSliceFlag{
 			Name:   "outscale-security-group",
 			Usage:  "Outscale VPC security group",
 			Value:  []string{defaultSecurityGroup},
 			EnvVar: "OS_SECURITY_GROUP",
 		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-security-group-id",
+			Usage: "ID of an existing security group of the Net, replacing the default " + defaultSecurityGroup + " group (repeatable)",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-primary-security-group",
+			Usage:  "Security group listed first on the instance network interface, must be one of --outscale-security-group",
+			EnvVar: "OS_PRIMARY_SECURITY_GROUP",
+		},
+		mcnflag.BoolFlag{
+			Name:  "outscale-explicit-security-group",
+			Usage: "Require --outscale-security-group to name existing groups, never creating the default rancher-nodes group",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-reconcile-security-groups",
+			Usage:  "Add the security group rules missing since creation, e.g. new defaults of a driver upgrade, when the machine is started or provisioned again",
+			EnvVar: "OS_RECONCILE_SECURITY_GROUPS",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-sync-security-group",
+			Usage:  "Reconcile the nodes group to exactly the generated rules with --outscale-reconcile-security-groups, revoking the stale ones: all of them from the group of --outscale-security-group-per-machine, only those the machines configured alike generated from the shared group",
+			EnvVar: "OS_SYNC_SECURITY_GROUP",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-prune-default-rules",
+			Usage:  "With --outscale-sync-security-group, also revoke from the shared group the unmarked rules opening 22, 2376 and 6443/tcp to 0.0.0.0/0, the defaults of the driver versions before --outscale-allowed-cidr, unless still generated",
+			EnvVar: "OS_PRUNE_DEFAULT_RULES",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-security-group-per-machine",
+			Usage:  "Create a security group named after the machine instead of the shared " + defaultSecurityGroup + " group, deleted on removal. The machines of the cluster also join a shared " + defaultSecurityGroup + "-CLUSTER group, the only source of their node to node rules",
+			EnvVar: "OS_SECURITY_GROUP_PER_MACHINE",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-delete-security-group",
+			Usage:  "Delete on removal the security groups created by the driver once no other instance uses them",
+			EnvVar: "OS_DELETE_SECURITY_GROUP",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-security-group-rules",
+			Usage:  "Preset of the generated security group rules: k8s (RKE), rke2, k3s, minimal (SSH and Docker only) or none",
+			Value:  securityGroupRulesK8s,
+			EnvVar: "OS_SECURITY_GROUP_RULES",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-nodes-security-group",
+			Usage:  "Name of the default group getting the node rules, used when --outscale-security-group is not set",
+			Value:  defaultSecurityGroup,
+			EnvVar: "OS_NODES_SECURITY_GROUP",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-security-group-description",
+			Usage:  "Description of the security groups created by the driver",
+			Value:  defaultSecurityGroupDesc,
+			EnvVar: "OS_SECURITY_GROUP_DESCRIPTION",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-security-group-propagation-timeout",
+			Usage:  "How long create waits for the authorized security group rules to be visible before launching the instance (0 to not wait)",
+			Value:  defaultSecurityGroupPropagationTimeout,
+			EnvVar: "OS_SECURITY_GROUP_PROPAGATION_TIMEOUT",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-security-group-tag-key",
+			Usage:  "Tag key marking the security groups managed by the driver",
+			Value:  machineTag,
+			EnvVar: "OS_SECURITY_GROUP_TAG_KEY",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-security-group-tag-value",
+			Usage:  "Tag value marking the security groups managed by the driver, only groups with this value are managed when set (default: docker-machine version)",
+			EnvVar: "OS_SECURITY_GROUP_TAG_VALUE",
+		},
 		mcnflag.StringSliceFlag{
 			Name:  "outscale-open-port",
-			Usage: "Make the specified port number accessible from the Internet",
+			Usage: "Make the specified port number or range accessible from --outscale-allowed-cidr, or from the given CIDRs (e.g. 8443/tcp@10.0.0.0/8,192.168.0.0/16 or 30000-32767/tcp), or ICMP with an optional type and code (e.g. icmp, 8/icmp or 3:4/icmp)",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-node-role",
+			Usage: "Kubernetes role of the node (etcd, controlplane, worker), only the ports of its roles are opened in its --outscale-security-group-per-machine group, the shared " + defaultSecurityGroup + " group opening the ports of all roles (default all)",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-ssh-cidr",
+			Usage:  "Comma-separated CIDRs allowed to reach the SSH port",
+			EnvVar: "OS_SSH_CIDR",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-docker-cidr",
+			Usage:  "Comma-separated CIDRs allowed to reach the Docker port",
+			EnvVar: "OS_DOCKER_CIDR",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-allowed-cidr",
+			Usage: "CIDR allowed to reach the SSH, Docker, kube-api, NodePort and ingress ports, required unless --outscale-explicit-security-group (repeatable, give 0.0.0.0/0 to open them to the whole Internet)",
 		},
 		mcnflag.StringFlag{
 			Name:   "outscale-tags",
@@ -195,6 +564,26 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultInstanceType,
 			EnvVar: "OS_INSTANCE_TYPE",
 		},
+		mcnflag.StringFlag{
+			Name:   "outscale-existing-instance-id",
+			Usage:  "Existing VM to adopt as the machine instead of launching one, its network, security groups and key pair being used; requires --outscale-ssh-keypath or --outscale-ssh-agent",
+			EnvVar: "OS_EXISTING_INSTANCE_ID",
+		},
+		mcnflag.IntFlag{
+			Name:   "outscale-cpu",
+			Usage:  "Number of vCPUs of a tina instance type built with --outscale-ram, overriding --outscale-instance-type",
+			EnvVar: "OS_CPU",
+		},
+		mcnflag.IntFlag{
+			Name:   "outscale-ram",
+			Usage:  "Memory in GiB of a tina instance type built with --outscale-cpu",
+			EnvVar: "OS_RAM",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-performance",
+			Usage:  "Performance of a tina instance type: high (p1), medium (p2) or low (p3)",
+			EnvVar: "OS_PERFORMANCE",
+		},
 		mcnflag.StringFlag{
 			Name:   "outscale-device-name",
 			Usage:  "Outscale root device name",
@@ -212,6 +601,19 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultVolumeType,
 			EnvVar: "OS_VOLUME_TYPE",
 		},
+		mcnflag.IntFlag{
+			Name:   "outscale-volume-iops",
+			Usage:  "Provisioned IOPS of the io1 root volume, and of the io1 extra volumes not setting iops",
+			EnvVar: "OS_VOLUME_IOPS",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-extra-volume",
+			Usage: "Data volume to attach, as device=/dev/xvdb,size=GIB[,type=TYPE][,iops=IOPS][,snapshot=SNAPSHOT][,delete-on-termination=BOOL][,os-device=DEVICE][,fs=ext4|xfs,mount=PATH], formatted and mounted when mount is set and deleted on removal",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-ephemeral-device",
+			Usage: "Instance store device to map, as device=/dev/xvdc,name=ephemeralN[,os-device=DEVICE][,fs=ext4|xfs,mount=PATH]",
+		},
 		mcnflag.StringFlag{
 			Name:   "outscale-iam-instance-profile",
 			Usage:  "Outscale IAM Instance Profile",
@@ -223,14 +625,72 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultSSHUser,
 			EnvVar: "OS_SSH_USER",
 		},
+		mcnflag.IntFlag{
+			Name:   "outscale-ssh-connect-timeout",
+			Usage:  "Timeout in seconds of each attempt to reach SSH on a new instance",
+			Value:  defaultSSHConnectTimeout,
+			EnvVar: "OS_SSH_CONNECT_TIMEOUT",
+		},
+		mcnflag.IntFlag{
+			Name:   "outscale-ssh-retries",
+			Usage:  "Number of attempts to reach SSH on a new instance, 3 seconds apart (use 0 to disable)",
+			Value:  defaultSSHRetries,
+			EnvVar: "OS_SSH_RETRIES",
+		},
 		mcnflag.BoolFlag{
 			Name:  "outscale-private-address-only",
-			Usage: "Only use a private IP address",
+			Usage: "Only use a private IP address, the subnet reaching the Internet through a NAT service",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-check-nat-route",
+			Usage:  "Require a route to a NAT service with --outscale-private-address-only, failing early when the instance couldn't reach the Internet without a proxy",
+			EnvVar: "OS_CHECK_NAT_ROUTE",
 		},
 		mcnflag.BoolFlag{
 			Name:  "outscale-use-private-address",
 			Usage: "Force the usage of private IP address",
 		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-ipv6",
+			Usage:  "Request an IPv6 address on the primary network interface and open the security group rules to IPv6, requires --outscale-api=fcu",
+			EnvVar: "OS_IPV6",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-use-ipv6-address",
+			Usage:  "Force the usage of the IPv6 address, requires --outscale-ipv6",
+			EnvVar: "OS_USE_IPV6_ADDRESS",
+		},
+		mcnflag.IntFlag{
+			Name:   "outscale-secondary-private-ip-count",
+			Usage:  "Number of secondary private IPs to assign to the primary network interface",
+			EnvVar: "OS_SECONDARY_PRIVATE_IP_COUNT",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-secondary-private-ip",
+			Usage: "Secondary private IP to assign to the primary network interface, can be repeated",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-disable-source-dest-check",
+			Usage:  "Disable the source/destination check of the instance, for nodes doing NAT, BGP routing or acting as gateways",
+			EnvVar: "OS_DISABLE_SOURCE_DEST_CHECK",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-nic-id",
+			Usage:  "Existing network interface to launch the instance with as primary interface, keeping its subnet, security groups and IPs",
+			EnvVar: "OS_NIC_ID",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-extra-nic",
+			Usage: "Extra network interface attached in order after the primary one, as subnet=SUBNET-ID[,security-group=SG-ID...][,private-ip=IP], can be repeated",
+		},
+		mcnflag.BoolFlag{
+			Name:  "outscale-use-public-dns",
+			Usage: "Connect over SSH using the public DNS name of the instance instead of its IP",
+		},
+		mcnflag.BoolFlag{
+			Name:  "outscale-use-private-dns",
+			Usage: "Connect over SSH and Docker using the private DNS name of the instance instead of its IP, the name needing a --tls-san for the Docker TLS certificate",
+		},
 		mcnflag.BoolFlag{
 			Name:  "outscale-use-ebs-optimized-instance",
 			Usage: "Create an EBS optimized instance",
@@ -240,6 +700,30 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "SSH Key for Instance",
 			EnvVar: "OS_SSH_KEYPATH",
 		},
+		mcnflag.BoolFlag{
+			Name:  "outscale-ssh-agent",
+			Usage: "Authenticate SSH connections with the running ssh-agent instead of a private key file, which requires the external ssh client (not docker-machine --native-ssh)",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-ssh-agent-identity",
+			Usage:  "Comment or SHA256 fingerprint of the ssh-agent identity to use, required when the agent holds several",
+			EnvVar: "OS_SSH_AGENT_IDENTITY",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-bastion-host",
+			Usage:  "Bastion (jump host) to reach the instance SSH port through, as host or host:port. An ssh-config with a ProxyJump is written to the machine directory. The Docker URL is then tcp://localhost:2376, reached while ssh -F ssh-config -N -L 2376:localhost:2376 <machine> runs",
+			EnvVar: "OS_BASTION_HOST",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-bastion-user",
+			Usage:  "SSH user on the bastion, the instance SSH user if unset",
+			EnvVar: "OS_BASTION_USER",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-bastion-keypath",
+			Usage:  "SSH key for the bastion, the instance key or ssh-agent identity if unset",
+			EnvVar: "OS_BASTION_KEYPATH",
+		},
 		mcnflag.StringFlag{
 			Name:   "outscale-keypair-name",
 			Usage:  "Keypair to use; requires --outscale-ssh-keypath",
@@ -250,18 +734,197 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		 	Usage: "Set retry count for recoverable failures (use -1 to disable)",
 		 	Value: 5,
 		 },
+		mcnflag.StringFlag{
+			Name:   "outscale-retry-max-elapsed",
+			Usage:  "Stop retrying failed API calls once this much time has passed since the operation started (e.g. 10m)",
+			EnvVar: "OS_RETRY_MAX_ELAPSED",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-retry-max-delay",
+			Usage:  "Maximum delay between two retries of a failed API call, retries backing off exponentially up to it",
+			Value:  defaultRetryMaxDelay,
+			EnvVar: "OS_RETRY_MAX_DELAY",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-insecure-transport",
+			Usage:  "Call a custom --outscale-endpoint given without scheme over plain HTTP, for lab API gateways only",
+			EnvVar: "OS_INSECURE_TRANSPORT",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-min-tls-version",
+			Usage:  "Minimum TLS version of the API calls: 1.0, 1.1, 1.2 or 1.3",
+			Value:  defaultMinTLSVersion,
+			EnvVar: "OS_MIN_TLS_VERSION",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-http-proxy",
+			Usage:  "Proxy for the plain HTTP API calls, HTTP_PROXY being used if unset",
+			EnvVar: "OS_HTTP_PROXY",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-https-proxy",
+			Usage:  "Proxy for the HTTPS API calls, HTTPS_PROXY being used if unset",
+			EnvVar: "OS_HTTPS_PROXY",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-ca-bundle",
+			Usage:  "PEM file of additional CAs trusted for the API calls, such as the one of a TLS intercepting proxy",
+			EnvVar: "OS_CA_BUNDLE",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-api-log-level",
+			Usage:  "Logging of the API calls: off, error, debug or debug-with-body (which logs the HTTP bodies)",
+			Value:  apiLogOff,
+			EnvVar: "OS_API_LOG_LEVEL",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-api-timeout",
+			Usage:  "Timeout of each API call, retries included, 0 to wait forever",
+			Value:  defaultAPITimeout,
+			EnvVar: "OS_API_TIMEOUT",
+		},
 		mcnflag.StringFlag{
 			Name:   "outscale-endpoint",
-			Usage:  "Optional endpoint URL (hostname only or fully qualified URI)",
-			Value:  "https://fcu.us-east-2.outscale.com",
+			Usage:  "Optional endpoint URL (hostname only or fully qualified URI), {region} being replaced by --outscale-region (default " + defaultEndpoint + ")",
 			EnvVar: "OS_ENDPOINT",
 		},
+		mcnflag.StringFlag{
+			Name:   "outscale-api",
+			Usage:  "API used for the instance lifecycle: fcu (EC2 compatible) or oapi (native Outscale API)",
+			Value:  apiFCU,
+			EnvVar: "OS_API",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-oapi-endpoint",
+			Usage:  "Optional oAPI endpoint URL for --outscale-api=oapi, {region} being replaced by --outscale-region (default https://api.{region}.outscale.com/api/v1)",
+			EnvVar: "OS_OAPI_ENDPOINT",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-lbu-endpoint",
+			Usage:  "Optional LBU endpoint URL, derived from --outscale-endpoint by default",
+			EnvVar: "OS_LBU_ENDPOINT",
+		},
 		mcnflag.StringFlag{
 			Name:   "outscale-userdata",
 			Usage:  "path to file with cloud-init user data",
 			EnvVar: "OS_USERDATA",
 		},
+		mcnflag.StringFlag{
+			Name:   "outscale-user-agent-suffix",
+			Usage:  "Optional suffix appended to the driver User-Agent sent on API calls",
+			EnvVar: "OS_USER_AGENT_SUFFIX",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-remove-mode",
+			Usage:  "What remove does with the instance: terminate it, or stop it keeping its disks and external IP",
+			Value:  removeModeTerminate,
+			EnvVar: "OS_REMOVE_MODE",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-load-balancer",
+			Usage: "Name of an LBU the instance is added to outside of the driver, only deregistered from on remove (use --outscale-load-balancer-name to register it), can be repeated",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "outscale-load-balancer-name",
+			Usage: "Name of an LBU to register the instance with once running, deregistered from on remove, can be repeated",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-dns-webhook",
+			Usage:  "URL the A record of the machine is upserted and deleted through, with a JSON POST of action, zone, name, type, value and ttl",
+			EnvVar: "OS_DNS_WEBHOOK",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-dns-webhook-token",
+			Usage:  "Bearer token sent to --outscale-dns-webhook",
+			EnvVar: "OS_DNS_WEBHOOK_TOKEN",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-dns-zone",
+			Usage:  "DNS zone the A record of the machine is created in",
+			EnvVar: "OS_DNS_ZONE",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-dns-name",
+			Usage:  "Template of the record name in --outscale-dns-zone, with .MachineName, .Hostname, .Region and .Zone",
+			Value:  defaultDNSName,
+			EnvVar: "OS_DNS_NAME",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-load-balancer-drain-timeout",
+			Usage:  "How long remove waits for the LBU connections to drain before removing the instance (0 to not wait)",
+			Value:  defaultLoadBalancerDrainTimeout,
+			EnvVar: "OS_LOAD_BALANCER_DRAIN_TIMEOUT",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-public-ip",
+			Usage:  "Already allocated external IP or allocation ID to associate with the instance instead of allocating a new one",
+			EnvVar: "OS_PUBLIC_IP",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-public-ip-pool",
+			Usage:  "Name of a pool of tagged external IPs to pick the instance IP from, returning it to the pool on remove",
+			EnvVar: "OS_PUBLIC_IP_POOL",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-force-public-ip",
+			Usage:  "Associate an external IP even if the instance already got a public IP from its subnet",
+			EnvVar: "OS_FORCE_PUBLIC_IP",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-release-ip-on-stop",
+			Usage:  "Release the external IP while the machine is stopped, associating a new one on start",
+			EnvVar: "OS_RELEASE_IP_ON_STOP",
+		},
+		mcnflag.BoolFlag{
+			Name:   "outscale-keep-public-ip",
+			Usage:  "Keep the external IP allocated for the machine on remove instead of releasing it",
+			EnvVar: "OS_KEEP_PUBLIC_IP",
+		},
+		mcnflag.BoolFlag{
+			Name:  "outscale-snapshot-on-remove",
+			Usage: "Snapshot the root volume before terminating the instance on remove",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-expire-after",
+			Usage:  "Tag the instance with an expiry date this long after creation (e.g. 12h, 7d)",
+			EnvVar: "OS_EXPIRE_AFTER",
+		},
+		mcnflag.BoolFlag{
+			Name:  "outscale-log-file",
+			Usage: "Also write the driver logs and API calls of the machine to " + machineLogFile + " in the machine directory, credentials redacted",
+		},
+		mcnflag.BoolFlag{
+			Name:  "outscale-fake-api",
+			Usage: "Use a built-in fake of the Outscale API, keeping its state in the machine store (for development and CI)",
+		},
+		mcnflag.IntFlag{
+			Name:   "outscale-api-rate-limit",
+			Usage:  "Maximum number of API calls per second made by the driver process (0 to disable)",
+			EnvVar: "OS_API_RATE_LIMIT",
+		},
+		mcnflag.IntFlag{
+			Name:   "outscale-api-rate-burst",
+			Usage:  "Number of API calls allowed in a burst above --outscale-api-rate-limit",
+			Value:  defaultAPIRateBurst,
+			EnvVar: "OS_API_RATE_BURST",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-registry-ca-file",
+			Usage:  "Path to a private registry CA certificate to install on the instance",
+			EnvVar: "OS_REGISTRY_CA_FILE",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-registry-host",
+			Usage:  "Registry host[:port] whose Docker certs.d gets the --outscale-registry-ca-file certificate",
+			EnvVar: "OS_REGISTRY_HOST",
+		},
+		mcnflag.StringFlag{
+			Name:   "outscale-docker-daemon-config",
+			Usage:  "JSON daemon.json snippet merged into the instance Docker configuration (e.g. {\"log-driver\":\"journald\"}), an existing daemon.json is only merged when python3 is available; storage-driver has to match --engine-storage-driver",
+			EnvVar: "OS_DOCKER_DAEMON_CONFIG",
+		},
 	}
+	return append(flags, amazonec2AliasFlags(flags)...)
 }
 
 func NewDriver(hostName, storePath string) *Driver {
@@ -274,6 +937,8 @@ func NewDriver(hostName, storePath string) *Driver {
 		RootSize:             defaultRootSize,
 		Zone:                 defaultZone,
 		SecurityGroupNames:   []string{defaultSecurityGroup},
+		SSHConnectTimeout:    defaultSSHConnectTimeout,
+		SSHRetries:           defaultSSHRetries,
 		BaseDriver: &drivers.BaseDriver{
 			SSHUser:     defaultSSHUser,
 			MachineName: hostName,
@@ -282,42 +947,128 @@ func NewDriver(hostName, storePath string) *Driver {
 	}
 
 	driver.clientFactory = driver.buildClient
+	driver.apiFactory = driver.buildAPI
+	driver.lbuClientFactory = driver.buildLbuClient
 	driver.awsCredentialsFactory = driver.buildCredentials
 
 	return driver
 }
 
 func (d *Driver) buildClient() Ec2Client {
+	endpoint := d.Endpoint
+	if d.FakeAPI {
+		endpoint = fakeAPIEndpoint(d.StorePath)
+	}
+	return ec2.New(d.newSession(endpoint))
+}
+
+// newSession configures a session for the API served at endpoint, the SDK
+// default being used if it is empty
+func (d *Driver) newSession(endpoint string) *session.Session {
 	config := aws.NewConfig()
-	alogger := AwsLogger()
+	alogger := d.awsLogger()
 	config = config.WithRegion(d.Region)
 	config = config.WithCredentials(d.awsCredentialsFactory().Credentials())
 	config = config.WithLogger(alogger)
-	config = config.WithLogLevel(aws.LogDebugWithHTTPBody)
-	config = config.WithMaxRetries(d.RetryCount)
-	if d.Endpoint != "" {
-		config = config.WithEndpoint(d.Endpoint)
+	// validated by SetConfigFromFlags
+	logLevel, _ := parseAPILogLevel(d.APILogLevel)
+	config = config.WithLogLevel(logLevel)
+	config = request.WithRetryer(config, d.retryer())
+	// let the retryer veto the retries the SDK handlers already decided
+	config.EnforceShouldRetryCheck = aws.Bool(true)
+	config = config.WithHTTPClient(d.httpClient())
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
 		config = config.WithDisableSSL(d.DisableSSL)
 	}
-	return ec2.New(session.New(config))
+	sess := session.New(config)
+	sess.Handlers.Build.PushBackNamed(request.NamedHandler{
+		Name: "outscale.UserAgentHandler",
+		Fn: func(r *request.Request) {
+			r.HTTPRequest.Header.Set("User-Agent", d.userAgent()+" "+r.HTTPRequest.Header.Get("User-Agent"))
+		},
+	})
+	sess.Handlers.Validate.PushFrontNamed(d.apiTimeoutHandler())
+	sess.Handlers.UnmarshalError.PushFrontNamed(captureRequestIDHandler)
+	apiRateLimiter.configure(d.APIRateLimit, d.APIRateBurst)
+	sess.Handlers.Send.PushFrontNamed(rateLimitHandler)
+	sess.Handlers.UnmarshalError.PushBackNamed(requestIDErrorHandler)
+	sess.Handlers.Complete.PushBackNamed(d.apiSummaryHandler())
+	sess.Handlers.Complete.PushBackNamed(d.forgetInstanceHandler())
+	if d.APILogLevel == apiLogError {
+		sess.Handlers.Complete.PushBackNamed(apiErrorLogHandler(alogger))
+	}
+	if d.retryStart.IsZero() {
+		d.retryStart = time.Now()
+	}
+	return sess
+}
+
+// userAgent identifies the driver and its version to the Outscale API
+func (d *Driver) userAgent() string {
+	ua := userAgentName + "/" + Version
+	if d.UserAgentSuffix != "" {
+		ua += " " + d.UserAgentSuffix
+	}
+	return ua
 }
 
 func (d *Driver) buildCredentials() awsCredentials {
 	return NewAWSCredentials(d.AccessKey, d.SecretKey, d.SessionToken)
 }
 
+// getClient returns the API client, built once per driver and rebuilt only
+// when the settings it depends on change, so that polling the machine state
+// reuses the credentials and connections of the previous calls
 func (d *Driver) getClient() Ec2Client {
-	return d.clientFactory()
+	d.clientMu.Lock()
+	defer d.clientMu.Unlock()
+
+	return d.cachedClient()
+}
+
+// clientCacheKey identifies the settings the cached clients depend on
+func (d *Driver) clientCacheKey() string {
+	return strings.Join([]string{d.Region, d.Endpoint, strconv.FormatBool(d.FakeAPI), d.AccessKey, d.SecretKey, d.SessionToken}, "\x00")
+}
+
+// cachedClient is getClient for the callers holding clientMu
+func (d *Driver) cachedClient() Ec2Client {
+	key := d.clientCacheKey()
+	if d.client == nil || d.clientKey != key {
+		d.client = d.clientFactory()
+		d.clientKey = key
+	}
+	return d.client
 }
 
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
-	d.Endpoint = flags.String("outscale-endpoint")
+	flags = withAmazonec2Aliases(flags, d.GetCreateFlags())
+	var err error
+	d.Endpoint, err = expandEndpoint(flags.String("outscale-endpoint"), flags.String("outscale-region"))
+	if err != nil {
+		return err
+	}
 
 	region, err := validateAwsRegion(flags.String("outscale-region"))
 	if err != nil && d.Endpoint == "" {
 		return err
 	}
 
+	d.DisableSSL = flags.Bool("outscale-insecure-transport")
+	switch {
+	case d.Endpoint == "" && d.DisableSSL:
+		return errorDisableSSLWithoutCustomEndpoint
+	case d.Endpoint == "" && region == customEndpointRegion:
+		return errorCustomEndpointRequired
+	case d.Endpoint == "":
+		d.Endpoint = regionEndpoint(region)
+	default:
+		if other := endpointRegion(d.Endpoint); other != "" && region != "" && other != region {
+			log.Warnf("--outscale-endpoint %s is in region %s, not in --outscale-region %s", d.Endpoint, other, region)
+		}
+	}
+
 	image := flags.String("outscale-ami")
 	if len(image) == 0 {
 		image = regionDetails[region].AmiId
@@ -328,92 +1079,433 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.SessionToken = flags.String("outscale-session-token")
 	d.Region = region
 	d.AMI = image
-	d.InstanceType = flags.String("outscale-instance-type")
+	d.AMIName = flags.String("outscale-ami-name")
+	if image == "" && d.AMIName == "" && regionDetails[region] != nil {
+		d.AMIName = regionDetails[region].AMIName
+	}
+	d.AMITags = flags.StringSlice("outscale-ami-tag")
+	d.AMIOwner = flags.String("outscale-ami-owner")
+	d.AMICacheTTL = flags.String("outscale-ami-cache-ttl")
+	d.InstanceType, err = tinaInstanceType(flags.String("outscale-instance-type"), flags.Int("outscale-cpu"), flags.Int("outscale-ram"), flags.String("outscale-performance"))
+	if err != nil {
+		return err
+	}
 	d.VpcId = flags.String("outscale-vpc-id")
+	d.VpcName = flags.String("outscale-vpc-name")
+	d.VpcTags = flags.StringSlice("outscale-vpc-tag")
 	d.SubnetId = flags.String("outscale-subnet-id")
+	d.SubnetMap = flags.String("outscale-subnet-map")
+	d.SubnetName = flags.String("outscale-subnet-name")
+	d.SubnetFilters = flags.StringSlice("outscale-subnet-filter")
+	d.SubnetPickFirst = flags.Bool("outscale-subnet-pick-first")
+	d.CreateNet = flags.Bool("outscale-create-net")
+	d.NetCIDR = flags.String("outscale-net-cidr")
+	d.CreateDedicatedSubnet = flags.Bool("outscale-create-subnet")
 	d.SecurityGroupNames = flags.StringSlice("outscale-security-group")
+	d.ExistingSecurityGroupIds = flags.StringSlice("outscale-security-group-id")
+	d.PrimarySecurityGroup = flags.String("outscale-primary-security-group")
+	d.ExplicitSecurityGroup = flags.Bool("outscale-explicit-security-group")
+	d.ReconcileSecurityGroups = flags.Bool("outscale-reconcile-security-groups")
+	d.SyncSecurityGroup = flags.Bool("outscale-sync-security-group")
+	d.PruneDefaultRules = flags.Bool("outscale-prune-default-rules")
+	d.SecurityGroupPerMachine = flags.Bool("outscale-security-group-per-machine")
+	d.DeleteSecurityGroup = flags.Bool("outscale-delete-security-group")
+	d.SecurityGroupRules = flags.String("outscale-security-group-rules")
+	d.NodesSecurityGroup = flags.String("outscale-nodes-security-group")
+	d.SecurityGroupDescription = flags.String("outscale-security-group-description")
+	d.SecurityGroupPropagationTimeout = flags.String("outscale-security-group-propagation-timeout")
+	d.SecurityGroupTagKey = flags.String("outscale-security-group-tag-key")
+	d.SecurityGroupTagValue = flags.String("outscale-security-group-tag-value")
 	d.Tags = flags.String("outscale-tags")
 	zone := flags.String("outscale-zone")
 	d.Zone = zone[:]
+	d.Zones = parseZones(flags.String("outscale-zones"))
 	d.DeviceName = flags.String("outscale-device-name")
 	d.RootSize = int64(flags.Int("outscale-root-size"))
 	d.VolumeType = flags.String("outscale-volume-type")
+	d.VolumeIops = int64(flags.Int("outscale-volume-iops"))
+	d.ExtraVolumes = flags.StringSlice("outscale-extra-volume")
+	d.EphemeralDevices = flags.StringSlice("outscale-ephemeral-device")
 	d.IamInstanceProfile = flags.String("outscale-iam-instance-profile")
 	d.SSHUser = flags.String("outscale-ssh-user")
 	d.SSHPort = 22
+	d.SSHConnectTimeout = flags.Int("outscale-ssh-connect-timeout")
+	d.SSHRetries = flags.Int("outscale-ssh-retries")
 	d.PrivateIPOnly = flags.Bool("outscale-private-address-only")
+	d.CheckNatRoute = flags.Bool("outscale-check-nat-route")
 	d.UsePrivateIP = flags.Bool("outscale-use-private-address")
+	d.UsePublicDNS = flags.Bool("outscale-use-public-dns")
+	d.UsePrivateDNS = flags.Bool("outscale-use-private-dns")
+	d.IPv6 = flags.Bool("outscale-ipv6")
+	d.UseIPv6Address = flags.Bool("outscale-use-ipv6-address")
+	d.SecondaryPrivateIPCount = flags.Int("outscale-secondary-private-ip-count")
+	d.SecondaryPrivateIPs = flags.StringSlice("outscale-secondary-private-ip")
+	d.ExtraNICs = flags.StringSlice("outscale-extra-nic")
+	d.NicId = flags.String("outscale-nic-id")
+	d.DisableSourceDestCheck = flags.Bool("outscale-disable-source-dest-check")
 	d.UseEbsOptimizedInstance = flags.Bool("outscale-use-ebs-optimized-instance")
 	d.SSHPrivateKeyPath = flags.String("outscale-ssh-keypath")
+	d.SSHAgent = flags.Bool("outscale-ssh-agent")
+	d.SSHAgentIdentity = flags.String("outscale-ssh-agent-identity")
+	d.BastionHost = flags.String("outscale-bastion-host")
+	d.BastionUser = flags.String("outscale-bastion-user")
+	d.BastionKeyPath = flags.String("outscale-bastion-keypath")
 	d.KeyName = flags.String("outscale-keypair-name")
 	d.ExistingKey = flags.String("outscale-keypair-name") != ""
 	d.SetSwarmConfigFromFlags(flags)
 	d.RetryCount = flags.Int("outscale-retries")
+	d.RetryMaxElapsed = flags.String("outscale-retry-max-elapsed")
+	d.RetryMaxDelay = flags.String("outscale-retry-max-delay")
+	d.APITimeout = flags.String("outscale-api-timeout")
+	d.APILogLevel = flags.String("outscale-api-log-level")
+	d.HTTPProxy = flags.String("outscale-http-proxy")
+	d.HTTPSProxy = flags.String("outscale-https-proxy")
+	d.CABundle = flags.String("outscale-ca-bundle")
 	d.OpenPorts = flags.StringSlice("outscale-open-port")
+	d.NodeRoles = flags.StringSlice("outscale-node-role")
 	d.UserDataFile = flags.String("outscale-userdata")
-	d.DisableSSL = false
+	d.AdoptInstanceId = flags.String("outscale-existing-instance-id")
+	d.UserAgentSuffix = flags.String("outscale-user-agent-suffix")
+	d.FakeAPI = flags.Bool("outscale-fake-api")
+	d.API = flags.String("outscale-api")
+	d.LogToFile = flags.Bool("outscale-log-file")
+	d.APIRateLimit = flags.Int("outscale-api-rate-limit")
+	d.APIRateBurst = flags.Int("outscale-api-rate-burst")
+	d.RegistryCAFile = flags.String("outscale-registry-ca-file")
+	d.RegistryHost = flags.String("outscale-registry-host")
+	d.DockerDaemonConfig = flags.String("outscale-docker-daemon-config")
+	d.SnapshotOnRemove = flags.Bool("outscale-snapshot-on-remove")
+	d.KeepPublicIp = flags.Bool("outscale-keep-public-ip")
+	d.ReusePublicIp = flags.String("outscale-public-ip")
+	d.PublicIpPool = flags.String("outscale-public-ip-pool")
+	d.ForcePublicIp = flags.Bool("outscale-force-public-ip")
+	d.ReleaseIpOnStop = flags.Bool("outscale-release-ip-on-stop")
+	d.RemoveMode = flags.String("outscale-remove-mode")
+	d.LoadBalancers = flags.StringSlice("outscale-load-balancer")
+	d.RegisterLoadBalancers = flags.StringSlice("outscale-load-balancer-name")
+	d.DNSWebhook = flags.String("outscale-dns-webhook")
+	d.DNSWebhookToken = flags.String("outscale-dns-webhook-token")
+	d.DNSZone = flags.String("outscale-dns-zone")
+	d.DNSName = flags.String("outscale-dns-name")
+	for _, name := range d.RegisterLoadBalancers {
+		d.LoadBalancers = appendUnique(d.LoadBalancers, name)
+	}
+	d.LoadBalancerDrainTimeout = flags.String("outscale-load-balancer-drain-timeout")
+	d.ExpireAfter = flags.String("outscale-expire-after")
+	d.MinTLSVersion = flags.String("outscale-min-tls-version")
+
+	if d.SSHAgent && d.SSHPrivateKeyPath != "" {
+		return errorSSHAgentWithKeyPath
+	}
+
+	if err := d.checkSSHAgentClient(); err != nil {
+		return err
+	}
 
-	if d.KeyName != "" && d.SSHPrivateKeyPath == "" {
-	 	return errorNoPrivateSSHKey
+	if d.UsePublicDNS && d.UsePrivateDNS {
+		return errorPublicDNSWithPrivateDNS
 	}
 
-	_, err = d.awsCredentialsFactory().Credentials().Get()
-	if err != nil {
-		return errorMissingCredentials
+	if d.BastionHost == "" && (d.BastionUser != "" || d.BastionKeyPath != "") {
+		return errorBastionOptionsWithoutHost
 	}
 
-	if d.VpcId == "" {
-		d.VpcId, err = d.getDefaultVPCId()
-		if err != nil {
-			log.Warnf("Couldn't determine your account Default VPC ID : %q", err)
-		}
+	if d.BastionHost != "" && d.FakeAPI {
+		return errorBastionWithFakeAPI
 	}
 
-	if d.SubnetId == "" && d.VpcId == "" {
-		return errorNoVPCIdFound
+	if d.UseIPv6Address && !d.IPv6 {
+		return errorIPv6AddressWithoutIPv6
 	}
 
-	if d.SubnetId != "" && d.VpcId != "" {
-		subnetFilter := []*ec2.Filter{
-			{
-				Name:   aws.String("subnet-id"),
-				Values: []*string{&d.SubnetId},
-			},
-		}
+	if d.PruneDefaultRules && !d.SyncSecurityGroup {
+		return errorPruneWithoutSync
+	}
 
-		subnets, err := d.getClient().DescribeSubnets(&ec2.DescribeSubnetsInput{
-			Filters: subnetFilter,
-		})
-		if err != nil {
+	if err := validateSecondaryPrivateIPs(d.SecondaryPrivateIPCount, d.SecondaryPrivateIPs); err != nil {
+		return err
+	}
+
+	for _, value := range d.ExtraNICs {
+		if _, err := parseExtraNIC(value); err != nil {
 			return err
 		}
+	}
 
-		if subnets == nil || len(subnets.Subnets) == 0 {
-			return errorNoSubnetsFound
-		}
+	if _, err := parseSubnetFilters(d.SubnetFilters); err != nil {
+		return err
+	}
 
-		if *subnets.Subnets[0].VpcId != d.VpcId {
-			return fmt.Errorf("SubnetId: %s does not belong to VpcId: %s", d.SubnetId, d.VpcId)
-		}
+	if len(d.Zones) > 0 && (d.SubnetId != "" || d.NicId != "" || len(d.ExtraNICs) > 0 || d.CreateDedicatedSubnet) {
+		return errorZonesWithSubnet
 	}
 
-	if d.isSwarmMaster() {
-		u, err := url.Parse(d.SwarmHost)
-		if err != nil {
-			return fmt.Errorf("error parsing swarm host: %s", err)
-		}
+	if d.CreateDedicatedSubnet && (d.SubnetId != "" || d.SubnetName != "" || d.SubnetMap != "" || len(d.SubnetFilters) > 0 || d.NicId != "") {
+		return errorCreateSubnetWithSubnet
+	}
 
-		parts := strings.Split(u.Host, ":")
-		port, err := strconv.Atoi(parts[1])
-		if err != nil {
-			return err
-		}
+	if d.NicId != "" && (d.IPv6 || d.SecondaryPrivateIPCount > 0 || len(d.SecondaryPrivateIPs) > 0) {
+		return errorNicIdWithInterfaceOptions
+	}
 
-		swarmPort = port
+	if d.AdoptInstanceId != "" && d.SSHPrivateKeyPath == "" && !d.SSHAgent {
+		return errorAdoptWithoutSSHKey
 	}
 
-	return nil
-}
+	if d.AdoptInstanceId != "" && (d.CreateNet || d.CreateDedicatedSubnet || d.NicId != "" || len(d.Zones) > 0) {
+		return errorAdoptWithLaunchOptions
+	}
+
+	if d.KeyName != "" && d.SSHPrivateKeyPath == "" && !d.SSHAgent {
+	 	return errorNoPrivateSSHKey
+	}
+
+	if err := validateRegistryHost(d.RegistryHost); err != nil {
+		return err
+	}
+
+	if err := validateDockerDaemonConfig(d.DockerDaemonConfig, flags.String("engine-storage-driver")); err != nil {
+		return err
+	}
+
+	if d.SSHSourceCidrs, err = parseCIDRList(flags.String("outscale-ssh-cidr")); err != nil {
+		return err
+	}
+
+	if d.DockerSourceCidrs, err = parseCIDRList(flags.String("outscale-docker-cidr")); err != nil {
+		return err
+	}
+
+	d.AllowedCidrs = nil
+	for _, value := range flags.StringSlice("outscale-allowed-cidr") {
+		cidrs, err := parseCIDRList(value)
+		if err != nil {
+			return fmt.Errorf("invalid --outscale-allowed-cidr %q: %s", value, err)
+		}
+		d.AllowedCidrs = append(d.AllowedCidrs, cidrs...)
+	}
+	for _, p := range d.OpenPorts {
+		if _, err := parseOpenPort(p); err != nil {
+			return err
+		}
+	}
+
+	if _, err := parseExpireAfter(d.ExpireAfter); err != nil {
+		return err
+	}
+
+	if _, err := parseRetryMaxElapsed(d.RetryMaxElapsed); err != nil {
+		return err
+	}
+
+	if _, err := parseRetryMaxDelay(d.RetryMaxDelay); err != nil {
+		return err
+	}
+
+	if _, err := parseAPITimeout(d.APITimeout); err != nil {
+		return err
+	}
+
+	if _, err := parseAPILogLevel(d.APILogLevel); err != nil {
+		return err
+	}
+
+	if err := d.validateTransport(); err != nil {
+		return err
+	}
+
+	if err := d.checkDNSOptions(); err != nil {
+		return err
+	}
+
+	if _, err := parseDrainTimeout(d.LoadBalancerDrainTimeout); err != nil {
+		return err
+	}
+
+	if _, err := parsePropagationTimeout(d.SecurityGroupPropagationTimeout); err != nil {
+		return err
+	}
+
+	if _, err := d.extraVolumes(); err != nil {
+		return err
+	}
+
+	if err := d.validateVolumeIops(); err != nil {
+		return err
+	}
+
+	if err := validateNodeRoles(d.NodeRoles); err != nil {
+		return err
+	}
+
+	switch d.API {
+	case "", apiFCU:
+	case apiOAPI:
+		if d.FakeAPI {
+			return errors.New("--outscale-fake-api only emulates --outscale-api=" + apiFCU)
+		}
+		if d.IamInstanceProfile != "" {
+			return errorIamInstanceProfileWithOAPI
+		}
+		if d.IPv6 {
+			return errorIPv6WithOAPI
+		}
+	default:
+		return fmt.Errorf("invalid --outscale-api %q, expected %s or %s", d.API, apiFCU, apiOAPI)
+	}
+
+	if d.LbuEndpoint, err = expandEndpoint(flags.String("outscale-lbu-endpoint"), flags.String("outscale-region")); err != nil {
+		return err
+	}
+
+	if d.OAPIEndpoint, err = expandEndpoint(flags.String("outscale-oapi-endpoint"), flags.String("outscale-region")); err != nil {
+		return err
+	}
+
+	switch d.RemoveMode {
+	case "", removeModeTerminate, removeModeStop:
+	default:
+		return fmt.Errorf("invalid --outscale-remove-mode %q, expected %s or %s", d.RemoveMode, removeModeTerminate, removeModeStop)
+	}
+
+	if d.ReusePublicIp != "" && !strings.HasPrefix(d.ReusePublicIp, "eipalloc-") && net.ParseIP(d.ReusePublicIp) == nil {
+		return fmt.Errorf("invalid --outscale-public-ip %q, expected an IP address or an allocation ID", d.ReusePublicIp)
+	}
+
+	if d.ReusePublicIp != "" && d.PublicIpPool != "" {
+		return errorPublicIpWithPool
+	}
+
+	if d.PrivateIPOnly && (d.ReusePublicIp != "" || d.PublicIpPool != "" || d.ForcePublicIp || d.ReleaseIpOnStop) {
+		return errorPublicIpWithPrivateOnly
+	}
+
+	if _, err := parseAMICacheTTL(d.AMICacheTTL); err != nil {
+		return err
+	}
+
+	if err := validateAMITags(d.AMITags); err != nil {
+		return err
+	}
+
+	for _, id := range d.ExistingSecurityGroupIds {
+		if !strings.HasPrefix(id, "sg-") {
+			return fmt.Errorf("invalid --outscale-security-group-id %q, expected a security group ID like sg-12345678", id)
+		}
+	}
+	// --outscale-security-group defaults to rancher-nodes, which stands for
+	// the nodes group
+	if len(d.SecurityGroupNames) == 1 && d.SecurityGroupNames[0] == defaultSecurityGroup {
+		d.SecurityGroupNames = []string{d.nodesSecurityGroupName()}
+	}
+	// the IDs replace the default group, not the ones given by name
+	if len(d.ExistingSecurityGroupIds) > 0 && len(d.SecurityGroupNames) == 1 && d.SecurityGroupNames[0] == d.nodesSecurityGroupName() {
+		d.SecurityGroupNames = nil
+	}
+
+	if err := validateSecurityGroupRules(d.SecurityGroupRules); err != nil {
+		return err
+	}
+
+	if len(d.AllowedCidrs) == 0 && !d.ExplicitSecurityGroup && d.fallsBackToAllowedCidrs() {
+		return errorMissingAllowedCidr
+	}
+
+	if d.ExplicitSecurityGroup && d.SecurityGroupPerMachine {
+		return errorSecurityGroupPerMachineWithExplicit
+	}
+
+	if d.ExplicitSecurityGroup && ((len(d.SecurityGroupNames) == 0 && len(d.ExistingSecurityGroupIds) == 0) || stringInSlice(d.nodesSecurityGroupName(), d.SecurityGroupNames)) {
+		return errorImplicitSecurityGroup
+	}
+
+	if d.PrimarySecurityGroup != "" && !stringInSlice(d.PrimarySecurityGroup, d.SecurityGroupNames) {
+		return fmt.Errorf("--outscale-primary-security-group %s is not one of the --outscale-security-group values", d.PrimarySecurityGroup)
+	}
+
+	if d.FakeAPI && d.AccessKey == "" {
+		d.AccessKey = "fake-access-key"
+		d.SecretKey = "fake-secret-key"
+	}
+
+	_, err = d.awsCredentialsFactory().Credentials().Get()
+	if err != nil {
+		return errorMissingCredentials
+	}
+
+	if d.SubnetId == "" && d.SubnetMap != "" {
+		if d.SubnetId, err = d.subnetForZone(d.Zone); err != nil {
+			return err
+		}
+	}
+
+	if d.VpcId == "" && (d.VpcName != "" || len(d.VpcTags) > 0) {
+		if d.VpcId, err = d.findVpc(); err != nil {
+			return err
+		}
+	}
+
+	if d.VpcId == "" {
+		d.VpcId, err = d.getDefaultVPCId()
+		if err != nil {
+			log.Debugf("Couldn't determine your account Default VPC ID from its attributes : %q", err)
+			if d.VpcId, err = d.findDefaultNet(); err != nil {
+				log.Warnf("Couldn't determine your account Default VPC ID : %q", err)
+			}
+		}
+	}
+
+	if d.CreateNet {
+		if _, err := parseNetCIDR(d.NetCIDR); err != nil {
+			return err
+		}
+	}
+
+	if d.SubnetId == "" && d.VpcId == "" && !d.CreateNet {
+		return errorNoVPCIdFound
+	}
+
+	if d.SubnetId != "" && d.VpcId != "" {
+		subnetFilter := []*ec2.Filter{
+			{
+				Name:   aws.String("subnet-id"),
+				Values: []*string{&d.SubnetId},
+			},
+		}
+
+		subnets, err := d.describeSubnets(&ec2.DescribeSubnetsInput{
+			Filters: subnetFilter,
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(subnets) == 0 {
+			return errorNoSubnetsFound
+		}
+
+		if *subnets[0].VpcId != d.VpcId {
+			return fmt.Errorf("SubnetId: %s does not belong to VpcId: %s", d.SubnetId, d.VpcId)
+		}
+	}
+
+	if d.isSwarmMaster() {
+		u, err := url.Parse(d.SwarmHost)
+		if err != nil {
+			return fmt.Errorf("error parsing swarm host: %s", err)
+		}
+
+		parts := strings.Split(u.Host, ":")
+		port, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return err
+		}
+
+		swarmPort = port
+	}
+
+	return nil
+}
 
 // DriverName returns the name of the driver
 func (d *Driver) DriverName() string {
@@ -434,22 +1526,58 @@ func (d *Driver) checkSubnet() error {
 			},
 		}
 
-		subnets, err := d.getClient().DescribeSubnets(&ec2.DescribeSubnetsInput{
+		if d.SubnetName != "" {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String("tag:Name"),
+				Values: []*string{aws.String(d.SubnetName)},
+			})
+		}
+		subnetFilters, _ := parseSubnetFilters(d.SubnetFilters) // validated by SetConfigFromFlags
+		filters = append(filters, subnetFilters...)
+
+		subnets, err := d.describeSubnets(&ec2.DescribeSubnetsInput{
 			Filters: filters,
 		})
 		if err != nil {
 			return err
 		}
 
-		if len(subnets.Subnets) == 0 {
+		if len(subnets) == 0 {
+			if d.SubnetName != "" {
+				return fmt.Errorf("unable to find a subnet named %s in the zone: %s", d.SubnetName, regionZone)
+			}
+			if len(d.SubnetFilters) > 0 {
+				return fmt.Errorf("no subnet matches --outscale-subnet-filter %s in the zone: %s", strings.Join(d.SubnetFilters, " "), regionZone)
+			}
+			if d.CreateNet {
+				subnetId, err := d.createSubnet(regionZone)
+				if err != nil {
+					return err
+				}
+				d.SubnetId = subnetId
+				d.tagNetResource(d.SubnetId, defaultNetName+"-"+regionZone)
+				return nil
+			}
 			return fmt.Errorf("unable to find a subnet in the zone: %s", regionZone)
 		}
 
-		d.SubnetId = *subnets.Subnets[0].SubnetId
+		if d.SubnetName != "" && len(subnets) > 1 {
+			return fmt.Errorf("several subnets named %s in the zone: %s", d.SubnetName, regionZone)
+		}
+
+		if len(d.SubnetFilters) > 0 && len(subnets) > 1 && !d.SubnetPickFirst {
+			ids := make([]string, 0, len(subnets))
+			for _, subnet := range subnets {
+				ids = append(ids, *subnet.SubnetId)
+			}
+			return fmt.Errorf("several subnets match --outscale-subnet-filter %s in the zone %s: %s, narrow the filter or set --outscale-subnet-pick-first", strings.Join(d.SubnetFilters, " "), regionZone, strings.Join(ids, ", "))
+		}
+
+		d.SubnetId = *subnets[0].SubnetId
 
 		// try to find default
-		if len(subnets.Subnets) > 1 {
-			for _, subnet := range subnets.Subnets {
+		if len(subnets) > 1 {
+			for _, subnet := range subnets {
 				if subnet.DefaultForAz != nil && *subnet.DefaultForAz {
 					d.SubnetId = *subnet.SubnetId
 					break
@@ -461,6 +1589,38 @@ func (d *Driver) checkSubnet() error {
 	return nil
 }
 
+// parseSubnetMap parses zone=subnet pairs separated by commas
+func parseSubnetMap(value string) (map[string]string, error) {
+	subnets := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --outscale-subnet-map entry %q, expected zone=subnet-id", pair)
+		}
+		subnets[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return subnets, nil
+}
+
+// subnetForZone picks the --outscale-subnet-map subnet of a zone, the map
+// keys being either full zone names or zone letters.
+func (d *Driver) subnetForZone(zone string) (string, error) {
+	subnets, err := parseSubnetMap(d.SubnetMap)
+	if err != nil {
+		return "", err
+	}
+	for key, subnet := range subnets {
+		if zone == key || zone == d.Region+key {
+			return subnet, nil
+		}
+	}
+	return "", fmt.Errorf("no subnet for zone %s in --outscale-subnet-map", zone)
+}
+
 func (d *Driver) checkAMI() error {
 	// Check if image exists
 	images, err := d.getClient().DescribeImages(&ec2.DescribeImagesInput{
@@ -480,12 +1640,38 @@ func (d *Driver) checkAMI() error {
 
 	//store bdm list && update size and encryption settings
 	d.bdmList = images.Images[0].BlockDeviceMappings
+	d.amiArchitecture = aws.StringValue(images.Images[0].Architecture)
 
 	return nil
 }
 
 func (d *Driver) PreCreateCheck() error {
-	if err := d.checkSubnet(); err != nil {
+	d.logPhase("pre-create check")
+
+	if d.AdoptInstanceId != "" {
+		return d.checkAdoptedInstance()
+	}
+
+	if err := d.createNet(); err != nil {
+		return err
+	}
+
+	if err := d.checkNetworkInterface(); err != nil {
+		return err
+	}
+
+	// the dedicated subnet is created along with the instance
+	if !d.CreateDedicatedSubnet {
+		if err := d.checkSubnet(); err != nil {
+			return err
+		}
+	}
+
+	if err := d.checkNatRoute(); err != nil {
+		return err
+	}
+
+	if err := d.resolveAMI(); err != nil {
 		return err
 	}
 
@@ -493,20 +1679,50 @@ func (d *Driver) PreCreateCheck() error {
 		return err
 	}
 
-	return nil
+	if err := d.checkInstanceType(); err != nil {
+		return err
+	}
+
+	d.checkEbsOptimized()
+
+	if d.ReusePublicIp != "" {
+		if _, err := d.findReusedAddress(); err != nil {
+			return err
+		}
+	}
+
+	return d.checkQuotas()
+}
+
+// checkEbsOptimized turns off the EBS optimization the instance type does
+// not support, since the launch would fail otherwise
+func (d *Driver) checkEbsOptimized() {
+	if !d.UseEbsOptimizedInstance {
+		return
+	}
+
+	info, ok := lookupInstanceType(d.InstanceType)
+	if !ok {
+		log.Debugf("instance type %s is not in the catalog, assuming it supports EBS optimization", d.InstanceType)
+		return
+	}
+	if !info.EbsOptimized {
+		log.Warnf("instance type %s does not support EBS optimization, launching it without", d.InstanceType)
+		d.UseEbsOptimizedInstance = false
+	}
 }
 
-func (d *Driver) instanceIpAvailable() bool {
+func (d *Driver) instanceIpAvailable() (bool, error) {
 	ip, err := d.GetIP()
 	if err != nil {
-		log.Debug(err)
+		return false, err
 	}
 	if ip != "" {
 		d.IPAddress = ip
 		log.Debugf("Got the IP Address, it's %q", d.IPAddress)
-		return true
+		return true, nil
 	}
-	return false
+	return false, nil
 }
 
 func makePointerSlice(stackSlice []string) []*string {
@@ -527,7 +1743,46 @@ func migrateStringToSlice(value string, values []string) (result []string) {
 }
 
 func (d *Driver) securityGroupNames() (ids []string) {
-	return migrateStringToSlice(d.SecurityGroupName, d.SecurityGroupNames)
+	names := migrateStringToSlice(d.SecurityGroupName, d.SecurityGroupNames)
+	if d.SecurityGroupPerMachine {
+		// the machine group takes the place of rancher-nodes
+		machineGroup := d.machineSecurityGroupName()
+		replaced := false
+		for i, name := range names {
+			if name == d.nodesSecurityGroupName() {
+				names[i] = machineGroup
+				replaced = true
+			}
+		}
+		if !replaced {
+			names = append(names, machineGroup)
+		}
+		// the cluster group comes first, the peers rules of the machine
+		// group refer to it
+		names = append([]string{d.clusterSecurityGroupName()}, names...)
+	}
+	return orderSecurityGroups(names, d.PrimarySecurityGroup)
+}
+
+// orderSecurityGroups removes duplicates, keeping the order the groups were
+// given in, and moves the primary group first.
+func orderSecurityGroups(names []string, primary string) (result []string) {
+	if primary != "" {
+		result = append(result, primary)
+	}
+	for _, name := range names {
+		result = appendUnique(result, name)
+	}
+	return
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
 }
 
 func (d *Driver) securityGroupIds() (ids []string) {
@@ -535,13 +1790,30 @@ func (d *Driver) securityGroupIds() (ids []string) {
 }
 
 func (d *Driver) Base64UserData() (userdata string, err error) {
+	var buf []byte
 	if d.UserDataFile != "" {
-		buf, ioerr := ioutil.ReadFile(d.UserDataFile)
+		var ioerr error
+		buf, ioerr = ioutil.ReadFile(d.UserDataFile)
 		if ioerr != nil {
 			log.Warnf("failed to read user data file %q: %s", d.UserDataFile, ioerr)
 			err = errorReadingUserData
 			return
 		}
+	}
+
+	generated, err := d.generateCloudInit()
+	if err != nil {
+		return
+	}
+	if !generated.empty() {
+		if len(buf) == 0 {
+			buf = generated.render()
+		} else if buf, err = mergeUserData(buf, generated.render()); err != nil {
+			return
+		}
+	}
+
+	if len(buf) > 0 {
 		userdata = base64.StdEncoding.EncodeToString(buf)
 	}
 	return
@@ -549,27 +1821,89 @@ func (d *Driver) Base64UserData() (userdata string, err error) {
 
 func (d *Driver) Create() error {
 	// PreCreateCheck has already been called
+	// the retry time budget covers the whole create
+	d.logPhase("create")
 
 	if err := d.innerCreate(); err != nil {
-		// cleanup partially created resources
-		d.Remove()
+		// cleanup partially created resources, an adopted instance being
+		// left as it was
+		if d.AdoptInstanceId != "" {
+			d.InstanceId = ""
+		}
+		// with its own retry time budget, the create may have exhausted it
+		d.logPhase("remove")
+		d.removeForCleanup().log()
+		d.clearCreateProgress()
 		return err
 	}
 
+	d.clearCreateProgress()
 	return nil
 }
 
 func (d *Driver) innerCreate() error {
 	log.Infof("Launching instance...")
+	// the create configures the groups itself
+	d.securityGroupsReconciled = true
+
+	// A previous attempt (e.g. a Rancher retry after a timeout) may already
+	// have launched an instance for this machine, adopt it, with its key
+	// pair, instead of creating a duplicate
+	if d.AdoptInstanceId == "" && d.InstanceId == "" {
+		instance, err := d.findExistingInstance()
+		if err != nil {
+			return fmt.Errorf("Error looking up existing instance: %s", err)
+		}
+		if instance != nil {
+			log.Infof("Adopting existing instance %s tagged for machine %s", *instance.InstanceId, d.MachineName)
+			if !d.KeyPairCreated {
+				if err := d.adoptKeyPair(instance); err != nil {
+					return err
+				}
+				d.KeyPairCreated = true
+			}
+			d.InstanceId = *instance.InstanceId
+			if err := d.findExistingAddress(); err != nil {
+				return fmt.Errorf("Error looking up external IP: %s", err)
+			}
+			d.saveCreateProgress()
+		}
+	}
+
+	if !d.KeyPairCreated {
+		if err := d.createKeyPair(); err != nil {
+			return fmt.Errorf("unable to create key pair: %s", err)
+		}
+		d.KeyPairCreated = true
+		d.saveCreateProgress()
+	}
+
+	// the adopted instance keeps its own security groups
+	if !d.SecurityGroupsConfigured && d.AdoptInstanceId == "" {
+		if err := d.configureSecurityGroups(d.securityGroupNames()); err != nil {
+			return err
+		}
+		if err := d.configureExistingSecurityGroups(d.ExistingSecurityGroupIds); err != nil {
+			return err
+		}
+		if err := d.waitForRequestedRules(); err != nil {
+			return err
+		}
+		d.SecurityGroupsConfigured = true
+		d.saveCreateProgress()
+	}
+
+	if d.CreateDedicatedSubnet && !d.SubnetCreated {
+		subnetId, err := d.createSubnet(d.getRegionZone())
+		if err != nil {
+			return err
+		}
+		d.SubnetId = subnetId
+		d.SubnetCreated = true
+		d.saveCreateProgress()
+		d.tagResource(d.SubnetId)
+	}
 
-	if err := d.createKeyPair(); err != nil {
-		return fmt.Errorf("unable to create key pair: %s", err)
-	}
-
-	if err := d.configureSecurityGroups(d.securityGroupNames()); err != nil {
-		return err
-	}
-
 	var userdata string
 	if b64, err := d.Base64UserData(); err != nil {
 		return err
@@ -579,81 +1913,120 @@ func (d *Driver) innerCreate() error {
 
 	bdmList := d.updateBDMList()
 
-	netSpecs := []*ec2.InstanceNetworkInterfaceSpecification{{
-		DeviceIndex:              aws.Int64(0), // eth0
-		Groups:                   makePointerSlice(d.securityGroupIds()),
-		SubnetId:                 &d.SubnetId,
-		AssociatePublicIpAddress: aws.Bool(!d.PrivateIPOnly),
-	}}
-
-	regionZone := d.getRegionZone()
 	log.Debugf("launching instance in subnet %s", d.SubnetId)
 
 	var instance *ec2.Instance
-		inst, err := d.getClient().RunInstances(&ec2.RunInstancesInput{
-			ImageId:  &d.AMI,
-			MinCount: aws.Int64(1),
-			MaxCount: aws.Int64(1),
-			Placement: &ec2.Placement{
-				AvailabilityZone: &regionZone,
-			},
-			KeyName:           &d.KeyName,
-			InstanceType:      &d.InstanceType,
-			NetworkInterfaces: netSpecs,
-			IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-				Name: &d.IamInstanceProfile,
-			},
-			EbsOptimized:        &d.UseEbsOptimizedInstance,
-			BlockDeviceMappings: bdmList,
-			UserData:            &userdata,
+	if d.AdoptInstanceId != "" {
+		inst, err := d.adoptInstance()
+		if err != nil {
+			return err
+		}
+		instance = inst
+	} else if d.InstanceId != "" {
+		// only an instance known to be gone is replaced, launching another
+		// one on a throttled or timed out lookup would leak the first
+		inst, err := d.getInstance()
+		if err != nil && !isInstanceNotFound(err) {
+			return fmt.Errorf("Error looking up instance %s: %s", d.InstanceId, err)
+		}
+		if err != nil || *inst.State.Name == ec2.InstanceStateNameTerminated || *inst.State.Name == ec2.InstanceStateNameShuttingDown {
+			log.Warnf("Instance %s of the interrupted create is gone, launching a new one", d.InstanceId)
+			d.InstanceId = ""
+			d.AssociationId = ""
+		} else {
+			instance = inst
+		}
+	}
+
+	launched := instance == nil
+	if launched {
+		// tagged at launch so that a retried create finds the instance
+		// even if this call times out
+		tags, err := d.instanceTags(d.Tags)
+		if err != nil {
+			return err
+		}
+		// the zone and subnet change when the launch fails over to
+		// another of --outscale-zones
+		inst, err := d.runInstance(func() *ec2.RunInstancesInput {
+			regionZone := d.getRegionZone()
+			return &ec2.RunInstancesInput{
+				ImageId:  &d.AMI,
+				MinCount: aws.Int64(1),
+				MaxCount: aws.Int64(1),
+				Placement: &ec2.Placement{
+					AvailabilityZone: &regionZone,
+				},
+				KeyName:           &d.KeyName,
+				InstanceType:      &d.InstanceType,
+				NetworkInterfaces: d.networkInterfaceSpecs(),
+				IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
+					Name: &d.IamInstanceProfile,
+				},
+				EbsOptimized:        &d.UseEbsOptimizedInstance,
+				BlockDeviceMappings: bdmList,
+				UserData:            &userdata,
+				TagSpecifications: []*ec2.TagSpecification{{
+					ResourceType: aws.String(ec2.ResourceTypeInstance),
+					Tags:         tags,
+				}},
+			}
 		})
 
 		if err != nil {
 			return fmt.Errorf("Error launching instance: %s", err)
 		}
-		instance = inst.Instances[0]
-	// }
+		instance = inst
+	}
 
 	d.InstanceId = *instance.InstanceId
+	d.saveCreateProgress()
 
-	//Outscale does not provision an Extenal IP automatically so need to do it
-	//here before the IP can be discovered
+	// the adopted or resumed instance gets the tags of this create
+	if !launched {
+		log.Debug("Settings tags for instance")
+		if err := d.configureTags(d.Tags); err != nil {
+			return fmt.Errorf("Unable to tag instance %s: %s", d.InstanceId, err)
+		}
+	}
 
-	d.waitForInstance()
+	//Outscale does not always provision an Extenal IP automatically so need
+	//to do it here before the IP can be discovered
 
-	log.Debug("Allocating External IP Address")
+	if err := d.waitForInstance(); err != nil {
+		return err
+	}
 
-	eip, err := d.getClient().AllocateAddress(&ec2.AllocateAddressInput{
-		Domain: aws.String("vpc"),
-	})
+	d.tagRootVolume()
+	d.recordExtraVolumes()
 
-	if err != nil {
-		return fmt.Errorf("Error allocating external IP: %s", err)
+	if err := d.registerWithLoadBalancers(); err != nil {
+		return err
 	}
-	d.AllocationId = *eip.AllocationId
-	d.PublicIp = *eip.PublicIp
 
-	log.Debug("Associating External IP Address")
-	_, err = d.getClient().AssociateAddress(&ec2.AssociateAddressInput{
-		AllocationId: aws.String(d.AllocationId),
-		InstanceId:   aws.String(d.InstanceId),
-		PublicIp:     aws.String(d.PublicIp),
-	})
-	if err != nil {
-		return fmt.Errorf("Error associating external IP: %s", err)
-	} else {
-		log.Debug("waiting for ip address to become available")
-		if err := mcnutils.WaitFor(d.instanceIpAvailable); err != nil {
+	if !d.PrivateIPOnly {
+		if err := d.assignExternalIp(); err != nil {
 			return err
 		}
 	}
 
+	log.Debug("waiting for ip address to become available")
+	if err := waitFor("the instance IP address", d.instanceIpAvailable); err != nil {
+		return err
+	}
+
+	if err := d.createDNSRecord(); err != nil {
+		return err
+	}
+
 	//End outscale specifics
 
 	if instance.PrivateIpAddress != nil {
 		d.PrivateIPAddress = *instance.PrivateIpAddress
 	}
 
+	log.Infof("Security groups attached to %s: %s", d.InstanceId, strings.Join(d.securityGroupIds(), ", "))
+
 	//d.waitForInstance()
 
 	if d.HttpEndpoint != "" || d.HttpTokens != "" {
@@ -667,186 +2040,835 @@ func (d *Driver) innerCreate() error {
 		}
 	}
 
+	if d.DisableSourceDestCheck {
+		_, err := d.getClient().ModifyInstanceAttribute(&ec2.ModifyInstanceAttributeInput{
+			InstanceId:      aws.String(d.InstanceId),
+			SourceDestCheck: &ec2.AttributeBooleanValue{Value: aws.Bool(false)},
+		})
+		if err != nil {
+			return fmt.Errorf("Error disabling source/destination check for instance: %s", err)
+		}
+	}
+
 	log.Debugf("created instance ID %s, IP address %s, Private IP address %s",
 		d.InstanceId,
 		d.IPAddress,
 		d.PrivateIPAddress,
 	)
 
-	log.Debug("Settings tags for instance")
-	err = d.configureTags(d.Tags)
+	if d.BastionHost != "" {
+		if path, err := d.writeBastionSSHConfig(); err != nil {
+			log.Warnf("Unable to write the bastion SSH config: %s", err)
+		} else {
+			log.Infof("Reaching the instance through bastion %s, connect with: ssh -F %s %s", d.proxyJump(), path, d.MachineName)
+			log.Infof("The Docker URL of the machine is tcp://localhost:%d, forward it with: ssh -F %s -N -L %d:localhost:%d %s", dockerPort, path, dockerPort, dockerPort, d.MachineName)
+		}
+	}
+
+	if err := d.waitForSSH(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (d *Driver) GetURL() (string, error) {
+	// Same as drivers.MustBeRunning followed by GetIP, but with a single
+	// lookup of the instance
+	inst, err := d.getInstance()
+	if err != nil {
+		return "", err
+	}
+	if instanceState(inst) != state.Running {
+		return "", drivers.ErrHostIsNotRunning
+	}
+
+	if d.BastionHost != "" {
+		// The private address can't be reached from outside the Net, and the
+		// driver tunnels close with its process: the URL is the local end of
+		// the ssh -L forward printed by Create, localhost being in the server
+		// certificate and the provisioning only taking the port from it
+		return fmt.Sprintf("tcp://%s", net.JoinHostPort("localhost", strconv.Itoa(dockerPort))), nil
+	}
+
+	ip, err := d.instanceIP(inst)
+	if err != nil {
+		return "", err
+	}
+	if ip == "" {
+		return "", nil
+	}
+
+	host := ip
+	if dns := aws.StringValue(inst.PrivateDnsName); d.UsePrivateDNS && dns != "" {
+		host = dns
+	}
+
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(host, strconv.Itoa(dockerPort))), nil
+}
+
+func (d *Driver) GetIP() (string, error) {
+	if ip := d.storedIP(); ip != "" && time.Since(d.IPCheckedAt) < storedIPTTL {
+		return ip, nil
+	}
+
+	inst, err := d.getInstance()
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := d.instanceIP(inst)
+	if err != nil {
+		return "", err
+	}
+	d.checkStoredIP(ip)
+	return ip, nil
+}
+
+func (d *Driver) instanceIP(inst *ec2.Instance) (string, error) {
+	if d.PrivateIPOnly {
+		if inst.PrivateIpAddress == nil {
+			return "", fmt.Errorf("No private IP for instance %v", *inst.InstanceId)
+		}
+		return *inst.PrivateIpAddress, nil
+	}
+
+	if d.UsePrivateIP {
+		if inst.PrivateIpAddress == nil {
+			return "", fmt.Errorf("No private IP for instance %v", *inst.InstanceId)
+		}
+		return *inst.PrivateIpAddress, nil
+	}
+
+	if d.UseIPv6Address {
+		if ip := instanceIPv6(inst); ip != "" {
+			return ip, nil
+		}
+		return "", fmt.Errorf("No IPv6 address for instance %v", *inst.InstanceId)
+	}
+
+	if inst.PublicIpAddress == nil {
+		return "", fmt.Errorf("No IP for instance %v", *inst.InstanceId)
+	}
+	return *inst.PublicIpAddress, nil
+}
+
+// instanceIPv6 returns the first IPv6 address of the instance network
+// interfaces
+func instanceIPv6(inst *ec2.Instance) string {
+	for _, iface := range inst.NetworkInterfaces {
+		for _, address := range iface.Ipv6Addresses {
+			if ip := aws.StringValue(address.Ipv6Address); ip != "" {
+				return ip
+			}
+		}
+	}
+	return ""
+}
+
+func (d *Driver) GetState() (state.State, error) {
+	inst, err := d.getInstance()
+	if err != nil {
+		return state.Error, err
+	}
+
+	d.ProviderState = aws.StringValue(inst.State.Name)
+	d.ProviderStateReason = ""
+	if inst.StateReason != nil {
+		d.ProviderStateReason = aws.StringValue(inst.StateReason.Message)
+	}
+
+	return instanceState(inst), nil
+}
+
+func instanceState(inst *ec2.Instance) state.State {
+	switch *inst.State.Name {
+	case ec2.InstanceStateNamePending:
+		return state.Starting
+	case ec2.InstanceStateNameRunning:
+		return state.Running
+	case ec2.InstanceStateNameStopping:
+		return state.Stopping
+	case ec2.InstanceStateNameShuttingDown:
+		return state.Stopping
+	case ec2.InstanceStateNameStopped:
+		return state.Stopped
+	case ec2.InstanceStateNameTerminated:
+		return state.Error
+	case instanceStateQuarantine:
+		log.Warnf("instance %s is in quarantine, contact the Outscale support", aws.StringValue(inst.InstanceId))
+		return state.Error
+	default:
+		log.Warnf("unrecognized instance state: %v", *inst.State.Name)
+		return state.Error
+	}
+}
+
+func (d *Driver) GetSSHHostname() (string, error) {
+	// provision and upgrade reach the machine over SSH without starting it,
+	// its groups are reconciled before
+	if d.ReconcileSecurityGroups && !d.securityGroupsReconciled && d.InstanceId != "" {
+		d.reconcileSecurityGroupsOnce()
+	}
+
+	if d.BastionHost != "" {
+		host, _, err := d.bastionEndpoint()
+		return host, err
+	}
+	return d.instanceSSHHostname()
+}
+
+// instanceSSHHostname returns the address the instance is reached at for SSH
+func (d *Driver) instanceSSHHostname() (string, error) {
+	usePublicDNS := d.UsePublicDNS && !d.PrivateIPOnly && !d.UsePrivateIP && !d.UseIPv6Address
+	if !usePublicDNS && !d.UsePrivateDNS {
+		return d.GetIP()
+	}
+
+	inst, err := d.getInstance()
+	if err != nil {
+		return "", err
+	}
+	if d.UsePrivateDNS {
+		if dns := aws.StringValue(inst.PrivateDnsName); dns != "" {
+			return dns, nil
+		}
+		log.Debugf("No private DNS name for instance %s, using its IP", d.InstanceId)
+		return d.instanceIP(inst)
+	}
+	if dns := aws.StringValue(inst.PublicDnsName); dns != "" {
+		return dns, nil
+	}
+
+	log.Debugf("No public DNS name for instance %s, using its IP", d.InstanceId)
+	return d.instanceIP(inst)
+}
+
+func (d *Driver) GetSSHUsername() string {
+	if d.SSHUser == "" {
+		d.SSHUser = defaultSSHUser
+	}
+
+	return d.SSHUser
+}
+
+func (d *Driver) Start() error {
+	d.logPhase("start")
+
+	err := d.getAPI().StartInstance(d.InstanceId)
+	d.instanceCache.forget()
+	if err != nil {
+		return err
+	}
+
+	if err := d.waitForInstance(); err != nil {
+		return err
+	}
+
+	if d.ReconcileSecurityGroups {
+		d.reconcileSecurityGroupsOnce()
+	}
+
+	if d.ReleaseIpOnStop && d.AllocationId == "" {
+		if err := d.assignExternalIp(); err != nil {
+			return err
+		}
+		if err := waitFor("the instance IP address", d.instanceIpAvailable); err != nil {
+			return err
+		}
+		return d.createDNSRecord()
+	}
+
+	return d.ensureAddressAssociated()
+}
+
+// assignExternalIp gives the instance an external IP: the one given with
+// --outscale-public-ip, one from the --outscale-public-ip-pool pool or a newly
+// allocated one. Nothing is done when the subnet already gave the instance a
+// public IP, unless --outscale-force-public-ip is set.
+func (d *Driver) assignExternalIp() error {
+	if d.AllocationId == "" && d.ReusePublicIp == "" && d.PublicIpPool == "" && !d.ForcePublicIp {
+		if publicIp := d.autoAssignedPublicIp(); publicIp != "" {
+			log.Infof("Instance %s already has public IP %s, not allocating an external IP", d.InstanceId, publicIp)
+			return nil
+		}
+	}
+
+	if d.AllocationId == "" && d.ReusePublicIp != "" {
+		address, err := d.findReusedAddress()
+		if err != nil {
+			return err
+		}
+		log.Infof("Reusing external IP %s", aws.StringValue(address.PublicIp))
+		d.AllocationId = aws.StringValue(address.AllocationId)
+		d.PublicIp = aws.StringValue(address.PublicIp)
+		d.ExistingPublicIp = true
+		if aws.StringValue(address.InstanceId) == d.InstanceId {
+			d.AssociationId = aws.StringValue(address.AssociationId)
+		}
+		d.saveCreateProgress()
+	}
+
+	if d.AllocationId == "" && d.PublicIpPool != "" {
+		if err := d.claimPoolAddress(); err != nil {
+			return err
+		}
+		d.saveCreateProgress()
+	}
+
+	if d.AllocationId == "" {
+		log.Debug("Allocating External IP Address")
+
+		eip, err := d.getAPI().AllocateAddress(&ec2.AllocateAddressInput{
+			Domain: aws.String("vpc"),
+		})
+
+		if err != nil {
+			return fmt.Errorf("Error allocating external IP: %s", err)
+		}
+		d.AllocationId = *eip.AllocationId
+		d.PublicIp = *eip.PublicIp
+		d.saveCreateProgress()
+
+		d.tagResource(d.AllocationId)
+	}
+
+	if d.AssociationId == "" {
+		log.Debug("Associating External IP Address")
+		input, err := d.associateAddressInput(d.AllocationId)
+		if err != nil {
+			return fmt.Errorf("Error associating external IP: %s", err)
+		}
+		assoc, err := d.getAPI().AssociateAddress(input)
+		d.instanceCache.forget()
+		if err != nil {
+			return fmt.Errorf("Error associating external IP: %s", err)
+		}
+		d.AssociationId = aws.StringValue(assoc.AssociationId)
+		d.saveCreateProgress()
+	}
+	return nil
+}
+
+// autoAssignedPublicIp returns the public IP the instance got at launch, if
+// any
+func (d *Driver) autoAssignedPublicIp() string {
+	inst, err := d.getInstance()
+	if err != nil {
+		log.Warnf("Unable to look up the public IP of %s: %s", d.InstanceId, err)
+		return ""
+	}
+	return aws.StringValue(inst.PublicIpAddress)
+}
+
+// ensureAddressAssociated associates the external IP with the instance again
+// if a stop/start cycle lost the association, so that the machine keeps its
+// address across maintenance stops
+func (d *Driver) ensureAddressAssociated() error {
+	if d.AllocationId == "" {
+		return nil
+	}
+
+	addresses, err := d.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{
+		AllocationIds: []*string{&d.AllocationId},
+	})
+	if err != nil {
+		return fmt.Errorf("Error checking external IP %s: %s", d.PublicIp, err)
+	}
+	if len(addresses.Addresses) == 0 {
+		return fmt.Errorf("external IP %s (%s) no longer exists", d.PublicIp, d.AllocationId)
+	}
+
+	address := addresses.Addresses[0]
+	switch aws.StringValue(address.InstanceId) {
+	case d.InstanceId:
+		d.AssociationId = aws.StringValue(address.AssociationId)
+		return nil
+	case "":
+	default:
+		return fmt.Errorf("external IP %s is associated with %s instead of %s", d.PublicIp, aws.StringValue(address.InstanceId), d.InstanceId)
+	}
+
+	log.Infof("Associating external IP %s with %s again", d.PublicIp, d.InstanceId)
+	input, err := d.associateAddressInput(d.AllocationId)
+	if err != nil {
+		return fmt.Errorf("Error associating external IP: %s", err)
+	}
+	assoc, err := d.getAPI().AssociateAddress(input)
+	d.instanceCache.forget()
+	if err != nil {
+		return fmt.Errorf("Error associating external IP: %s", err)
+	}
+	d.AssociationId = aws.StringValue(assoc.AssociationId)
+	return nil
+}
+
+// releaseAddress disassociates and releases the external IP of the machine.
+// The release is retried while the terminating instance still holds the IP.
+func (d *Driver) releaseAddress() error {
+	if err := d.disassociateAddress(); err != nil {
+		return err
+	}
+
+	err := waitFor("release of external IP "+d.PublicIp, func() (bool, error) {
+		_, err := d.getAPI().ReleaseAddress(&ec2.ReleaseAddressInput{
+			AllocationId: aws.String(d.AllocationId),
+		})
+		if err != nil && !isAddressNotFound(err) {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to release external IP: %s", err)
+	}
+	d.AllocationId = ""
+	return nil
+}
+
+// findReusedAddress looks up the external IP given with --outscale-public-ip
+// and checks that no other instance uses it
+func (d *Driver) findReusedAddress() (*ec2.Address, error) {
+	input := &ec2.DescribeAddressesInput{}
+	if strings.HasPrefix(d.ReusePublicIp, "eipalloc-") {
+		input.AllocationIds = []*string{aws.String(d.ReusePublicIp)}
+	} else {
+		input.PublicIps = []*string{aws.String(d.ReusePublicIp)}
+	}
+
+	addresses, err := d.getClient().DescribeAddresses(input)
+	if err != nil && !isAddressNotFound(err) {
+		return nil, fmt.Errorf("Error looking up external IP %s: %s", d.ReusePublicIp, err)
+	}
+	if err != nil || len(addresses.Addresses) == 0 || addresses.Addresses[0].AllocationId == nil {
+		return nil, fmt.Errorf("external IP %s does not exist", d.ReusePublicIp)
+	}
+
+	address := addresses.Addresses[0]
+	if instanceId := aws.StringValue(address.InstanceId); instanceId != "" && instanceId != d.InstanceId {
+		return nil, fmt.Errorf("external IP %s is already associated with %s", d.ReusePublicIp, instanceId)
+	}
+	return address, nil
+}
+
+// disassociateAddress disassociates the external IP from the instance
+func (d *Driver) disassociateAddress() error {
+	if d.AssociationId == "" {
+		return nil
+	}
+
+	_, err := d.getAPI().DisassociateAddress(&ec2.DisassociateAddressInput{
+		AssociationId: aws.String(d.AssociationId),
+	})
+	d.instanceCache.forget()
+	if err != nil && !isAddressNotFound(err) {
+		return fmt.Errorf("unable to disassociate external IP: %s", err)
+	}
+	d.AssociationId = ""
+	return nil
+}
+
+// isAddressNotFound tells whether the external IP or its association is
+// already gone
+func isAddressNotFound(err error) bool {
+	return strings.HasPrefix(err.Error(), "InvalidAllocationID.NotFound") ||
+		strings.HasPrefix(err.Error(), "InvalidAddress.NotFound") ||
+		strings.HasPrefix(err.Error(), "InvalidAssociationID.NotFound")
+}
+
+func (d *Driver) Stop() error {
+	d.logPhase("stop")
 
+	err := d.getAPI().StopInstance(d.InstanceId, false)
+	d.instanceCache.forget()
 	if err != nil {
-		return fmt.Errorf("Unable to tag instance %s: %s", d.InstanceId, err)
+		return err
 	}
 
+	if d.ReleaseIpOnStop {
+		return d.releaseAddressOnStop()
+	}
 	return nil
 }
 
-func (d *Driver) GetURL() (string, error) {
-	if err := drivers.MustBeRunning(d); err != nil {
-		return "", err
+// releaseAddressOnStop gives up the external IP of the stopped machine, Start
+// getting it a new one. The IP given with --outscale-public-ip is kept.
+func (d *Driver) releaseAddressOnStop() error {
+	switch {
+	case d.AllocationId == "":
+		return nil
+	case d.ExistingPublicIp:
+		log.Infof("Keeping external IP %s given with --outscale-public-ip", d.PublicIp)
+		return nil
+	case d.PublicIpPool != "":
+		log.Infof("Returning external IP %s to pool %s", d.PublicIp, d.PublicIpPool)
+		return d.returnPoolAddress()
 	}
 
-	ip, err := d.GetIP()
-	if err != nil {
-		return "", err
-	}
-	if ip == "" {
-		return "", nil
+	log.Infof("Releasing external IP %s of the stopped machine", d.PublicIp)
+	return d.releaseAddress()
+}
+
+func (d *Driver) Restart() error {
+	d.logPhase("restart")
+
+	defer d.instanceCache.forget()
+	return d.getAPI().RebootInstance(d.InstanceId)
+}
+
+func (d *Driver) Kill() error {
+	d.logPhase("kill")
+
+	defer d.instanceCache.forget()
+	return d.getAPI().StopInstance(d.InstanceId, true)
+}
+
+func (d *Driver) Remove() error {
+	d.logPhase("remove")
+
+	// the resources of a Create interrupted before docker-machine saved
+	// them with the machine
+	d.loadCreateProgress()
+
+	report := d.remove()
+	report.log()
+	return report.err()
+}
+
+func (d *Driver) remove() *removeReport {
+	if d.RemoveMode == removeModeStop {
+		return d.removeByStopping(&removeReport{})
 	}
+	return d.removeResources(d.SnapshotOnRemove)
+}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(dockerPort))), nil
+// removeForCleanup removes what a failed Create left behind. The instance is
+// always terminated, whatever --outscale-remove-mode, a stopped half-built
+// instance being adopted by the next create otherwise, and its root volume
+// is not snapshotted.
+func (d *Driver) removeForCleanup() *removeReport {
+	return d.removeResources(false)
 }
 
-func (d *Driver) GetIP() (string, error) {
-	inst, err := d.getInstance()
-	if err != nil {
-		return "", err
+// removeResources terminates the instance, after a snapshot of its root
+// volume with snapshot, and deletes the resources the driver created for it
+func (d *Driver) removeResources(snapshot bool) *removeReport {
+	report := &removeReport{}
+
+	instanceGone := d.InstanceId == ""
+	if d.InstanceId != "" {
+		d.deregisterFromLoadBalancers(report)
+		if snapshot {
+			if err := d.snapshotRootVolume(); err != nil {
+				// the snapshot is a safety net, keep the instance without it
+				report.done("snapshot", "", err)
+				report.kept("instance", d.InstanceId, "root volume snapshot failed")
+			} else {
+				report.kept("snapshot", d.RemoveSnapshotId, "root volume of "+d.InstanceId)
+			}
+		}
+		if !snapshot || d.RemoveSnapshotId != "" {
+			err := d.terminate()
+			report.done("instance", d.InstanceId, err)
+			instanceGone = err == nil
+		}
 	}
 
-	if d.PrivateIPOnly {
-		if inst.PrivateIpAddress == nil {
-			return "", fmt.Errorf("No private IP for instance %v", *inst.InstanceId)
+	if d.DNSRecordName != "" {
+		if instanceGone {
+			// deleteDNSRecord clears DNSRecordName
+			name := d.DNSRecordName
+			report.done("DNS record", name, d.deleteDNSRecord())
+		} else {
+			report.kept("DNS record", d.DNSRecordName, "points at the kept instance")
 		}
-		return *inst.PrivateIpAddress, nil
 	}
 
-	if d.UsePrivateIP {
-		if inst.PrivateIpAddress == nil {
-			return "", fmt.Errorf("No private IP for instance %v", *inst.InstanceId)
+	if d.AllocationId != "" {
+		switch {
+		case d.ExistingPublicIp:
+			report.kept("external IP", d.PublicIp, "existing external IP")
+		case d.KeepPublicIp:
+			report.kept("external IP", d.PublicIp, "--outscale-keep-public-ip is set")
+		case !instanceGone:
+			report.kept("external IP", d.PublicIp, "associated with the kept instance")
+		case d.PublicIpPool != "":
+			pool := d.PublicIpPool
+			if err := d.returnPoolAddress(); err != nil {
+				report.done("external IP", d.PublicIp, err)
+			} else {
+				report.kept("external IP", d.PublicIp, "returned to pool "+pool)
+			}
+		default:
+			report.done("external IP", d.PublicIp, d.releaseAddress())
 		}
-		return *inst.PrivateIpAddress, nil
 	}
 
-	if inst.PublicIpAddress == nil {
-		return "", fmt.Errorf("No IP for instance %v", *inst.InstanceId)
+	if d.NicId != "" {
+		report.kept("network interface", d.NicId, "existing network interface")
 	}
-	return *inst.PublicIpAddress, nil
-}
 
-func (d *Driver) GetState() (state.State, error) {
-	inst, err := d.getInstance()
-	if err != nil {
-		return state.Error, err
+	d.removeExtraVolumes(report, instanceGone)
+
+	if d.SubnetCreated {
+		d.removeSubnet(report, instanceGone)
 	}
-	switch *inst.State.Name {
-	case ec2.InstanceStateNamePending:
-		return state.Starting, nil
-	case ec2.InstanceStateNameRunning:
-		return state.Running, nil
-	case ec2.InstanceStateNameStopping:
-		return state.Stopping, nil
-	case ec2.InstanceStateNameShuttingDown:
-		return state.Stopping, nil
-	case ec2.InstanceStateNameStopped:
-		return state.Stopped, nil
-	case ec2.InstanceStateNameTerminated:
-		return state.Error, nil
-	default:
-		log.Warnf("unrecognized instance state: %v", *inst.State.Name)
-		return state.Error, nil
+
+	if d.KeyName != "" {
+		if d.ExistingKey {
+			report.kept("key pair", d.KeyName, "existing key pair")
+		} else {
+			report.done("key pair", d.KeyName, d.deleteKeyPair())
+		}
 	}
-}
 
-func (d *Driver) GetSSHHostname() (string, error) {
-	return d.GetIP()
+	for _, id := range d.securityGroupIds() {
+		switch {
+		case id == d.MachineSecurityGroupId:
+			d.removeMachineSecurityGroup(report, instanceGone)
+		case d.DeleteSecurityGroup:
+			d.removeSecurityGroup(report, id, instanceGone)
+		default:
+			report.kept("security group", id, "shared between machines")
+		}
+	}
+
+	return report
 }
 
-func (d *Driver) GetSSHUsername() string {
-	if d.SSHUser == "" {
-		d.SSHUser = defaultSSHUser
+// removeByStopping stops the instance and leaves it, with its disks, external
+// IP and key pair, for a later manual destruction. The instance is tagged
+// with the removal date.
+func (d *Driver) removeByStopping(report *removeReport) *removeReport {
+	if d.InstanceId == "" {
+		return report
 	}
 
-	return d.SSHUser
-}
+	d.deregisterFromLoadBalancers(report)
+	if d.DNSRecordName != "" {
+		report.kept("DNS record", d.DNSRecordName, "points at the kept instance")
+	}
 
-func (d *Driver) Start() error {
-	_, err := d.getClient().StartInstances(&ec2.StartInstancesInput{
-		InstanceIds: []*string{&d.InstanceId},
+	err := d.getAPI().StopInstance(d.InstanceId, false)
+	d.instanceCache.forget()
+	if err != nil {
+		report.done("instance", d.InstanceId, fmt.Errorf("unable to stop instance: %s", err))
+		return report
+	}
+	_, err = d.getClient().CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{&d.InstanceId},
+		Tags: []*ec2.Tag{{
+			Key:   aws.String(removedAtTag),
+			Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+		}},
 	})
 	if err != nil {
-		return err
+		log.Warnf("Unable to tag %s as removed: %s", d.InstanceId, err)
 	}
 
-	return d.waitForInstance()
+	report.kept("instance", d.InstanceId, "stopped, --outscale-remove-mode is stop")
+	if d.AllocationId != "" {
+		report.kept("external IP", d.PublicIp, "associated with the stopped instance")
+	}
+	if d.KeyName != "" {
+		report.kept("key pair", d.KeyName, "used by the stopped instance")
+	}
+	if d.SubnetCreated {
+		report.kept("subnet", d.SubnetId, "used by the stopped instance")
+	}
+	return report
 }
 
-func (d *Driver) Stop() error {
-	_, err := d.getClient().StopInstances(&ec2.StopInstancesInput{
-		InstanceIds: []*string{&d.InstanceId},
-		Force:       aws.Bool(false),
-	})
-	return err
-}
+func (d *Driver) getInstance() (*ec2.Instance, error) {
+	key := d.Region + "/" + d.InstanceId
+	if inst := d.instanceCache.get(key); inst != nil {
+		return inst, nil
+	}
 
-func (d *Driver) Restart() error {
-	_, err := d.getClient().RebootInstances(&ec2.RebootInstancesInput{
-		InstanceIds: []*string{&d.InstanceId},
+	// Concurrent lookups of the same instance (e.g. Rancher polling GetState
+	// and GetIP) share a single DescribeInstances call
+	inst, err := instanceLookups.Do(key, func() (interface{}, error) {
+		return d.getAPI().GetInstance(d.InstanceId)
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+	d.instanceCache.put(key, inst.(*ec2.Instance))
+	return inst.(*ec2.Instance), nil
 }
 
-func (d *Driver) Kill() error {
-	_, err := d.getClient().StopInstances(&ec2.StopInstancesInput{
-		InstanceIds: []*string{&d.InstanceId},
-		Force:       aws.Bool(true),
-	})
-	return err
-}
+// findExistingInstance looks for a non-terminated instance already tagged
+// with this machine name and cluster, returning nil if there is none.
+func (d *Driver) findExistingInstance() (*ec2.Instance, error) {
+	if d.MachineName == "" {
+		return nil, nil
+	}
 
-func (d *Driver) Remove() error {
-	multierr := mcnutils.MultiError{
-		Errs: []error{},
+	instances, err := d.describeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:Name"),
+				Values: []*string{aws.String(d.resourceName())},
+			},
+			{
+				Name:   aws.String("tag:" + d.clusterTagKey()),
+				Values: []*string{aws.String("owned")},
+			},
+			{
+				Name: aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{
+					ec2.InstanceStateNamePending,
+					ec2.InstanceStateNameRunning,
+					ec2.InstanceStateNameStopping,
+					ec2.InstanceStateNameStopped,
+				}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if err := d.terminate(); err != nil {
-		multierr.Errs = append(multierr.Errs, err)
+	if len(instances) > 0 {
+		return instances[0], nil
 	}
+	return nil, nil
+}
 
-	if !d.ExistingKey {
-		if err := d.deleteKeyPair(); err != nil {
-			multierr.Errs = append(multierr.Errs, err)
+// adoptKeyPair reuses the key pair of an instance launched by a previous
+// create, failing when its private key is not available
+func (d *Driver) adoptKeyPair(instance *ec2.Instance) error {
+	keyName := aws.StringValue(instance.KeyName)
+	if !d.SSHAgent && d.SSHPrivateKeyPath == "" {
+		if _, err := os.Stat(d.GetSSHKeyPath()); err != nil {
+			return fmt.Errorf("instance %s of a previous create of %s uses key pair %s, whose private key %s is not available, remove the instance or give --outscale-ssh-keypath", aws.StringValue(instance.InstanceId), d.MachineName, keyName, d.GetSSHKeyPath())
 		}
 	}
 
-	if len(multierr.Errs) == 0 {
-		return nil
+	if keyName != "" {
+		d.KeyName = keyName
 	}
-
-	return multierr
+	if d.SSHAgent || d.SSHPrivateKeyPath != "" {
+		return d.createKeyPair()
+	}
+	return nil
 }
 
-func (d *Driver) getInstance() (*ec2.Instance, error) {
-	instances, err := d.getClient().DescribeInstances(&ec2.DescribeInstancesInput{
-		InstanceIds: []*string{&d.InstanceId},
+// findExistingAddress picks up an external IP already associated with the
+// instance, so that an adopted instance does not get a second one.
+func (d *Driver) findExistingAddress() error {
+	if d.AllocationId != "" {
+		return nil
+	}
+
+	addresses, err := d.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance-id"),
+				Values: []*string{&d.InstanceId},
+			},
+		},
 	})
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	for _, address := range addresses.Addresses {
+		if address.AllocationId == nil {
+			continue
+		}
+		log.Debugf("Reusing external IP %s already associated with %s", aws.StringValue(address.PublicIp), d.InstanceId)
+		d.AllocationId = *address.AllocationId
+		d.PublicIp = aws.StringValue(address.PublicIp)
+		d.AssociationId = aws.StringValue(address.AssociationId)
+		return nil
 	}
-	return instances.Reservations[0].Instances[0], nil
+	return nil
 }
 
-func (d *Driver) instanceIsRunning() bool {
-	st, err := d.GetState()
+func (d *Driver) instanceIsRunning() (bool, error) {
+	inst, err := d.getInstance()
 	if err != nil {
-		log.Debug(err)
+		return false, err
 	}
-	if st == state.Running {
-		return true
+
+	switch *inst.State.Name {
+	case ec2.InstanceStateNameRunning:
+		return true, nil
+	case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+		reason := "unknown reason"
+		if inst.StateReason != nil {
+			reason = aws.StringValue(inst.StateReason.Message)
+		}
+		return false, fatal(fmt.Errorf("instance %s is %s: %s", d.InstanceId, *inst.State.Name, reason))
 	}
-	return false
+	return false, nil
 }
 
 func (d *Driver) waitForInstance() error {
-	if err := mcnutils.WaitFor(d.instanceIsRunning); err != nil {
+	return waitFor("instance "+d.InstanceId+" to be running", d.instanceIsRunning)
+}
+
+// waitForSSH waits for sshd to answer on the new instance, using the
+// configurable timeout and retries instead of the fixed libmachine ones which
+// are too short for slow booting images.
+func (d *Driver) waitForSSH() error {
+	if d.SSHRetries <= 0 || d.FakeAPI {
+		return nil
+	}
+
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		return err
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
 		return err
 	}
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	timeout := time.Duration(d.SSHConnectTimeout) * time.Second
 
+	log.Debugf("waiting for SSH to be available on %s", address)
+	if err := mcnutils.WaitForSpecific(func() bool {
+		return sshAvailable(address, timeout)
+	}, d.SSHRetries, sshRetryInterval); err != nil {
+		return fmt.Errorf("SSH on %s not available after %d attempts: %s", address, d.SSHRetries, err)
+	}
 	return nil
 }
 
+// sshAvailable reports whether an SSH server banner can be read at address
+func sshAvailable(address string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		log.Debugf("SSH not available yet: %s", err)
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	banner := make([]byte, 4)
+	if _, err := io.ReadFull(conn, banner); err != nil || string(banner) != "SSH-" {
+		log.Debugf("SSH not ready yet on %s", address)
+		return false
+	}
+	return true
+}
+
 func (d *Driver) createKeyPair() error {
+	if d.SSHAgent {
+		log.Debugf("Using ssh-agent identity")
+		publicKey, err := d.writeAgentPublicKey()
+		if err != nil {
+			return err
+		}
+		if d.KeyName != "" || d.AdoptInstanceId != "" {
+			log.Debugf("Using existing EC2 key pair: %s", d.KeyName)
+			return nil
+		}
+		return d.importKeyPair(publicKey)
+	}
+
 	keyPath := ""
 
 	if d.SSHPrivateKeyPath == "" {
@@ -860,7 +2882,9 @@ func (d *Driver) createKeyPair() error {
 		if err := mcnutils.CopyFile(d.SSHPrivateKeyPath, d.GetSSHKeyPath()); err != nil {
 			return err
 		}
-		if d.KeyName != "" {
+		// the adopted instance already authorizes the key, with or
+		// without a key pair
+		if d.KeyName != "" || d.AdoptInstanceId != "" {
 			log.Debugf("Using existing EC2 key pair: %s", d.KeyName)
 			return nil
 		}
@@ -875,15 +2899,20 @@ func (d *Driver) createKeyPair() error {
 		return err
 	}
 
+	return d.importKeyPair(publicKey)
+}
+
+// importKeyPair imports the public key under a name derived from the machine
+func (d *Driver) importKeyPair(publicKey []byte) error {
 	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
 	b := make([]byte, 5)
 	for i := range b {
 		b[i] = charset[r.Intn(len(charset))]
 	}
-	keyName := d.MachineName + "-" + string(b)
+	keyName := d.keyPairPrefix() + "-" + string(b)
 
 	log.Debugf("creating key pair: %s", keyName)
-	_, err = d.getClient().ImportKeyPair(&ec2.ImportKeyPairInput{
+	keyPair, err := d.getAPI().ImportKeyPair(&ec2.ImportKeyPairInput{
 		KeyName:           &keyName,
 		PublicKeyMaterial: publicKey,
 	})
@@ -891,6 +2920,16 @@ func (d *Driver) createKeyPair() error {
 		return err
 	}
 	d.KeyName = keyName
+
+	// Keypairs can only be tagged when the API gives them an ID
+	if keyPair.KeyPairId != nil {
+		d.tagResource(*keyPair.KeyPairId, &ec2.Tag{
+			Key:   aws.String(createdByTag),
+			Value: aws.String(userAgentName),
+		})
+	} else {
+		log.Debugf("no ID returned for key pair %s, leaving it untagged", keyName)
+	}
 	return nil
 }
 
@@ -901,13 +2940,11 @@ func (d *Driver) terminate() error {
 	}
 
 	log.Debugf("terminating instance: %s", d.InstanceId)
-	_, err := d.getClient().TerminateInstances(&ec2.TerminateInstancesInput{
-		InstanceIds: []*string{&d.InstanceId},
-	})
+	err := d.getAPI().TerminateInstance(d.InstanceId)
+	d.instanceCache.forget()
 
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "unknown instance") ||
-			strings.HasPrefix(err.Error(), "InvalidInstanceID.NotFound") {
+		if isInstanceNotFound(err) {
 			log.Warn("Remote instance does not exist, proceeding with removing local reference")
 			return nil
 		}
@@ -921,42 +2958,129 @@ func (d *Driver) isSwarmMaster() bool {
 	return d.SwarmMaster
 }
 
-func (d *Driver) securityGroupAvailableFunc(id string) func() bool {
-	return func() bool {
+// describeInstances returns the instances of every reservation, following
+// NextToken like describeSecurityGroups
+func (d *Driver) describeInstances(input *ec2.DescribeInstancesInput) ([]*ec2.Instance, error) {
+	instances := []*ec2.Instance{}
+	for {
+		output, err := d.getClient().DescribeInstances(input)
+		if err != nil {
+			return instances, err
+		}
+		for _, reservation := range output.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			return instances, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// describeSubnets follows NextToken like describeSecurityGroups
+func (d *Driver) describeSubnets(input *ec2.DescribeSubnetsInput) ([]*ec2.Subnet, error) {
+	subnets := []*ec2.Subnet{}
+	for {
+		output, err := d.getClient().DescribeSubnets(input)
+		if err != nil {
+			return subnets, err
+		}
+		subnets = append(subnets, output.Subnets...)
+		if aws.StringValue(output.NextToken) == "" {
+			return subnets, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// describeVpcs follows NextToken like describeSecurityGroups
+func (d *Driver) describeVpcs(input *ec2.DescribeVpcsInput) ([]*ec2.Vpc, error) {
+	vpcs := []*ec2.Vpc{}
+	for {
+		output, err := d.getClient().DescribeVpcs(input)
+		if err != nil {
+			return vpcs, err
+		}
+		vpcs = append(vpcs, output.Vpcs...)
+		if aws.StringValue(output.NextToken) == "" {
+			return vpcs, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// describeSecurityGroups follows NextToken so that accounts with many groups
+// don't get truncated results
+func (d *Driver) describeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) ([]*ec2.SecurityGroup, error) {
+	groups := []*ec2.SecurityGroup{}
+	for {
+		output, err := d.getClient().DescribeSecurityGroups(input)
+		if err != nil {
+			return groups, err
+		}
+		groups = append(groups, output.SecurityGroups...)
+		if aws.StringValue(output.NextToken) == "" {
+			return groups, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func (d *Driver) securityGroupAvailableFunc(id string) func() (bool, error) {
+	return func() (bool, error) {
 
-		securityGroup, err := d.getClient().DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		securityGroups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
 			GroupIds: []*string{&id},
 		})
-		if err == nil && len(securityGroup.SecurityGroups) > 0 {
-			return true
-		} else if err == nil {
+		if err != nil {
+			return false, err
+		}
+		if len(securityGroups) == 0 {
 			log.Debugf("No security group with id %v found", id)
-			return false
+			return false, nil
 		}
-		log.Debug(err)
-		return false
+		return true, nil
 	}
 }
 
 func (d *Driver) configureTags(tagGroups string) error {
+	tags, err := d.instanceTags(tagGroups)
+	if err != nil {
+		return err
+	}
 
-	tags := []*ec2.Tag{}
-	tags = append(tags, &ec2.Tag{
-		Key:   aws.String("Name"),
-		Value: &d.MachineName,
+	_, err = d.getClient().CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{&d.InstanceId},
+		Tags:      tags,
 	})
+	return err
+}
+
+// instanceTags returns the tags of the instance: the machine tags, the
+// expiry date and the --outscale-tags ones
+func (d *Driver) instanceTags(tagGroups string) ([]*ec2.Tag, error) {
+	tags := d.machineTags()
 
 	//Added for outscale, where the instance requires tagging to be used with the cloud provider for outscale 
-	//This assumes the hostname (which populates MachineName) uses the format of clustername-
-	ClusterName := d.MachineName[:strings.IndexByte(d.MachineName, '-')]
 	tags = append(tags, &ec2.Tag{
-		Key:   aws.String("OscK8sClusterID/" + ClusterName),
-		Value: aws.String("owned"),
-	}, &ec2.Tag{
 		Key:   aws.String("OscK8sNodeName"),
-		Value: &d.MachineName,
+		Value: aws.String(d.k8sNodeName()),
 	})
 
+	if d.ExpireAfter != "" {
+		if d.ExpiresAt == "" {
+			expireAfter, err := parseExpireAfter(d.ExpireAfter)
+			if err != nil {
+				return nil, err
+			}
+			d.ExpiresAt = time.Now().Add(expireAfter).UTC().Format(time.RFC3339)
+		}
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(expiresAtTag),
+			Value: aws.String(d.ExpiresAt),
+		})
+	}
+
 	if tagGroups != "" {
 		t := strings.Split(tagGroups, ",")
 		if len(t) > 0 && len(t)%2 != 0 {
@@ -970,16 +3094,102 @@ func (d *Driver) configureTags(tagGroups string) error {
 		}
 	}
 
+	return tags, nil
+}
+
+// machineTags identify the resources created for this machine
+func (d *Driver) machineTags() []*ec2.Tag {
+	return []*ec2.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String(d.resourceName()),
+		},
+		{
+			Key:   aws.String(d.clusterTagKey()),
+			Value: aws.String("owned"),
+		},
+	}
+}
+
+// tagResource applies the machine tags, and any extra ones, to a resource
+// created alongside the instance. Failures are only logged, the tags being
+// informative.
+func (d *Driver) tagResource(id string, extra ...*ec2.Tag) {
 	_, err := d.getClient().CreateTags(&ec2.CreateTagsInput{
-		Resources: []*string{&d.InstanceId},
-		Tags:      tags,
+		Resources: []*string{aws.String(id)},
+		Tags:      append(d.machineTags(), extra...),
+	})
+	if err != nil {
+		log.Warnf("Unable to tag %s: %s", id, err)
+	}
+}
+
+// tagRootVolume names the root volume after the machine, the volume ID being
+// known once the instance is running
+func (d *Driver) tagRootVolume() {
+	inst, err := d.getInstance()
+	if err != nil {
+		log.Warnf("Unable to look up the root volume of %s: %s", d.InstanceId, err)
+		return
+	}
+
+	volumeId := rootVolumeId(inst)
+	if volumeId == "" {
+		log.Warnf("No root volume found for %s", d.InstanceId)
+		return
+	}
+	d.tagResource(volumeId)
+}
+
+// snapshotRootVolume snapshots the root volume of the instance, recording
+// the snapshot ID in RemoveSnapshotId
+func (d *Driver) snapshotRootVolume() error {
+	inst, err := d.getInstance()
+	if err != nil {
+		return err
+	}
+
+	volumeId := rootVolumeId(inst)
+	if volumeId == "" {
+		return fmt.Errorf("no root volume found for %s", d.InstanceId)
+	}
+
+	log.Infof("Snapshotting root volume %s of %s", volumeId, d.InstanceId)
+	snapshot, err := d.getAPI().CreateSnapshot(&ec2.CreateSnapshotInput{
+		VolumeId:    aws.String(volumeId),
+		Description: aws.String(fmt.Sprintf("Root volume of %s (%s) before removal", d.MachineName, d.InstanceId)),
 	})
+	if err != nil {
+		return fmt.Errorf("unable to snapshot %s: %s", volumeId, err)
+	}
+
+	d.RemoveSnapshotId = aws.StringValue(snapshot.SnapshotId)
+	d.tagResource(d.RemoveSnapshotId)
+	log.Infof("Root volume of %s saved as snapshot %s", d.MachineName, d.RemoveSnapshotId)
+	return nil
+}
+
+// rootVolumeId returns the ID of the volume mapped to the root device
+func rootVolumeId(inst *ec2.Instance) string {
+	for _, bdm := range inst.BlockDeviceMappings {
+		if aws.StringValue(bdm.DeviceName) == aws.StringValue(inst.RootDeviceName) && bdm.Ebs != nil {
+			return aws.StringValue(bdm.Ebs.VolumeId)
+		}
+	}
+	return ""
+}
 
-	if err != nil {
-		return err
+// clusterName assumes the hostname (which populates MachineName) uses the
+// format of clustername-
+func (d *Driver) clusterName() string {
+	if i := strings.IndexByte(d.MachineName, '-'); i >= 0 {
+		return d.MachineName[:i]
 	}
+	return d.MachineName
+}
 
-	return nil
+func (d *Driver) clusterTagKey() string {
+	return "OscK8sClusterID/" + d.clusterName()
 }
 
 func (d *Driver) configureSecurityGroups(groupNames []string) error {
@@ -989,7 +3199,8 @@ func (d *Driver) configureSecurityGroups(groupNames []string) error {
 	}
 
 	log.Debugf("configuring security groups in %s", d.VpcId)
-	version := version.Version
+	defer d.lockSecurityGroups()()
+	managedTag := d.securityGroupTag()
 
 	filters := []*ec2.Filter{
 		{
@@ -1002,7 +3213,7 @@ func (d *Driver) configureSecurityGroups(groupNames []string) error {
 		},
 	}
 
-	groups, err := d.getClient().DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+	groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
 		Filters: filters,
 	})
 	if err != nil {
@@ -1010,7 +3221,7 @@ func (d *Driver) configureSecurityGroups(groupNames []string) error {
 	}
 
 	var groupsByName = make(map[string]*ec2.SecurityGroup)
-	for _, securityGroup := range groups.SecurityGroups {
+	for _, securityGroup := range groups {
 		groupsByName[*securityGroup.GroupName] = securityGroup
 	}
 
@@ -1020,16 +3231,19 @@ func (d *Driver) configureSecurityGroups(groupNames []string) error {
 		if ok {
 			log.Debugf("found existing security group (%s) in %s", groupName, d.VpcId)
 			group = securityGroup
+		} else if d.ExplicitSecurityGroup {
+			return fmt.Errorf("security group %s not found in %s, --outscale-explicit-security-group prevents creating it", groupName, d.VpcId)
 		} else {
 			log.Debugf("creating security group (%s) in %s", groupName, d.VpcId)
-			groupResp, err := d.getClient().CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+			groupResp, err := d.getAPI().CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
 				GroupName:   aws.String(groupName),
-				Description: aws.String("Rancher Nodes"),
+				Description: aws.String(d.securityGroupDescription()),
 				VpcId:       aws.String(d.VpcId),
 			})
-			if err != nil && !strings.Contains(err.Error(), "already exists") {
+			if err != nil && !isDuplicateError(err) {
 				return err
 			} else if err != nil {
+				log.Debugf("security group (%s) created concurrently in %s", groupName, d.VpcId)
 				filters := []*ec2.Filter{
 					{
 						Name:   aws.String("group-name"),
@@ -1040,16 +3254,16 @@ func (d *Driver) configureSecurityGroups(groupNames []string) error {
 						Values: []*string{&d.VpcId},
 					},
 				}
-				groups, err := d.getClient().DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+				groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
 					Filters: filters,
 				})
 				if err != nil {
 					return err
 				}
-				if len(groups.SecurityGroups) == 0 {
+				if len(groups) == 0 {
 					return errors.New("can't find security group")
 				}
-				group = groups.SecurityGroups[0]
+				group = groups[0]
 			}
 
 			// Manually translate into the security group construct
@@ -1061,252 +3275,453 @@ func (d *Driver) configureSecurityGroups(groupNames []string) error {
 				}
 			}
 
+			tags := []*ec2.Tag{managedTag}
+			if d.SecurityGroupPerMachine && groupName == d.clusterSecurityGroupName() {
+				tags = append(tags, &ec2.Tag{Key: aws.String(d.clusterTagKey()), Value: aws.String("owned")})
+			}
 			_, err = d.getClient().CreateTags(&ec2.CreateTagsInput{
-				Tags: []*ec2.Tag{
-					{
-						Key:   aws.String(machineTag),
-						Value: aws.String(version),
-					},
-				},
+				Tags:      tags,
 				Resources: []*string{group.GroupId},
 			})
-			if err != nil && !strings.Contains(err.Error(), "already exists") {
+			if err != nil && !isDuplicateError(err) {
 				return fmt.Errorf("can't create tag for security group. err: %v", err)
 			}
 
-			// set Tag to group manually so that we know the group has rancher-nodes tag
-			group.Tags = []*ec2.Tag{
-				{
-					Key:   aws.String(machineTag),
-					Value: aws.String(version),
-				},
-			}
+			// set Tag to group manually so that we know the group is managed
+			group.Tags = tags
 
 			// wait until created (dat eventual consistency)
 			log.Debugf("waiting for group (%s) to become available", *group.GroupId)
-			if err := mcnutils.WaitFor(d.securityGroupAvailableFunc(*group.GroupId)); err != nil {
+			if err := waitFor("security group "+*group.GroupId, d.securityGroupAvailableFunc(*group.GroupId)); err != nil {
 				return err
 			}
 		}
-		d.SecurityGroupIds = append(d.SecurityGroupIds, *group.GroupId)
+		d.SecurityGroupIds = appendUnique(d.SecurityGroupIds, *group.GroupId)
+		if d.SecurityGroupPerMachine && groupName == d.machineSecurityGroupName() && d.isManagedSecurityGroup(group) {
+			d.MachineSecurityGroupId = *group.GroupId
+		}
+		if d.isClusterSecurityGroup(group) {
+			d.ClusterSecurityGroupId = *group.GroupId
+		}
 
-		inboundPerms, err := d.configureSecurityGroupPermissions(group)
-		if err != nil {
+		if err := d.authorizeSecurityGroup(group); err != nil {
 			return err
 		}
+	}
 
-		if len(inboundPerms) != 0 {
-			log.Debugf("authorizing group %s with inbound permissions: %v", groupNames, inboundPerms)
-			_, err := d.getClient().AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
-				GroupId:       group.GroupId,
-				IpPermissions: inboundPerms,
-			})
-			if err != nil && !strings.Contains(err.Error(), "already exists") {
+	return nil
+}
+
+// configureExistingSecurityGroups looks up the --outscale-security-group-id
+// groups, which must belong to the Net of the machine, and adds them the
+// missing rules like to the groups given by name
+func (d *Driver) configureExistingSecurityGroups(groupIds []string) error {
+	if len(groupIds) == 0 {
+		return nil
+	}
+	defer d.lockSecurityGroups()()
+
+	groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: makePointerSlice(groupIds),
+	})
+	if err != nil {
+		return err
+	}
+	groupsById := make(map[string]*ec2.SecurityGroup)
+	for _, group := range groups {
+		groupsById[aws.StringValue(group.GroupId)] = group
+	}
+
+	for _, id := range groupIds {
+		group, ok := groupsById[id]
+		if !ok {
+			return fmt.Errorf("security group %s not found", id)
+		}
+		if vpcId := aws.StringValue(group.VpcId); vpcId != d.VpcId {
+			return fmt.Errorf("security group %s belongs to %s, not to the machine Net %s", id, vpcId, d.VpcId)
+		}
+		log.Debugf("found security group %s (%s) in %s", id, aws.StringValue(group.GroupName), d.VpcId)
+		d.SecurityGroupIds = appendUnique(d.SecurityGroupIds, id)
+
+		if err := d.authorizeSecurityGroup(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorizeSecurityGroup adds the rules group misses, tolerating the
+// drivers adding them at the same time
+func (d *Driver) authorizeSecurityGroup(group *ec2.SecurityGroup) error {
+	if d.isSyncedSecurityGroup(group) {
+		return d.retryConcurrentRules(group, func(group *ec2.SecurityGroup) error {
+			_, _, err := d.syncSecurityGroup(group)
+			return err
+		})
+	}
+
+	_, err := d.authorizeMissingRules(group)
+	return err
+}
+
+// reconcileSecurityGroups adds the rules the instance security groups are
+// missing, e.g. defaults introduced by a driver upgrade, and returns the
+// added rules. With --outscale-sync-security-group, the stale rules of the
+// machine own group are revoked too.
+func (d *Driver) reconcileSecurityGroups() ([]string, error) {
+	groupIds := d.securityGroupIds()
+	if len(groupIds) == 0 {
+		return nil, nil
+	}
+	defer d.lockSecurityGroups()()
+
+	groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: makePointerSlice(groupIds),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	added := []string{}
+	for _, group := range groups {
+		if d.isSyncedSecurityGroup(group) {
+			err := d.retryConcurrentRules(group, func(group *ec2.SecurityGroup) error {
+				_, authorized, err := d.syncSecurityGroup(group)
+				for _, rule := range authorized {
+					added = append(added, fmt.Sprintf("%s: %s", *group.GroupId, rule))
+				}
 				return err
+			})
+			if err != nil {
+				return added, err
 			}
+			continue
+		}
+
+		inboundPerms, err := d.authorizeMissingRules(group)
+		if err != nil {
+			return added, err
 		}
 
+		for _, perm := range inboundPerms {
+			rule := fmt.Sprintf("%s: %s", *group.GroupId, describePermission(perm))
+			log.Infof("Added missing security group rule %s", rule)
+			added = append(added, rule)
+		}
 	}
 
-	return nil
+	return added, nil
 }
 
-func (d *Driver) configureSecurityGroupPermissions(group *ec2.SecurityGroup) ([]*ec2.IpPermission, error) {
-	hasPortsInbound := make(map[string]bool)
-	for _, p := range group.IpPermissions {
-		if p.FromPort != nil {
-			hasPortsInbound[fmt.Sprintf("%d/%s", *p.FromPort, *p.IpProtocol)] = true
-		}
+// reconcileSecurityGroupsOnce reconciles the security groups of the machine
+// for the running command, a failure only being reported
+func (d *Driver) reconcileSecurityGroupsOnce() {
+	d.securityGroupsReconciled = true
+	if _, err := d.reconcileSecurityGroups(); err != nil {
+		log.Warnf("Unable to reconcile security group rules: %s", err)
+	}
+}
+
+// describePermission formats a rule as PORT[-PORT]/PROTO from SOURCES
+func describePermission(perm *ec2.IpPermission) string {
+	ports := fmt.Sprintf("%d", aws.Int64Value(perm.FromPort))
+	if aws.Int64Value(perm.ToPort) != aws.Int64Value(perm.FromPort) {
+		ports += fmt.Sprintf("-%d", aws.Int64Value(perm.ToPort))
+	}
+
+	sources := []string{}
+	for _, r := range perm.IpRanges {
+		sources = append(sources, aws.StringValue(r.CidrIp))
+	}
+	for _, r := range perm.Ipv6Ranges {
+		sources = append(sources, aws.StringValue(r.CidrIpv6))
 	}
+	for _, pair := range perm.UserIdGroupPairs {
+		sources = append(sources, aws.StringValue(pair.GroupId))
+	}
+
+	return fmt.Sprintf("%s/%s from %s", ports, aws.StringValue(perm.IpProtocol), strings.Join(sources, ","))
+}
 
+// configureSecurityGroupPermissions returns the generated rules group is
+// missing. The rules are compared per source, a rule of the group opening
+// the same ports to another source not preventing the generated one.
+func (d *Driver) configureSecurityGroupPermissions(group *ec2.SecurityGroup) ([]*ec2.IpPermission, error) {
+	if d.isClusterSecurityGroup(group) {
+		return nil, nil
+	}
 	inboundPerms := []*ec2.IpPermission{}
 
-	if !hasPortsInbound["22/tcp"] {
+	if d.securityGroupRules() != securityGroupRulesNone {
 		inboundPerms = append(inboundPerms, &ec2.IpPermission{
 			IpProtocol: aws.String("tcp"),
 			FromPort:   aws.Int64(22),
 			ToPort:     aws.Int64(22),
-			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
+			IpRanges:   d.allowedIpRanges(d.SSHSourceCidrs),
 		})
-	}
 
-	if !hasPortsInbound[fmt.Sprintf("%d/tcp", dockerPort)] {
 		inboundPerms = append(inboundPerms, &ec2.IpPermission{
 			IpProtocol: aws.String("tcp"),
 			FromPort:   aws.Int64(int64(dockerPort)),
 			ToPort:     aws.Int64(int64(dockerPort)),
-			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
+			IpRanges:   d.allowedIpRanges(d.DockerSourceCidrs),
 		})
 	}
 
-	// we are only adding custom ports when the group is rancher-nodes
-	if *group.GroupName == defaultSecurityGroup && hasTagKey(group.Tags, machineSecurityGroupName) {
-		// kubeapi
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", kubeApiPort)] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(kubeApiPort)),
-				ToPort:     aws.Int64(int64(kubeApiPort)),
-				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
-			})
+	// we are only adding custom ports when the group is rancher-nodes, or
+	// the machine own group
+	if d.isNodesSecurityGroup(group) {
+		for _, rule := range nodeRulePresets[d.securityGroupRules()] {
+			if rule.role != "" && !d.groupHasNodeRole(group, rule.role) {
+				continue
+			}
+			perm := &ec2.IpPermission{
+				IpProtocol: aws.String(rule.protocol),
+				FromPort:   aws.Int64(rule.ports[0]),
+				ToPort:     aws.Int64(rule.ports[1]),
+			}
+			if rule.peers {
+				perm.UserIdGroupPairs = []*ec2.UserIdGroupPair{{GroupId: d.peersGroupId(group)}}
+			} else {
+				perm.IpRanges = d.allowedIpRanges(nil)
+			}
+			inboundPerms = append(inboundPerms, perm)
 		}
+	}
 
-		// etcd
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", etcdPorts[0])] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(etcdPorts[0])),
-				ToPort:     aws.Int64(int64(etcdPorts[1])),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: group.GroupId,
-					},
-				},
-			})
+	for _, p := range d.OpenPorts {
+		port, err := parseOpenPort(p)
+		if err != nil {
+			return nil, err
 		}
+		inboundPerms = append(inboundPerms, &ec2.IpPermission{
+			IpProtocol: aws.String(port.Protocol),
+			FromPort:   aws.Int64(port.FromPort),
+			ToPort:     aws.Int64(port.ToPort),
+			IpRanges:   d.allowedIpRanges(port.Cidrs),
+		})
+	}
 
-		// vxlan
-		if !hasPortsInbound[fmt.Sprintf("%d/udp", vxlanPorts[0])] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("udp"),
-				FromPort:   aws.Int64(int64(vxlanPorts[0])),
-				ToPort:     aws.Int64(int64(vxlanPorts[1])),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: group.GroupId,
-					},
-				},
-			})
-		}
+	d.addIPv6Ranges(inboundPerms)
 
-		// flannel
-		if !hasPortsInbound[fmt.Sprintf("%d/udp", flannelPorts[0])] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("udp"),
-				FromPort:   aws.Int64(int64(flannelPorts[0])),
-				ToPort:     aws.Int64(int64(flannelPorts[1])),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: group.GroupId,
-					},
-				},
-			})
+	// a rule without any source would be rejected, it is left out
+	opened := inboundPerms[:0]
+	for _, perm := range inboundPerms {
+		if len(perm.IpRanges) > 0 || len(perm.Ipv6Ranges) > 0 || len(perm.UserIdGroupPairs) > 0 {
+			opened = append(opened, perm)
 		}
+	}
+	markGeneratedPermissions(opened, d.generatedRuleMarker())
 
-		// others
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", otherKubePorts[0])] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(otherKubePorts[0])),
-				ToPort:     aws.Int64(int64(otherKubePorts[1])),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: group.GroupId,
-					},
-				},
-			})
-		}
+	log.Debugf("configuring security group authorization for %s", strings.Join(d.allowedCidrs(), ","))
 
-		// kube proxy
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", kubeProxyPorts[0])] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(kubeProxyPorts[0])),
-				ToPort:     aws.Int64(int64(kubeProxyPorts[1])),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: group.GroupId,
-					},
-				},
-			})
-		}
+	return missingSourcePermissions(group, opened), nil
+}
 
-		// node exporter
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", nodeExporter)] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(nodeExporter)),
-				ToPort:     aws.Int64(int64(nodeExporter)),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: group.GroupId,
-					},
-				},
-			})
+// addIPv6Ranges moves the IPv6 CIDRs of the rules to their IPv6 ranges and,
+// with --outscale-ipv6, opens to the whole IPv6 Internet the rules open to
+// the whole IPv4 Internet, but for the ICMP ones which don't apply to ICMPv6
+func (d *Driver) addIPv6Ranges(perms []*ec2.IpPermission) {
+	for _, perm := range perms {
+		var ranges []*ec2.IpRange
+		for _, r := range perm.IpRanges {
+			cidr := aws.StringValue(r.CidrIp)
+			if ip, _, err := net.ParseCIDR(cidr); err == nil && ip.To4() == nil {
+				perm.Ipv6Ranges = append(perm.Ipv6Ranges, &ec2.Ipv6Range{CidrIpv6: aws.String(cidr)})
+				continue
+			}
+			if cidr == ipRange && d.IPv6 && aws.StringValue(perm.IpProtocol) != "icmp" {
+				perm.Ipv6Ranges = append(perm.Ipv6Ranges, &ec2.Ipv6Range{CidrIpv6: aws.String(ipv6Range)})
+			}
+			ranges = append(ranges, r)
 		}
+		perm.IpRanges = ranges
+	}
+}
 
-		// nodePorts
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", nodePorts[0])] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(nodePorts[0])),
-				ToPort:     aws.Int64(int64(nodePorts[1])),
-				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
-			})
-		}
+// hasNodeRole reports whether the node has role, every node having all the
+// roles when --outscale-node-role is not set
+func (d *Driver) hasNodeRole(role string) bool {
+	return len(d.NodeRoles) == 0 || stringInSlice(role, d.NodeRoles)
+}
 
-		if !hasPortsInbound[fmt.Sprintf("%d/udp", nodePorts[0])] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("udp"),
-				FromPort:   aws.Int64(int64(nodePorts[0])),
-				ToPort:     aws.Int64(int64(nodePorts[1])),
-				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
-			})
+// groupHasNodeRole reports whether the rules of role go to group. The shared
+// group serves the nodes of all roles, only the machine own group is
+// restricted to the roles of the node.
+func (d *Driver) groupHasNodeRole(group *ec2.SecurityGroup, role string) bool {
+	if !d.SecurityGroupPerMachine || aws.StringValue(group.GroupName) != d.machineSecurityGroupName() {
+		return true
+	}
+	return d.hasNodeRole(role)
+}
+
+func validateNodeRoles(roles []string) error {
+	for _, role := range roles {
+		switch role {
+		case nodeRoleEtcd, nodeRoleControlPlane, nodeRoleWorker:
+		default:
+			return fmt.Errorf("invalid --outscale-node-role %q, expected %s, %s or %s", role, nodeRoleEtcd, nodeRoleControlPlane, nodeRoleWorker)
 		}
+	}
+	return nil
+}
 
-		// nginx ingress
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", httpPort)] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(httpPort)),
-				ToPort:     aws.Int64(int64(httpPort)),
-				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
-			})
+// openPort is an --outscale-open-port entry
+type openPort struct {
+	FromPort int64
+	ToPort   int64
+	Protocol string
+	Cidrs    []string
+}
+
+// parseOpenPort splits an --outscale-open-port entry of the form
+// PORT[-PORT][/PROTO][@CIDR[,CIDR...]], or [TYPE[:CODE]/]icmp[@CIDR...] for
+// an ICMP rule
+func parseOpenPort(value string) (*openPort, error) {
+	entry := value
+	port := &openPort{}
+	if i := strings.Index(value, "@"); i >= 0 {
+		var err error
+		if port.Cidrs, err = parseCIDRList(value[i+1:]); err != nil {
+			return nil, err
+		}
+		if len(port.Cidrs) == 0 {
+			return nil, fmt.Errorf("missing CIDR in open port %s", entry)
 		}
+		value = value[:i]
+	}
 
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", httpsPort)] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(httpsPort)),
-				ToPort:     aws.Int64(int64(httpsPort)),
-				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
-			})
+	if value == "icmp" {
+		value = "/icmp"
+	}
+	var ports string
+	ports, port.Protocol = driverutil.SplitPortProto(value)
+	switch port.Protocol {
+	case "tcp", "udp":
+	case "icmp":
+		return parseICMPType(port, ports, entry)
+	default:
+		return nil, fmt.Errorf("invalid protocol %s in open port %s, expected tcp, udp or icmp", port.Protocol, entry)
+	}
+
+	from, to := ports, ports
+	if i := strings.Index(ports, "-"); i >= 0 {
+		from, to = ports[:i], ports[i+1:]
+	}
+	var err error
+	if port.FromPort, err = parsePortNumber(from); err != nil {
+		return nil, err
+	}
+	if port.ToPort, err = parsePortNumber(to); err != nil {
+		return nil, err
+	}
+	if port.FromPort > port.ToPort {
+		return nil, fmt.Errorf("invalid port range %s in open port %s", ports, entry)
+	}
+	return port, nil
+}
+
+// parseICMPType sets the ICMP type and code of the rule, which the API takes
+// as its ports, -1 allowing them all
+func parseICMPType(port *openPort, value, entry string) (*openPort, error) {
+	port.FromPort, port.ToPort = -1, -1
+	if value == "" {
+		return port, nil
+	}
+
+	icmpType, code := value, ""
+	if i := strings.Index(value, ":"); i >= 0 {
+		icmpType, code = value[:i], value[i+1:]
+	}
+	var err error
+	if port.FromPort, err = strconv.ParseInt(icmpType, 10, 0); err != nil || port.FromPort < 0 || port.FromPort > 255 {
+		return nil, fmt.Errorf("invalid ICMP type %s in open port %s, expected 0-255", icmpType, entry)
+	}
+	if code == "" {
+		return port, nil
+	}
+	if port.ToPort, err = strconv.ParseInt(code, 10, 0); err != nil || port.ToPort < 0 || port.ToPort > 255 {
+		return nil, fmt.Errorf("invalid ICMP code %s in open port %s, expected 0-255", code, entry)
+	}
+	return port, nil
+}
+
+func parsePortNumber(port string) (int64, error) {
+	portNum, err := strconv.ParseInt(port, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port number %s: %s", port, err)
+	}
+	if portNum < 0 || portNum > 65535 {
+		return 0, fmt.Errorf("invalid port number %s: out of the 0-65535 range", port)
+	}
+	return portNum, nil
+}
+
+// parseCIDRList parses a comma-separated list of CIDRs
+func parseCIDRList(value string) ([]string, error) {
+	var cidrs []string
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %s", cidr, err)
 		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
 
-		// calico additional port: https://docs.projectcalico.org/getting-started/openstack/requirements#network-requirements
-		if !hasPortsInbound[fmt.Sprintf("%d/tcp", calicoPort)] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(int64(calicoPort)),
-				ToPort:     aws.Int64(int64(calicoPort)),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: group.GroupId,
-					},
-				},
-			})
+// allowedCidrs returns the --outscale-allowed-cidr CIDRs. The machines
+// created before the option was required have none, and their generated
+// rules stay closed rather than opened to the whole Internet
+func (d *Driver) allowedCidrs() []string {
+	return d.AllowedCidrs
+}
+
+// fallsBackToAllowedCidrs reports whether a generated rule lacks CIDRs of
+// its own, being opened to --outscale-allowed-cidr: the SSH and Docker rules
+// without --outscale-ssh-cidr and --outscale-docker-cidr, the node rules not
+// reserved to the peers and the open ports without @CIDR
+func (d *Driver) fallsBackToAllowedCidrs() bool {
+	preset := d.securityGroupRules()
+	if preset != securityGroupRulesNone && (len(d.SSHSourceCidrs) == 0 || len(d.DockerSourceCidrs) == 0) {
+		return true
+	}
+
+	if d.SecurityGroupPerMachine || stringInSlice(d.nodesSecurityGroupName(), d.SecurityGroupNames) {
+		for _, rule := range nodeRulePresets[preset] {
+			if !rule.peers && (!d.SecurityGroupPerMachine || rule.role == "" || d.hasNodeRole(rule.role)) {
+				return true
+			}
 		}
 	}
 
 	for _, p := range d.OpenPorts {
-		port, protocol := driverutil.SplitPortProto(p)
-		portNum, err := strconv.ParseInt(port, 10, 0)
-		if err != nil {
-			return nil, fmt.Errorf("invalid port number %s: %s", port, err)
-		}
-		if !hasPortsInbound[fmt.Sprintf("%s/%s", port, protocol)] {
-			inboundPerms = append(inboundPerms, &ec2.IpPermission{
-				IpProtocol: aws.String(protocol),
-				FromPort:   aws.Int64(portNum),
-				ToPort:     aws.Int64(portNum),
-				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
-			})
+		if port, err := parseOpenPort(p); err == nil && len(port.Cidrs) == 0 {
+			return true
 		}
 	}
+	return false
+}
 
-	log.Debugf("configuring security group authorization for %s", ipRange)
+// allowedIpRanges returns the IP ranges of a generated rule, its own CIDRs
+// taking precedence over --outscale-allowed-cidr
+func (d *Driver) allowedIpRanges(cidrs []string) []*ec2.IpRange {
+	if len(cidrs) == 0 {
+		cidrs = d.allowedCidrs()
+	}
+	return sourceIpRanges(cidrs)
+}
 
-	return inboundPerms, nil
+// sourceIpRanges returns one IP range per CIDR
+func sourceIpRanges(cidrs []string) []*ec2.IpRange {
+	ranges := make([]*ec2.IpRange, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		ranges = append(ranges, &ec2.IpRange{CidrIp: aws.String(cidr)})
+	}
+	return ranges
 }
 
 func (d *Driver) deleteKeyPair() error {
@@ -1317,13 +3732,17 @@ func (d *Driver) deleteKeyPair() error {
 
 	log.Debugf("deleting key pair: %s", d.KeyName)
 
-	instance, err := d.getInstance()
-	if err != nil {
-		return err
+	keyName := aws.String(d.KeyName)
+	if d.InstanceId != "" {
+		instance, err := d.getInstance()
+		if err != nil {
+			return err
+		}
+		keyName = instance.KeyName
 	}
 
-	_, err = d.getClient().DeleteKeyPair(&ec2.DeleteKeyPairInput{
-		KeyName: instance.KeyName,
+	_, err := d.getAPI().DeleteKeyPair(&ec2.DeleteKeyPairInput{
+		KeyName: keyName,
 	})
 	if err != nil {
 		return err
@@ -1347,6 +3766,111 @@ func (d *Driver) getDefaultVPCId() (string, error) {
 	return "", errors.New("No default-vpc attribute")
 }
 
+// findVpc resolves --outscale-vpc-name and --outscale-vpc-tag to the ID of
+// the single Net matching them
+func (d *Driver) findVpc() (string, error) {
+	filters, err := parseTagFilters("--outscale-vpc-tag", d.VpcTags)
+	if err != nil {
+		return "", err
+	}
+	if d.VpcName != "" {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("tag:Name"),
+			Values: []*string{aws.String(d.VpcName)},
+		})
+	}
+
+	vpcs, err := d.describeVpcs(&ec2.DescribeVpcsInput{
+		Filters: filters,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch len(vpcs) {
+	case 0:
+		return "", errors.New("no VPC matches --outscale-vpc-name/--outscale-vpc-tag")
+	case 1:
+		return *vpcs[0].VpcId, nil
+	}
+
+	ids := make([]string, 0, len(vpcs))
+	for _, vpc := range vpcs {
+		ids = append(ids, *vpc.VpcId)
+	}
+	return "", fmt.Errorf("several VPCs match --outscale-vpc-name/--outscale-vpc-tag: %s", strings.Join(ids, ", "))
+}
+
+// parseSubnetFilters turns the name=value entries of --outscale-subnet-filter
+// into API filters
+func parseSubnetFilters(values []string) ([]*ec2.Filter, error) {
+	filters := []*ec2.Filter{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid --outscale-subnet-filter %q, expected name=value like tag:Tier=nodes", value)
+		}
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String(parts[0]),
+			Values: []*string{aws.String(parts[1])},
+		})
+	}
+	return filters, nil
+}
+
+// parseTagFilters turns the key=value entries of a flag into tag filters
+func parseTagFilters(flag string, tags []string) ([]*ec2.Filter, error) {
+	filters := []*ec2.Filter{}
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid %s %q, expected key=value", flag, tag)
+		}
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("tag:" + parts[0]),
+			Values: []*string{aws.String(parts[1])},
+		})
+	}
+	return filters, nil
+}
+
+// findDefaultNet looks for the default Net when the default-vpc account
+// attribute is missing, as it is on some Outscale endpoints: the Net flagged
+// as default, else the one named "default", else the only Net of the account.
+func (d *Driver) findDefaultNet() (string, error) {
+	vpcs, err := d.describeVpcs(&ec2.DescribeVpcsInput{})
+	if err != nil {
+		return "", err
+	}
+
+	for _, vpc := range vpcs {
+		if aws.BoolValue(vpc.IsDefault) {
+			return *vpc.VpcId, nil
+		}
+	}
+
+	for _, vpc := range vpcs {
+		for _, tag := range vpc.Tags {
+			if aws.StringValue(tag.Key) == "Name" && aws.StringValue(tag.Value) == defaultNetName {
+				return *vpc.VpcId, nil
+			}
+		}
+	}
+
+	if len(vpcs) == 1 {
+		return *vpcs[0].VpcId, nil
+	}
+
+	ids := make([]string, 0, len(vpcs))
+	for _, vpc := range vpcs {
+		ids = append(ids, *vpc.VpcId)
+	}
+	if len(ids) == 0 {
+		return "", errors.New("no Net found, create one and pass it with --outscale-vpc-id")
+	}
+	return "", fmt.Errorf("no default Net among %s, pick one with --outscale-vpc-id", strings.Join(ids, ", "))
+}
+
 func (d *Driver) getRegionZone() string {
 	if d.Endpoint == "" {
 		return d.Region + d.Zone
@@ -1354,6 +3878,28 @@ func (d *Driver) getRegionZone() string {
 	return d.Zone
 }
 
+// parseExpireAfter parses a duration, also accepting a number of days
+// (e.g. 7d) on top of the time.ParseDuration units.
+func parseExpireAfter(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	var duration time.Duration
+	var err error
+	if strings.HasSuffix(value, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(value, "d"))
+		duration = time.Duration(days) * 24 * time.Hour
+	} else {
+		duration, err = time.ParseDuration(value)
+	}
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid --outscale-expire-after %q, expected a positive duration like 12h or 7d", value)
+	}
+	return duration, nil
+}
+
 func generateId() string {
 	rb := make([]byte, 10)
 	_, err := rand.Read(rb)
@@ -1366,15 +3912,57 @@ func generateId() string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func hasTagKey(tags []*ec2.Tag, key string) bool {
-	for _, tag := range tags {
-		if *tag.Key == key {
+func stringInSlice(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
 			return true
 		}
 	}
 	return false
 }
 
+// nodesSecurityGroupName is the group getting the node rules, rancher-nodes
+// unless --outscale-nodes-security-group is set
+func (d *Driver) nodesSecurityGroupName() string {
+	if d.NodesSecurityGroup == "" {
+		return defaultSecurityGroup
+	}
+	return d.NodesSecurityGroup
+}
+
+func (d *Driver) securityGroupDescription() string {
+	if d.SecurityGroupDescription == "" {
+		return defaultSecurityGroupDesc
+	}
+	return d.SecurityGroupDescription
+}
+
+// securityGroupTag is the tag set on the security groups the driver creates
+func (d *Driver) securityGroupTag() *ec2.Tag {
+	key := d.SecurityGroupTagKey
+	if key == "" {
+		key = machineTag
+	}
+	value := d.SecurityGroupTagValue
+	if value == "" {
+		value = version.Version
+	}
+	return &ec2.Tag{Key: aws.String(key), Value: aws.String(value)}
+}
+
+// isManagedSecurityGroup tells whether the group carries the management tag,
+// also matching its value when one is configured
+func (d *Driver) isManagedSecurityGroup(group *ec2.SecurityGroup) bool {
+	tag := d.securityGroupTag()
+	for _, t := range group.Tags {
+		if aws.StringValue(t.Key) != *tag.Key {
+			continue
+		}
+		return d.SecurityGroupTagValue == "" || aws.StringValue(t.Value) == *tag.Value
+	}
+	return false
+}
+
 func (d *Driver) updateBDMList() []*ec2.BlockDeviceMapping {
 	var bdmList []*ec2.BlockDeviceMapping
 
@@ -1383,11 +3971,20 @@ func (d *Driver) updateBDMList() []*ec2.BlockDeviceMapping {
 			if *bdm.DeviceName == d.DeviceName {
 				bdm.Ebs.VolumeSize = aws.Int64(d.RootSize)
 				bdm.Ebs.VolumeType = aws.String(d.VolumeType)
+				if d.VolumeType == volumeTypeIO1 && d.VolumeIops > 0 {
+					bdm.Ebs.Iops = aws.Int64(d.VolumeIops)
+				}
 			}
 			bdm.Ebs.DeleteOnTermination = aws.Bool(true)
 			bdmList = append(bdmList, bdm)
 		}
 	}
 
+	// validated by SetConfigFromFlags
+	specs, _ := d.extraVolumes()
+	for _, spec := range specs {
+		bdmList = append(bdmList, spec.blockDeviceMapping())
+	}
+
 	return bdmList
 }
@@ -2,9 +2,14 @@ package outscale
 
 import (
 	"github.com/docker/machine/version"
+	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"errors"
+	"fmt"
 	"reflect"
 
 	"io/ioutil"
@@ -14,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/docker/machine/commands/commandstest"
+	"github.com/docker/machine/libmachine/state"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -58,6 +64,7 @@ func TestConfigureSecurityGroupPermissionsSshOnly(t *testing.T) {
 			IpProtocol: aws.String("tcp"),
 			FromPort:   aws.Int64(int64(testSSHPort)),
 			ToPort:     aws.Int64(int64(testSSHPort)),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
 		},
 	}
 
@@ -76,6 +83,7 @@ func TestConfigureSecurityGroupPermissionsDockerOnly(t *testing.T) {
 			IpProtocol: aws.String("tcp"),
 			FromPort:   aws.Int64((testDockerPort)),
 			ToPort:     aws.Int64((testDockerPort)),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
 		},
 	}
 
@@ -94,11 +102,13 @@ func TestConfigureSecurityGroupPermissionsDockerAndSsh(t *testing.T) {
 			IpProtocol: aws.String("tcp"),
 			FromPort:   aws.Int64(testSSHPort),
 			ToPort:     aws.Int64(testSSHPort),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
 		},
 		{
 			IpProtocol: aws.String("tcp"),
 			FromPort:   aws.Int64(testDockerPort),
 			ToPort:     aws.Int64(testDockerPort),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
 		},
 	}
 
@@ -108,6 +118,63 @@ func TestConfigureSecurityGroupPermissionsDockerAndSsh(t *testing.T) {
 	assert.Empty(t, perms)
 }
 
+func TestConfigureSecurityGroupPermissionsComparesSources(t *testing.T) {
+	driver := NewTestDriver()
+	driver.AllowedCidrs = []string{"10.0.0.0/8"}
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-wide"),
+		GroupName: aws.String("monitoring"),
+		IpPermissions: []*ec2.IpPermission{
+			{
+				IpProtocol: aws.String("tcp"),
+				FromPort:   aws.Int64(testSSHPort),
+				ToPort:     aws.Int64(testSSHPort),
+				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
+			},
+			{
+				IpProtocol: aws.String("tcp"),
+				FromPort:   aws.Int64(testDockerPort),
+				ToPort:     aws.Int64(testDockerPort),
+				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/8"), Description: aws.String("added by hand")}},
+			},
+		},
+	}
+
+	// the SSH port open to the Internet doesn't prevent the restricted rule
+	perms, err := driver.configureSecurityGroupPermissions(group)
+	assert.NoError(t, err)
+	assert.Len(t, perms, 1)
+	assert.Equal(t, "22/tcp from 10.0.0.0/8", describePermission(perms[0]))
+}
+
+func TestSetConfigFromFlagsAllowedCidrOnlyWhenUsed(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"name":                          "test",
+			"outscale-region":               "us-east-2",
+			"outscale-zone":                 "us-east-2a",
+			"outscale-security-group-rules": securityGroupRulesNone,
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+
+	// the open port without CIDR falls back to --outscale-allowed-cidr
+	options.Data["outscale-open-port"] = []string{"8443/tcp"}
+	assert.Equal(t, errorMissingAllowedCidr, driver.SetConfigFromFlags(options))
+
+	options.Data["outscale-security-group-rules"] = securityGroupRulesMinimal
+	options.Data["outscale-ssh-cidr"] = "10.0.0.0/8"
+	options.Data["outscale-docker-cidr"] = "10.0.0.0/8"
+	options.Data["outscale-open-port"] = []string{"8443/tcp@192.168.0.0/16"}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+
+	// the k8s preset opens the kube-api and node ports to it
+	options.Data["outscale-security-group-rules"] = securityGroupRulesK8s
+	assert.Equal(t, errorMissingAllowedCidr, driver.SetConfigFromFlags(options))
+}
+
 func TestConfigureSecurityGroupPermissionsSkipReadOnly(t *testing.T) {
 	driver := NewTestDriver()
 	driver.SecurityGroupReadOnly = true
@@ -139,12 +206,14 @@ func TestConfigureSecurityGroupPermissionsOpenPortsSkipExisting(t *testing.T) {
 		{
 			IpProtocol: aws.String("tcp"),
 			FromPort:   aws.Int64(8888),
-			ToPort:     aws.Int64(testSSHPort),
+			ToPort:     aws.Int64(8888),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
 		},
 		{
 			IpProtocol: aws.String("tcp"),
 			FromPort:   aws.Int64(8080),
-			ToPort:     aws.Int64(testSSHPort),
+			ToPort:     aws.Int64(8080),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
 		},
 	}
 	driver.OpenPorts = []string{"8888/tcp", "8080/udp", "8080"}
@@ -155,6 +224,28 @@ func TestConfigureSecurityGroupPermissionsOpenPortsSkipExisting(t *testing.T) {
 	assert.Equal(t, aws.String("udp"), perms[2].IpProtocol)
 }
 
+func TestConfigureSecurityGroupPermissionsOpenPortRange(t *testing.T) {
+	driver := NewTestDriver()
+	driver.OpenPorts = []string{"30000-32767/tcp@10.0.0.0/8"}
+	perms, err := driver.configureSecurityGroupPermissions(securityGroupNoIpPermissions)
+
+	assert.NoError(t, err)
+	assert.Len(t, perms, 3)
+	assert.Equal(t, "30000-32767/tcp from 10.0.0.0/8", describePermission(perms[2]))
+}
+
+func TestConfigureSecurityGroupPermissionsOpenICMP(t *testing.T) {
+	driver := NewTestDriver()
+	driver.IPv6 = true
+	driver.OpenPorts = []string{"icmp@10.0.0.0/8", "3:4/icmp"}
+	perms, err := driver.configureSecurityGroupPermissions(securityGroupNoIpPermissions)
+
+	assert.NoError(t, err)
+	assert.Len(t, perms, 4)
+	assert.Equal(t, "-1/icmp from 10.0.0.0/8", describePermission(perms[2]))
+	assert.Equal(t, "3-4/icmp from 0.0.0.0/0", describePermission(perms[3]))
+}
+
 func TestConfigureSecurityGroupPermissionsInvalidOpenPorts(t *testing.T) {
 	driver := NewTestDriver()
 	driver.OpenPorts = []string{"2222/tcp", "abc1"}
@@ -164,6 +255,126 @@ func TestConfigureSecurityGroupPermissionsInvalidOpenPorts(t *testing.T) {
 	assert.Nil(t, perms)
 }
 
+func TestConfigureSecurityGroupPermissionsSourceCidrs(t *testing.T) {
+	driver := NewTestDriver()
+	driver.SSHSourceCidrs = []string{"10.0.0.0/8", "192.168.0.0/16"}
+	driver.OpenPorts = []string{"8443/tcp@10.0.0.0/8,172.16.0.0/12", "9090"}
+	perms, err := driver.configureSecurityGroupPermissions(securityGroupNoIpPermissions)
+
+	assert.NoError(t, err)
+	assert.Len(t, perms, 4)
+	assert.Len(t, perms[0].IpRanges, 2)
+	assert.Equal(t, aws.String("192.168.0.0/16"), perms[0].IpRanges[1].CidrIp)
+	assert.Equal(t, aws.String(ipRange), perms[1].IpRanges[0].CidrIp)
+	assert.Equal(t, aws.Int64(int64(8443)), perms[2].ToPort)
+	assert.Len(t, perms[2].IpRanges, 2)
+	assert.Equal(t, aws.String("172.16.0.0/12"), perms[2].IpRanges[1].CidrIp)
+	assert.Equal(t, aws.String(ipRange), perms[3].IpRanges[0].CidrIp)
+}
+
+func TestConfigureSecurityGroupPermissionsAllowedCidrs(t *testing.T) {
+	driver := NewTestDriver()
+	driver.AllowedCidrs = []string{"10.0.0.0/8", "2001:db8::/32"}
+	driver.DockerSourceCidrs = []string{"192.168.0.0/16"}
+	driver.OpenPorts = []string{"9090"}
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-allowed"),
+		GroupName: aws.String(defaultSecurityGroup),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}
+	perms, err := driver.configureSecurityGroupPermissions(group)
+
+	assert.NoError(t, err)
+	open := map[string]bool{}
+	for _, perm := range perms {
+		for _, r := range perm.IpRanges {
+			assert.NotEqual(t, ipRange, aws.StringValue(r.CidrIp))
+		}
+		open[describePermission(perm)] = true
+	}
+	// the other nodes reach the kube-api whatever --outscale-allowed-cidr
+	assert.True(t, open[fmt.Sprintf("%d/tcp from sg-allowed", kubeApiPort)])
+	assert.True(t, open["22/tcp from 10.0.0.0/8,2001:db8::/32"])
+	assert.True(t, open["2376/tcp from 192.168.0.0/16"])
+	assert.True(t, open[fmt.Sprintf("%d/tcp from 10.0.0.0/8,2001:db8::/32", kubeApiPort)])
+	assert.True(t, open[fmt.Sprintf("%d-%d/udp from 10.0.0.0/8,2001:db8::/32", nodePorts[0], nodePorts[1])])
+	assert.True(t, open["9090/tcp from 10.0.0.0/8,2001:db8::/32"])
+}
+
+func TestSetConfigFromFlagsInvalidAllowedCidr(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "us-east-2a",
+			"outscale-allowed-cidr": []string{"10.0.0.0/8", "192.168.0.0"},
+		},
+	}
+
+	err := driver.SetConfigFromFlags(options)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid --outscale-allowed-cidr "192.168.0.0"`)
+}
+
+func TestSetConfigFromFlagsRequiresAllowedCidr(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"name":            "test",
+			"outscale-region": "us-east-2",
+			"outscale-zone":   "us-east-2a",
+		},
+	}
+
+	assert.Equal(t, errorMissingAllowedCidr, driver.SetConfigFromFlags(options))
+
+	options.Data["outscale-allowed-cidr"] = []string{ipRange}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.Equal(t, []string{ipRange}, driver.allowedCidrs())
+
+	// machines stored before the option was required don't open their
+	// generated rules
+	driver.AllowedCidrs = nil
+	assert.Empty(t, driver.allowedCidrs())
+	perms, err := driver.configureSecurityGroupPermissions(&ec2.SecurityGroup{GroupName: aws.String("monitoring")})
+	assert.NoError(t, err)
+	assert.Empty(t, perms)
+}
+
+func TestParseOpenPortInvalidCidr(t *testing.T) {
+	_, err := parseOpenPort("8443/tcp@10.0.0.0")
+	assert.Error(t, err)
+
+	_, err = parseOpenPort("8443/tcp@")
+	assert.Error(t, err)
+}
+
+func TestParseOpenPortRange(t *testing.T) {
+	port, err := parseOpenPort("30000-32767/udp@10.0.0.0/8")
+	assert.NoError(t, err)
+	assert.Equal(t, &openPort{FromPort: 30000, ToPort: 32767, Protocol: "udp", Cidrs: []string{"10.0.0.0/8"}}, port)
+
+	port, err = parseOpenPort("8443")
+	assert.NoError(t, err)
+	assert.Equal(t, &openPort{FromPort: 8443, ToPort: 8443, Protocol: "tcp"}, port)
+
+	port, err = parseOpenPort("icmp")
+	assert.NoError(t, err)
+	assert.Equal(t, &openPort{FromPort: -1, ToPort: -1, Protocol: "icmp"}, port)
+
+	port, err = parseOpenPort("8/icmp@192.168.0.0/16")
+	assert.NoError(t, err)
+	assert.Equal(t, &openPort{FromPort: 8, ToPort: -1, Protocol: "icmp", Cidrs: []string{"192.168.0.0/16"}}, port)
+
+	for _, value := range []string{"32767-30000/tcp", "30000-/tcp", "8443-70000", "8443/sctp", "echo/icmp", "3:300/icmp"} {
+		_, err := parseOpenPort(value)
+		assert.Error(t, err, value)
+	}
+}
+
 func TestValidateAwsRegionValid(t *testing.T) {
 	regions := []string{"eu-west-1", "eu-central-1"}
 
@@ -218,9 +429,10 @@ func TestGetRegionZoneForDefaultEndpoint(t *testing.T) {
 	driver.awsCredentialsFactory = NewValidAwsCredentials
 	options := &commandstest.FakeFlagger{
 		Data: map[string]interface{}{
-			"name":            "test",
-			"outscale-region": "us-east-2",
-			"outscale-zone":   "us-east-2a",
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "us-east-2a",
 		},
 	}
 
@@ -237,10 +449,11 @@ func TestGetRegionZoneForCustomEndpoint(t *testing.T) {
 	driver.awsCredentialsFactory = NewValidAwsCredentials
 	options := &commandstest.FakeFlagger{
 		Data: map[string]interface{}{
-			"name":               "test",
-			"outscale-endpoint": "https://someurl",
-			"outscale-region":   "custom-endpoint",
-			"outscale-zone":     "custom-zone",
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-endpoint":     "https://someurl",
+			"outscale-region":       "custom-endpoint",
+			"outscale-zone":         "custom-zone",
 		},
 	}
 
@@ -272,9 +485,10 @@ func TestAwsCredentialsAreRequired(t *testing.T) {
 
 	options := &commandstest.FakeFlagger{
 		Data: map[string]interface{}{
-			"name":             "test",
-			"outscale-region": "us-east-2",
-			"outscale-zone":   "us-east-2a",
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "us-east-2a",
 		},
 	}
 
@@ -287,9 +501,10 @@ func TestValidAwsCredentialsAreAccepted(t *testing.T) {
 	driver.awsCredentialsFactory = NewValidAwsCredentials
 	options := &commandstest.FakeFlagger{
 		Data: map[string]interface{}{
-			"name":             "test",
-			"outscale-region": "us-east-2",
-			"outscale-zone":   "us-east-2a",
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "us-east-2a",
 		},
 	}
 
@@ -302,11 +517,12 @@ func TestEndpointIsMandatoryWhenSSLDisabled(t *testing.T) {
 	driver.awsCredentialsFactory = NewValidAwsCredentials
 	options := &commandstest.FakeFlagger{
 		Data: map[string]interface{}{
-			"name":                         "test",
+			"outscale-allowed-cidr":       []string{ipRange},
+			"name":                        "test",
 			"outscale-access-key":         "foobar",
 			"outscale-region":             "us-east-2",
 			"outscale-zone":               "us-east-2a",
-			"amazonec2-insecure-transport": true,
+			"outscale-insecure-transport": true,
 		},
 	}
 
@@ -347,6 +563,11 @@ var securityGroupNameTests = []struct {
 	{groupName: "bob", groupNames: []string{"bill"}, expected: []string{"bob", "bill"}},
 }
 
+func TestOrderSecurityGroups(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, orderSecurityGroups([]string{"a", "b", "a", "c"}, ""))
+	assert.Equal(t, []string{"c", "a", "b"}, orderSecurityGroups([]string{"a", "b", "c"}, "c"))
+}
+
 func TestMergeSecurityGroupName(t *testing.T) {
 	for _, tt := range securityGroupNameTests {
 		d := Driver{SecurityGroupName: tt.groupName, SecurityGroupNames: tt.groupNames}
@@ -390,7 +611,7 @@ func ipPermission(port int64) *ec2.IpPermission {
 		FromPort:   aws.Int64(port),
 		ToPort:     aws.Int64(port),
 		IpProtocol: aws.String("tcp"),
-		IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
+		IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange), Description: aws.String(NewTestDriver().generatedRuleMarker())}},
 	}
 }
 
@@ -469,6 +690,55 @@ func TestConfigureSecurityGroupsMixed(t *testing.T) {
 	recorder.AssertExpectations(t)
 }
 
+func TestConfigureExistingSecurityGroups(t *testing.T) {
+	recorder := fakeEC2SecurityGroupTestRecorder{}
+	recorder.On("DescribeSecurityGroups", &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String("sg-12345")},
+	}).Return(&ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+		{
+			GroupName:     aws.String("nodes"),
+			GroupId:       aws.String("sg-12345"),
+			VpcId:         aws.String("vpc-12345"),
+			IpPermissions: []*ec2.IpPermission{ipPermission(testSSHPort)},
+		},
+	}}, nil)
+	recorder.On("AuthorizeSecurityGroupIngress", &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String("sg-12345"),
+		IpPermissions: []*ec2.IpPermission{ipPermission(testDockerPort)},
+	}).Return(&ec2.AuthorizeSecurityGroupIngressOutput{}, nil)
+
+	driver := NewCustomTestDriver(&recorder)
+	driver.VpcId = "vpc-12345"
+	assert.NoError(t, driver.configureExistingSecurityGroups([]string{"sg-12345"}))
+	assert.Equal(t, []string{"sg-12345"}, driver.SecurityGroupIds)
+	recorder.AssertExpectations(t)
+
+	driver.VpcId = "vpc-other"
+	assert.EqualError(t, driver.configureExistingSecurityGroups([]string{"sg-12345"}), "security group sg-12345 belongs to vpc-12345, not to the machine Net vpc-other")
+}
+
+func TestSetConfigFromFlagsSecurityGroupId(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":      []string{ipRange},
+			"name":                       "test",
+			"outscale-region":            "us-east-2",
+			"outscale-zone":              "us-east-2a",
+			"outscale-security-group":    []string{defaultSecurityGroup},
+			"outscale-security-group-id": []string{"sg-12345"},
+		},
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.Empty(t, driver.securityGroupNames())
+	assert.Equal(t, []string{"sg-12345"}, driver.ExistingSecurityGroupIds)
+
+	options.Data["outscale-security-group-id"] = []string{"nodes"}
+	assert.EqualError(t, driver.SetConfigFromFlags(options), `invalid --outscale-security-group-id "nodes", expected a security group ID like sg-12345678`)
+}
+
 func TestConfigureSecurityGroupsErrLookupExist(t *testing.T) {
 	groups := []string{"group"}
 	recorder := fakeEC2SecurityGroupTestRecorder{}
@@ -557,3 +827,1019 @@ func TestInvalidAMI(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+func TestFindExistingInstanceNone(t *testing.T) {
+	fake := &fakeEC2WithInstances{}
+	driver := NewCustomTestDriver(fake)
+	driver.MachineName = "cluster-node1"
+
+	instance, err := driver.findExistingInstance()
+
+	assert.NoError(t, err)
+	assert.Nil(t, instance)
+	assert.Equal(t, "tag:Name", *fake.input.Filters[0].Name)
+	assert.Equal(t, "cluster-node1", *fake.input.Filters[0].Values[0])
+	assert.Equal(t, "tag:OscK8sClusterID/cluster", *fake.input.Filters[1].Name)
+}
+
+func TestFindExistingInstanceFound(t *testing.T) {
+	fake := &fakeEC2WithInstances{
+		reservations: []*ec2.Reservation{
+			{Instances: []*ec2.Instance{{InstanceId: aws.String("i-12345")}}},
+		},
+	}
+	driver := NewCustomTestDriver(fake)
+
+	instance, err := driver.findExistingInstance()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "i-12345", *instance.InstanceId)
+}
+
+func TestFindExistingAddress(t *testing.T) {
+	fake := &fakeEC2WithInstances{
+		addresses: []*ec2.Address{
+			{
+				AllocationId:  aws.String("eipalloc-1"),
+				AssociationId: aws.String("eipassoc-1"),
+				PublicIp:      aws.String("1.2.3.4"),
+			},
+		},
+	}
+	driver := NewCustomTestDriver(fake)
+	driver.InstanceId = "i-12345"
+
+	err := driver.findExistingAddress()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "eipalloc-1", driver.AllocationId)
+	assert.Equal(t, "eipassoc-1", driver.AssociationId)
+	assert.Equal(t, "1.2.3.4", driver.PublicIp)
+}
+
+func TestClusterName(t *testing.T) {
+	driver := NewTestDriver()
+
+	driver.MachineName = "mycluster-pool1-abcde"
+	assert.Equal(t, "mycluster", driver.clusterName())
+
+	driver.MachineName = "standalone"
+	assert.Equal(t, "standalone", driver.clusterName())
+}
+
+func TestUserAgent(t *testing.T) {
+	driver := NewTestDriver()
+
+	assert.Equal(t, "docker-machine-driver-outscale/"+Version, driver.userAgent())
+
+	driver.UserAgentSuffix = "rancher/2.5"
+	assert.Equal(t, "docker-machine-driver-outscale/"+Version+" rancher/2.5", driver.userAgent())
+}
+
+func TestExtractRequestID(t *testing.T) {
+	xmlBody := []byte(`<Response><Errors><Error><Code>InvalidAMIID.NotFound</Code></Error></Errors><RequestId>b8ba4fa2-7c4d</RequestId></Response>`)
+	assert.Equal(t, "b8ba4fa2-7c4d", extractRequestID(xmlBody))
+
+	jsonBody := []byte(`{"Errors":[{"Code":"5021"}],"ResponseContext":{"RequestId":"0475ca1e-d0c5"}}`)
+	assert.Equal(t, "0475ca1e-d0c5", extractRequestID(jsonBody))
+
+	assert.Empty(t, extractRequestID([]byte("<Response></Response>")))
+}
+
+func TestCallGroupCoalescesConcurrentCalls(t *testing.T) {
+	var group callGroup
+	var calls int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := group.Do("i-12345", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "running", nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "running", val)
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestGetInstanceReusesRecentLookup(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{InstanceId: aws.String("i-12345")}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+
+	_, err := driver.getInstance()
+	assert.NoError(t, err)
+	assert.NotNil(t, client.input)
+
+	client.input = nil
+	_, err = driver.getInstance()
+	assert.NoError(t, err)
+	assert.Nil(t, client.input)
+
+	driver.instanceCache.forget()
+	_, err = driver.getInstance()
+	assert.NoError(t, err)
+	assert.NotNil(t, client.input)
+}
+
+func TestTokenBucketLimitsRate(t *testing.T) {
+	bucket := newTokenBucket(20, 1)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		bucket.Wait()
+	}
+
+	// the first call uses the burst token, the 4 others wait 50ms each
+	assert.True(t, time.Since(start) >= 190*time.Millisecond)
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter := &rateLimiter{}
+	limiter.configure(0, defaultAPIRateBurst)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		limiter.wait()
+	}
+
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+}
+
+func TestSSHAvailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_8.0\r\n"))
+			conn.Close()
+		}
+	}()
+
+	assert.True(t, sshAvailable(listener.Addr().String(), time.Second))
+}
+
+func TestSSHUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	assert.False(t, sshAvailable(address, time.Second))
+}
+
+func TestTagResource(t *testing.T) {
+	recorder := &fakeEC2TagRecorder{}
+	driver := NewCustomTestDriver(recorder)
+	driver.MachineName = "mycluster-node1"
+
+	driver.tagResource("eipalloc-12345")
+
+	assert.Len(t, recorder.inputs, 1)
+	assert.Equal(t, "eipalloc-12345", *recorder.inputs[0].Resources[0])
+	assert.Equal(t, []*ec2.Tag{
+		{Key: aws.String("Name"), Value: aws.String("mycluster-node1")},
+		{Key: aws.String("OscK8sClusterID/mycluster"), Value: aws.String("owned")},
+	}, recorder.inputs[0].Tags)
+}
+
+func TestParseExpireAfter(t *testing.T) {
+	duration, err := parseExpireAfter("12h")
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, duration)
+
+	duration, err = parseExpireAfter("7d")
+	assert.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, duration)
+
+	duration, err = parseExpireAfter("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), duration)
+
+	_, err = parseExpireAfter("soon")
+	assert.Error(t, err)
+
+	_, err = parseExpireAfter("-1h")
+	assert.Error(t, err)
+}
+
+func TestConfigureTagsWithExpiry(t *testing.T) {
+	recorder := &fakeEC2TagRecorder{}
+	driver := NewCustomTestDriver(recorder)
+	driver.MachineName = "mycluster-node1"
+	driver.InstanceId = "i-12345"
+	driver.ExpireAfter = "1d"
+
+	err := driver.configureTags("")
+
+	assert.NoError(t, err)
+	expiresAt, err := time.Parse(time.RFC3339, driver.ExpiresAt)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), expiresAt, time.Minute)
+	assert.Contains(t, recorder.inputs[0].Tags, &ec2.Tag{Key: aws.String(expiresAtTag), Value: aws.String(driver.ExpiresAt)})
+}
+
+func TestExplicitSecurityGroupRejectsDefault(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"name":                             "test",
+			"outscale-region":                  "us-east-2",
+			"outscale-zone":                    "us-east-2a",
+			"outscale-security-group":          []string{defaultSecurityGroup},
+			"outscale-explicit-security-group": true,
+		},
+	}
+
+	err := driver.SetConfigFromFlags(options)
+
+	assert.Equal(t, errorImplicitSecurityGroup, err)
+}
+
+func TestSetConfigFromFlagsNodesSecurityGroup(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":               []string{ipRange},
+			"name":                                "test",
+			"outscale-region":                     "us-east-2",
+			"outscale-zone":                       "us-east-2a",
+			"outscale-security-group":             []string{defaultSecurityGroup},
+			"outscale-nodes-security-group":       "docker-hosts",
+			"outscale-security-group-description": "Docker hosts",
+		},
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.Equal(t, []string{"docker-hosts"}, driver.securityGroupNames())
+	assert.Equal(t, "Docker hosts", driver.securityGroupDescription())
+	assert.True(t, driver.isNodesSecurityGroup(&ec2.SecurityGroup{
+		GroupName: aws.String("docker-hosts"),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}))
+
+	options.Data["outscale-explicit-security-group"] = true
+	assert.Equal(t, errorImplicitSecurityGroup, driver.SetConfigFromFlags(options))
+}
+
+func TestExplicitSecurityGroupDoesNotCreate(t *testing.T) {
+	groups := []string{"missingGroup"}
+	recorder := fakeEC2SecurityGroupTestRecorder{}
+	recorder.On("DescribeSecurityGroups", mock.MatchedBy(matchGroupLookup(groups))).Return(
+		&ec2.DescribeSecurityGroupsOutput{}, nil)
+
+	driver := NewCustomTestDriver(&recorder)
+	driver.ExplicitSecurityGroup = true
+	err := driver.configureSecurityGroups(groups)
+
+	assert.Error(t, err)
+	recorder.AssertExpectations(t)
+	recorder.AssertNotCalled(t, "CreateSecurityGroup", mock.Anything)
+}
+
+func TestParseSubnetMap(t *testing.T) {
+	subnets, err := parseSubnetMap("a=subnet-111, b=subnet-222")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "subnet-111", "b": "subnet-222"}, subnets)
+
+	_, err = parseSubnetMap("a=subnet-111,b")
+	assert.Error(t, err)
+}
+
+func TestSubnetForZone(t *testing.T) {
+	driver := NewTestDriver()
+	driver.Region = "eu-west-2"
+	driver.SubnetMap = "a=subnet-111,eu-west-2b=subnet-222"
+
+	subnet, err := driver.subnetForZone("eu-west-2a")
+	assert.NoError(t, err)
+	assert.Equal(t, "subnet-111", subnet)
+
+	subnet, err = driver.subnetForZone("eu-west-2b")
+	assert.NoError(t, err)
+	assert.Equal(t, "subnet-222", subnet)
+
+	_, err = driver.subnetForZone("eu-west-2c")
+	assert.Error(t, err)
+}
+
+func TestParseRetryMaxElapsed(t *testing.T) {
+	duration, err := parseRetryMaxElapsed("10m")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, duration)
+
+	duration, err = parseRetryMaxElapsed("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), duration)
+
+	_, err = parseRetryMaxElapsed("-1m")
+	assert.Error(t, err)
+}
+
+func TestReconcileSecurityGroups(t *testing.T) {
+	recorder := fakeEC2SecurityGroupTestRecorder{}
+
+	group := &ec2.SecurityGroup{
+		GroupName:     aws.String("test-group"),
+		GroupId:       aws.String("existingGroupId"),
+		IpPermissions: []*ec2.IpPermission{ipPermission(testSSHPort)},
+	}
+
+	recorder.On("DescribeSecurityGroups", &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String("existingGroupId")},
+	}).Return(&ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []*ec2.SecurityGroup{group},
+	}, nil)
+	recorder.On("AuthorizeSecurityGroupIngress", mock.MatchedBy(func(input *ec2.AuthorizeSecurityGroupIngressInput) bool {
+		return *input.GroupId == "existingGroupId" && len(input.IpPermissions) == 1
+	})).Return(&ec2.AuthorizeSecurityGroupIngressOutput{}, nil)
+
+	driver := NewCustomTestDriver(&recorder)
+	driver.SecurityGroupIds = []string{"existingGroupId"}
+	added, err := driver.reconcileSecurityGroups()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"existingGroupId: 2376/tcp from 0.0.0.0/0"}, added)
+	recorder.AssertExpectations(t)
+}
+
+func TestDescribePermission(t *testing.T) {
+	perm := &ec2.IpPermission{
+		IpProtocol:       aws.String("udp"),
+		FromPort:         aws.Int64(8472),
+		ToPort:           aws.Int64(8473),
+		UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-1")}},
+	}
+	assert.Equal(t, "8472-8473/udp from sg-1", describePermission(perm))
+}
+
+func TestFindDefaultNet(t *testing.T) {
+	tests := []struct {
+		name  string
+		vpcs  []*ec2.Vpc
+		vpcId string
+	}{
+		{"flagged default", []*ec2.Vpc{{VpcId: aws.String("vpc-1")}, {VpcId: aws.String("vpc-2"), IsDefault: aws.Bool(true)}}, "vpc-2"},
+		{"named default", []*ec2.Vpc{{VpcId: aws.String("vpc-1")}, {VpcId: aws.String("vpc-2"), Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("default")}}}}, "vpc-2"},
+		{"single net", []*ec2.Vpc{{VpcId: aws.String("vpc-1")}}, "vpc-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewCustomTestDriver(&fakeEC2WithVpcs{vpcs: tt.vpcs})
+			vpcId, err := driver.findDefaultNet()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.vpcId, vpcId)
+		})
+	}
+}
+
+func TestFindDefaultNetAmbiguous(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithVpcs{vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc-1")}, {VpcId: aws.String("vpc-2")}}})
+	_, err := driver.findDefaultNet()
+	assert.EqualError(t, err, "no default Net among vpc-1, vpc-2, pick one with --outscale-vpc-id")
+}
+
+func TestSetConfigFromFlagsFallsBackToDefaultNet(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithVpcs{
+		fakeEC2WithDescribe: &fakeEC2WithDescribe{err: errors.New("Not Found")},
+		vpcs:                []*ec2.Vpc{{VpcId: aws.String("vpc-1")}},
+	})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "us-east-2a",
+		},
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.Equal(t, "vpc-1", driver.VpcId)
+}
+
+func TestExpandEndpoint(t *testing.T) {
+	endpoint, err := expandEndpoint("https://fcu.{region}.outscale.com", "eu-west-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://fcu.eu-west-2.outscale.com", endpoint)
+
+	endpoint, err = expandEndpoint("https://fcu.us-east-2.outscale.com", "eu-west-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://fcu.us-east-2.outscale.com", endpoint)
+
+	_, err = expandEndpoint("https://fcu.{region}.outscale.com", "")
+	assert.Error(t, err)
+}
+
+func TestSetConfigFromFlagsExpandsEndpointRegion(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-endpoint":     "https://fcu.{region}.outscale.com",
+			"outscale-region":       "eu-west-2",
+			"outscale-zone":         "eu-west-2a",
+		},
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.Equal(t, "https://fcu.eu-west-2.outscale.com", driver.Endpoint)
+}
+
+func TestSecurityGroupTag(t *testing.T) {
+	driver := NewTestDriver()
+	tag := driver.securityGroupTag()
+	assert.Equal(t, machineTag, *tag.Key)
+	assert.Equal(t, version.Version, *tag.Value)
+
+	driver.SecurityGroupTagKey = "owner"
+	driver.SecurityGroupTagValue = "rancher-prod"
+	tag = driver.securityGroupTag()
+	assert.Equal(t, "owner", *tag.Key)
+	assert.Equal(t, "rancher-prod", *tag.Value)
+}
+
+func TestIsManagedSecurityGroup(t *testing.T) {
+	group := &ec2.SecurityGroup{Tags: []*ec2.Tag{{Key: aws.String("owner"), Value: aws.String("rancher-staging")}}}
+
+	driver := NewTestDriver()
+	assert.False(t, driver.isManagedSecurityGroup(group))
+
+	driver.SecurityGroupTagKey = "owner"
+	assert.True(t, driver.isManagedSecurityGroup(group))
+
+	driver.SecurityGroupTagValue = "rancher-prod"
+	assert.False(t, driver.isManagedSecurityGroup(group))
+
+	driver.SecurityGroupTagValue = "rancher-staging"
+	assert.True(t, driver.isManagedSecurityGroup(group))
+}
+
+func TestRootVolumeId(t *testing.T) {
+	inst := &ec2.Instance{
+		RootDeviceName: aws.String("/dev/sda1"),
+		BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvdb"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-data")}},
+			{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root")}},
+		},
+	}
+	assert.Equal(t, "vol-root", rootVolumeId(inst))
+
+	inst.RootDeviceName = aws.String("/dev/sdz")
+	assert.Equal(t, "", rootVolumeId(inst))
+}
+
+func TestTagRootVolume(t *testing.T) {
+	recorder := &fakeEC2TagRecorder{}
+	client := &fakeEC2InstanceTagRecorder{
+		fakeEC2TagRecorder: recorder,
+		instance: &ec2.Instance{
+			InstanceId:     aws.String("i-12345"),
+			RootDeviceName: aws.String("/dev/sda1"),
+			BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+				{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root")}},
+			},
+		},
+	}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+
+	driver.tagRootVolume()
+
+	assert.Len(t, recorder.inputs, 1)
+	assert.Equal(t, "vol-root", *recorder.inputs[0].Resources[0])
+	assert.Equal(t, &ec2.Tag{Key: aws.String("Name"), Value: aws.String("machineFoo")}, recorder.inputs[0].Tags[0])
+}
+
+func TestFindVpc(t *testing.T) {
+	client := &fakeEC2WithVpcs{vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc-1")}}}
+	driver := NewCustomTestDriver(client)
+	driver.VpcName = "production"
+	driver.VpcTags = []string{"env=prod"}
+
+	vpcId, err := driver.findVpc()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "vpc-1", vpcId)
+	assert.Equal(t, []*ec2.Filter{
+		{Name: aws.String("tag:env"), Values: []*string{aws.String("prod")}},
+		{Name: aws.String("tag:Name"), Values: []*string{aws.String("production")}},
+	}, client.input.Filters)
+}
+
+func TestFindVpcNotUnique(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithVpcs{vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc-1")}, {VpcId: aws.String("vpc-2")}}})
+	driver.VpcName = "production"
+
+	_, err := driver.findVpc()
+	assert.EqualError(t, err, "several VPCs match --outscale-vpc-name/--outscale-vpc-tag: vpc-1, vpc-2")
+
+	driver = NewCustomTestDriver(&fakeEC2WithVpcs{})
+	driver.VpcName = "production"
+
+	_, err = driver.findVpc()
+	assert.Error(t, err)
+}
+
+func TestCheckSubnetByName(t *testing.T) {
+	client := &fakeEC2WithSubnets{subnets: []*ec2.Subnet{{SubnetId: aws.String("subnet-1")}}}
+	driver := NewCustomTestDriver(client)
+	driver.VpcId = "vpc-1"
+	driver.SubnetName = "nodes"
+
+	assert.NoError(t, driver.checkSubnet())
+	assert.Equal(t, "subnet-1", driver.SubnetId)
+	assert.Equal(t, &ec2.Filter{Name: aws.String("tag:Name"), Values: []*string{aws.String("nodes")}}, client.input.Filters[2])
+}
+
+func TestCheckSubnetByNameNotUnique(t *testing.T) {
+	client := &fakeEC2WithSubnets{subnets: []*ec2.Subnet{{SubnetId: aws.String("subnet-1")}, {SubnetId: aws.String("subnet-2")}}}
+	driver := NewCustomTestDriver(client)
+	driver.VpcId = "vpc-1"
+	driver.SubnetName = "nodes"
+
+	assert.Error(t, driver.checkSubnet())
+}
+
+func TestCheckSubnetByFilter(t *testing.T) {
+	client := &fakeEC2WithSubnets{subnets: []*ec2.Subnet{{SubnetId: aws.String("subnet-1")}}}
+	driver := NewCustomTestDriver(client)
+	driver.VpcId = "vpc-1"
+	driver.SubnetFilters = []string{"tag:Tier=nodes"}
+
+	assert.NoError(t, driver.checkSubnet())
+	assert.Equal(t, "subnet-1", driver.SubnetId)
+	assert.Equal(t, &ec2.Filter{Name: aws.String("tag:Tier"), Values: []*string{aws.String("nodes")}}, client.input.Filters[2])
+}
+
+func TestCheckSubnetByFilterNotUnique(t *testing.T) {
+	client := &fakeEC2WithSubnets{subnets: []*ec2.Subnet{{SubnetId: aws.String("subnet-1")}, {SubnetId: aws.String("subnet-2")}}}
+	driver := NewCustomTestDriver(client)
+	driver.VpcId = "vpc-1"
+	driver.SubnetFilters = []string{"tag:Tier=nodes"}
+
+	err := driver.checkSubnet()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "subnet-1, subnet-2")
+
+	driver.SubnetPickFirst = true
+	assert.NoError(t, driver.checkSubnet())
+	assert.Equal(t, "subnet-1", driver.SubnetId)
+}
+
+func TestParseSubnetFilters(t *testing.T) {
+	filters, err := parseSubnetFilters([]string{"tag:Tier=nodes", "cidr-block=10.0.1.0/24"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*ec2.Filter{
+		{Name: aws.String("tag:Tier"), Values: []*string{aws.String("nodes")}},
+		{Name: aws.String("cidr-block"), Values: []*string{aws.String("10.0.1.0/24")}},
+	}, filters)
+
+	_, err = parseSubnetFilters([]string{"nodes"})
+	assert.EqualError(t, err, `invalid --outscale-subnet-filter "nodes", expected name=value like tag:Tier=nodes`)
+}
+
+func TestGetSSHHostnamePublicDNS(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:      aws.String("i-12345"),
+			PublicIpAddress: aws.String("1.2.3.4"),
+			PublicDnsName:   aws.String("ows-1-2-3-4.eu-west-2.compute.outscale.com"),
+		}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+
+	hostname, err := driver.GetSSHHostname()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", hostname)
+
+	driver.UsePublicDNS = true
+	hostname, err = driver.GetSSHHostname()
+	assert.NoError(t, err)
+	assert.Equal(t, "ows-1-2-3-4.eu-west-2.compute.outscale.com", hostname)
+
+	client.reservations[0].Instances[0].PublicDnsName = nil
+	hostname, err = driver.GetSSHHostname()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", hostname)
+}
+
+func TestGetSSHHostnameAndURLPrivateDNS(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:       aws.String("i-12345"),
+			State:            &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			PublicIpAddress:  aws.String("1.2.3.4"),
+			PrivateIpAddress: aws.String("10.0.0.4"),
+			PrivateDnsName:   aws.String("ip-10-0-0-4.eu-west-2.compute.internal"),
+		}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.UsePrivateDNS = true
+
+	hostname, err := driver.GetSSHHostname()
+	assert.NoError(t, err)
+	assert.Equal(t, "ip-10-0-0-4.eu-west-2.compute.internal", hostname)
+	url, err := driver.GetURL()
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://ip-10-0-0-4.eu-west-2.compute.internal:2376", url)
+
+	client.reservations[0].Instances[0].PrivateDnsName = nil
+	hostname, err = driver.GetSSHHostname()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", hostname)
+	url, err = driver.GetURL()
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://1.2.3.4:2376", url)
+}
+
+func TestGetStateRecordsProviderState(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:  aws.String("i-12345"),
+			State:       &ec2.InstanceState{Name: aws.String(instanceStateQuarantine)},
+			StateReason: &ec2.StateReason{Message: aws.String("Abuse report")},
+		}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+
+	st, err := driver.GetState()
+
+	assert.NoError(t, err)
+	assert.Equal(t, state.Error, st)
+	assert.Equal(t, instanceStateQuarantine, driver.ProviderState)
+	assert.Equal(t, "Abuse report", driver.ProviderStateReason)
+}
+
+func TestCheckEbsOptimized(t *testing.T) {
+	driver := NewTestDriver()
+	driver.UseEbsOptimizedInstance = true
+
+	driver.InstanceType = "m4.large"
+	driver.checkEbsOptimized()
+	assert.True(t, driver.UseEbsOptimizedInstance)
+
+	driver.InstanceType = "tinav4.c2r4p2"
+	driver.checkEbsOptimized()
+	assert.True(t, driver.UseEbsOptimizedInstance)
+
+	driver.InstanceType = "t2.micro"
+	driver.checkEbsOptimized()
+	assert.False(t, driver.UseEbsOptimizedInstance)
+}
+
+func TestDescribeSecurityGroupsFollowsNextToken(t *testing.T) {
+	client := &fakeEC2WithSecurityGroupPages{pages: [][]*ec2.SecurityGroup{
+		{{GroupId: aws.String("sg-1")}},
+		{{GroupId: aws.String("sg-2")}, {GroupId: aws.String("sg-3")}},
+	}}
+	driver := NewCustomTestDriver(client)
+
+	groups, err := driver.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{})
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "sg-3", *groups[2].GroupId)
+	assert.Equal(t, []string{"", "page-1"}, client.tokens)
+}
+
+func TestCheckSubnetFollowsNextToken(t *testing.T) {
+	client := &fakeEC2WithPages{subnets: [][]*ec2.Subnet{
+		{{SubnetId: aws.String("subnet-1")}},
+		{{SubnetId: aws.String("subnet-2"), DefaultForAz: aws.Bool(true)}},
+	}}
+	driver := NewCustomTestDriver(client)
+
+	assert.NoError(t, driver.checkSubnet())
+	assert.Equal(t, "subnet-2", driver.SubnetId)
+}
+
+func TestDescribeInstancesFollowsNextToken(t *testing.T) {
+	client := &fakeEC2WithPages{instances: [][]*ec2.Instance{
+		{{InstanceId: aws.String("i-1")}},
+		{{InstanceId: aws.String("i-2")}, {InstanceId: aws.String("i-3")}},
+	}}
+	driver := NewCustomTestDriver(client)
+
+	instances, err := driver.describeInstances(&ec2.DescribeInstancesInput{})
+
+	assert.NoError(t, err)
+	assert.Len(t, instances, 3)
+	assert.Equal(t, "i-3", *instances[2].InstanceId)
+}
+
+func TestEnsureAddressAssociated(t *testing.T) {
+	client := &fakeEC2Reassociate{fakeEC2WithInstances: &fakeEC2WithInstances{addresses: []*ec2.Address{{
+		AllocationId: aws.String("eipalloc-12345"),
+		PublicIp:     aws.String("1.2.3.4"),
+	}}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.AllocationId = "eipalloc-12345"
+	driver.AssociationId = "eipassoc-old"
+	driver.PublicIp = "1.2.3.4"
+
+	assert.NoError(t, driver.ensureAddressAssociated())
+	assert.Len(t, client.associated, 1)
+	assert.Equal(t, "i-12345", *client.associated[0].InstanceId)
+	assert.Equal(t, "eipassoc-new", driver.AssociationId)
+
+	client.addresses[0].InstanceId = aws.String("i-12345")
+	client.addresses[0].AssociationId = aws.String("eipassoc-new")
+	assert.NoError(t, driver.ensureAddressAssociated())
+	assert.Len(t, client.associated, 1)
+
+	client.addresses[0].InstanceId = aws.String("i-other")
+	assert.EqualError(t, driver.ensureAddressAssociated(), "external IP 1.2.3.4 is associated with i-other instead of i-12345")
+}
+
+func TestAssignExternalIpSkipsAutoAssignedPublicIp(t *testing.T) {
+	client := &fakeEC2Allocate{fakeEC2Reassociate: &fakeEC2Reassociate{fakeEC2WithInstances: &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:      aws.String("i-12345"),
+			PublicIpAddress: aws.String("1.2.3.4"),
+		}},
+	}}}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+
+	assert.NoError(t, driver.assignExternalIp())
+	assert.Equal(t, 0, client.allocated)
+	assert.Empty(t, driver.AllocationId)
+
+	driver.ForcePublicIp = true
+	assert.NoError(t, driver.assignExternalIp())
+	assert.Equal(t, 1, client.allocated)
+	assert.Equal(t, "eipalloc-new", driver.AllocationId)
+	assert.Equal(t, "eipassoc-new", driver.AssociationId)
+}
+
+func TestConfigureSecurityGroupPermissionsNodeRoles(t *testing.T) {
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-12345"),
+		GroupName: aws.String(defaultSecurityGroup),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}
+	ports := func(perms []*ec2.IpPermission) []string {
+		result := []string{}
+		for _, perm := range perms {
+			result = append(result, fmt.Sprintf("%d/%s", *perm.FromPort, *perm.IpProtocol))
+		}
+		return result
+	}
+
+	driver := NewTestDriver()
+	all, err := driver.configureSecurityGroupPermissions(group)
+	assert.NoError(t, err)
+	assert.Contains(t, ports(all), "6443/tcp")
+	assert.Contains(t, ports(all), "2379/tcp")
+	assert.Contains(t, ports(all), "30000/tcp")
+
+	// the shared group serves the nodes of every role
+	driver.NodeRoles = []string{nodeRoleEtcd}
+	shared, err := driver.configureSecurityGroupPermissions(group)
+	assert.NoError(t, err)
+	assert.Equal(t, ports(all), ports(shared))
+
+	driver = NewTestDriver()
+	driver.MachineName = "cluster-node1"
+	driver.SecurityGroupPerMachine = true
+	group = &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-node1"),
+		GroupName: aws.String("cluster-node1"),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}
+
+	driver.NodeRoles = []string{nodeRoleEtcd}
+	etcd, err := driver.configureSecurityGroupPermissions(group)
+	assert.NoError(t, err)
+	assert.Contains(t, ports(etcd), "2379/tcp")
+	assert.Contains(t, ports(etcd), "8472/udp")
+	assert.NotContains(t, ports(etcd), "6443/tcp")
+	assert.NotContains(t, ports(etcd), "30000/tcp")
+	assert.NotContains(t, ports(etcd), "443/tcp")
+
+	driver.NodeRoles = []string{nodeRoleControlPlane, nodeRoleWorker}
+	mixed, err := driver.configureSecurityGroupPermissions(group)
+	assert.NoError(t, err)
+	assert.Contains(t, ports(mixed), "6443/tcp")
+	assert.Contains(t, ports(mixed), "30000/udp")
+	assert.NotContains(t, ports(mixed), "2379/tcp")
+}
+
+func TestValidateNodeRoles(t *testing.T) {
+	assert.NoError(t, validateNodeRoles(nil))
+	assert.NoError(t, validateNodeRoles([]string{"etcd", "controlplane", "worker"}))
+	assert.EqualError(t, validateNodeRoles([]string{"master"}), `invalid --outscale-node-role "master", expected etcd, controlplane or worker`)
+}
+
+func TestSetConfigFromFlagsDerivesEndpointFromRegion(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "ap-northeast-1",
+			"outscale-zone":         "ap-northeast-1a",
+		},
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.Equal(t, "https://fcu.ap-northeast-1.outscale.com", driver.Endpoint)
+	assert.Equal(t, "https://lbu.ap-northeast-1.outscale.com", driver.lbuEndpoint())
+}
+
+func TestSetConfigFromFlagsCustomEndpointRegion(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       customEndpointRegion,
+		},
+	}
+
+	assert.Equal(t, errorCustomEndpointRequired, driver.SetConfigFromFlags(options))
+}
+
+func TestEndpointRegion(t *testing.T) {
+	assert.Equal(t, "eu-west-2", endpointRegion("https://fcu.eu-west-2.outscale.com"))
+	assert.Equal(t, "", endpointRegion("https://someurl"))
+}
+
+func TestSetConfigFromFlagsCloudgouvRegion(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "cloudgouv-eu-west-1",
+			"outscale-zone":         "cloudgouv-eu-west-1a",
+		},
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.Equal(t, "https://fcu.cloudgouv-eu-west-1.outscale.com", driver.Endpoint)
+	assert.Equal(t, "https://lbu.cloudgouv-eu-west-1.outscale.com", driver.lbuEndpoint())
+	assert.Equal(t, "https://api.cloudgouv-eu-west-1.outscale.com/api/v1", oapiEndpoint(driver.Region))
+	assert.Equal(t, "cloudgouv-eu-west-1", endpointRegion(driver.Endpoint))
+	assert.Empty(t, driver.AMI)
+	assert.Equal(t, "Ubuntu-20.04-*", driver.AMIName)
+}
+
+func TestGetClientIsCachedUntilEndpointChanges(t *testing.T) {
+	driver := NewTestDriver()
+	builds := 0
+	driver.clientFactory = func() Ec2Client {
+		builds++
+		return &fakeEC2WithLogin{}
+	}
+
+	first := driver.getClient()
+	assert.Equal(t, first, driver.getClient())
+	assert.Equal(t, 1, builds)
+
+	driver.Endpoint = "https://fcu.eu-west-2.outscale.com"
+	driver.getClient()
+	assert.Equal(t, 2, builds)
+
+	driver.Region = "eu-west-2"
+	driver.getClient()
+	driver.getClient()
+	assert.Equal(t, 3, builds)
+}
+
+func TestFindReusedAddress(t *testing.T) {
+	client := &fakeEC2WithInstances{addresses: []*ec2.Address{{
+		AllocationId: aws.String("eipalloc-12345"),
+		PublicIp:     aws.String("1.2.3.4"),
+		InstanceId:   aws.String("i-other"),
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.ReusePublicIp = "1.2.3.4"
+
+	_, err := driver.findReusedAddress()
+	assert.EqualError(t, err, "external IP 1.2.3.4 is already associated with i-other")
+
+	client.addresses[0].InstanceId = nil
+	address, err := driver.findReusedAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, "eipalloc-12345", *address.AllocationId)
+
+	client.addresses = nil
+	_, err = driver.findReusedAddress()
+	assert.EqualError(t, err, "external IP 1.2.3.4 does not exist")
+}
+
+func TestSetConfigFromFlagsInvalidPublicIp(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "us-east-2a",
+			"outscale-public-ip":    "my-ip",
+		},
+	}
+
+	assert.EqualError(t, driver.SetConfigFromFlags(options), `invalid --outscale-public-ip "my-ip", expected an IP address or an allocation ID`)
+}
+
+func TestAddIPv6Ranges(t *testing.T) {
+	driver := NewTestDriver()
+	perms := []*ec2.IpPermission{
+		{IpRanges: sourceIpRanges([]string{ipRange})},
+		{IpRanges: sourceIpRanges([]string{"10.0.0.0/8", "2001:db8::/32"})},
+	}
+
+	driver.addIPv6Ranges(perms)
+	assert.Equal(t, []*ec2.IpRange{{CidrIp: aws.String(ipRange)}}, perms[0].IpRanges)
+	assert.Empty(t, perms[0].Ipv6Ranges)
+	assert.Equal(t, []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/8")}}, perms[1].IpRanges)
+	assert.Equal(t, []*ec2.Ipv6Range{{CidrIpv6: aws.String("2001:db8::/32")}}, perms[1].Ipv6Ranges)
+
+	driver.IPv6 = true
+	perms = []*ec2.IpPermission{{IpRanges: sourceIpRanges([]string{ipRange})}}
+	driver.addIPv6Ranges(perms)
+	assert.Equal(t, []*ec2.Ipv6Range{{CidrIpv6: aws.String(ipv6Range)}}, perms[0].Ipv6Ranges)
+	assert.Equal(t, "22/tcp from 0.0.0.0/0,::/0", describePermission(&ec2.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int64(22),
+		ToPort:     aws.Int64(22),
+		IpRanges:   perms[0].IpRanges,
+		Ipv6Ranges: perms[0].Ipv6Ranges,
+	}))
+}
+
+func TestGetIPUsesIPv6Address(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:      aws.String("i-12345"),
+			PublicIpAddress: aws.String("1.2.3.4"),
+			NetworkInterfaces: []*ec2.InstanceNetworkInterface{{
+				Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::4")}},
+			}},
+		}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.IPv6 = true
+	driver.UseIPv6Address = true
+
+	ip, err := driver.GetIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:db8::4", ip)
+
+	client.reservations[0].Instances[0].NetworkInterfaces = nil
+	_, err = driver.GetIP()
+	assert.EqualError(t, err, "No IPv6 address for instance i-12345")
+}
+
+func TestSetConfigFromFlagsIPv6AddressRequiresIPv6(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":     []string{ipRange},
+			"name":                      "test",
+			"outscale-region":           "us-east-2",
+			"outscale-zone":             "us-east-2a",
+			"outscale-use-ipv6-address": true,
+		},
+	}
+
+	assert.Equal(t, errorIPv6AddressWithoutIPv6, driver.SetConfigFromFlags(options))
+}
@@ -0,0 +1,472 @@
+package outscale
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// OutscaleAPI is the instance lifecycle as the driver uses it, independent of
+// the API protocol. Instances are returned as EC2 instances whichever API
+// looked them up.
+type OutscaleAPI interface {
+	// GetInstance returns an error if the instance doesn't exist
+	GetInstance(id string) (*ec2.Instance, error)
+
+	StartInstance(id string) error
+
+	StopInstance(id string, force bool) error
+
+	RebootInstance(id string) error
+
+	TerminateInstance(id string) error
+
+	// The resources Create makes and Remove deletes, the inputs and outputs
+	// being the EC2 ones whichever API is called
+
+	RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error)
+
+	AllocateAddress(input *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error)
+
+	AssociateAddress(input *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error)
+
+	DisassociateAddress(input *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error)
+
+	ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error)
+
+	ImportKeyPair(input *ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error)
+
+	DeleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error)
+
+	CreateSecurityGroup(input *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error)
+
+	DeleteSecurityGroup(input *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error)
+
+	CreateSnapshot(input *ec2.CreateSnapshotInput) (*ec2.Snapshot, error)
+
+	DeleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error)
+}
+
+// buildAPI returns the implementation selected by --outscale-api. It is
+// called by getAPI with clientMu held.
+func (d *Driver) buildAPI() OutscaleAPI {
+	if d.API == apiOAPI {
+		return &oapiAPI{client: d.buildOAPIClient()}
+	}
+	return &fcuAPI{client: d.cachedClient()}
+}
+
+// getAPI returns the API, cached along with the EC2 client and rebuilt
+// like it when the settings it depends on change
+func (d *Driver) getAPI() OutscaleAPI {
+	d.clientMu.Lock()
+	defer d.clientMu.Unlock()
+
+	key := strings.Join([]string{d.clientCacheKey(), d.API, d.OAPIEndpoint}, "\x00")
+	if d.api == nil || d.apiKey != key {
+		d.api = d.apiFactory()
+		d.apiKey = key
+	}
+	return d.api
+}
+
+// fcuAPI implements OutscaleAPI with the FCU EC2 compatible API
+type fcuAPI struct {
+	client Ec2Client
+}
+
+func (a *fcuAPI) GetInstance(id string) (*ec2.Instance, error) {
+	instances, err := a.client.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{&id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(instances.Reservations) == 0 || len(instances.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("unknown instance %s", id)
+	}
+	return instances.Reservations[0].Instances[0], nil
+}
+
+func (a *fcuAPI) StartInstance(id string) error {
+	_, err := a.client.StartInstances(&ec2.StartInstancesInput{
+		InstanceIds: []*string{&id},
+	})
+	return err
+}
+
+func (a *fcuAPI) StopInstance(id string, force bool) error {
+	_, err := a.client.StopInstances(&ec2.StopInstancesInput{
+		InstanceIds: []*string{&id},
+		Force:       aws.Bool(force),
+	})
+	return err
+}
+
+func (a *fcuAPI) RebootInstance(id string) error {
+	_, err := a.client.RebootInstances(&ec2.RebootInstancesInput{
+		InstanceIds: []*string{&id},
+	})
+	return err
+}
+
+func (a *fcuAPI) TerminateInstance(id string) error {
+	_, err := a.client.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: []*string{&id},
+	})
+	return err
+}
+
+func (a *fcuAPI) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	return a.client.RunInstances(input)
+}
+
+func (a *fcuAPI) AllocateAddress(input *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	return a.client.AllocateAddress(input)
+}
+
+func (a *fcuAPI) AssociateAddress(input *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	return a.client.AssociateAddress(input)
+}
+
+func (a *fcuAPI) DisassociateAddress(input *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+	return a.client.DisassociateAddress(input)
+}
+
+func (a *fcuAPI) ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	return a.client.ReleaseAddress(input)
+}
+
+func (a *fcuAPI) ImportKeyPair(input *ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error) {
+	return a.client.ImportKeyPair(input)
+}
+
+func (a *fcuAPI) DeleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+	return a.client.DeleteKeyPair(input)
+}
+
+func (a *fcuAPI) CreateSecurityGroup(input *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error) {
+	return a.client.CreateSecurityGroup(input)
+}
+
+func (a *fcuAPI) DeleteSecurityGroup(input *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+	return a.client.DeleteSecurityGroup(input)
+}
+
+func (a *fcuAPI) CreateSnapshot(input *ec2.CreateSnapshotInput) (*ec2.Snapshot, error) {
+	return a.client.CreateSnapshot(input)
+}
+
+func (a *fcuAPI) DeleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	return a.client.DeleteVolume(input)
+}
+
+// oapiAPI implements OutscaleAPI with the native oAPI
+type oapiAPI struct {
+	client *oapiClient
+}
+
+func (a *oapiAPI) GetInstance(id string) (*ec2.Instance, error) {
+	vms, err := a.client.readVms([]string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) == 0 {
+		return nil, fmt.Errorf("unknown instance %s", id)
+	}
+	return vms[0].instance(), nil
+}
+
+func (a *oapiAPI) StartInstance(id string) error {
+	return a.client.call("StartVms", map[string]interface{}{"VmIds": []string{id}}, &struct{}{})
+}
+
+func (a *oapiAPI) StopInstance(id string, force bool) error {
+	return a.client.call("StopVms", map[string]interface{}{"VmIds": []string{id}, "ForceStop": force}, &struct{}{})
+}
+
+func (a *oapiAPI) RebootInstance(id string) error {
+	return a.client.call("RebootVms", map[string]interface{}{"VmIds": []string{id}}, &struct{}{})
+}
+
+func (a *oapiAPI) TerminateInstance(id string) error {
+	return a.client.call("DeleteVms", map[string]interface{}{"VmIds": []string{id}}, &struct{}{})
+}
+
+// RunInstances creates the VM with CreateVms, which doesn't take tags, so the
+// instance tags are set right after it
+func (a *oapiAPI) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	params := map[string]interface{}{
+		"ImageId":     aws.StringValue(input.ImageId),
+		"VmType":      aws.StringValue(input.InstanceType),
+		"MinVmsCount": aws.Int64Value(input.MinCount),
+		"MaxVmsCount": aws.Int64Value(input.MaxCount),
+	}
+	if input.KeyName != nil {
+		params["KeypairName"] = aws.StringValue(input.KeyName)
+	}
+	if input.UserData != nil {
+		params["UserData"] = aws.StringValue(input.UserData)
+	}
+	if input.Placement != nil && input.Placement.AvailabilityZone != nil {
+		params["Placement"] = map[string]interface{}{"SubregionName": aws.StringValue(input.Placement.AvailabilityZone)}
+	}
+	if input.EbsOptimized != nil {
+		params["BsuOptimized"] = aws.BoolValue(input.EbsOptimized)
+	}
+
+	var mappings []map[string]interface{}
+	for _, bdm := range input.BlockDeviceMappings {
+		if bdm.Ebs == nil {
+			continue
+		}
+		bsu := map[string]interface{}{"DeleteOnVmDeletion": aws.BoolValue(bdm.Ebs.DeleteOnTermination)}
+		if bdm.Ebs.VolumeSize != nil {
+			bsu["VolumeSize"] = aws.Int64Value(bdm.Ebs.VolumeSize)
+		}
+		if bdm.Ebs.VolumeType != nil {
+			bsu["VolumeType"] = aws.StringValue(bdm.Ebs.VolumeType)
+		}
+		if bdm.Ebs.Iops != nil {
+			bsu["Iops"] = aws.Int64Value(bdm.Ebs.Iops)
+		}
+		if bdm.Ebs.SnapshotId != nil {
+			bsu["SnapshotId"] = aws.StringValue(bdm.Ebs.SnapshotId)
+		}
+		mappings = append(mappings, map[string]interface{}{"DeviceName": aws.StringValue(bdm.DeviceName), "Bsu": bsu})
+	}
+	if len(mappings) > 0 {
+		params["BlockDeviceMappings"] = mappings
+	}
+
+	// CreateVms takes neither a public IP nor IPv6 addresses for the NICs:
+	// Create associates the external IP after the launch whatever the API,
+	// and SetConfigFromFlags rejects --outscale-ipv6 with the oAPI
+	var nics []map[string]interface{}
+	for _, spec := range input.NetworkInterfaces {
+		nic := map[string]interface{}{"DeviceNumber": aws.Int64Value(spec.DeviceIndex)}
+		if spec.NetworkInterfaceId != nil {
+			nic["NicId"] = aws.StringValue(spec.NetworkInterfaceId)
+			nics = append(nics, nic)
+			continue
+		}
+		nic["SubnetId"] = aws.StringValue(spec.SubnetId)
+		nic["SecurityGroupIds"] = aws.StringValueSlice(spec.Groups)
+		nic["DeleteOnVmDeletion"] = spec.DeleteOnTermination == nil || aws.BoolValue(spec.DeleteOnTermination)
+		var ips []map[string]interface{}
+		if spec.PrivateIpAddress != nil {
+			ips = append(ips, map[string]interface{}{"PrivateIp": aws.StringValue(spec.PrivateIpAddress), "IsPrimary": true})
+		}
+		for _, ip := range spec.PrivateIpAddresses {
+			ips = append(ips, map[string]interface{}{"PrivateIp": aws.StringValue(ip.PrivateIpAddress), "IsPrimary": aws.BoolValue(ip.Primary)})
+		}
+		if len(ips) > 0 {
+			nic["PrivateIps"] = ips
+		}
+		if spec.SecondaryPrivateIpAddressCount != nil {
+			nic["SecondaryPrivateIpCount"] = aws.Int64Value(spec.SecondaryPrivateIpAddressCount)
+		}
+		nics = append(nics, nic)
+	}
+	if len(nics) > 0 {
+		params["Nics"] = nics
+	}
+
+	output := &struct {
+		Vms []*oapiVm `json:"Vms"`
+	}{}
+	if err := a.client.call("CreateVms", params, output); err != nil {
+		return nil, err
+	}
+	if len(output.Vms) == 0 {
+		return nil, fmt.Errorf("CreateVms returned no VM")
+	}
+
+	reservation := &ec2.Reservation{}
+	for _, vm := range output.Vms {
+		reservation.Instances = append(reservation.Instances, vm.instance())
+	}
+	for _, spec := range input.TagSpecifications {
+		if aws.StringValue(spec.ResourceType) != ec2.ResourceTypeInstance || len(spec.Tags) == 0 {
+			continue
+		}
+		if err := a.createTags(output.Vms[0].VmId, spec.Tags); err != nil {
+			return reservation, fmt.Errorf("unable to tag instance %s: %s", output.Vms[0].VmId, err)
+		}
+	}
+	return reservation, nil
+}
+
+// createTags tags the resource, oAPI tags being the EC2 ones
+func (a *oapiAPI) createTags(id string, tags []*ec2.Tag) error {
+	oapiTags := []oapiTag{}
+	for _, tag := range tags {
+		oapiTags = append(oapiTags, oapiTag{Key: aws.StringValue(tag.Key), Value: aws.StringValue(tag.Value)})
+	}
+	return a.client.call("CreateTags", map[string]interface{}{
+		"ResourceIds": []string{id},
+		"Tags":        oapiTags,
+	}, &struct{}{})
+}
+
+func (a *oapiAPI) AllocateAddress(input *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	output := &struct {
+		PublicIp struct {
+			PublicIpId string `json:"PublicIpId"`
+			PublicIp   string `json:"PublicIp"`
+		} `json:"PublicIp"`
+	}{}
+	if err := a.client.call("CreatePublicIp", map[string]interface{}{}, output); err != nil {
+		return nil, err
+	}
+	return &ec2.AllocateAddressOutput{
+		AllocationId: aws.String(output.PublicIp.PublicIpId),
+		PublicIp:     aws.String(output.PublicIp.PublicIp),
+		Domain:       aws.String("vpc"),
+	}, nil
+}
+
+func (a *oapiAPI) AssociateAddress(input *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	params := map[string]interface{}{}
+	if input.AllocationId != nil {
+		params["PublicIpId"] = aws.StringValue(input.AllocationId)
+	}
+	if input.PublicIp != nil {
+		params["PublicIp"] = aws.StringValue(input.PublicIp)
+	}
+	if input.InstanceId != nil {
+		params["VmId"] = aws.StringValue(input.InstanceId)
+	}
+	if input.NetworkInterfaceId != nil {
+		params["NicId"] = aws.StringValue(input.NetworkInterfaceId)
+	}
+	if input.PrivateIpAddress != nil {
+		params["PrivateIp"] = aws.StringValue(input.PrivateIpAddress)
+	}
+	if input.AllowReassociation != nil {
+		params["AllowRelink"] = aws.BoolValue(input.AllowReassociation)
+	}
+	output := &struct {
+		LinkPublicIpId string `json:"LinkPublicIpId"`
+	}{}
+	if err := a.client.call("LinkPublicIp", params, output); err != nil {
+		return nil, err
+	}
+	return &ec2.AssociateAddressOutput{AssociationId: aws.String(output.LinkPublicIpId)}, nil
+}
+
+func (a *oapiAPI) DisassociateAddress(input *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+	params := map[string]interface{}{}
+	if input.AssociationId != nil {
+		params["LinkPublicIpId"] = aws.StringValue(input.AssociationId)
+	}
+	if input.PublicIp != nil {
+		params["PublicIp"] = aws.StringValue(input.PublicIp)
+	}
+	return &ec2.DisassociateAddressOutput{}, a.client.call("UnlinkPublicIp", params, &struct{}{})
+}
+
+func (a *oapiAPI) ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	params := map[string]interface{}{}
+	if input.AllocationId != nil {
+		params["PublicIpId"] = aws.StringValue(input.AllocationId)
+	}
+	if input.PublicIp != nil {
+		params["PublicIp"] = aws.StringValue(input.PublicIp)
+	}
+	return &ec2.ReleaseAddressOutput{}, a.client.call("DeletePublicIp", params, &struct{}{})
+}
+
+func (a *oapiAPI) ImportKeyPair(input *ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error) {
+	output := &struct {
+		Keypair struct {
+			KeypairName        string `json:"KeypairName"`
+			KeypairFingerprint string `json:"KeypairFingerprint"`
+		} `json:"Keypair"`
+	}{}
+	err := a.client.call("CreateKeypair", map[string]interface{}{
+		"KeypairName": aws.StringValue(input.KeyName),
+		"PublicKey":   base64.StdEncoding.EncodeToString(input.PublicKeyMaterial),
+	}, output)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.ImportKeyPairOutput{
+		KeyName:        aws.String(output.Keypair.KeypairName),
+		KeyFingerprint: aws.String(output.Keypair.KeypairFingerprint),
+	}, nil
+}
+
+func (a *oapiAPI) DeleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+	return &ec2.DeleteKeyPairOutput{}, a.client.call("DeleteKeypair", map[string]interface{}{
+		"KeypairName": aws.StringValue(input.KeyName),
+	}, &struct{}{})
+}
+
+func (a *oapiAPI) CreateSecurityGroup(input *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error) {
+	output := &struct {
+		SecurityGroup struct {
+			SecurityGroupId string `json:"SecurityGroupId"`
+		} `json:"SecurityGroup"`
+	}{}
+	err := a.client.call("CreateSecurityGroup", map[string]interface{}{
+		"SecurityGroupName": aws.StringValue(input.GroupName),
+		"Description":       aws.StringValue(input.Description),
+		"NetId":             aws.StringValue(input.VpcId),
+	}, output)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.CreateSecurityGroupOutput{GroupId: aws.String(output.SecurityGroup.SecurityGroupId)}, nil
+}
+
+func (a *oapiAPI) DeleteSecurityGroup(input *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+	params := map[string]interface{}{}
+	if input.GroupId != nil {
+		params["SecurityGroupId"] = aws.StringValue(input.GroupId)
+	}
+	if input.GroupName != nil {
+		params["SecurityGroupName"] = aws.StringValue(input.GroupName)
+	}
+	return &ec2.DeleteSecurityGroupOutput{}, a.client.call("DeleteSecurityGroup", params, &struct{}{})
+}
+
+func (a *oapiAPI) CreateSnapshot(input *ec2.CreateSnapshotInput) (*ec2.Snapshot, error) {
+	output := &struct {
+		Snapshot struct {
+			SnapshotId string `json:"SnapshotId"`
+			State      string `json:"State"`
+		} `json:"Snapshot"`
+	}{}
+	err := a.client.call("CreateSnapshot", map[string]interface{}{
+		"VolumeId":    aws.StringValue(input.VolumeId),
+		"Description": aws.StringValue(input.Description),
+	}, output)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.Snapshot{
+		SnapshotId: aws.String(output.Snapshot.SnapshotId),
+		VolumeId:   input.VolumeId,
+		State:      aws.String(output.Snapshot.State),
+	}, nil
+}
+
+func (a *oapiAPI) DeleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	return &ec2.DeleteVolumeOutput{}, a.client.call("DeleteVolume", map[string]interface{}{
+		"VolumeId": aws.StringValue(input.VolumeId),
+	}, &struct{}{})
+}
+
+// isInstanceNotFound reports whether err means the instance doesn't exist,
+// whichever API returned it
+func isInstanceNotFound(err error) bool {
+	if apiErr, ok := err.(*oapiError); ok {
+		return apiErr.Type == oapiInvalidResource
+	}
+	return strings.HasPrefix(err.Error(), "unknown instance") ||
+		strings.HasPrefix(err.Error(), "InvalidInstanceID.NotFound")
+}
@@ -0,0 +1,144 @@
+package outscale
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOutscaleAPI keeps instance states in memory and records the calls, the
+// calls it doesn't fake going to the FCU implementation
+type fakeOutscaleAPI struct {
+	*fcuAPI
+	states  map[string]string
+	keyName string
+	calls   []string
+}
+
+func newFakeOutscaleAPI(states map[string]string) *fakeOutscaleAPI {
+	return &fakeOutscaleAPI{fcuAPI: &fcuAPI{client: &fakeEC2{}}, states: states}
+}
+
+func (f *fakeOutscaleAPI) GetInstance(id string) (*ec2.Instance, error) {
+	st, ok := f.states[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown instance %s", id)
+	}
+	return &ec2.Instance{InstanceId: aws.String(id), KeyName: aws.String(f.keyName), State: &ec2.InstanceState{Name: aws.String(st)}}, nil
+}
+
+func (f *fakeOutscaleAPI) StartInstance(id string) error {
+	f.calls = append(f.calls, "start "+id)
+	f.states[id] = ec2.InstanceStateNameRunning
+	return nil
+}
+
+func (f *fakeOutscaleAPI) StopInstance(id string, force bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("stop %s force=%t", id, force))
+	f.states[id] = ec2.InstanceStateNameStopped
+	return nil
+}
+
+func (f *fakeOutscaleAPI) RebootInstance(id string) error {
+	f.calls = append(f.calls, "reboot "+id)
+	return nil
+}
+
+func (f *fakeOutscaleAPI) TerminateInstance(id string) error {
+	f.calls = append(f.calls, "terminate "+id)
+	f.states[id] = ec2.InstanceStateNameTerminated
+	return nil
+}
+
+func (f *fakeOutscaleAPI) ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	f.calls = append(f.calls, "release "+aws.StringValue(input.AllocationId))
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+func (f *fakeOutscaleAPI) DeleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+	f.calls = append(f.calls, "delete key pair "+aws.StringValue(input.KeyName))
+	return &ec2.DeleteKeyPairOutput{}, nil
+}
+
+func TestLifecycleThroughOutscaleAPI(t *testing.T) {
+	api := newFakeOutscaleAPI(map[string]string{"i-12345": ec2.InstanceStateNameRunning})
+	driver := NewTestDriver()
+	driver.apiFactory = func() OutscaleAPI { return api }
+	driver.InstanceId = "i-12345"
+
+	assert.NoError(t, driver.Stop())
+	st, err := driver.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Stopped, st)
+
+	assert.NoError(t, driver.Start())
+	assert.NoError(t, driver.Restart())
+	assert.NoError(t, driver.Kill())
+	assert.NoError(t, driver.terminate())
+
+	assert.Equal(t, []string{
+		"stop i-12345 force=false",
+		"start i-12345",
+		"reboot i-12345",
+		"stop i-12345 force=true",
+		"terminate i-12345",
+	}, api.calls)
+}
+
+func TestRemoveThroughOutscaleAPI(t *testing.T) {
+	api := newFakeOutscaleAPI(map[string]string{"i-12345": ec2.InstanceStateNameRunning})
+	driver := NewTestDriver()
+	driver.apiFactory = func() OutscaleAPI { return api }
+	api.keyName = "machineFoo-abcde"
+	driver.InstanceId = "i-12345"
+	driver.KeyName = "machineFoo-abcde"
+	driver.AllocationId = "eipalloc-12345"
+	driver.PublicIp = "1.2.3.4"
+
+	assert.NoError(t, driver.Remove())
+
+	assert.Equal(t, []string{
+		"terminate i-12345",
+		"release eipalloc-12345",
+		"delete key pair machineFoo-abcde",
+	}, api.calls)
+}
+
+func TestBuildAPI(t *testing.T) {
+	driver := NewTestDriver()
+
+	_, ok := driver.buildAPI().(*fcuAPI)
+	assert.True(t, ok)
+
+	driver.API = apiOAPI
+	_, ok = driver.buildAPI().(*oapiAPI)
+	assert.True(t, ok)
+}
+
+func TestGetAPIIsCachedWithTheClient(t *testing.T) {
+	driver := NewTestDriver()
+	builds := 0
+	driver.apiFactory = func() OutscaleAPI {
+		builds++
+		return driver.buildAPI()
+	}
+
+	first := driver.getAPI()
+	assert.Equal(t, first, driver.getAPI())
+	assert.Equal(t, 1, builds)
+
+	driver.Region = "eu-west-2"
+	second := driver.getAPI()
+	assert.Equal(t, 2, builds)
+	assert.Equal(t, driver.getClient(), second.(*fcuAPI).client)
+
+	driver.API = apiOAPI
+	_, ok := driver.getAPI().(*oapiAPI)
+	assert.True(t, ok)
+	driver.getAPI()
+	assert.Equal(t, 3, builds)
+}
@@ -0,0 +1,238 @@
+package outscale
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const defaultBastionPort = "22"
+
+// bastionSSHConfigFile is written to the machine directory, for ssh -F to
+// reach the instance through the bastion outside of the driver
+const bastionSSHConfigFile = "ssh-config"
+
+var (
+	errorBastionOptionsWithoutHost = errors.New("--outscale-bastion-user and --outscale-bastion-keypath require --outscale-bastion-host")
+	errorBastionWithFakeAPI        = errors.New("--outscale-bastion-host can't be combined with --outscale-fake-api, whose instances can't be reached over SSH")
+)
+
+// bastionTunnel forwards the connections accepted on a local port to the SSH
+// port of the instance through the bastion host, so that both the libmachine
+// SSH clients and the provisioning reach an instance without public IP
+type bastionTunnel struct {
+	client   *ssh.Client
+	listener net.Listener
+	target   string
+}
+
+// bastionAddress returns the host:port of --outscale-bastion-host
+func (d *Driver) bastionAddress() string {
+	if _, _, err := net.SplitHostPort(d.BastionHost); err == nil {
+		return d.BastionHost
+	}
+	return net.JoinHostPort(d.BastionHost, defaultBastionPort)
+}
+
+func (d *Driver) bastionUser() string {
+	if d.BastionUser != "" {
+		return d.BastionUser
+	}
+	return d.GetSSHUsername()
+}
+
+// proxyJump returns the bastion in the ssh -J / ProxyJump format
+func (d *Driver) proxyJump() string {
+	return d.bastionUser() + "@" + d.bastionAddress()
+}
+
+// bastionAuth authenticates on the bastion with --outscale-bastion-keypath,
+// falling back to the key or ssh-agent identity of the machine. The returned
+// function closes the ssh-agent connection once authenticated.
+func (d *Driver) bastionAuth() (ssh.AuthMethod, func(), error) {
+	if d.BastionKeyPath == "" && d.SSHAgent {
+		conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to connect to ssh-agent: %s", err)
+		}
+		return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), func() { conn.Close() }, nil
+	}
+
+	keyPath := d.BastionKeyPath
+	if keyPath == "" {
+		keyPath = d.GetSSHKeyPath()
+	}
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read the bastion key: %s", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse the bastion key %s: %s", keyPath, err)
+	}
+	return ssh.PublicKeys(signer), func() {}, nil
+}
+
+// openBastionTunnel connects to the bastion and listens on a local port
+// forwarded to target
+func (d *Driver) openBastionTunnel(target string) (*bastionTunnel, error) {
+	auth, closeAuth, err := d.bastionAuth()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAuth()
+
+	client, err := ssh.Dial("tcp", d.bastionAddress(), &ssh.ClientConfig{
+		User: d.bastionUser(),
+		Auth: []ssh.AuthMethod{auth},
+		// like the libmachine clients, which don't check the host keys
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Duration(d.SSHConnectTimeout) * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to bastion %s: %s", d.proxyJump(), err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	tunnel := &bastionTunnel{client: client, listener: listener, target: target}
+	go tunnel.serve()
+	log.Debugf("forwarding %s to %s through bastion %s", listener.Addr(), target, d.proxyJump())
+	return tunnel, nil
+}
+
+func (t *bastionTunnel) serve() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+func (t *bastionTunnel) forward(conn net.Conn) {
+	defer conn.Close()
+
+	remote, err := t.client.Dial("tcp", t.target)
+	if err != nil {
+		log.Debugf("unable to reach %s through the bastion: %s", t.target, err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (t *bastionTunnel) port() int {
+	return t.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (t *bastionTunnel) Close() {
+	t.listener.Close()
+	t.client.Close()
+}
+
+// bastionEndpoint returns the local end of the tunnel to the SSH port of the
+// instance
+func (d *Driver) bastionEndpoint() (string, int, error) {
+	host, err := d.instanceSSHHostname()
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := d.BaseDriver.GetSSHPort()
+	if err != nil {
+		return "", 0, err
+	}
+	local, err := d.bastionForward(&d.bastion, net.JoinHostPort(host, strconv.Itoa(port)))
+	return "127.0.0.1", local, err
+}
+
+// bastionForward returns the local port of tunnel, opening it to target or
+// reopening it when the instance address changed
+func (d *Driver) bastionForward(tunnel **bastionTunnel, target string) (int, error) {
+	if *tunnel != nil && (*tunnel).target != target {
+		(*tunnel).Close()
+		*tunnel = nil
+	}
+	if *tunnel == nil {
+		opened, err := d.openBastionTunnel(target)
+		if err != nil {
+			return 0, err
+		}
+		*tunnel = opened
+	}
+	return (*tunnel).port(), nil
+}
+
+// bastionSSHConfig returns an ssh_config reaching the instance at host with
+// a ProxyJump through the bastion
+func (d *Driver) bastionSSHConfig(host string) string {
+	bastionHost, bastionPort, _ := net.SplitHostPort(d.bastionAddress())
+	bastionKey := d.BastionKeyPath
+	if bastionKey == "" && !d.SSHAgent {
+		bastionKey = d.GetSSHKeyPath()
+	}
+	port, _ := d.BaseDriver.GetSSHPort()
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "Host %s-bastion\n", d.MachineName)
+	fmt.Fprintf(&config, "  HostName %s\n  Port %s\n  User %s\n", bastionHost, bastionPort, d.bastionUser())
+	if bastionKey != "" {
+		fmt.Fprintf(&config, "  IdentityFile %s\n", bastionKey)
+	}
+	fmt.Fprintf(&config, "\nHost %s\n", d.MachineName)
+	fmt.Fprintf(&config, "  HostName %s\n  Port %d\n  User %s\n", host, port, d.GetSSHUsername())
+	if !d.SSHAgent {
+		fmt.Fprintf(&config, "  IdentityFile %s\n", d.GetSSHKeyPath())
+	}
+	fmt.Fprintf(&config, "  ProxyJump %s-bastion\n", d.MachineName)
+	// like the libmachine clients, which don't check the host keys
+	fmt.Fprintf(&config, "  StrictHostKeyChecking no\n  UserKnownHostsFile /dev/null\n")
+	return config.String()
+}
+
+// writeBastionSSHConfig writes the ssh_config of the instance to the machine
+// directory and returns its path
+func (d *Driver) writeBastionSSHConfig() (string, error) {
+	host, err := d.instanceSSHHostname()
+	if err != nil {
+		return "", err
+	}
+	path := d.ResolveStorePath(bastionSSHConfigFile)
+	if err := ioutil.WriteFile(path, []byte(d.bastionSSHConfig(host)), 0600); err != nil {
+		return "", fmt.Errorf("unable to write %s: %s", path, err)
+	}
+	return path, nil
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	if d.BastionHost == "" {
+		return d.BaseDriver.GetSSHPort()
+	}
+	_, port, err := d.bastionEndpoint()
+	return port, err
+}
@@ -0,0 +1,199 @@
+package outscale
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// startBastion runs an SSH server accepting signer and forwarding the
+// direct-tcpip channels, like a jump host
+func startBastion(t *testing.T, signer ssh.Signer) net.Listener {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(signer.PublicKey().Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					var target struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					ssh.Unmarshal(newChannel.ExtraData(), &target)
+					remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, channelRequests, _ := newChannel.Accept()
+					go ssh.DiscardRequests(channelRequests)
+					go func() {
+						defer channel.Close()
+						defer remote.Close()
+						go io.Copy(remote, channel)
+						io.Copy(channel, remote)
+					}()
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+// startSSHBanner listens like an instance sshd, writing its banner
+func startSSHBanner(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-test\r\n"))
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func TestBastionTunnel(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(private)
+	assert.NoError(t, err)
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}
+
+	dir, err := ioutil.TempDir("", "outscalebastion")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(dir, "bastion")
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+
+	bastion := startBastion(t, signer)
+	defer bastion.Close()
+	sshd := startSSHBanner(t)
+	defer sshd.Close()
+
+	driver := NewCustomTestDriver(&fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:       aws.String("i-12345"),
+			PrivateIpAddress: aws.String("127.0.0.1"),
+			State:            &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		}},
+	}}})
+	driver.InstanceId = "i-12345"
+	driver.PrivateIPOnly = true
+	driver.SSHPort = sshd.Addr().(*net.TCPAddr).Port
+	driver.SSHConnectTimeout = 5
+	driver.BastionHost = bastion.Addr().String()
+	driver.BastionUser = "jump"
+	driver.BastionKeyPath = keyPath
+	assert.Equal(t, "jump@"+bastion.Addr().String(), driver.proxyJump())
+
+	host, err := driver.GetSSHHostname()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+	port, err := driver.GetSSHPort()
+	assert.NoError(t, err)
+	assert.NotEqual(t, driver.SSHPort, port)
+	defer driver.bastion.Close()
+
+	assert.True(t, sshAvailable(net.JoinHostPort(host, strconv.Itoa(port)), 5*time.Second))
+
+	// the Docker URL is the local end of the documented ssh -L forward,
+	// which outlives the driver process, unlike its tunnels
+	url, err := driver.GetURL()
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://localhost:2376", url)
+}
+
+func TestBastionAddress(t *testing.T) {
+	driver := NewTestDriver()
+	driver.SSHUser = "outscale"
+	driver.BastionHost = "bastion.example.com"
+	assert.Equal(t, "outscale@bastion.example.com:22", driver.proxyJump())
+
+	driver.BastionHost = "10.0.0.5:2222"
+	driver.BastionUser = "jump"
+	assert.Equal(t, "jump@10.0.0.5:2222", driver.proxyJump())
+}
+
+func TestBastionSSHConfig(t *testing.T) {
+	driver := NewTestDriver()
+	driver.MachineName = "node1"
+	driver.SSHUser = "outscale"
+	driver.SSHPort = 22
+	driver.SSHKeyPath = "/store/machines/node1/id_rsa"
+	driver.BastionHost = "10.0.0.5:2222"
+	driver.BastionUser = "jump"
+	driver.BastionKeyPath = "/keys/bastion"
+
+	assert.Equal(t, `Host node1-bastion
+  HostName 10.0.0.5
+  Port 2222
+  User jump
+  IdentityFile /keys/bastion
+
+Host node1
+  HostName 192.168.1.10
+  Port 22
+  User outscale
+  IdentityFile /store/machines/node1/id_rsa
+  ProxyJump node1-bastion
+  StrictHostKeyChecking no
+  UserKnownHostsFile /dev/null
+`, driver.bastionSSHConfig("192.168.1.10"))
+}
+
+func TestSetConfigFromFlagsBastionUserWithoutHost(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "us-east-2a",
+			"outscale-bastion-user": "jump",
+		},
+	}
+
+	assert.Equal(t, errorBastionOptionsWithoutHost, driver.SetConfigFromFlags(options))
+}
@@ -0,0 +1,107 @@
+package outscale
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// callGroup coalesces concurrent calls sharing the same key, so that only
+// one of them reaches the API and the others wait for its result.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*groupCall
+}
+
+type groupCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Do runs fn unless a call for key is already in flight, in which case it
+// waits for that call and returns its result.
+func (g *callGroup) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*groupCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &groupCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return c.val, c.err
+}
+
+// instanceLookups is shared by all drivers of the process, Rancher running
+// many of them side by side.
+var instanceLookups callGroup
+
+// instanceCacheTTL is how long a driver reuses the instance it looked up,
+// shorter than waitInterval so that waiting for a change always sees it
+const instanceCacheTTL = 2 * time.Second
+
+// instanceCache keeps the last instance a driver looked up, so that the
+// GetState, GetIP and GetURL calls docker-machine and Rancher make in a row
+// share one lookup
+type instanceCache struct {
+	mu       sync.Mutex
+	key      string
+	instance *ec2.Instance
+	at       time.Time
+}
+
+// forgetInstanceHandler drops the cached instance after every API call but
+// the lookups, which may have changed the instance: associating an external
+// IP or changing an attribute while creating it for instance
+func (d *Driver) forgetInstanceHandler() request.NamedHandler {
+	return request.NamedHandler{
+		Name: "outscale.ForgetInstanceHandler",
+		Fn: func(r *request.Request) {
+			name := r.Operation.Name
+			if !strings.HasPrefix(name, "Describe") && !strings.HasPrefix(name, "Read") {
+				d.instanceCache.forget()
+			}
+		},
+	}
+}
+
+// get returns the instance of key if it was looked up less than
+// instanceCacheTTL ago
+func (c *instanceCache) get(key string) *ec2.Instance {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.instance == nil || c.key != key || time.Since(c.at) >= instanceCacheTTL {
+		return nil
+	}
+	return c.instance
+}
+
+func (c *instanceCache) put(key string, instance *ec2.Instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key, c.instance, c.at = key, instance, time.Now()
+}
+
+// forget drops the instance after the driver changed it
+func (c *instanceCache) forget() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.instance = nil
+}
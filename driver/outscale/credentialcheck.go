@@ -0,0 +1,258 @@
+package outscale
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+// Errors meaning the credentials themselves are wrong, as opposed to lacking
+// a permission
+var credentialErrorCodes = map[string]bool{
+	"AuthFailure":                true,
+	"SignatureDoesNotMatch":      true,
+	"InvalidClientTokenId":       true,
+	"InvalidAccessKeyId":         true,
+	"MissingAuthenticationToken": true,
+	"RequestExpired":             true,
+}
+
+// PermissionCheck is the outcome of one read-only call made with the
+// credentials
+type PermissionCheck struct {
+	Action  string `json:"action"`
+	Allowed bool   `json:"allowed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CredentialReport summarizes what the credentials are allowed to do
+type CredentialReport struct {
+	Valid       bool              `json:"valid"`
+	Permissions []PermissionCheck `json:"permissions"`
+}
+
+// ValidateCredentials checks the configured credentials with cheap
+// read-only calls, so that bad keys are caught when a cloud credential is
+// saved rather than on the first node creation. It only needs the
+// credential, region and endpoint options. An error is returned when the
+// credentials are rejected, missing permissions are only reported.
+func (d *Driver) ValidateCredentials() (*CredentialReport, error) {
+	if _, err := d.awsCredentialsFactory().Credentials().Get(); err != nil {
+		return &CredentialReport{}, errorMissingCredentials
+	}
+
+	client := d.getClient()
+	probes := []struct {
+		action string
+		call   func() error
+	}{
+		{"DescribeAccountAttributes", func() error {
+			_, err := client.DescribeAccountAttributes(&ec2.DescribeAccountAttributesInput{})
+			return err
+		}},
+		{"DescribeInstances", func() error {
+			_, err := client.DescribeInstances(&ec2.DescribeInstancesInput{
+				InstanceIds: []*string{aws.String("i-00000000")},
+			})
+			return ignoreNotFound(err)
+		}},
+		{"DescribeSecurityGroups", func() error {
+			_, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+				Filters: []*ec2.Filter{{Name: aws.String("group-name"), Values: []*string{aws.String(defaultSecurityGroup)}}},
+			})
+			return err
+		}},
+		{"DescribeKeyPairs", func() error {
+			_, err := client.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+				Filters: []*ec2.Filter{{Name: aws.String("key-name"), Values: []*string{aws.String(userAgentName)}}},
+			})
+			return err
+		}},
+		{"DescribeAddresses", func() error {
+			_, err := client.DescribeAddresses(&ec2.DescribeAddressesInput{
+				Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: []*string{aws.String("i-00000000")}}},
+			})
+			return err
+		}},
+	}
+
+	report := &CredentialReport{Valid: true}
+	for _, probe := range probes {
+		err := probe.call()
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoCredentialProviders" {
+			report.Valid = false
+			return report, fmt.Errorf("no Outscale credentials found: %s", err)
+		}
+		if awsErr, ok := err.(awserr.Error); ok && credentialErrorCodes[awsErr.Code()] {
+			report.Valid = false
+			return report, fmt.Errorf("the Outscale API rejected the credentials: %s", err)
+		}
+		check := PermissionCheck{Action: probe.action, Allowed: err == nil}
+		if err != nil {
+			check.Error = err.Error()
+		}
+		report.Permissions = append(report.Permissions, check)
+	}
+	return report, nil
+}
+
+// ValidateCredentialsCommand runs the validate-credentials subcommand: the
+// driver flags in args, falling back to their OS_* environment variables,
+// configure a driver whose credential report is written to out as JSON. The
+// report is written even when the credentials are rejected, along with the
+// error.
+func ValidateCredentialsCommand(args []string, out io.Writer) error {
+	d := NewDriver("", "")
+	options, err := parseCommandOptions(d.GetCreateFlags(), args)
+	if err != nil {
+		return err
+	}
+	if err := d.SetConfigFromFlags(options); err != nil {
+		return err
+	}
+
+	report, checkErr := d.ValidateCredentials()
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, string(encoded))
+	return checkErr
+}
+
+// commandOptions holds the driver options of a subcommand run outside of
+// docker-machine
+type commandOptions map[string]interface{}
+
+func (o commandOptions) String(key string) string {
+	value, _ := o[key].(*string)
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func (o commandOptions) StringSlice(key string) []string {
+	value, _ := o[key].(*stringSliceValue)
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func (o commandOptions) Int(key string) int {
+	value, _ := o[key].(*int)
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+func (o commandOptions) Bool(key string) bool {
+	value, _ := o[key].(*bool)
+	if value == nil {
+		return false
+	}
+	return *value
+}
+
+// stringSliceValue is a repeatable string flag, the first use replacing the
+// default
+type stringSliceValue []string
+
+func (s *stringSliceValue) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceValue) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// parseCommandOptions parses args, as --name value or --name=value, against
+// the driver flags. The default of a flag is its environment variable when
+// set, like under docker-machine.
+func parseCommandOptions(flags []mcnflag.Flag, args []string) (commandOptions, error) {
+	set := flag.NewFlagSet("", flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	options := commandOptions{}
+	for _, f := range flags {
+		switch f := f.(type) {
+		case mcnflag.StringFlag:
+			value := f.Value
+			if env := os.Getenv(f.EnvVar); f.EnvVar != "" && env != "" {
+				value = env
+			}
+			options[f.Name] = set.String(f.Name, value, f.Usage)
+		case mcnflag.IntFlag:
+			value := f.Value
+			if env := os.Getenv(f.EnvVar); f.EnvVar != "" && env != "" {
+				parsed, err := strconv.Atoi(env)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s %q: %s", f.EnvVar, env, err)
+				}
+				value = parsed
+			}
+			options[f.Name] = set.Int(f.Name, value, f.Usage)
+		case mcnflag.BoolFlag:
+			value := false
+			if env := os.Getenv(f.EnvVar); f.EnvVar != "" && env != "" {
+				parsed, err := strconv.ParseBool(env)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s %q: %s", f.EnvVar, env, err)
+				}
+				value = parsed
+			}
+			options[f.Name] = set.Bool(f.Name, value, f.Usage)
+		case mcnflag.StringSliceFlag:
+			value := &stringSliceValue{}
+			set.Var(value, f.Name, f.Usage)
+			options[f.Name] = value
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+	if set.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", set.Arg(0))
+	}
+
+	// docker-machine only falls back to the default of a slice flag when it
+	// isn't used, and splits its environment variable on commas
+	for _, f := range flags {
+		if f, ok := f.(mcnflag.StringSliceFlag); ok {
+			value := options[f.Name].(*stringSliceValue)
+			if len(*value) > 0 {
+				continue
+			}
+			if env := os.Getenv(f.EnvVar); f.EnvVar != "" && env != "" {
+				*value = strings.Split(env, ",")
+			} else {
+				*value = append(*value, f.Value...)
+			}
+		}
+	}
+	return options, nil
+}
+
+// ignoreNotFound drops the error of a describe call on a resource that
+// doesn't exist, which shows the call is allowed
+func ignoreNotFound(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "InvalidInstanceID.NotFound" {
+		return nil
+	}
+	return err
+}
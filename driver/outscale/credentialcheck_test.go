@@ -0,0 +1,98 @@
+package outscale
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCredentialsFakeAPI(t *testing.T) {
+	driver := NewDriver("", "")
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"outscale-fake-api":     true,
+			"outscale-region":       "us-east-2",
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+
+	report, err := driver.ValidateCredentials()
+
+	assert.NoError(t, err)
+	assert.True(t, report.Valid)
+	assert.Len(t, report.Permissions, 5)
+	for _, check := range report.Permissions {
+		assert.True(t, check.Allowed, "%s: %s", check.Action, check.Error)
+	}
+}
+
+func TestValidateCredentialsRejected(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2Unauthorized{Ec2Client: &fakeEC2{}, code: "AuthFailure"})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+
+	report, err := driver.ValidateCredentials()
+
+	assert.EqualError(t, err, "the Outscale API rejected the credentials: AuthFailure: denied")
+	assert.False(t, report.Valid)
+}
+
+func TestValidateCredentialsMissingPermission(t *testing.T) {
+	driver := NewDriver("", "")
+	driver.FakeAPI = true
+	// buildClient reads the credentials
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	client := &fakeEC2Unauthorized{Ec2Client: driver.buildClient(), code: "UnauthorizedOperation"}
+	driver.clientFactory = func() Ec2Client { return client }
+
+	report, err := driver.ValidateCredentials()
+
+	assert.NoError(t, err)
+	assert.True(t, report.Valid)
+	assert.Equal(t, PermissionCheck{Action: "DescribeAccountAttributes", Error: "UnauthorizedOperation: denied"}, report.Permissions[0])
+	assert.True(t, report.Permissions[1].Allowed)
+}
+
+func TestValidateCredentialsMissing(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2Unauthorized{Ec2Client: &fakeEC2{}, code: "NoCredentialProviders"})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+
+	report, err := driver.ValidateCredentials()
+
+	assert.EqualError(t, err, "no Outscale credentials found: NoCredentialProviders: denied")
+	assert.False(t, report.Valid)
+}
+
+func TestValidateCredentialsCommand(t *testing.T) {
+	var out bytes.Buffer
+	err := ValidateCredentialsCommand([]string{"--outscale-fake-api", "--outscale-region=us-east-2"}, &out)
+
+	assert.NoError(t, err)
+	var report CredentialReport
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.True(t, report.Valid)
+	assert.Len(t, report.Permissions, 5)
+}
+
+func TestValidateCredentialsCommandEnv(t *testing.T) {
+	os.Setenv("OS_DEFAULT_REGION", "us-west-1")
+	defer os.Unsetenv("OS_DEFAULT_REGION")
+
+	options, err := parseCommandOptions(NewDriver("", "").GetCreateFlags(), []string{"--outscale-access-key", "AK"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AK", options.String("outscale-access-key"))
+	assert.Equal(t, "us-west-1", options.String("outscale-region"))
+}
+
+func TestValidateCredentialsCommandUnknownFlag(t *testing.T) {
+	var out bytes.Buffer
+	err := ValidateCredentialsCommand([]string{"--outscale-nope"}, &out)
+
+	assert.Error(t, err)
+	assert.Empty(t, out.String())
+}
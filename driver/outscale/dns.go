@@ -0,0 +1,159 @@
+package outscale
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	defaultDNSName = "{{.Hostname}}"
+	defaultDNSTTL  = 300
+
+	dnsActionUpsert = "upsert"
+	dnsActionDelete = "delete"
+)
+
+var errorDNSZoneWithoutWebhook = errors.New("--outscale-dns-zone, --outscale-dns-name and --outscale-dns-webhook-token require --outscale-dns-webhook")
+
+// dnsNameData is what the --outscale-dns-name template is executed with
+type dnsNameData struct {
+	MachineName string
+	Hostname    string
+	Region      string
+	Zone        string
+}
+
+// dnsRecordRequest is the JSON body posted to --outscale-dns-webhook, the
+// webhook upserting or deleting the A record in the DNS provider
+type dnsRecordRequest struct {
+	Action  string `json:"action"`
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	TTL     int    `json:"ttl"`
+	Machine string `json:"machine"`
+}
+
+// checkDNSOptions validates the DNS record options, a webhook needing the
+// zone the record goes in
+func (d *Driver) checkDNSOptions() error {
+	if d.DNSWebhook == "" {
+		if d.DNSZone != "" || (d.DNSName != "" && d.DNSName != defaultDNSName) || d.DNSWebhookToken != "" {
+			return errorDNSZoneWithoutWebhook
+		}
+		return nil
+	}
+	if u, err := url.Parse(d.DNSWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --outscale-dns-webhook %q, expected an http(s) URL", d.DNSWebhook)
+	}
+	if d.DNSZone == "" {
+		return errors.New("--outscale-dns-webhook requires --outscale-dns-zone")
+	}
+	_, err := d.dnsRecordName()
+	return err
+}
+
+// dnsRecordName renders --outscale-dns-name in --outscale-dns-zone
+func (d *Driver) dnsRecordName() (string, error) {
+	if d.DNSName == "" {
+		d.DNSName = defaultDNSName
+	}
+	tmpl, err := template.New("dns-name").Option("missingkey=error").Parse(d.DNSName)
+	if err != nil {
+		return "", fmt.Errorf("invalid --outscale-dns-name %q: %s", d.DNSName, err)
+	}
+	var name bytes.Buffer
+	if err := tmpl.Execute(&name, dnsNameData{
+		MachineName: d.MachineName,
+		Hostname:    d.machineHostname(),
+		Region:      d.Region,
+		Zone:        d.getRegionZone(),
+	}); err != nil {
+		return "", fmt.Errorf("invalid --outscale-dns-name %q: %s", d.DNSName, err)
+	}
+	label := strings.Trim(name.String(), ".")
+	if label == "" {
+		return "", fmt.Errorf("--outscale-dns-name %q gives an empty name", d.DNSName)
+	}
+	return label + "." + strings.Trim(d.DNSZone, "."), nil
+}
+
+// createDNSRecord points the machine DNS record at its IP, replacing the
+// record of a previous IP after the machine got a new one
+func (d *Driver) createDNSRecord() error {
+	if d.DNSWebhook == "" {
+		return nil
+	}
+
+	name, err := d.dnsRecordName()
+	if err != nil {
+		return err
+	}
+	if d.DNSRecordName == name && d.DNSRecordValue == d.IPAddress {
+		return nil
+	}
+
+	log.Infof("Pointing DNS record %s at %s", name, d.IPAddress)
+	if err := d.callDNSWebhook(dnsActionUpsert, name, d.IPAddress); err != nil {
+		return err
+	}
+	d.DNSRecordName = name
+	d.DNSRecordValue = d.IPAddress
+	return nil
+}
+
+// deleteDNSRecord deletes the record createDNSRecord created
+func (d *Driver) deleteDNSRecord() error {
+	if err := d.callDNSWebhook(dnsActionDelete, d.DNSRecordName, d.DNSRecordValue); err != nil {
+		return err
+	}
+	d.DNSRecordName = ""
+	d.DNSRecordValue = ""
+	return nil
+}
+
+func (d *Driver) callDNSWebhook(action, name, value string) error {
+	body, err := json.Marshal(&dnsRecordRequest{
+		Action:  action,
+		Zone:    strings.Trim(d.DNSZone, "."),
+		Name:    name,
+		Type:    "A",
+		Value:   value,
+		TTL:     defaultDNSTTL,
+		Machine: d.MachineName,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", d.DNSWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.DNSWebhookToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.DNSWebhookToken)
+	}
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to %s DNS record %s: %s", action, name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unable to %s DNS record %s: webhook answered %s %s", action, name, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
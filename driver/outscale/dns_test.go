@@ -0,0 +1,127 @@
+package outscale
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDNSWebhook(t *testing.T, requests *[]dnsRecordRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		request := dnsRecordRequest{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		*requests = append(*requests, request)
+	}))
+}
+
+func TestDNSRecordName(t *testing.T) {
+	driver := NewTestDriver()
+	driver.MachineName = "Pool1_Worker"
+	driver.DNSZone = "nodes.example.com."
+
+	name, err := driver.dnsRecordName()
+	assert.NoError(t, err)
+	assert.Equal(t, driver.machineHostname()+".nodes.example.com", name)
+
+	driver.DNSName = "{{.MachineName}}.{{.Region}}"
+	driver.MachineName = "worker1"
+	name, err = driver.dnsRecordName()
+	assert.NoError(t, err)
+	assert.Equal(t, "worker1.us-east-2.nodes.example.com", name)
+
+	driver.DNSName = "{{.Rack}}"
+	_, err = driver.dnsRecordName()
+	assert.Error(t, err)
+}
+
+func TestCheckDNSOptions(t *testing.T) {
+	driver := NewTestDriver()
+	assert.NoError(t, driver.checkDNSOptions())
+
+	driver.DNSZone = "example.com"
+	assert.Equal(t, errorDNSZoneWithoutWebhook, driver.checkDNSOptions())
+
+	driver.DNSWebhook = "dns.example.com/records"
+	assert.EqualError(t, driver.checkDNSOptions(), `invalid --outscale-dns-webhook "dns.example.com/records", expected an http(s) URL`)
+
+	driver.DNSWebhook = "https://dns.example.com/records"
+	assert.NoError(t, driver.checkDNSOptions())
+
+	driver.DNSZone = ""
+	assert.EqualError(t, driver.checkDNSOptions(), "--outscale-dns-webhook requires --outscale-dns-zone")
+}
+
+func TestDNSRecordLifecycle(t *testing.T) {
+	requests := []dnsRecordRequest{}
+	webhook := newDNSWebhook(t, &requests)
+	defer webhook.Close()
+
+	driver := NewCustomTestDriver(&fakeEC2Remove{})
+	driver.MachineName = "worker1"
+	driver.DNSWebhook = webhook.URL
+	driver.DNSWebhookToken = "secret"
+	driver.DNSZone = "example.com"
+	driver.IPAddress = "1.2.3.4"
+
+	assert.NoError(t, driver.createDNSRecord())
+	assert.NoError(t, driver.createDNSRecord())
+	assert.Equal(t, "worker1.example.com", driver.DNSRecordName)
+
+	driver.IPAddress = "5.6.7.8"
+	assert.NoError(t, driver.createDNSRecord())
+
+	report := driver.remove()
+	assert.NoError(t, report.err())
+	assert.Equal(t, cleanupResult{Resource: "DNS record", Id: "worker1.example.com", Status: cleanupDeleted}, report.Results[0])
+	assert.Empty(t, driver.DNSRecordName)
+
+	assert.Equal(t, []dnsRecordRequest{
+		{Action: dnsActionUpsert, Zone: "example.com", Name: "worker1.example.com", Type: "A", Value: "1.2.3.4", TTL: defaultDNSTTL, Machine: "worker1"},
+		{Action: dnsActionUpsert, Zone: "example.com", Name: "worker1.example.com", Type: "A", Value: "5.6.7.8", TTL: defaultDNSTTL, Machine: "worker1"},
+		{Action: dnsActionDelete, Zone: "example.com", Name: "worker1.example.com", Type: "A", Value: "5.6.7.8", TTL: defaultDNSTTL, Machine: "worker1"},
+	}, requests)
+}
+
+func TestDNSRecordKeptWithInstance(t *testing.T) {
+	requests := []dnsRecordRequest{}
+	webhook := newDNSWebhook(t, &requests)
+	defer webhook.Close()
+
+	driver := NewCustomTestDriver(&fakeEC2Remove{terminateErr: errors.New("RequestLimitExceeded")})
+	driver.MachineName = "worker1"
+	driver.DNSWebhook = webhook.URL
+	driver.DNSWebhookToken = "secret"
+	driver.DNSZone = "example.com"
+	driver.IPAddress = "1.2.3.4"
+	driver.InstanceId = "i-12345"
+	assert.NoError(t, driver.createDNSRecord())
+
+	report := driver.remove()
+	assert.Error(t, report.err())
+	assert.Equal(t, "DNS record worker1.example.com: kept (points at the kept instance)", report.Results[1].String())
+	assert.Equal(t, "worker1.example.com", driver.DNSRecordName)
+	assert.Len(t, requests, 1)
+}
+
+func TestDNSWebhookError(t *testing.T) {
+	requests := []dnsRecordRequest{}
+	webhook := newDNSWebhook(t, &requests)
+	defer webhook.Close()
+
+	driver := NewTestDriver()
+	driver.MachineName = "worker1"
+	driver.DNSWebhook = webhook.URL
+	driver.DNSZone = "example.com"
+	driver.IPAddress = "1.2.3.4"
+
+	assert.EqualError(t, driver.createDNSRecord(), "unable to upsert DNS record worker1.example.com: webhook answered 401 Unauthorized bad token")
+	assert.Empty(t, driver.DNSRecordName)
+}
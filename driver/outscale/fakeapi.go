@@ -0,0 +1,1087 @@
+package outscale
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The fake API is an emulation of the FCU endpoint, served over httptest so
+// that the real SDK code path is exercised. It is enabled with
+// --outscale-fake-api. Its state is kept in the machine store, so that the
+// docker-machine commands of a machine, each running its own driver process,
+// see the same resources. The driver doesn't SSH to the fake instances, but
+// the provisioning docker-machine runs after Create does and fails once the
+// resources are created.
+
+const (
+	fakeAPIVpcId = "vpc-fake0001"
+
+	// fakeAPIStateFile is the file of the store path keeping the state
+	fakeAPIStateFile = "outscale-fake-api.json"
+)
+
+var (
+	fakeAPIMu      sync.Mutex
+	fakeAPIServers = map[string]*httptest.Server{}
+)
+
+// fakeAPIEndpoint starts the fake API keeping its state in storePath on
+// first use and returns its URL. Without a store path, the state only lives
+// as long as the driver process.
+func fakeAPIEndpoint(storePath string) string {
+	fakeAPIMu.Lock()
+	defer fakeAPIMu.Unlock()
+
+	server, ok := fakeAPIServers[storePath]
+	if !ok {
+		api := newFakeAPI()
+		if storePath != "" {
+			api.statePath = filepath.Join(storePath, fakeAPIStateFile)
+		}
+		server = httptest.NewServer(api)
+		fakeAPIServers[storePath] = server
+	}
+	return server.URL
+}
+
+type fakeAPIError struct {
+	Code    string
+	Message string
+}
+
+func (e *fakeAPIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type fakeAPI struct {
+	mu             sync.Mutex
+	statePath      string
+	counter        int
+	instances      map[string]*ec2.Instance
+	addresses      map[string]*ec2.Address
+	securityGroups map[string]*ec2.SecurityGroup
+	keyPairs       map[string]*ec2.KeyPairInfo
+	handlers       map[string]interface{}
+}
+
+// fakeAPIState is the persisted state of the fake API
+type fakeAPIState struct {
+	Counter        int
+	Instances      map[string]*ec2.Instance
+	Addresses      map[string]*ec2.Address
+	SecurityGroups map[string]*ec2.SecurityGroup
+	KeyPairs       map[string]*ec2.KeyPairInfo
+}
+
+// load reads the state saved by the last call, made by any process
+func (f *fakeAPI) load() error {
+	data, err := ioutil.ReadFile(f.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := fakeAPIState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid fake API state %s: %s", f.statePath, err)
+	}
+	f.counter = state.Counter
+	f.instances = map[string]*ec2.Instance{}
+	for id, instance := range state.Instances {
+		f.instances[id] = instance
+	}
+	f.addresses = map[string]*ec2.Address{}
+	for id, address := range state.Addresses {
+		f.addresses[id] = address
+	}
+	f.securityGroups = map[string]*ec2.SecurityGroup{}
+	for id, group := range state.SecurityGroups {
+		f.securityGroups[id] = group
+	}
+	f.keyPairs = map[string]*ec2.KeyPairInfo{}
+	for name, keyPair := range state.KeyPairs {
+		f.keyPairs[name] = keyPair
+	}
+	return nil
+}
+
+// save writes the state for the next call, replacing the file atomically
+func (f *fakeAPI) save() error {
+	data, err := json.Marshal(fakeAPIState{
+		Counter:        f.counter,
+		Instances:      f.instances,
+		Addresses:      f.addresses,
+		SecurityGroups: f.securityGroups,
+		KeyPairs:       f.keyPairs,
+	})
+	if err != nil {
+		return err
+	}
+	tmp := f.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.statePath)
+}
+
+func newFakeAPI() *fakeAPI {
+	f := &fakeAPI{
+		instances:      map[string]*ec2.Instance{},
+		addresses:      map[string]*ec2.Address{},
+		securityGroups: map[string]*ec2.SecurityGroup{},
+		keyPairs:       map[string]*ec2.KeyPairInfo{},
+	}
+	f.handlers = map[string]interface{}{
+		"DescribeAccountAttributes":     f.describeAccountAttributes,
+		"DescribeSubnets":               f.describeSubnets,
+		"DescribeVpcs":                  f.describeVpcs,
+		"DescribeImages":                f.describeImages,
+		"ImportKeyPair":                 f.importKeyPair,
+		"DescribeKeyPairs":              f.describeKeyPairs,
+		"DeleteKeyPair":                 f.deleteKeyPair,
+		"CreateSecurityGroup":           f.createSecurityGroup,
+		"DescribeSecurityGroups":        f.describeSecurityGroups,
+		"AuthorizeSecurityGroupIngress": f.authorizeSecurityGroupIngress,
+		"RevokeSecurityGroupIngress":    f.revokeSecurityGroupIngress,
+		"DeleteSecurityGroup":           f.deleteSecurityGroup,
+		"CreateTags":                    f.createTags,
+		"RunInstances":                  f.runInstances,
+		"DescribeInstances":             f.describeInstances,
+		"StartInstances":                f.startInstances,
+		"StopInstances":                 f.stopInstances,
+		"RebootInstances":               f.rebootInstances,
+		"TerminateInstances":            f.terminateInstances,
+		"ModifyInstanceMetadataOptions": f.modifyInstanceMetadataOptions,
+		"ModifyInstanceAttribute":       f.modifyInstanceAttribute,
+		"AllocateAddress":               f.allocateAddress,
+		"AssociateAddress":              f.associateAddress,
+		"DescribeAddresses":             f.describeAddresses,
+		"DescribeNetworkInterfaces":     f.describeNetworkInterfaces,
+		"DisassociateAddress":           f.disassociateAddress,
+		"ReleaseAddress":                f.releaseAddress,
+		"CreateSnapshot":                f.createSnapshot,
+		"DeleteVolume":                  f.deleteVolume,
+	}
+	return f
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.statePath != "" {
+		unlock, err := lockFile(f.statePath + ".lock")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer unlock()
+		if err := f.load(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	action := r.Form.Get("Action")
+	requestID := f.newId("req")
+
+	handler, ok := f.handlers[action]
+	if !ok {
+		writeFakeAPIError(w, requestID, &fakeAPIError{"InvalidAction", fmt.Sprintf("The action %s is not valid for this web service.", action)})
+		return
+	}
+
+	fn := reflect.ValueOf(handler)
+	input := reflect.New(fn.Type().In(0).Elem())
+	decodeQuery(r.Form, input.Elem(), "")
+
+	results := fn.Call([]reflect.Value{input})
+	if err, _ := results[1].Interface().(error); err != nil {
+		writeFakeAPIError(w, requestID, err)
+		return
+	}
+	if f.statePath != "" {
+		if err := f.save(); err != nil {
+			writeFakeAPIError(w, requestID, err)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	root := xml.StartElement{Name: xml.Name{Local: action + "Response"}}
+	e.EncodeToken(root)
+	encodeXMLValue(e, "requestId", reflect.ValueOf(requestID), "")
+	output := results[0].Elem()
+	for i := 0; i < output.NumField(); i++ {
+		field := output.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		encodeXMLValue(e, xmlFieldName(field), output.Field(i), field.Tag)
+	}
+	e.EncodeToken(root.End())
+	e.Flush()
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(buf.Bytes())
+}
+
+func writeFakeAPIError(w http.ResponseWriter, requestID string, err error) {
+	apiErr, ok := err.(*fakeAPIError)
+	if !ok {
+		apiErr = &fakeAPIError{"InternalError", err.Error()}
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, "<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors><RequestID>%s</RequestID></Response>",
+		xmlEscape(apiErr.Code), xmlEscape(apiErr.Message), requestID)
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func (f *fakeAPI) newId(prefix string) string {
+	f.counter++
+	return fmt.Sprintf("%s-%08x", prefix, f.counter)
+}
+
+// decodeQuery fills v from EC2 query parameters, following the naming rules
+// the SDK uses to build them.
+func decodeQuery(values url.Values, v reflect.Value, prefix string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !queryHasPrefix(values, prefix) {
+			return
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		decodeQuery(values, v.Elem(), prefix)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := queryFieldName(field)
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			decodeQuery(values, v.Field(i), name)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if b, err := base64.StdEncoding.DecodeString(values.Get(prefix)); err == nil {
+				v.SetBytes(b)
+			}
+			return
+		}
+		for i := 1; queryHasPrefix(values, prefix+"."+strconv.Itoa(i)); i++ {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			decodeQuery(values, elem, prefix+"."+strconv.Itoa(i))
+			v.Set(reflect.Append(v, elem))
+		}
+	case reflect.String:
+		v.SetString(values.Get(prefix))
+	case reflect.Bool:
+		b, _ := strconv.ParseBool(values.Get(prefix))
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, _ := strconv.ParseInt(values.Get(prefix), 10, 64)
+		v.SetInt(n)
+	case reflect.Float64:
+		n, _ := strconv.ParseFloat(values.Get(prefix), 64)
+		v.SetFloat(n)
+	}
+}
+
+func queryHasPrefix(values url.Values, prefix string) bool {
+	for key := range values {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func queryFieldName(field reflect.StructField) string {
+	name := field.Tag.Get("queryName")
+	if name == "" {
+		if field.Tag.Get("flattened") != "" && field.Tag.Get("locationNameList") != "" {
+			name = field.Tag.Get("locationNameList")
+		} else {
+			name = field.Tag.Get("locationName")
+		}
+		if name != "" {
+			name = strings.ToUpper(name[:1]) + name[1:]
+		}
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+func xmlFieldName(field reflect.StructField) string {
+	if name := field.Tag.Get("locationName"); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// encodeXMLValue writes v the way the EC2 protocol unmarshaler expects to
+// read it back.
+func encodeXMLValue(e *xml.Encoder, name string, v reflect.Value, tag reflect.StructTag) {
+	text := func(s string) {
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		e.EncodeToken(start)
+		e.EncodeToken(xml.CharData(s))
+		e.EncodeToken(start.End())
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			encodeXMLValue(e, name, v.Elem(), tag)
+		}
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			text(t.UTC().Format("2006-01-02T15:04:05Z"))
+			return
+		}
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		e.EncodeToken(start)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			encodeXMLValue(e, xmlFieldName(field), v.Field(i), field.Tag)
+		}
+		e.EncodeToken(start.End())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			text(base64.StdEncoding.EncodeToString(v.Bytes()))
+			return
+		}
+		member := tag.Get("locationNameList")
+		if member == "" {
+			member = "member"
+		}
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		e.EncodeToken(start)
+		for i := 0; i < v.Len(); i++ {
+			encodeXMLValue(e, member, v.Index(i), "")
+		}
+		e.EncodeToken(start.End())
+	case reflect.String:
+		text(v.String())
+	case reflect.Bool:
+		text(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int64:
+		text(strconv.FormatInt(v.Int(), 10))
+	case reflect.Float64:
+		text(strconv.FormatFloat(v.Float(), 'f', -1, 64))
+	}
+}
+
+// matchFakeFilters reports whether an object matches all filters, lookup
+// returning the object values for a filter name. Unknown filters are
+// ignored.
+func matchFakeFilters(filters []*ec2.Filter, lookup func(name string) ([]string, bool)) bool {
+	for _, filter := range filters {
+		values, known := lookup(aws.StringValue(filter.Name))
+		if !known {
+			continue
+		}
+		matched := false
+		for _, want := range filter.Values {
+			for _, got := range values {
+				if aws.StringValue(want) == got {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func fakeTagLookup(tags []*ec2.Tag, name string) ([]string, bool) {
+	if !strings.HasPrefix(name, "tag:") {
+		return nil, false
+	}
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == strings.TrimPrefix(name, "tag:") {
+			return []string{aws.StringValue(tag.Value)}, true
+		}
+	}
+	return nil, true
+}
+
+func fakeMergeTags(tags []*ec2.Tag, add []*ec2.Tag) []*ec2.Tag {
+	for _, tag := range add {
+		replaced := false
+		for _, existing := range tags {
+			if aws.StringValue(existing.Key) == aws.StringValue(tag.Key) {
+				existing.Value = tag.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			tags = append(tags, &ec2.Tag{Key: tag.Key, Value: tag.Value})
+		}
+	}
+	return tags
+}
+
+func (f *fakeAPI) describeAccountAttributes(input *ec2.DescribeAccountAttributesInput) (*ec2.DescribeAccountAttributesOutput, error) {
+	return &ec2.DescribeAccountAttributesOutput{
+		AccountAttributes: []*ec2.AccountAttribute{
+			{
+				AttributeName: aws.String("default-vpc"),
+				AttributeValues: []*ec2.AccountAttributeValue{
+					{AttributeValue: aws.String(fakeAPIVpcId)},
+				},
+			},
+		},
+	}, nil
+}
+
+func (f *fakeAPI) describeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	vpc := &ec2.Vpc{
+		VpcId:     aws.String(fakeAPIVpcId),
+		CidrBlock: aws.String("10.0.0.0/16"),
+		IsDefault: aws.Bool(true),
+		State:     aws.String(ec2.VpcStateAvailable),
+	}
+	match := matchFakeFilters(input.Filters, func(name string) ([]string, bool) {
+		switch name {
+		case "vpc-id":
+			return []string{fakeAPIVpcId}, true
+		case "is-default":
+			return []string{"true"}, true
+		}
+		return fakeTagLookup(vpc.Tags, name)
+	})
+	if !match || (len(input.VpcIds) > 0 && !stringInPointerSlice(fakeAPIVpcId, input.VpcIds)) {
+		return &ec2.DescribeVpcsOutput{}, nil
+	}
+	return &ec2.DescribeVpcsOutput{Vpcs: []*ec2.Vpc{vpc}}, nil
+}
+
+func (f *fakeAPI) describeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	subnet := &ec2.Subnet{
+		SubnetId:         aws.String("subnet-fake0001"),
+		VpcId:            aws.String(fakeAPIVpcId),
+		AvailabilityZone: aws.String(defaultZone),
+		CidrBlock:        aws.String("10.0.0.0/24"),
+		DefaultForAz:     aws.Bool(true),
+	}
+	for _, filter := range input.Filters {
+		if len(filter.Values) == 0 {
+			continue
+		}
+		switch aws.StringValue(filter.Name) {
+		case "subnet-id":
+			subnet.SubnetId = filter.Values[0]
+		case "availability-zone":
+			subnet.AvailabilityZone = filter.Values[0]
+		}
+	}
+	for _, id := range input.SubnetIds {
+		subnet.SubnetId = id
+	}
+	return &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{subnet}}, nil
+}
+
+func (f *fakeAPI) describeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	images := []*ec2.Image{}
+	for _, id := range input.ImageIds {
+		images = append(images, &ec2.Image{
+			ImageId:        id,
+			Name:           id,
+			State:          aws.String(ec2.ImageStateAvailable),
+			Architecture:   aws.String(ec2.ArchitectureValuesX8664),
+			RootDeviceName: aws.String("/dev/sda1"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/sda1"),
+					Ebs: &ec2.EbsBlockDevice{
+						VolumeSize: aws.Int64(10),
+						VolumeType: aws.String(defaultVolumeType),
+					},
+				},
+			},
+		})
+	}
+	return &ec2.DescribeImagesOutput{Images: images}, nil
+}
+
+func (f *fakeAPI) importKeyPair(input *ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error) {
+	name := aws.StringValue(input.KeyName)
+	if _, ok := f.keyPairs[name]; ok {
+		return nil, &fakeAPIError{"InvalidKeyPair.Duplicate", fmt.Sprintf("The keypair '%s' already exists.", name)}
+	}
+	keyPair := &ec2.KeyPairInfo{
+		KeyName:        input.KeyName,
+		KeyPairId:      aws.String(f.newId("key")),
+		KeyFingerprint: aws.String("fa:ke"),
+	}
+	f.keyPairs[name] = keyPair
+	return &ec2.ImportKeyPairOutput{
+		KeyName:        input.KeyName,
+		KeyPairId:      keyPair.KeyPairId,
+		KeyFingerprint: keyPair.KeyFingerprint,
+	}, nil
+}
+
+func (f *fakeAPI) describeKeyPairs(input *ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+	keyPairs := []*ec2.KeyPairInfo{}
+	for name, keyPair := range f.keyPairs {
+		if len(input.KeyNames) > 0 && !stringInPointerSlice(name, input.KeyNames) {
+			continue
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	for _, name := range input.KeyNames {
+		if _, ok := f.keyPairs[aws.StringValue(name)]; !ok {
+			return nil, &fakeAPIError{keypairNotFoundCode, fmt.Sprintf("The key pair '%s' does not exist", aws.StringValue(name))}
+		}
+	}
+	return &ec2.DescribeKeyPairsOutput{KeyPairs: keyPairs}, nil
+}
+
+func (f *fakeAPI) deleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+	delete(f.keyPairs, aws.StringValue(input.KeyName))
+	return &ec2.DeleteKeyPairOutput{}, nil
+}
+
+func (f *fakeAPI) createSecurityGroup(input *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error) {
+	for _, group := range f.securityGroups {
+		if aws.StringValue(group.GroupName) == aws.StringValue(input.GroupName) &&
+			aws.StringValue(group.VpcId) == aws.StringValue(input.VpcId) {
+			return nil, &fakeAPIError{"InvalidGroup.Duplicate", fmt.Sprintf("The security group '%s' already exists", aws.StringValue(input.GroupName))}
+		}
+	}
+	id := f.newId("sg")
+	f.securityGroups[id] = &ec2.SecurityGroup{
+		GroupId:     aws.String(id),
+		GroupName:   input.GroupName,
+		Description: input.Description,
+		VpcId:       input.VpcId,
+	}
+	return &ec2.CreateSecurityGroupOutput{GroupId: aws.String(id)}, nil
+}
+
+func (f *fakeAPI) describeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	groups := []*ec2.SecurityGroup{}
+	for id, group := range f.securityGroups {
+		if len(input.GroupIds) > 0 && !stringInPointerSlice(id, input.GroupIds) {
+			continue
+		}
+		match := matchFakeFilters(input.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "group-name":
+				return []string{aws.StringValue(group.GroupName)}, true
+			case "group-id":
+				return []string{id}, true
+			case "vpc-id":
+				return []string{aws.StringValue(group.VpcId)}, true
+			}
+			return fakeTagLookup(group.Tags, name)
+		})
+		if match {
+			groups = append(groups, group)
+		}
+	}
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: groups}, nil
+}
+
+func (f *fakeAPI) authorizeSecurityGroupIngress(input *ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	group, ok := f.securityGroups[aws.StringValue(input.GroupId)]
+	if !ok {
+		return nil, &fakeAPIError{"InvalidGroup.NotFound", fmt.Sprintf("The security group '%s' does not exist", aws.StringValue(input.GroupId))}
+	}
+	current := splitPermissions(group.IpPermissions)
+	for rule := range splitPermissions(input.IpPermissions) {
+		if _, ok := current[rule]; ok {
+			return nil, &fakeAPIError{"InvalidPermission.Duplicate", fmt.Sprintf("the specified rule %s already exists", rule)}
+		}
+	}
+	group.IpPermissions = append(group.IpPermissions, input.IpPermissions...)
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *fakeAPI) revokeSecurityGroupIngress(input *ec2.RevokeSecurityGroupIngressInput) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	group, ok := f.securityGroups[aws.StringValue(input.GroupId)]
+	if !ok {
+		return nil, &fakeAPIError{"InvalidGroup.NotFound", fmt.Sprintf("The security group '%s' does not exist", aws.StringValue(input.GroupId))}
+	}
+	revoked := splitPermissions(input.IpPermissions)
+	kept := []*ec2.IpPermission{}
+	for name, perm := range splitPermissions(group.IpPermissions) {
+		if _, ok := revoked[name]; !ok {
+			kept = append(kept, perm)
+		}
+	}
+	group.IpPermissions = kept
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *fakeAPI) deleteSecurityGroup(input *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+	if _, ok := f.securityGroups[aws.StringValue(input.GroupId)]; !ok {
+		return nil, &fakeAPIError{"InvalidGroup.NotFound", fmt.Sprintf("The security group '%s' does not exist", aws.StringValue(input.GroupId))}
+	}
+	delete(f.securityGroups, aws.StringValue(input.GroupId))
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+func (f *fakeAPI) createTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	for _, resource := range input.Resources {
+		id := aws.StringValue(resource)
+		if instance, ok := f.instances[id]; ok {
+			instance.Tags = fakeMergeTags(instance.Tags, input.Tags)
+		} else if group, ok := f.securityGroups[id]; ok {
+			group.Tags = fakeMergeTags(group.Tags, input.Tags)
+		} else if address, ok := f.addresses[id]; ok {
+			address.Tags = fakeMergeTags(address.Tags, input.Tags)
+		} else {
+			for _, keyPair := range f.keyPairs {
+				if aws.StringValue(keyPair.KeyPairId) == id {
+					keyPair.Tags = fakeMergeTags(keyPair.Tags, input.Tags)
+				}
+			}
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *fakeAPI) runInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	id := f.newId("i")
+	instance := &ec2.Instance{
+		InstanceId:       aws.String(id),
+		ImageId:          input.ImageId,
+		InstanceType:     input.InstanceType,
+		KeyName:          input.KeyName,
+		Placement:        input.Placement,
+		PrivateIpAddress: aws.String(fmt.Sprintf("10.0.0.%d", len(f.instances)%250+4)),
+		VpcId:            aws.String(fakeAPIVpcId),
+		SourceDestCheck:  aws.Bool(true),
+		State: &ec2.InstanceState{
+			Code: aws.Int64(0),
+			Name: aws.String(ec2.InstanceStateNamePending),
+		},
+	}
+	for _, netSpec := range input.NetworkInterfaces {
+		if aws.Int64Value(netSpec.DeviceIndex) == 0 {
+			instance.SubnetId = netSpec.SubnetId
+		}
+		iface := &ec2.InstanceNetworkInterface{
+			NetworkInterfaceId: aws.String(f.newId("eni")),
+			SubnetId:           netSpec.SubnetId,
+			PrivateIpAddress:   netSpec.PrivateIpAddress,
+			Attachment: &ec2.InstanceNetworkInterfaceAttachment{
+				DeviceIndex:         netSpec.DeviceIndex,
+				DeleteOnTermination: netSpec.DeleteOnTermination,
+			},
+		}
+		for _, private := range netSpec.PrivateIpAddresses {
+			iface.PrivateIpAddresses = append(iface.PrivateIpAddresses, &ec2.InstancePrivateIpAddress{
+				PrivateIpAddress: private.PrivateIpAddress,
+				Primary:          private.Primary,
+			})
+		}
+		for i := int64(0); i < aws.Int64Value(netSpec.SecondaryPrivateIpAddressCount); i++ {
+			iface.PrivateIpAddresses = append(iface.PrivateIpAddresses, &ec2.InstancePrivateIpAddress{
+				PrivateIpAddress: aws.String(fmt.Sprintf("10.0.1.%d", i+4)),
+				Primary:          aws.Bool(false),
+			})
+		}
+		for i := int64(0); i < aws.Int64Value(netSpec.Ipv6AddressCount); i++ {
+			iface.Ipv6Addresses = append(iface.Ipv6Addresses, &ec2.InstanceIpv6Address{
+				Ipv6Address: aws.String(fmt.Sprintf("2001:db8::%x", len(f.instances)%250+4)),
+			})
+		}
+		instance.NetworkInterfaces = append(instance.NetworkInterfaces, iface)
+		for _, group := range netSpec.Groups {
+			instance.SecurityGroups = append(instance.SecurityGroups, &ec2.GroupIdentifier{GroupId: group})
+		}
+	}
+	for _, bdm := range input.BlockDeviceMappings {
+		if bdm.Ebs == nil {
+			continue
+		}
+		instance.BlockDeviceMappings = append(instance.BlockDeviceMappings, &ec2.InstanceBlockDeviceMapping{
+			DeviceName: bdm.DeviceName,
+			Ebs: &ec2.EbsInstanceBlockDevice{
+				VolumeId:            aws.String(f.newId("vol")),
+				DeleteOnTermination: aws.Bool(aws.BoolValue(bdm.Ebs.DeleteOnTermination)),
+				Status:              aws.String(ec2.AttachmentStatusAttached),
+			},
+		})
+	}
+	if len(instance.BlockDeviceMappings) > 0 {
+		instance.RootDeviceName = instance.BlockDeviceMappings[0].DeviceName
+	}
+	for _, spec := range input.TagSpecifications {
+		if aws.StringValue(spec.ResourceType) == ec2.ResourceTypeInstance {
+			instance.Tags = fakeMergeTags(instance.Tags, spec.Tags)
+		}
+	}
+	f.instances[id] = instance
+
+	return &ec2.Reservation{
+		ReservationId: aws.String(f.newId("r")),
+		Instances:     []*ec2.Instance{instance},
+	}, nil
+}
+
+// advance moves an instance out of a transitional state, every describe
+// call completing one transition.
+func (f *fakeAPI) advance(instance *ec2.Instance) {
+	switch aws.StringValue(instance.State.Name) {
+	case ec2.InstanceStateNamePending:
+		instance.State = &ec2.InstanceState{Code: aws.Int64(16), Name: aws.String(ec2.InstanceStateNameRunning)}
+	case ec2.InstanceStateNameStopping:
+		instance.State = &ec2.InstanceState{Code: aws.Int64(80), Name: aws.String(ec2.InstanceStateNameStopped)}
+	case ec2.InstanceStateNameShuttingDown:
+		instance.State = &ec2.InstanceState{Code: aws.Int64(48), Name: aws.String(ec2.InstanceStateNameTerminated)}
+	}
+}
+
+func (f *fakeAPI) lookupInstances(ids []*string) ([]*ec2.Instance, error) {
+	instances := []*ec2.Instance{}
+	for _, id := range ids {
+		instance, ok := f.instances[aws.StringValue(id)]
+		if !ok {
+			return nil, &fakeAPIError{"InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%s' does not exist", aws.StringValue(id))}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func (f *fakeAPI) describeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	instances, err := f.lookupInstances(input.InstanceIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(input.InstanceIds) == 0 {
+		for _, instance := range f.instances {
+			instances = append(instances, instance)
+		}
+	}
+
+	output := &ec2.DescribeInstancesOutput{}
+	for _, instance := range instances {
+		f.advance(instance)
+		match := matchFakeFilters(input.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "instance-id":
+				return []string{aws.StringValue(instance.InstanceId)}, true
+			case "instance-state-name":
+				return []string{aws.StringValue(instance.State.Name)}, true
+			case "vpc-id":
+				return []string{aws.StringValue(instance.VpcId)}, true
+			case "subnet-id":
+				return []string{aws.StringValue(instance.SubnetId)}, true
+			case "instance.group-id":
+				groups := []string{}
+				for _, group := range instance.SecurityGroups {
+					groups = append(groups, aws.StringValue(group.GroupId))
+				}
+				return groups, true
+			}
+			return fakeTagLookup(instance.Tags, name)
+		})
+		if match {
+			output.Reservations = append(output.Reservations, &ec2.Reservation{
+				ReservationId: aws.String("r-" + strings.TrimPrefix(aws.StringValue(instance.InstanceId), "i-")),
+				Instances:     []*ec2.Instance{instance},
+			})
+		}
+	}
+	return output, nil
+}
+
+func (f *fakeAPI) setState(ids []*string, from []string, to string, code int64) ([]*ec2.InstanceStateChange, error) {
+	instances, err := f.lookupInstances(ids)
+	if err != nil {
+		return nil, err
+	}
+	changes := []*ec2.InstanceStateChange{}
+	for _, instance := range instances {
+		previous := instance.State
+		for _, name := range from {
+			if aws.StringValue(instance.State.Name) == name {
+				instance.State = &ec2.InstanceState{Code: aws.Int64(code), Name: aws.String(to)}
+			}
+		}
+		changes = append(changes, &ec2.InstanceStateChange{
+			InstanceId:    instance.InstanceId,
+			PreviousState: previous,
+			CurrentState:  instance.State,
+		})
+	}
+	return changes, nil
+}
+
+func (f *fakeAPI) startInstances(input *ec2.StartInstancesInput) (*ec2.StartInstancesOutput, error) {
+	changes, err := f.setState(input.InstanceIds, []string{ec2.InstanceStateNameStopped}, ec2.InstanceStateNamePending, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.StartInstancesOutput{StartingInstances: changes}, nil
+}
+
+func (f *fakeAPI) stopInstances(input *ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error) {
+	changes, err := f.setState(input.InstanceIds, []string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning}, ec2.InstanceStateNameStopping, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.StopInstancesOutput{StoppingInstances: changes}, nil
+}
+
+func (f *fakeAPI) rebootInstances(input *ec2.RebootInstancesInput) (*ec2.RebootInstancesOutput, error) {
+	if _, err := f.lookupInstances(input.InstanceIds); err != nil {
+		return nil, err
+	}
+	return &ec2.RebootInstancesOutput{}, nil
+}
+
+func (f *fakeAPI) terminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	changes, err := f.setState(input.InstanceIds, []string{
+		ec2.InstanceStateNamePending,
+		ec2.InstanceStateNameRunning,
+		ec2.InstanceStateNameStopping,
+		ec2.InstanceStateNameStopped,
+	}, ec2.InstanceStateNameShuttingDown, 32)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range input.InstanceIds {
+		f.instances[aws.StringValue(id)].PublicIpAddress = nil
+		for _, address := range f.addresses {
+			if aws.StringValue(address.InstanceId) == aws.StringValue(id) {
+				address.InstanceId = nil
+				address.AssociationId = nil
+			}
+		}
+	}
+	return &ec2.TerminateInstancesOutput{TerminatingInstances: changes}, nil
+}
+
+func (f *fakeAPI) modifyInstanceMetadataOptions(input *ec2.ModifyInstanceMetadataOptionsInput) (*ec2.ModifyInstanceMetadataOptionsOutput, error) {
+	if _, err := f.lookupInstances([]*string{input.InstanceId}); err != nil {
+		return nil, err
+	}
+	return &ec2.ModifyInstanceMetadataOptionsOutput{InstanceId: input.InstanceId}, nil
+}
+
+func (f *fakeAPI) modifyInstanceAttribute(input *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	instances, err := f.lookupInstances([]*string{input.InstanceId})
+	if err != nil {
+		return nil, err
+	}
+	if input.SourceDestCheck != nil {
+		instances[0].SourceDestCheck = input.SourceDestCheck.Value
+	}
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (f *fakeAPI) allocateAddress(input *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	id := f.newId("eipalloc")
+	address := &ec2.Address{
+		AllocationId: aws.String(id),
+		PublicIp:     aws.String(fmt.Sprintf("198.51.100.%d", len(f.addresses)%250+1)),
+		Domain:       aws.String("vpc"),
+	}
+	f.addresses[id] = address
+	return &ec2.AllocateAddressOutput{
+		AllocationId: address.AllocationId,
+		PublicIp:     address.PublicIp,
+		Domain:       address.Domain,
+	}, nil
+}
+
+func (f *fakeAPI) associateAddress(input *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	address, ok := f.addresses[aws.StringValue(input.AllocationId)]
+	if !ok {
+		return nil, &fakeAPIError{"InvalidAllocationID.NotFound", fmt.Sprintf("The allocation ID '%s' does not exist", aws.StringValue(input.AllocationId))}
+	}
+	if input.NetworkInterfaceId != nil {
+		for id, instance := range f.instances {
+			for _, iface := range instance.NetworkInterfaces {
+				if aws.StringValue(iface.NetworkInterfaceId) == aws.StringValue(input.NetworkInterfaceId) {
+					input.InstanceId = aws.String(id)
+				}
+			}
+		}
+		if input.InstanceId == nil {
+			return nil, &fakeAPIError{"InvalidNetworkInterfaceID.NotFound", fmt.Sprintf("The network interface '%s' does not exist", aws.StringValue(input.NetworkInterfaceId))}
+		}
+	} else if instance, ok := f.instances[aws.StringValue(input.InstanceId)]; ok && len(instance.NetworkInterfaces) > 1 {
+		return nil, &fakeAPIError{"InvalidInstanceID", "There are multiple interfaces attached to instance. Please specify an interface ID for the operation instead."}
+	}
+	instances, err := f.lookupInstances([]*string{input.InstanceId})
+	if err != nil {
+		return nil, err
+	}
+	if associated := aws.StringValue(address.InstanceId); associated != "" && associated != aws.StringValue(input.InstanceId) && !aws.BoolValue(input.AllowReassociation) {
+		return nil, &fakeAPIError{"Resource.AlreadyAssociated", fmt.Sprintf("The address '%s' is already associated with %s", aws.StringValue(address.PublicIp), associated)}
+	}
+	address.InstanceId = input.InstanceId
+	address.AssociationId = aws.String(f.newId("eipassoc"))
+	instances[0].PublicIpAddress = address.PublicIp
+	return &ec2.AssociateAddressOutput{AssociationId: address.AssociationId}, nil
+}
+
+func (f *fakeAPI) describeAddresses(input *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	addresses := []*ec2.Address{}
+	for id, address := range f.addresses {
+		if len(input.AllocationIds) > 0 && !stringInPointerSlice(id, input.AllocationIds) {
+			continue
+		}
+		if len(input.PublicIps) > 0 && !stringInPointerSlice(aws.StringValue(address.PublicIp), input.PublicIps) {
+			continue
+		}
+		match := matchFakeFilters(input.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "allocation-id":
+				return []string{id}, true
+			case "instance-id":
+				return []string{aws.StringValue(address.InstanceId)}, true
+			case "public-ip":
+				return []string{aws.StringValue(address.PublicIp)}, true
+			}
+			return fakeTagLookup(address.Tags, name)
+		})
+		if match {
+			addresses = append(addresses, address)
+		}
+	}
+	return &ec2.DescribeAddressesOutput{Addresses: addresses}, nil
+}
+
+func (f *fakeAPI) describeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	nics := []*ec2.NetworkInterface{}
+	for _, instance := range f.instances {
+		placement := instance.Placement
+		if placement == nil {
+			placement = &ec2.Placement{}
+		}
+		for _, iface := range instance.NetworkInterfaces {
+			if len(input.NetworkInterfaceIds) > 0 && !stringInPointerSlice(aws.StringValue(iface.NetworkInterfaceId), input.NetworkInterfaceIds) {
+				continue
+			}
+			nics = append(nics, &ec2.NetworkInterface{
+				NetworkInterfaceId: iface.NetworkInterfaceId,
+				AvailabilityZone:   placement.AvailabilityZone,
+				SubnetId:           iface.SubnetId,
+				VpcId:              instance.VpcId,
+				PrivateIpAddress:   iface.PrivateIpAddress,
+				Status:             aws.String(ec2.NetworkInterfaceStatusInUse),
+			})
+		}
+	}
+	if len(input.NetworkInterfaceIds) > 0 && len(nics) == 0 {
+		return nil, &fakeAPIError{"InvalidNetworkInterfaceID.NotFound", fmt.Sprintf("The networkInterface ID '%s' does not exist", aws.StringValue(input.NetworkInterfaceIds[0]))}
+	}
+	return &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: nics}, nil
+}
+
+func (f *fakeAPI) disassociateAddress(input *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+	for _, address := range f.addresses {
+		if aws.StringValue(address.AssociationId) == aws.StringValue(input.AssociationId) {
+			if instance, ok := f.instances[aws.StringValue(address.InstanceId)]; ok {
+				instance.PublicIpAddress = nil
+			}
+			address.InstanceId = nil
+			address.AssociationId = nil
+			return &ec2.DisassociateAddressOutput{}, nil
+		}
+	}
+	return nil, &fakeAPIError{"InvalidAssociationID.NotFound", fmt.Sprintf("The association ID '%s' does not exist", aws.StringValue(input.AssociationId))}
+}
+
+func (f *fakeAPI) releaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	address, ok := f.addresses[aws.StringValue(input.AllocationId)]
+	if !ok {
+		return nil, &fakeAPIError{"InvalidAllocationID.NotFound", fmt.Sprintf("The allocation ID '%s' does not exist", aws.StringValue(input.AllocationId))}
+	}
+	if address.AssociationId != nil {
+		return nil, &fakeAPIError{"InvalidIPAddress.InUse", fmt.Sprintf("The address '%s' is in use", aws.StringValue(address.PublicIp))}
+	}
+	delete(f.addresses, aws.StringValue(input.AllocationId))
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+func stringInPointerSlice(s string, values []*string) bool {
+	for _, v := range values {
+		if aws.StringValue(v) == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeAPI) createSnapshot(input *ec2.CreateSnapshotInput) (*ec2.Snapshot, error) {
+	return &ec2.Snapshot{
+		SnapshotId:  aws.String(f.newId("snap")),
+		VolumeId:    input.VolumeId,
+		Description: input.Description,
+		State:       aws.String(ec2.SnapshotStatePending),
+	}, nil
+}
+
+// deleteVolume deletes a volume kept by its terminating instance, the
+// volumes deleted on termination being gone with it
+func (f *fakeAPI) deleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	for _, instance := range f.instances {
+		for i, bdm := range instance.BlockDeviceMappings {
+			if aws.StringValue(bdm.Ebs.VolumeId) != aws.StringValue(input.VolumeId) {
+				continue
+			}
+			if name := aws.StringValue(instance.State.Name); name != ec2.InstanceStateNameShuttingDown && name != ec2.InstanceStateNameTerminated {
+				return nil, &fakeAPIError{"VolumeInUse", fmt.Sprintf("Volume %s is attached to %s", aws.StringValue(input.VolumeId), aws.StringValue(instance.InstanceId))}
+			}
+			if aws.BoolValue(bdm.Ebs.DeleteOnTermination) {
+				break
+			}
+			instance.BlockDeviceMappings = append(instance.BlockDeviceMappings[:i], instance.BlockDeviceMappings[i+1:]...)
+			return &ec2.DeleteVolumeOutput{}, nil
+		}
+	}
+	return nil, &fakeAPIError{"InvalidVolume.NotFound", fmt.Sprintf("The volume '%s' does not exist", aws.StringValue(input.VolumeId))}
+}
@@ -0,0 +1,587 @@
+package outscale
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeQuery(t *testing.T) {
+	values := url.Values{
+		"Action":           {"DescribeInstances"},
+		"InstanceId.1":     {"i-1"},
+		"InstanceId.2":     {"i-2"},
+		"Filter.1.Name":    {"tag:Name"},
+		"Filter.1.Value.1": {"node1"},
+		"MaxResults":       {"10"},
+	}
+
+	input := &ec2.DescribeInstancesInput{}
+	decodeQuery(values, reflect.ValueOf(input).Elem(), "")
+
+	assert.Len(t, input.InstanceIds, 2)
+	assert.Equal(t, "i-2", *input.InstanceIds[1])
+	assert.Equal(t, "tag:Name", *input.Filters[0].Name)
+	assert.Equal(t, "node1", *input.Filters[0].Values[0])
+	assert.Equal(t, int64(10), *input.MaxResults)
+}
+
+func TestFakeAPICreateRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-node1"), 0700))
+
+	driver := NewDriver("cluster-node1", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "cluster-node1",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.Equal(t, fakeAPIVpcId, driver.VpcId)
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+
+	assert.NotEmpty(t, driver.InstanceId)
+	assert.NotEmpty(t, driver.AllocationId)
+	assert.Equal(t, driver.PublicIp, driver.IPAddress)
+	assert.Len(t, driver.SecurityGroupIds, 1)
+
+	keyPairs, err := driver.getClient().DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+		KeyNames: []*string{aws.String(driver.KeyName)},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, keyPairs.KeyPairs, 1)
+	assert.Contains(t, keyPairs.KeyPairs[0].Tags, &ec2.Tag{Key: aws.String("Name"), Value: aws.String("cluster-node1")})
+	assert.Contains(t, keyPairs.KeyPairs[0].Tags, &ec2.Tag{Key: aws.String(createdByTag), Value: aws.String(userAgentName)})
+
+	st, err := driver.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Running, st)
+
+	allocationId := driver.AllocationId
+	assert.NoError(t, driver.Remove())
+
+	addresses, err := driver.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{})
+	assert.NoError(t, err)
+	for _, address := range addresses.Addresses {
+		assert.NotEqual(t, allocationId, aws.StringValue(address.AllocationId))
+	}
+
+	st, err = driver.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Error, st)
+}
+
+func TestFakeAPIReusePublicIp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-node1"), 0700))
+
+	driver := NewDriver("cluster-node1", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "cluster-node1",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+
+	eip, err := driver.getClient().AllocateAddress(&ec2.AllocateAddressInput{Domain: aws.String("vpc")})
+	assert.NoError(t, err)
+	driver.ReusePublicIp = *eip.PublicIp
+
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+	assert.Equal(t, *eip.AllocationId, driver.AllocationId)
+	assert.True(t, driver.ExistingPublicIp)
+	assert.Equal(t, *eip.PublicIp, driver.IPAddress)
+
+	assert.NoError(t, driver.Remove())
+
+	addresses, err := driver.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{
+		AllocationIds: []*string{eip.AllocationId},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, addresses.Addresses, 1)
+}
+
+func TestFakeAPIStartReassociatesPublicIp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-restart"), 0700))
+
+	driver := NewDriver("cluster-restart", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "cluster-restart",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+	defer driver.Remove()
+
+	assert.NoError(t, driver.Stop())
+	st, err := driver.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Stopped, st)
+
+	// the maintenance stop lost the association
+	_, err = driver.getClient().DisassociateAddress(&ec2.DisassociateAddressInput{
+		AssociationId: aws.String(driver.AssociationId),
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, driver.Start())
+	ip, err := driver.GetIP()
+	assert.NoError(t, err)
+	assert.Equal(t, driver.PublicIp, ip)
+
+	addresses, err := driver.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{
+		AllocationIds: []*string{aws.String(driver.AllocationId)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, driver.InstanceId, aws.StringValue(addresses.Addresses[0].InstanceId))
+	assert.Equal(t, driver.AssociationId, aws.StringValue(addresses.Addresses[0].AssociationId))
+}
+
+func TestFakeAPIReleaseIpOnStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-idle"), 0700))
+
+	driver := NewDriver("cluster-idle", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":       []string{ipRange},
+			"name":                        "cluster-idle",
+			"outscale-fake-api":           true,
+			"outscale-region":             "us-east-2",
+			"outscale-zone":               "us-east-2a",
+			"outscale-ami":                defaultAmiId,
+			"outscale-instance-type":      defaultInstanceType,
+			"outscale-security-group":     []string{defaultSecurityGroup},
+			"outscale-release-ip-on-stop": true,
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+	defer driver.Remove()
+
+	released := driver.AllocationId
+	assert.NoError(t, driver.Stop())
+	assert.Empty(t, driver.AllocationId)
+
+	addresses, err := driver.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{})
+	assert.NoError(t, err)
+	for _, address := range addresses.Addresses {
+		assert.NotEqual(t, released, aws.StringValue(address.AllocationId))
+	}
+
+	st, err := driver.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Stopped, st)
+
+	assert.NoError(t, driver.Start())
+	assert.NotEmpty(t, driver.AllocationId)
+	assert.NotEqual(t, released, driver.AllocationId)
+	assert.Equal(t, driver.PublicIp, driver.IPAddress)
+}
+
+func TestFakeAPIGatewayNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-dataplane"), 0700))
+
+	driver := NewDriver("cluster-dataplane", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":              []string{ipRange},
+			"name":                               "cluster-dataplane",
+			"outscale-fake-api":                  true,
+			"outscale-region":                    "us-east-2",
+			"outscale-zone":                      "us-east-2a",
+			"outscale-ami":                       defaultAmiId,
+			"outscale-instance-type":             defaultInstanceType,
+			"outscale-security-group":            []string{defaultSecurityGroup},
+			"outscale-extra-nic":                 []string{"subnet=subnet-data,private-ip=10.0.1.5"},
+			"outscale-disable-source-dest-check": true,
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+	assert.Equal(t, driver.PublicIp, driver.IPAddress)
+
+	inst, err := driver.getInstance()
+	assert.NoError(t, err)
+	assert.Len(t, inst.NetworkInterfaces, 2)
+	assert.Equal(t, "10.0.1.5", aws.StringValue(inst.NetworkInterfaces[1].PrivateIpAddress))
+	assert.False(t, aws.BoolValue(inst.SourceDestCheck))
+
+	assert.NoError(t, driver.Remove())
+}
+
+func TestFakeAPIAdoptInstance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-adopted1"), 0700))
+	keyPath := filepath.Join(dir, "id_rsa")
+	assert.NoError(t, ioutil.WriteFile(keyPath, []byte("private key"), 0600))
+
+	driver := NewDriver("cluster-adopted1", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":         []string{ipRange},
+			"name":                          "cluster-adopted1",
+			"outscale-fake-api":             true,
+			"outscale-region":               "us-east-2",
+			"outscale-zone":                 "us-east-2a",
+			"outscale-ssh-keypath":          keyPath,
+			"outscale-existing-instance-id": "i-tbd",
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+
+	// a VM created outside docker-machine, e.g. by Terraform
+	reservation, err := driver.getClient().RunInstances(&ec2.RunInstancesInput{
+		ImageId:      aws.String(defaultAmiId),
+		InstanceType: aws.String("tinav5.c2r4p2"),
+		KeyName:      aws.String("terraform"),
+		MinCount:     aws.Int64(1),
+		MaxCount:     aws.Int64(1),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-2b")},
+		NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{{
+			DeviceIndex: aws.Int64(0),
+			SubnetId:    aws.String("subnet-terraform"),
+			Groups:      []*string{aws.String("sg-terraform")},
+		}},
+	})
+	require.NoError(t, err)
+	require.Len(t, reservation.Instances, 1)
+	instanceId := *reservation.Instances[0].InstanceId
+	eip, err := driver.getClient().AllocateAddress(&ec2.AllocateAddressInput{Domain: aws.String("vpc")})
+	assert.NoError(t, err)
+	_, err = driver.getClient().AssociateAddress(&ec2.AssociateAddressInput{AllocationId: eip.AllocationId, InstanceId: aws.String(instanceId)})
+	assert.NoError(t, err)
+
+	driver.AdoptInstanceId = instanceId
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.Equal(t, "subnet-terraform", driver.SubnetId)
+	assert.Equal(t, "us-east-2b", driver.Zone)
+	assert.Equal(t, []string{"sg-terraform"}, driver.SecurityGroupIds)
+	assert.Equal(t, "terraform", driver.KeyName)
+
+	assert.NoError(t, driver.Create())
+	assert.Equal(t, instanceId, driver.InstanceId)
+	assert.Equal(t, *eip.AllocationId, driver.AllocationId)
+	assert.True(t, driver.ExistingPublicIp)
+	assert.Equal(t, *eip.PublicIp, driver.IPAddress)
+
+	report := driver.remove()
+	assert.NoError(t, report.err())
+	assert.Contains(t, report.Results, cleanupResult{Resource: "external IP", Id: *eip.PublicIp, Status: cleanupKept, Reason: "existing external IP"})
+	assert.Contains(t, report.Results, cleanupResult{Resource: "key pair", Id: "terraform", Status: cleanupKept, Reason: "existing key pair"})
+
+	st, err := driver.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Error, st)
+}
+
+func TestFakeAPIDeleteSecurityGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	create := func(name string) *Driver {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", name), 0700))
+		driver := NewDriver(name, dir)
+		options := &commandstest.FakeFlagger{
+			Data: map[string]interface{}{
+				"outscale-allowed-cidr":          []string{ipRange},
+				"name":                           name,
+				"outscale-fake-api":              true,
+				"outscale-region":                "us-east-2",
+				"outscale-zone":                  "us-east-2a",
+				"outscale-ami":                   defaultAmiId,
+				"outscale-instance-type":         defaultInstanceType,
+				"outscale-security-group":        []string{"delete-sg-nodes"},
+				"outscale-delete-security-group": true,
+			},
+		}
+		assert.NoError(t, driver.SetConfigFromFlags(options))
+		assert.NoError(t, driver.PreCreateCheck())
+		assert.NoError(t, driver.Create())
+		return driver
+	}
+
+	first := create("deletesg-node1")
+	second := create("deletesg-node2")
+	groupId := first.SecurityGroupIds[0]
+	assert.Equal(t, []string{groupId}, second.SecurityGroupIds)
+
+	report := first.remove()
+	assert.Contains(t, report.Results, cleanupResult{Resource: "security group", Id: groupId, Status: cleanupKept, Reason: "used by " + second.InstanceId})
+
+	report = second.remove()
+	assert.Contains(t, report.Results, cleanupResult{Resource: "security group", Id: groupId, Status: cleanupDeleted})
+	groups, err := second.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(groupId)},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestFakeAPIExtraVolumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "volumes-node1"), 0700))
+
+	driver := NewDriver("volumes-node1", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":  []string{ipRange},
+			"name":                   "volumes-node1",
+			"outscale-fake-api":      true,
+			"outscale-region":        "us-east-2",
+			"outscale-zone":          "us-east-2a",
+			"outscale-ami":           defaultAmiId,
+			"outscale-instance-type": defaultInstanceType,
+			"outscale-extra-volume": []string{
+				"device=/dev/xvdb,size=10",
+				"device=/dev/xvdc,size=100,delete-on-termination=false",
+			},
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+	assert.Len(t, driver.ExtraVolumeIds, 1)
+	assert.Len(t, driver.RetainedVolumeIds, 1)
+
+	report := driver.remove()
+	assert.Contains(t, report.Results, cleanupResult{Resource: "volume", Id: driver.ExtraVolumeIds[0], Status: cleanupDeleted})
+	assert.Contains(t, report.Results, cleanupResult{Resource: "volume", Id: driver.RetainedVolumeIds[0], Status: cleanupKept, Reason: "delete-on-termination is false"})
+	_, err = driver.getClient().DeleteVolume(&ec2.DeleteVolumeInput{VolumeId: aws.String(driver.RetainedVolumeIds[0])})
+	assert.NoError(t, err)
+}
+
+func TestFakeAPIStartReconcilesSecurityGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-upgrade"), 0700))
+
+	driver := NewDriver("cluster-upgrade", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "cluster-upgrade",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+	defer driver.Remove()
+
+	rules := func() []*ec2.IpPermission {
+		groups, err := driver.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+			GroupIds: makePointerSlice(driver.SecurityGroupIds),
+		})
+		assert.NoError(t, err)
+		return groups[0].IpPermissions
+	}
+	created := len(rules())
+
+	// a rule introduced after the machine creation
+	_, err = driver.getClient().RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(driver.SecurityGroupIds[0]),
+		IpPermissions: rules()[:1],
+	})
+	assert.NoError(t, err)
+
+	// listing the machine doesn't change its groups
+	_, err = driver.GetURL()
+	assert.NoError(t, err)
+	assert.Len(t, rules(), created-1)
+
+	driver.ReconcileSecurityGroups = true
+	assert.NoError(t, driver.Stop())
+	st, err := driver.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Stopped, st)
+	assert.NoError(t, driver.Start())
+	assert.Len(t, rules(), created)
+
+	// provisioning the machine again, in another command, reconciles them too
+	_, err = driver.getClient().RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(driver.SecurityGroupIds[0]),
+		IpPermissions: rules()[:1],
+	})
+	assert.NoError(t, err)
+	driver.securityGroupsReconciled = false
+	_, err = driver.GetSSHHostname()
+	assert.NoError(t, err)
+	assert.Len(t, rules(), created)
+}
+
+func TestFakeAPIRetriedCreateAdoptsInstance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "cluster-retry",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}
+	create := func() (*Driver, error) {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-retry"), 0700))
+		driver := NewDriver("cluster-retry", dir)
+		assert.NoError(t, driver.SetConfigFromFlags(options))
+		assert.NoError(t, driver.PreCreateCheck())
+		return driver, driver.Create()
+	}
+
+	first, err := create()
+	assert.NoError(t, err)
+	instance, err := first.getInstance()
+	assert.NoError(t, err)
+	name, _ := fakeTagLookup(instance.Tags, "tag:Name")
+	assert.Equal(t, []string{"cluster-retry"}, name)
+
+	// the private key of the instance is lost
+	keyPath := first.GetSSHKeyPath()
+	assert.NoError(t, os.Rename(keyPath, keyPath+".lost"))
+	_, err = create()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "private key")
+	assert.NoError(t, os.Rename(keyPath+".lost", keyPath))
+	keyPairs, err := first.getClient().DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+		KeyNames: []*string{aws.String(first.KeyName)},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, keyPairs.KeyPairs, 1)
+
+	retried, err := create()
+	assert.NoError(t, err)
+	assert.Equal(t, first.InstanceId, retried.InstanceId)
+	assert.Equal(t, first.KeyName, retried.KeyName)
+	assert.Equal(t, first.AllocationId, retried.AllocationId)
+
+	assert.NoError(t, retried.Remove())
+}
+
+func TestFakeAPIStatePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalefake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-node1"), 0700))
+
+	driver := NewDriver("cluster-node1", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "cluster-node1",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+
+	// the fake API of another driver process reads the same state
+	other := newFakeAPI()
+	other.statePath = filepath.Join(dir, fakeAPIStateFile)
+	require.NoError(t, other.load())
+	require.Contains(t, other.instances, driver.InstanceId)
+	assert.Contains(t, other.addresses, driver.AllocationId)
+	assert.Contains(t, other.keyPairs, driver.KeyName)
+	assert.Equal(t, driver.PrivateIPAddress, aws.StringValue(other.instances[driver.InstanceId].PrivateIpAddress))
+
+	allocationId := driver.AllocationId
+	assert.NoError(t, driver.Remove())
+
+	require.NoError(t, other.load())
+	assert.NotContains(t, other.addresses, allocationId)
+}
+
+func TestFakeAPIWithBastion(t *testing.T) {
+	driver := NewDriver("", "")
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-fake-api":     true,
+			"outscale-region":       "us-east-2",
+			"outscale-bastion-host": "bastion.example.com",
+		},
+	}
+
+	assert.Equal(t, errorBastionWithFakeAPI, driver.SetConfigFromFlags(options))
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package outscale
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on path, shared with the other processes
+// and released when they exit, and returns the function releasing it
+func lockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
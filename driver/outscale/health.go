@@ -0,0 +1,181 @@
+package outscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// healthReportFile is written to the machine directory by Verify, for
+// Rancher or scripts to act on.
+const healthReportFile = "outscale-health.json"
+
+const (
+	healthOK      = "ok"
+	healthFailed  = "failed"
+	healthUnknown = "unknown"
+)
+
+// HealthCheck is the outcome of the check of one resource of the machine.
+// An unknown status means the check itself could not run.
+type HealthCheck struct {
+	Check  string `json:"check"`
+	Id     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// HealthReport cross-checks the machine config against the resources that
+// actually exist
+type HealthReport struct {
+	Machine string        `json:"machine"`
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+func (r *HealthReport) add(check, id, status, detail string) {
+	r.Checks = append(r.Checks, HealthCheck{Check: check, Id: id, Status: status, Detail: detail})
+	if status != healthOK {
+		r.Healthy = false
+	}
+}
+
+// check records a check failed with detail, or unknown if err is not nil
+func (r *HealthReport) check(check, id string, err error, detail string) {
+	switch {
+	case err != nil:
+		r.add(check, id, healthUnknown, err.Error())
+	case detail != "":
+		r.add(check, id, healthFailed, detail)
+	default:
+		r.add(check, id, healthOK, "")
+	}
+}
+
+// Verify checks that the instance is running, that its external IP, key
+// pair and volumes are still attached and that its security groups have the
+// rules the driver configures. Nothing is repaired. The report is also
+// saved to the machine directory.
+func (d *Driver) Verify() (*HealthReport, error) {
+	report := &HealthReport{Machine: d.MachineName, Healthy: true}
+
+	inst, err := d.getInstance()
+	if err != nil {
+		report.check("instance", d.InstanceId, err, "")
+	} else {
+		d.verifyInstance(report, inst)
+	}
+
+	if d.AllocationId != "" {
+		detail, err := d.verifyAddress()
+		report.check("external IP", d.PublicIp, err, detail)
+	}
+
+	if d.KeyName != "" {
+		_, err := d.getClient().DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+			KeyNames: []*string{&d.KeyName},
+		})
+		if err != nil && strings.Contains(err.Error(), keypairNotFoundCode) {
+			report.check("key pair", d.KeyName, nil, "does not exist")
+		} else {
+			report.check("key pair", d.KeyName, err, "")
+		}
+	}
+
+	d.verifySecurityGroups(report)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return report, err
+	}
+	if err := ioutil.WriteFile(d.ResolveStorePath(healthReportFile), data, 0600); err != nil {
+		return report, fmt.Errorf("unable to save health report: %s", err)
+	}
+	return report, nil
+}
+
+func (d *Driver) verifyInstance(report *HealthReport, inst *ec2.Instance) {
+	instanceState := aws.StringValue(inst.State.Name)
+	if instanceState != ec2.InstanceStateNameRunning {
+		report.check("instance", d.InstanceId, nil, "state is "+instanceState)
+	} else {
+		report.check("instance", d.InstanceId, nil, "")
+	}
+
+	for _, bdm := range inst.BlockDeviceMappings {
+		if bdm.Ebs == nil {
+			continue
+		}
+		detail := ""
+		if status := aws.StringValue(bdm.Ebs.Status); status != ec2.AttachmentStatusAttached {
+			detail = aws.StringValue(bdm.DeviceName) + " is " + status
+		}
+		report.check("volume", aws.StringValue(bdm.Ebs.VolumeId), nil, detail)
+	}
+}
+
+// verifyAddress returns why the external IP is not associated with the
+// instance, empty if it is
+func (d *Driver) verifyAddress() (string, error) {
+	addresses, err := d.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{
+		AllocationIds: []*string{&d.AllocationId},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(addresses.Addresses) == 0 {
+		return "does not exist", nil
+	}
+
+	instanceId := aws.StringValue(addresses.Addresses[0].InstanceId)
+	switch instanceId {
+	case d.InstanceId:
+		return "", nil
+	case "":
+		return "not associated", nil
+	default:
+		return "associated with " + instanceId, nil
+	}
+}
+
+func (d *Driver) verifySecurityGroups(report *HealthReport) {
+	groupIds := d.securityGroupIds()
+	if len(groupIds) == 0 {
+		return
+	}
+
+	groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: makePointerSlice(groupIds),
+	})
+	if err != nil {
+		report.check("security group", strings.Join(groupIds, ","), err, "")
+		return
+	}
+
+	found := map[string]bool{}
+	for _, group := range groups {
+		groupId := aws.StringValue(group.GroupId)
+		found[groupId] = true
+
+		missing, err := d.configureSecurityGroupPermissions(group)
+		rules := []string{}
+		for _, perm := range missing {
+			rules = append(rules, describePermission(perm))
+		}
+		detail := ""
+		if len(rules) > 0 {
+			detail = "missing rules " + strings.Join(rules, ", ")
+		}
+		report.check("security group", groupId, err, detail)
+	}
+
+	for _, groupId := range groupIds {
+		if !found[groupId] {
+			report.check("security group", groupId, nil, "does not exist")
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package outscale
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalehealth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-health"), 0700))
+
+	driver := NewDriver("cluster-health", dir)
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "cluster-health",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+	defer driver.Remove()
+
+	report, err := driver.Verify()
+	assert.NoError(t, err)
+	assert.True(t, report.Healthy, "%+v", report.Checks)
+	assert.Equal(t, HealthCheck{Check: "instance", Id: driver.InstanceId, Status: healthOK}, report.Checks[0])
+
+	saved := &HealthReport{}
+	data, err := ioutil.ReadFile(driver.ResolveStorePath(healthReportFile))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, saved))
+	assert.Equal(t, report, saved)
+
+	_, err = driver.getClient().DeleteKeyPair(&ec2.DeleteKeyPairInput{KeyName: aws.String(driver.KeyName)})
+	assert.NoError(t, err)
+	assert.NoError(t, driver.Stop())
+
+	report, err = driver.Verify()
+	assert.NoError(t, err)
+	assert.False(t, report.Healthy)
+	assert.Equal(t, healthFailed, report.Checks[0].Status)
+	assert.Contains(t, report.Checks, HealthCheck{Check: "key pair", Id: driver.KeyName, Status: healthFailed, Detail: "does not exist"})
+}
+
+func TestVerifyAddress(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithInstances{addresses: []*ec2.Address{{
+		AllocationId: aws.String("eipalloc-12345"),
+		InstanceId:   aws.String("i-other"),
+	}}})
+	driver.InstanceId = "i-12345"
+	driver.AllocationId = "eipalloc-12345"
+
+	detail, err := driver.verifyAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, "associated with i-other", detail)
+}
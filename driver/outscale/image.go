@@ -0,0 +1,204 @@
+package outscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// imageCacheFile is shared by all the machines of the store, so that the
+// nodes of a scale-up resolve a filter to the same image.
+const imageCacheFile = "outscale-image-cache.json"
+
+const defaultAMICacheTTL = "1h"
+
+// defaultAMIOwner restricts the image lookups to the images of the account
+const defaultAMIOwner = "self"
+
+type imageCacheEntry struct {
+	ImageId    string    `json:"imageId"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+// parseAMICacheTTL parses --outscale-ami-cache-ttl, 0 disabling the cache
+func parseAMICacheTTL(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid --outscale-ami-cache-ttl %q, expected a duration like 1h", value)
+	}
+	return ttl, nil
+}
+
+// imageFilters returns the filters selecting the image candidates, nil when
+// the image is given by ID
+func (d *Driver) imageFilters() []*ec2.Filter {
+	if d.AMIName == "" && len(d.AMITags) == 0 {
+		return nil
+	}
+
+	filters := []*ec2.Filter{}
+	if d.AMIName != "" {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("name"),
+			Values: []*string{aws.String(d.AMIName)},
+		})
+	}
+	if details := regionDetails[d.Region]; details != nil && details.AMIOwner != "" && d.AMIName == details.AMIName {
+		// the region default must not match an image shared by anyone
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("owner-alias"),
+			Values: []*string{aws.String(details.AMIOwner)},
+		})
+	}
+	// validated by SetConfigFromFlags
+	tagFilters, _ := parseTagFilters("--outscale-ami-tag", d.AMITags)
+	filters = append(filters, tagFilters...)
+	return append(filters, &ec2.Filter{
+		Name:   aws.String("state"),
+		Values: []*string{aws.String(ec2.ImageStateAvailable)},
+	})
+}
+
+// validateAMITags checks the --outscale-ami-tag entries are key=value pairs
+func validateAMITags(tags []string) error {
+	_, err := parseTagFilters("--outscale-ami-tag", tags)
+	return err
+}
+
+// imageOwners returns the owners the image candidates must belong to, so
+// that a name or tag filter doesn't pick an image published by another
+// account. The region default image is filtered by owner alias instead.
+func (d *Driver) imageOwners() []*string {
+	if details := regionDetails[d.Region]; details != nil && details.AMIOwner != "" && d.AMIName == details.AMIName {
+		return nil
+	}
+	if d.AMIOwner == "" {
+		return []*string{aws.String(defaultAMIOwner)}
+	}
+	return []*string{aws.String(d.AMIOwner)}
+}
+
+// imageCacheKey identifies a lookup, self and the aliases depending on the
+// endpoint and the account of the credentials
+func (d *Driver) imageCacheKey(filters []*ec2.Filter) string {
+	parts := []string{d.Region, d.Endpoint, d.AccessKey, "owner=" + strings.Join(aws.StringValueSlice(d.imageOwners()), ",")}
+	for _, filter := range filters {
+		parts = append(parts, aws.StringValue(filter.Name)+"="+strings.Join(aws.StringValueSlice(filter.Values), ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// resolveAMI replaces d.AMI by the newest image matching the image filters,
+// reusing a still valid resolution from the store cache.
+func (d *Driver) resolveAMI() error {
+	filters := d.imageFilters()
+	if filters == nil {
+		return nil
+	}
+
+	ttl, err := parseAMICacheTTL(d.AMICacheTTL)
+	if err != nil {
+		return err
+	}
+
+	key := d.imageCacheKey(filters)
+	cache := d.loadImageCache()
+	if entry, ok := cache[key]; ok && ttl > 0 && time.Since(entry.ResolvedAt) < ttl {
+		log.Debugf("Using cached image %s for %s", entry.ImageId, key)
+		d.AMI = entry.ImageId
+		return nil
+	}
+
+	images, err := d.getClient().DescribeImages(&ec2.DescribeImagesInput{
+		Filters: filters,
+		Owners:  d.imageOwners(),
+	})
+	if err != nil {
+		return fmt.Errorf("Error looking up images: %s", err)
+	}
+
+	image := newestImage(images.Images)
+	if image == nil {
+		return fmt.Errorf("no image matching %s on region %s", key, d.getRegionZone())
+	}
+	d.AMI = *image.ImageId
+	log.Infof("Using image %s (%s)", d.AMI, aws.StringValue(image.Name))
+
+	if ttl > 0 {
+		cache[key] = imageCacheEntry{ImageId: d.AMI, ResolvedAt: time.Now()}
+		d.saveImageCache(cache)
+	}
+	return nil
+}
+
+// newestImage returns the most recently created image, nil if there is none
+func newestImage(images []*ec2.Image) *ec2.Image {
+	var newest *ec2.Image
+	for _, image := range images {
+		// creation dates are ISO 8601 and compare as strings
+		if newest == nil || aws.StringValue(image.CreationDate) > aws.StringValue(newest.CreationDate) {
+			newest = image
+		}
+	}
+	return newest
+}
+
+func (d *Driver) imageCachePath() string {
+	return filepath.Join(d.StorePath, imageCacheFile)
+}
+
+func (d *Driver) loadImageCache() map[string]imageCacheEntry {
+	cache := make(map[string]imageCacheEntry)
+
+	data, err := ioutil.ReadFile(d.imageCachePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Unable to read image cache: %s", err)
+		}
+		return cache
+	}
+
+	if err := json.Unmarshal(data, &cache); err != nil {
+		log.Warnf("Ignoring invalid image cache: %s", err)
+		return make(map[string]imageCacheEntry)
+	}
+	return cache
+}
+
+// saveImageCache replaces the cache file atomically, as machines of the same
+// store may be created concurrently
+func (d *Driver) saveImageCache(cache map[string]imageCacheEntry) {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		log.Warnf("Unable to encode image cache: %s", err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(d.StorePath, imageCacheFile)
+	if err != nil {
+		log.Warnf("Unable to save image cache: %s", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.imageCachePath())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Warnf("Unable to save image cache: %s", err)
+	}
+}
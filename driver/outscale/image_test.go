@@ -0,0 +1,146 @@
+package outscale
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewestImage(t *testing.T) {
+	assert.Nil(t, newestImage(nil))
+
+	image := newestImage([]*ec2.Image{
+		{ImageId: aws.String("ami-1"), CreationDate: aws.String("2021-02-04T10:00:00.000Z")},
+		{ImageId: aws.String("ami-2"), CreationDate: aws.String("2021-03-01T10:00:00.000Z")},
+		{ImageId: aws.String("ami-3"), CreationDate: aws.String("2020-12-24T10:00:00.000Z")},
+	})
+	assert.Equal(t, "ami-2", *image.ImageId)
+}
+
+func TestResolveAMIByIdSkipsLookup(t *testing.T) {
+	client := &fakeEC2WithImages{}
+	driver := NewCustomTestDriver(client)
+
+	assert.NoError(t, driver.resolveAMI())
+	assert.Equal(t, defaultAmiId, driver.AMI)
+	assert.Equal(t, 0, client.calls)
+}
+
+func TestResolveAMIUsesCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscaleimages")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &fakeEC2WithImages{images: []*ec2.Image{
+		{ImageId: aws.String("ami-1"), CreationDate: aws.String("2021-02-04T10:00:00.000Z")},
+	}}
+	driver := NewCustomTestDriver(client)
+	driver.StorePath = dir
+	driver.AMIName = "CentOS-8-*"
+	driver.AMICacheTTL = "1h"
+
+	assert.NoError(t, driver.resolveAMI())
+	assert.Equal(t, "ami-1", driver.AMI)
+
+	// a newer image published meanwhile is not picked up until the TTL expires
+	client.images = append(client.images, &ec2.Image{ImageId: aws.String("ami-2"), CreationDate: aws.String("2021-03-01T10:00:00.000Z")})
+	other := NewCustomTestDriver(client)
+	other.StorePath = dir
+	other.AMIName = "CentOS-8-*"
+	other.AMICacheTTL = "1h"
+
+	assert.NoError(t, other.resolveAMI())
+	assert.Equal(t, "ami-1", other.AMI)
+	assert.Equal(t, 1, client.calls)
+
+	cache := other.loadImageCache()
+	for key, entry := range cache {
+		entry.ResolvedAt = time.Now().Add(-2 * time.Hour)
+		cache[key] = entry
+	}
+	other.saveImageCache(cache)
+
+	assert.NoError(t, other.resolveAMI())
+	assert.Equal(t, "ami-2", other.AMI)
+	assert.Equal(t, 2, client.calls)
+}
+
+func TestResolveAMINoMatch(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithImages{})
+	driver.AMIName = "missing-*"
+	driver.AMICacheTTL = "0"
+
+	assert.Error(t, driver.resolveAMI())
+}
+
+func TestImageFiltersByTag(t *testing.T) {
+	driver := NewTestDriver()
+	driver.AMITags = []string{"team=platform", "channel=stable"}
+
+	filters := driver.imageFilters()
+	assert.Len(t, filters, 3)
+	assert.Equal(t, "tag:team", *filters[0].Name)
+	assert.Equal(t, "platform", *filters[0].Values[0])
+	assert.Equal(t, "tag:channel", *filters[1].Name)
+	assert.Equal(t, "state", *filters[2].Name)
+}
+
+func TestResolveAMIByTag(t *testing.T) {
+	client := &fakeEC2WithImages{images: []*ec2.Image{
+		{ImageId: aws.String("ami-old"), CreationDate: aws.String("2021-02-04T10:00:00.000Z")},
+		{ImageId: aws.String("ami-new"), CreationDate: aws.String("2021-03-01T10:00:00.000Z")},
+	}}
+	driver := NewCustomTestDriver(client)
+	driver.AMITags = []string{"channel=stable"}
+	driver.AMICacheTTL = "0"
+
+	assert.NoError(t, driver.resolveAMI())
+	assert.Equal(t, "ami-new", driver.AMI)
+	assert.Equal(t, []*string{aws.String("self")}, client.input.Owners)
+
+	driver.AMIOwner = "Outscale"
+	assert.NoError(t, driver.resolveAMI())
+	assert.Equal(t, []*string{aws.String("Outscale")}, client.input.Owners)
+}
+
+func TestImageCacheKeyScope(t *testing.T) {
+	driver := NewTestDriver()
+	driver.AMIName = "CentOS-8-*"
+	driver.AccessKey = "AK1"
+	key := driver.imageCacheKey(driver.imageFilters())
+
+	driver.AccessKey = "AK2"
+	assert.NotEqual(t, key, driver.imageCacheKey(driver.imageFilters()))
+
+	driver.AccessKey = "AK1"
+	driver.AMIOwner = "123456789012"
+	assert.NotEqual(t, key, driver.imageCacheKey(driver.imageFilters()))
+}
+
+func TestValidateAMITags(t *testing.T) {
+	assert.NoError(t, validateAMITags([]string{"team=platform", "empty="}))
+	assert.Error(t, validateAMITags([]string{"team"}))
+	assert.Error(t, validateAMITags([]string{"=platform"}))
+}
+
+func TestImageFiltersRegionDefaultOwner(t *testing.T) {
+	driver := NewTestDriver()
+	driver.Region = "cloudgouv-eu-west-1"
+	driver.AMIName = "Ubuntu-20.04-*"
+
+	filters := driver.imageFilters()
+	assert.Len(t, filters, 3)
+	assert.Equal(t, "owner-alias", *filters[1].Name)
+	assert.Equal(t, "Outscale", *filters[1].Values[0])
+
+	assert.Nil(t, driver.imageOwners())
+
+	driver.AMIName = "custom-*"
+	assert.Len(t, driver.imageFilters(), 2)
+	assert.Equal(t, []*string{aws.String("self")}, driver.imageOwners())
+}
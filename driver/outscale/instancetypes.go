@@ -0,0 +1,339 @@
+package outscale
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// instanceTypeInfo describes an AWS compatible instance type accepted by
+// Outscale
+type instanceTypeInfo struct {
+	VCPUs        int64
+	MemoryGiB    float64
+	EbsOptimized bool
+}
+
+// instanceTypes is the catalog of the AWS compatible types, tina types
+// (tinavX.cYrZpW) being described by their name
+var instanceTypes = map[string]instanceTypeInfo{
+	"t2.nano":     {1, 0.5, false},
+	"t2.micro":    {1, 1, false},
+	"t2.small":    {1, 2, false},
+	"t2.medium":   {2, 4, false},
+	"t2.large":    {2, 8, false},
+	"m4.large":    {2, 8, true},
+	"m4.xlarge":   {4, 16, true},
+	"m4.2xlarge":  {8, 32, true},
+	"m4.4xlarge":  {16, 64, true},
+	"m4.10xlarge": {40, 160, true},
+	"m5.large":    {2, 8, true},
+	"m5.xlarge":   {4, 16, true},
+	"m5.2xlarge":  {8, 32, true},
+	"m5.4xlarge":  {16, 64, true},
+	"c4.large":    {2, 3.75, true},
+	"c4.xlarge":   {4, 7.5, true},
+	"c4.2xlarge":  {8, 15, true},
+	"c4.4xlarge":  {16, 30, true},
+	"c4.8xlarge":  {36, 60, true},
+	"r4.large":    {2, 15.25, true},
+	"r4.xlarge":   {4, 30.5, true},
+	"r4.2xlarge":  {8, 61, true},
+	"r4.4xlarge":  {16, 122, true},
+	"r4.8xlarge":  {32, 244, true},
+	"r4.16xlarge": {64, 488, true},
+}
+
+// Limits of the tina types
+const (
+	maxTinaGeneration     = 7
+	maxTinaVCPUs          = 80
+	maxTinaMemoryGiB      = 1039
+	maxTinaMemoryPerVCPU  = 16
+	lowestTinaPerformance = 3
+)
+
+// Tina type of --outscale-cpu and --outscale-ram when the generation and the
+// performance are not given
+const (
+	defaultTinaGeneration  = 5
+	defaultTinaPerformance = "medium"
+)
+
+// tinaPerformances maps the --outscale-performance values to the tina
+// performance, 1 being the highest
+var tinaPerformances = map[string]int{
+	"high":   1,
+	"medium": 2,
+	"low":    3,
+}
+
+var tinaTypePattern = regexp.MustCompile(`^tinav(\d+)\.c(\d+)r(\d+)p(\d+)$`)
+
+// tinaShape is an Outscale tina type: tinavX.cYrZpW is a VM of generation X
+// with Y vCPUs, Z GiB of memory and the performance W, 1 being the highest
+type tinaShape struct {
+	Generation  int
+	VCPUs       int
+	MemoryGiB   int
+	Performance int
+}
+
+// parseTinaType parses a tina type name, returning false for other names
+func parseTinaType(name string) (*tinaShape, bool) {
+	m := tinaTypePattern.FindStringSubmatch(strings.ToLower(name))
+	if m == nil {
+		return nil, false
+	}
+	shape := &tinaShape{}
+	for i, field := range []*int{&shape.Generation, &shape.VCPUs, &shape.MemoryGiB, &shape.Performance} {
+		*field, _ = strconv.Atoi(m[i+1])
+	}
+	return shape, true
+}
+
+func (s *tinaShape) String() string {
+	return fmt.Sprintf("tinav%d.c%dr%dp%d", s.Generation, s.VCPUs, s.MemoryGiB, s.Performance)
+}
+
+func (s *tinaShape) validate() error {
+	switch {
+	case s.Generation < 1 || s.Generation > maxTinaGeneration:
+		return fmt.Errorf("tina generation %d does not exist, expected 1 to %d", s.Generation, maxTinaGeneration)
+	case s.VCPUs < 1 || s.VCPUs > maxTinaVCPUs:
+		return fmt.Errorf("%d vCPUs is out of the 1 to %d range", s.VCPUs, maxTinaVCPUs)
+	case s.MemoryGiB < 1 || s.MemoryGiB > maxTinaMemoryGiB:
+		return fmt.Errorf("%d GiB of memory is out of the 1 to %d range", s.MemoryGiB, maxTinaMemoryGiB)
+	case s.MemoryGiB < s.VCPUs || s.MemoryGiB > s.VCPUs*maxTinaMemoryPerVCPU:
+		return fmt.Errorf("%d GiB of memory for %d vCPUs is out of the 1 to %d GiB per vCPU range", s.MemoryGiB, s.VCPUs, maxTinaMemoryPerVCPU)
+	case s.Performance < 1 || s.Performance > lowestTinaPerformance:
+		return fmt.Errorf("performance p%d does not exist, expected p1 to p%d", s.Performance, lowestTinaPerformance)
+	}
+	return nil
+}
+
+func parseTinaPerformance(value string) (int, error) {
+	if value == "" {
+		value = defaultTinaPerformance
+	}
+	performance, ok := tinaPerformances[value]
+	if !ok {
+		return 0, fmt.Errorf("invalid --outscale-performance %q, expected high, medium or low", value)
+	}
+	return performance, nil
+}
+
+// tinaInstanceType returns the tina type of --outscale-cpu, --outscale-ram
+// and --outscale-performance, so that users don't have to spell the type
+// name. The generation of a tina --outscale-instance-type is kept, which is
+// returned as is when none of them is set.
+func tinaInstanceType(instanceType string, cpu, ram int, performance string) (string, error) {
+	if cpu == 0 && ram == 0 && performance == "" {
+		return instanceType, nil
+	}
+
+	shape, isTina := parseTinaType(instanceType)
+	switch {
+	case cpu == 0 && ram == 0 && !isTina:
+		return "", fmt.Errorf("--outscale-performance requires a tina --outscale-instance-type or --outscale-cpu and --outscale-ram")
+	case cpu == 0 && ram == 0:
+	case cpu == 0 || ram == 0:
+		return "", fmt.Errorf("--outscale-cpu and --outscale-ram must be given together")
+	case isTina:
+		shape.VCPUs, shape.MemoryGiB = cpu, ram
+	default:
+		shape = &tinaShape{Generation: defaultTinaGeneration, VCPUs: cpu, MemoryGiB: ram}
+	}
+
+	if performance != "" || shape.Performance == 0 {
+		p, err := parseTinaPerformance(performance)
+		if err != nil {
+			return "", err
+		}
+		shape.Performance = p
+	}
+	if err := shape.validate(); err != nil {
+		return "", fmt.Errorf("invalid instance type %s: %s", shape, err)
+	}
+	return shape.String(), nil
+}
+
+// lookupInstanceType returns the catalog entry of an AWS compatible type, or
+// the description of a valid tina type
+func lookupInstanceType(name string) (instanceTypeInfo, bool) {
+	if shape, ok := parseTinaType(name); ok {
+		if shape.validate() != nil {
+			return instanceTypeInfo{}, false
+		}
+		return instanceTypeInfo{VCPUs: int64(shape.VCPUs), MemoryGiB: float64(shape.MemoryGiB), EbsOptimized: true}, true
+	}
+	info, ok := instanceTypes[strings.ToLower(name)]
+	return info, ok
+}
+
+// validateInstanceType checks a tina type describes a shape Outscale offers
+func validateInstanceType(name string) error {
+	if shape, ok := parseTinaType(name); ok {
+		if err := shape.validate(); err != nil {
+			return fmt.Errorf("invalid instance type %q: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// unknownInstanceType describes why name is neither in the catalog nor a
+// tina type, suggesting the closest catalog entries, and returns "" for a
+// known type. The catalog may lag behind the types Outscale offers, so this
+// is only a hint.
+func unknownInstanceType(name string) string {
+	if _, ok := lookupInstanceType(name); ok {
+		return ""
+	}
+	if _, ok := parseTinaType(name); ok {
+		return ""
+	}
+
+	catalog := []string{}
+	for typ := range instanceTypes {
+		catalog = append(catalog, typ)
+	}
+	msg := fmt.Sprintf("unknown instance type %q, expected an AWS compatible type or a tina type like tinav5.c4r8p1", name)
+	if alternatives := closestInstanceTypes(strings.ToLower(name), catalog, 3); len(alternatives) > 0 {
+		msg += ", did you mean " + strings.Join(alternatives, ", ") + "?"
+	}
+	return msg
+}
+
+// closestInstanceTypes returns up to max types of catalog within a few edits
+// of name, closest first
+func closestInstanceTypes(name string, catalog []string, max int) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	candidates := []candidate{}
+	for _, typ := range catalog {
+		if distance := editDistance(name, typ); distance <= 3 {
+			candidates = append(candidates, candidate{typ, distance})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	closest := []string{}
+	for i := 0; i < len(candidates) && i < max; i++ {
+		closest = append(closest, candidates[i].name)
+	}
+	return closest
+}
+
+// editDistance is the Levenshtein distance of a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// oapiVmType is an instance type, as returned by the oAPI ReadVmTypes
+type oapiVmType struct {
+	VmTypeName   string  `json:"VmTypeName"`
+	VcoreCount   int64   `json:"VcoreCount"`
+	MemorySize   float64 `json:"MemorySize"`
+	BsuOptimized bool    `json:"BsuOptimized"`
+}
+
+func (c *oapiClient) readVmTypes() ([]*oapiVmType, error) {
+	output := &struct {
+		VmTypes []*oapiVmType `json:"VmTypes"`
+	}{}
+	err := c.call("ReadVmTypes", map[string]interface{}{}, output)
+	return output.VmTypes, err
+}
+
+// readVmTypes returns the instance types of the region
+func (d *Driver) readVmTypes() ([]*oapiVmType, error) {
+	if d.FakeAPI {
+		return nil, errors.New("the oAPI is not emulated by --outscale-fake-api")
+	}
+	return d.buildOAPIClient().readVmTypes()
+}
+
+// checkOfferedInstanceType checks the region offers name among types, the
+// instance types it lists. The tina types are only checked against the tina
+// limits, any shape within them being accepted though not listed.
+func checkOfferedInstanceType(name, region string, types []*oapiVmType) error {
+	if _, ok := parseTinaType(name); ok {
+		return nil
+	}
+	offered := []string{}
+	for _, typ := range types {
+		if strings.EqualFold(typ.VmTypeName, name) {
+			return nil
+		}
+		offered = append(offered, strings.ToLower(typ.VmTypeName))
+	}
+
+	msg := fmt.Sprintf("instance type %q is not offered in region %s", name, region)
+	if alternatives := closestInstanceTypes(strings.ToLower(name), offered, 3); len(alternatives) > 0 {
+		msg += ", did you mean " + strings.Join(alternatives, ", ") + "?"
+	}
+	return errors.New(msg)
+}
+
+// checkInstanceType validates --outscale-instance-type against the types of
+// the region, or the static catalog when they can't be read, and its
+// compatibility with the image, all the Outscale types being x86_64
+func (d *Driver) checkInstanceType() error {
+	if err := validateInstanceType(d.InstanceType); err != nil {
+		return err
+	}
+
+	types, err := d.readVmTypes()
+	if err != nil {
+		log.Debugf("Unable to read the instance types of %s, checking the static catalog: %s", d.Region, err)
+		if msg := unknownInstanceType(d.InstanceType); msg != "" {
+			log.Warnf("Creating the instance anyway: %s", msg)
+		}
+	} else if err := checkOfferedInstanceType(d.InstanceType, d.Region, types); err != nil {
+		return err
+	}
+
+	if d.amiArchitecture != "" && d.amiArchitecture != ec2.ArchitectureValuesX8664 {
+		return fmt.Errorf("image %s is %s, which instance type %s can't run, the Outscale instance types being %s", d.AMI, d.amiArchitecture, d.InstanceType, ec2.ArchitectureValuesX8664)
+	}
+	return nil
+}
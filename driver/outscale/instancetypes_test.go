@@ -0,0 +1,106 @@
+package outscale
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTinaType(t *testing.T) {
+	shape, ok := parseTinaType("tinav5.c4r8p1")
+	assert.True(t, ok)
+	assert.Equal(t, &tinaShape{Generation: 5, VCPUs: 4, MemoryGiB: 8, Performance: 1}, shape)
+	assert.NoError(t, shape.validate())
+
+	_, ok = parseTinaType("m4.large")
+	assert.False(t, ok)
+}
+
+func TestValidateInstanceType(t *testing.T) {
+	assert.NoError(t, validateInstanceType("m5.xlarge"))
+	assert.NoError(t, validateInstanceType("tinav4.c2r4p2"))
+
+	assert.EqualError(t, validateInstanceType("tinav4.c2r64p2"), `invalid instance type "tinav4.c2r64p2": 64 GiB of memory for 2 vCPUs is out of the 1 to 16 GiB per vCPU range`)
+	assert.EqualError(t, validateInstanceType("tinav4.c2r4p5"), `invalid instance type "tinav4.c2r4p5": performance p5 does not exist, expected p1 to p3`)
+	assert.NoError(t, validateInstanceType("m5.xlarg"))
+}
+
+func TestUnknownInstanceType(t *testing.T) {
+	assert.Equal(t, "", unknownInstanceType("m5.xlarge"))
+	assert.Equal(t, "", unknownInstanceType("tinav4.c2r4p2"))
+	assert.Equal(t, `unknown instance type "m5.xlarg", expected an AWS compatible type or a tina type like tinav5.c4r8p1, did you mean m5.xlarge, m4.xlarge, m5.2xlarge?`, unknownInstanceType("m5.xlarg"))
+	assert.Equal(t, `unknown instance type "banana", expected an AWS compatible type or a tina type like tinav5.c4r8p1`, unknownInstanceType("banana"))
+
+	driver := NewTestDriver()
+	driver.InstanceType = "m9.large"
+	assert.NoError(t, driver.checkInstanceType())
+}
+
+func TestLookupTinaType(t *testing.T) {
+	info, ok := lookupInstanceType("tinav5.c4r16p1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), info.VCPUs)
+	assert.True(t, info.EbsOptimized)
+}
+
+func TestCheckInstanceTypeArchitecture(t *testing.T) {
+	driver := NewTestDriver()
+	driver.InstanceType = "t2.medium"
+	driver.AMI = "ami-arm"
+	driver.amiArchitecture = "arm64"
+
+	assert.EqualError(t, driver.checkInstanceType(), "image ami-arm is arm64, which instance type t2.medium can't run, the Outscale instance types being x86_64")
+}
+
+func TestTinaInstanceType(t *testing.T) {
+	typ, err := tinaInstanceType("m5.xlarge", 0, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "m5.xlarge", typ)
+
+	typ, err = tinaInstanceType("m5.xlarge", 4, 16, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "tinav5.c4r16p2", typ)
+
+	typ, err = tinaInstanceType("tinav3.c2r4p3", 8, 32, "high")
+	assert.NoError(t, err)
+	assert.Equal(t, "tinav3.c8r32p1", typ)
+
+	typ, err = tinaInstanceType("tinav3.c2r4p3", 0, 0, "low")
+	assert.NoError(t, err)
+	assert.Equal(t, "tinav3.c2r4p3", typ)
+
+	_, err = tinaInstanceType("m5.xlarge", 0, 0, "high")
+	assert.EqualError(t, err, "--outscale-performance requires a tina --outscale-instance-type or --outscale-cpu and --outscale-ram")
+
+	_, err = tinaInstanceType("m5.xlarge", 4, 0, "")
+	assert.EqualError(t, err, "--outscale-cpu and --outscale-ram must be given together")
+
+	_, err = tinaInstanceType("m5.xlarge", 2, 64, "")
+	assert.EqualError(t, err, "invalid instance type tinav5.c2r64p2: 64 GiB of memory for 2 vCPUs is out of the 1 to 16 GiB per vCPU range")
+
+	_, err = tinaInstanceType("m5.xlarge", 2, 4, "fast")
+	assert.EqualError(t, err, `invalid --outscale-performance "fast", expected high, medium or low`)
+}
+
+func TestCheckInstanceTypeOfferedInRegion(t *testing.T) {
+	server := newFakeOAPI(t, map[string]string{
+		"ReadVmTypes": `{"VmTypes":[
+			{"VmTypeName":"m4.large","VcoreCount":2,"MemorySize":8},
+			{"VmTypeName":"m4.xlarge","VcoreCount":4,"MemorySize":16},
+			{"VmTypeName":"tinav5.c2r4p2","VcoreCount":2,"MemorySize":4}]}`,
+	})
+	defer server.Close()
+	driver, _ := newTestOAPIDriver(server)
+	driver.OAPIEndpoint = server.URL + "/api/v1"
+
+	driver.InstanceType = "m4.large"
+	assert.NoError(t, driver.checkInstanceType())
+
+	// tina shapes are not all listed
+	driver.InstanceType = "tinav5.c4r8p1"
+	assert.NoError(t, driver.checkInstanceType())
+
+	// in the static catalog, but not offered in the region
+	driver.InstanceType = "m5.large"
+	assert.EqualError(t, driver.checkInstanceType(), `instance type "m5.large" is not offered in region us-east-2, did you mean m4.large, m4.xlarge?`)
+}
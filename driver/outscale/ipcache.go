@@ -0,0 +1,50 @@
+package outscale
+
+import (
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// storedIPTTL is how long the stored IP is trusted before GetIP checks it
+// against the API again
+const storedIPTTL = 5 * time.Minute
+
+// storedIP returns the IP recorded in the machine config when it can't
+// have changed, so that GetIP doesn't call the API each time docker-machine
+// asks for it: the private IP, kept by the instance for its lifetime, or the
+// external IP while it is associated. An empty string means it must be
+// looked up.
+func (d *Driver) storedIP() string {
+	switch {
+	case d.PrivateIPOnly || d.UsePrivateIP:
+		return d.PrivateIPAddress
+	case d.UseIPv6Address:
+		return ""
+	case d.AssociationId != "":
+		return d.PublicIp
+	}
+	return ""
+}
+
+// checkStoredIP compares the stored IP with ip, just looked up. When they
+// differ, the instance having been changed outside of the driver, the stored
+// IP is replaced or dropped and checked again on the next call. When they
+// match, it is trusted for storedIPTTL.
+func (d *Driver) checkStoredIP(ip string) {
+	stored := d.storedIP()
+	switch {
+	case stored == "":
+		return
+	case stored == ip:
+		d.IPCheckedAt = time.Now()
+		return
+	case d.PrivateIPOnly || d.UsePrivateIP:
+		log.Debugf("The private IP of instance %s changed from %s to %s", d.InstanceId, stored, ip)
+		d.PrivateIPAddress = ip
+	default:
+		log.Debugf("External IP %s is no longer associated with instance %s", stored, d.InstanceId)
+		d.AssociationId = ""
+	}
+	d.IPCheckedAt = time.Time{}
+}
@@ -0,0 +1,106 @@
+package outscale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetIPUsesStoredIP(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:       aws.String("i-12345"),
+			PublicIpAddress:  aws.String("1.2.3.4"),
+			PrivateIpAddress: aws.String("10.0.0.4"),
+		}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+
+	// no external IP associated yet
+	ip, err := driver.GetIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", ip)
+	assert.NotNil(t, client.input)
+	assert.True(t, driver.IPCheckedAt.IsZero())
+
+	// the stored IP is checked once, then trusted
+	client.input = nil
+	driver.instanceCache.forget()
+	driver.PublicIp = "1.2.3.4"
+	driver.AssociationId = "eipassoc-12345"
+	ip, err = driver.GetIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", ip)
+	assert.NotNil(t, client.input)
+	assert.False(t, driver.IPCheckedAt.IsZero())
+
+	client.input = nil
+	ip, err = driver.GetIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", ip)
+	assert.Nil(t, client.input)
+
+	driver.PrivateIPOnly = true
+	driver.PrivateIPAddress = "10.0.0.4"
+	ip, err = driver.GetIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.4", ip)
+	assert.Nil(t, client.input)
+}
+
+func TestGetIPRevalidatesStoredIP(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:      aws.String("i-12345"),
+			PublicIpAddress: aws.String("5.6.7.8"),
+		}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.PublicIp = "1.2.3.4"
+	driver.AssociationId = "eipassoc-12345"
+	driver.IPCheckedAt = time.Now().Add(-storedIPTTL)
+
+	ip, err := driver.GetIP()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "5.6.7.8", ip)
+	assert.NotNil(t, client.input)
+	assert.Empty(t, driver.AssociationId)
+	assert.Equal(t, "1.2.3.4", driver.PublicIp)
+	assert.True(t, driver.IPCheckedAt.IsZero())
+}
+
+func TestGetIPReplacesStoredPrivateIP(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:       aws.String("i-12345"),
+			PrivateIpAddress: aws.String("10.0.0.5"),
+		}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.PrivateIPOnly = true
+	driver.PrivateIPAddress = "10.0.0.4"
+
+	ip, err := driver.GetIP()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", ip)
+	assert.Equal(t, "10.0.0.5", driver.PrivateIPAddress)
+	assert.True(t, driver.IPCheckedAt.IsZero())
+}
+
+func TestStoredIPAfterRelease(t *testing.T) {
+	driver := NewTestDriver()
+	driver.PublicIp = "1.2.3.4"
+	assert.Equal(t, "", driver.storedIP())
+
+	driver.UseIPv6Address = true
+	driver.AssociationId = "eipassoc-12345"
+	assert.Equal(t, "", driver.storedIP())
+}
@@ -0,0 +1,108 @@
+package outscale
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// publicIpPoolTag tags the external IPs of the pool named with
+// --outscale-public-ip-pool
+const publicIpPoolTag = "public-ip-pool"
+
+// freePoolAddresses lists the external IPs of the pool not associated with an
+// instance
+func (d *Driver) freePoolAddresses() ([]*ec2.Address, error) {
+	addresses, err := d.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:" + publicIpPoolTag),
+				Values: []*string{aws.String(d.PublicIpPool)},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing external IP pool %s: %s", d.PublicIpPool, err)
+	}
+
+	free := []*ec2.Address{}
+	for _, address := range addresses.Addresses {
+		if address.AllocationId != nil && aws.StringValue(address.InstanceId) == "" {
+			free = append(free, address)
+		}
+	}
+	return free, nil
+}
+
+// claimPoolAddress associates a free external IP of the pool with the
+// instance. Another machine may grab the same IP meanwhile, in which case the
+// next free one is tried. When the pool has no free IP left a new one is
+// allocated and added to the pool, its association being left to the caller.
+func (d *Driver) claimPoolAddress() error {
+	addresses, err := d.freePoolAddresses()
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		input, err := d.associateAddressInput(*address.AllocationId)
+		if err != nil {
+			return fmt.Errorf("Error associating external IP: %s", err)
+		}
+		input.AllowReassociation = aws.Bool(false)
+		assoc, err := d.getAPI().AssociateAddress(input)
+		d.instanceCache.forget()
+		if err != nil && isAddressInUse(err) {
+			log.Debugf("External IP %s was just taken from pool %s, trying the next one", aws.StringValue(address.PublicIp), d.PublicIpPool)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Error associating external IP: %s", err)
+		}
+
+		log.Infof("Using external IP %s from pool %s", aws.StringValue(address.PublicIp), d.PublicIpPool)
+		d.AllocationId = *address.AllocationId
+		d.PublicIp = aws.StringValue(address.PublicIp)
+		d.AssociationId = aws.StringValue(assoc.AssociationId)
+		return nil
+	}
+
+	log.Infof("No free external IP left in pool %s, allocating a new one", d.PublicIpPool)
+	eip, err := d.getAPI().AllocateAddress(&ec2.AllocateAddressInput{
+		Domain: aws.String("vpc"),
+	})
+	if err != nil {
+		return fmt.Errorf("Error allocating external IP: %s", err)
+	}
+	d.AllocationId = *eip.AllocationId
+	d.PublicIp = *eip.PublicIp
+
+	_, err = d.getClient().CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{eip.AllocationId},
+		Tags:      []*ec2.Tag{{Key: aws.String(publicIpPoolTag), Value: aws.String(d.PublicIpPool)}},
+	})
+	if err != nil {
+		log.Warnf("Unable to add external IP %s to pool %s: %s", d.PublicIp, d.PublicIpPool, err)
+	}
+	return nil
+}
+
+// returnPoolAddress disassociates the external IP from the instance, leaving
+// it free in the pool for the next machine
+func (d *Driver) returnPoolAddress() error {
+	if err := d.disassociateAddress(); err != nil {
+		return err
+	}
+	d.AllocationId = ""
+	return nil
+}
+
+// isAddressInUse tells whether the external IP is associated with another
+// instance
+func isAddressInUse(err error) bool {
+	return strings.HasPrefix(err.Error(), "Resource.AlreadyAssociated") ||
+		strings.HasPrefix(err.Error(), "InvalidIPAddress.InUse")
+}
@@ -0,0 +1,93 @@
+package outscale
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeAPIPublicIpPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalepool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	newPoolDriver := func(name string) *Driver {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", name), 0700))
+		driver := NewDriver(name, dir)
+		assert.NoError(t, driver.SetConfigFromFlags(&commandstest.FakeFlagger{
+			Data: map[string]interface{}{
+				"outscale-allowed-cidr":   []string{ipRange},
+				"name":                    name,
+				"outscale-fake-api":       true,
+				"outscale-region":         "us-east-2",
+				"outscale-zone":           "us-east-2a",
+				"outscale-ami":            defaultAmiId,
+				"outscale-instance-type":  defaultInstanceType,
+				"outscale-security-group": []string{defaultSecurityGroup},
+				"outscale-public-ip-pool": "pool-test",
+			},
+		}))
+		return driver
+	}
+
+	first := newPoolDriver("pool-node1")
+	assert.NoError(t, first.PreCreateCheck())
+	assert.NoError(t, first.Create())
+	pooled := first.AllocationId
+
+	addresses, err := first.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{
+		AllocationIds: []*string{aws.String(pooled)},
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, addresses.Addresses[0].Tags, &ec2.Tag{Key: aws.String(publicIpPoolTag), Value: aws.String("pool-test")})
+
+	assert.NoError(t, first.Remove())
+
+	second := newPoolDriver("pool-node2")
+	assert.NoError(t, second.PreCreateCheck())
+	assert.NoError(t, second.Create())
+	assert.Equal(t, pooled, second.AllocationId)
+	assert.Equal(t, second.PublicIp, second.IPAddress)
+
+	third := newPoolDriver("pool-node3")
+	assert.NoError(t, third.PreCreateCheck())
+	assert.NoError(t, third.Create())
+	assert.NotEqual(t, pooled, third.AllocationId)
+
+	assert.NoError(t, second.Remove())
+	assert.NoError(t, third.Remove())
+
+	addresses, err = first.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("tag:" + publicIpPoolTag), Values: []*string{aws.String("pool-test")}}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, addresses.Addresses, 2)
+	for _, address := range addresses.Addresses {
+		assert.Nil(t, address.InstanceId)
+	}
+}
+
+func TestClaimPoolAddressSkipsTakenAddresses(t *testing.T) {
+	client := &fakeEC2PoolRace{
+		fakeEC2WithInstances: &fakeEC2WithInstances{addresses: []*ec2.Address{
+			{AllocationId: aws.String("eipalloc-used"), PublicIp: aws.String("1.2.3.3"), InstanceId: aws.String("i-other")},
+			{AllocationId: aws.String("eipalloc-taken"), PublicIp: aws.String("1.2.3.4")},
+			{AllocationId: aws.String("eipalloc-free"), PublicIp: aws.String("1.2.3.5")},
+		}},
+		taken: "eipalloc-taken",
+	}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.PublicIpPool = "pool"
+
+	assert.NoError(t, driver.claimPoolAddress())
+	assert.Equal(t, "eipalloc-free", driver.AllocationId)
+	assert.Equal(t, "1.2.3.5", driver.PublicIp)
+	assert.Equal(t, "eipassoc-12345", driver.AssociationId)
+}
@@ -0,0 +1,133 @@
+package outscale
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Same default as the LBU connection draining timeout
+const defaultLoadBalancerDrainTimeout = "300s"
+
+// lbuDrainInterval is the delay between two checks of a draining instance
+var lbuDrainInterval = 5 * time.Second
+
+// LbuClient is the subset of the LBU (load balancer) API used by the driver
+type LbuClient interface {
+	RegisterInstancesWithLoadBalancer(input *elb.RegisterInstancesWithLoadBalancerInput) (*elb.RegisterInstancesWithLoadBalancerOutput, error)
+
+	DeregisterInstancesFromLoadBalancer(input *elb.DeregisterInstancesFromLoadBalancerInput) (*elb.DeregisterInstancesFromLoadBalancerOutput, error)
+
+	DescribeInstanceHealth(input *elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error)
+}
+
+func (d *Driver) buildLbuClient() LbuClient {
+	return elb.New(d.newSession(d.lbuEndpoint()))
+}
+
+func (d *Driver) getLbuClient() LbuClient {
+	return d.lbuClientFactory()
+}
+
+// lbuEndpoint returns --outscale-lbu-endpoint, or the LBU endpoint next to
+// the FCU one (https://fcu.eu-west-2.outscale.com gives
+// https://lbu.eu-west-2.outscale.com)
+func (d *Driver) lbuEndpoint() string {
+	if d.LbuEndpoint != "" {
+		return d.LbuEndpoint
+	}
+	return strings.Replace(d.Endpoint, "fcu.", "lbu.", 1)
+}
+
+// parseDrainTimeout parses --outscale-load-balancer-drain-timeout, 0 meaning
+// not to wait.
+func parseDrainTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid --outscale-load-balancer-drain-timeout %q, expected a duration like 300s", value)
+	}
+	return timeout, nil
+}
+
+// registerWithLoadBalancers adds the running instance to the backends of the
+// --outscale-load-balancer-name LBUs, registering it again being harmless on
+// a retried create
+func (d *Driver) registerWithLoadBalancers() error {
+	for _, name := range d.RegisterLoadBalancers {
+		log.Infof("Registering %s with load balancer %s", d.InstanceId, name)
+		_, err := d.getLbuClient().RegisterInstancesWithLoadBalancer(&elb.RegisterInstancesWithLoadBalancerInput{
+			LoadBalancerName: aws.String(name),
+			Instances:        []*elb.Instance{{InstanceId: aws.String(d.InstanceId)}},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to register %s with load balancer %s: %s", d.InstanceId, name, err)
+		}
+	}
+	return nil
+}
+
+// deregisterFromLoadBalancers takes the instance out of its LBUs and waits
+// for their connections to drain, so that removing a node doesn't drop live
+// connections. A drain that doesn't complete in time only delays the
+// removal.
+func (d *Driver) deregisterFromLoadBalancers(report *removeReport) {
+	timeout, _ := parseDrainTimeout(d.LoadBalancerDrainTimeout)
+
+	for _, name := range d.LoadBalancers {
+		_, err := d.getLbuClient().DeregisterInstancesFromLoadBalancer(&elb.DeregisterInstancesFromLoadBalancerInput{
+			LoadBalancerName: aws.String(name),
+			Instances:        []*elb.Instance{{InstanceId: aws.String(d.InstanceId)}},
+		})
+		if err != nil && !isNotRegistered(err) {
+			report.done("load balancer registration", name, err)
+			continue
+		}
+		report.done("load balancer registration", name, nil)
+
+		if timeout == 0 {
+			continue
+		}
+		log.Infof("Waiting up to %s for the connections of %s to drain from %s", timeout, d.InstanceId, name)
+		attempts := int(timeout/lbuDrainInterval) + 1
+		if err := waitForSpecific("connections to drain from "+name, d.instanceDrainedFunc(name), attempts, lbuDrainInterval); err != nil {
+			log.Warnf("Removing %s anyway: %s", d.InstanceId, err)
+		}
+	}
+}
+
+// instanceDrainedFunc reports whether the LBU stopped sending traffic to the
+// instance, which stays InService while its connections drain
+func (d *Driver) instanceDrainedFunc(name string) func() (bool, error) {
+	return func() (bool, error) {
+		output, err := d.getLbuClient().DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
+			LoadBalancerName: aws.String(name),
+			Instances:        []*elb.Instance{{InstanceId: aws.String(d.InstanceId)}},
+		})
+		if err != nil {
+			if isNotRegistered(err) {
+				return true, nil
+			}
+			return false, err
+		}
+
+		for _, instanceState := range output.InstanceStates {
+			if aws.StringValue(instanceState.InstanceId) == d.InstanceId && aws.StringValue(instanceState.State) == "InService" {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+func isNotRegistered(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == elb.ErrCodeInvalidEndPointException
+}
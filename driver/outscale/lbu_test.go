@@ -0,0 +1,81 @@
+package outscale
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLbuEndpoint(t *testing.T) {
+	driver := NewTestDriver()
+	driver.Endpoint = "https://fcu.eu-west-2.outscale.com"
+	assert.Equal(t, "https://lbu.eu-west-2.outscale.com", driver.lbuEndpoint())
+
+	driver.LbuEndpoint = "https://lbu.example.com"
+	assert.Equal(t, "https://lbu.example.com", driver.lbuEndpoint())
+}
+
+func TestParseDrainTimeout(t *testing.T) {
+	timeout, err := parseDrainTimeout("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), timeout)
+
+	timeout, err = parseDrainTimeout("30s")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	_, err = parseDrainTimeout("soon")
+	assert.EqualError(t, err, `invalid --outscale-load-balancer-drain-timeout "soon", expected a duration like 300s`)
+}
+
+func TestRegisterWithLoadBalancers(t *testing.T) {
+	lbu := &fakeLbu{}
+	driver := NewTestDriver()
+	driver.lbuClientFactory = func() LbuClient { return lbu }
+	driver.InstanceId = "i-12345"
+	driver.RegisterLoadBalancers = []string{"lbu-web", "lbu-api"}
+
+	assert.NoError(t, driver.registerWithLoadBalancers())
+	assert.Equal(t, []string{"lbu-web", "lbu-api"}, lbu.registered)
+
+	driver.RegisterLoadBalancers = []string{"lbu-missing"}
+	err := driver.registerWithLoadBalancers()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to register i-12345 with load balancer lbu-missing")
+}
+
+func TestRemoveWaitsForDraining(t *testing.T) {
+	defer func(interval time.Duration) { lbuDrainInterval = interval }(lbuDrainInterval)
+	lbuDrainInterval = time.Millisecond
+
+	lbu := &fakeLbu{drainedAfter: 2}
+	driver := NewCustomTestDriver(&fakeEC2Remove{})
+	driver.lbuClientFactory = func() LbuClient { return lbu }
+	driver.InstanceId = "i-12345"
+	driver.LoadBalancers = []string{"lbu-web"}
+	driver.LoadBalancerDrainTimeout = "1s"
+
+	report := driver.remove()
+
+	assert.NoError(t, report.err())
+	assert.Equal(t, []string{"lbu-web"}, lbu.deregistered)
+	assert.Equal(t, 3, lbu.healthCalls)
+	assert.Equal(t, "load balancer registration lbu-web: deleted", report.Results[0].String())
+	assert.Equal(t, cleanupResult{Resource: "instance", Id: "i-12345", Status: cleanupDeleted}, report.Results[1])
+}
+
+func TestRemoveReportsDeregistrationFailure(t *testing.T) {
+	lbu := &fakeLbu{deregErr: errors.New("throttled")}
+	driver := NewCustomTestDriver(&fakeEC2Remove{})
+	driver.lbuClientFactory = func() LbuClient { return lbu }
+	driver.InstanceId = "i-12345"
+	driver.LoadBalancers = []string{"lbu-web"}
+
+	report := driver.remove()
+
+	assert.Equal(t, cleanupFailed, report.Results[0].Status)
+	assert.Equal(t, 0, lbu.healthCalls)
+	assert.EqualError(t, report.err(), "unable to remove some resources, clean them manually:\nload balancer registration lbu-web: failed (throttled)")
+}
@@ -1,10 +1,21 @@
 package outscale
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// --outscale-api-log-level values
+const (
+	apiLogOff           = "off"
+	apiLogError         = "error"
+	apiLogDebug         = "debug"
+	apiLogDebugWithBody = "debug-with-body"
 )
 
 type awslogger struct {
@@ -12,11 +23,48 @@ type awslogger struct {
 }
 
 func AwsLogger() aws.Logger {
+	return newAwsLogger(os.Stderr)
+}
+
+func newAwsLogger(w io.Writer) aws.Logger {
 	return &awslogger{
-		logger: log.New(os.Stderr, "", log.LstdFlags),
+		logger: log.New(w, "", log.LstdFlags),
 	}
 }
 
+// awsLogger returns the SDK logger of the driver, with the secrets masked
+func (d *Driver) awsLogger() aws.Logger {
+	return newAwsLogger(&redactingWriter{w: os.Stderr, r: d.redactor()})
+}
+
 func (l awslogger) Log(args ...interface{}) {
 	l.logger.Println(args...)
 }
+
+// parseAPILogLevel returns the SDK log level of --outscale-api-log-level,
+// an empty value meaning off. The error level logs nothing through the SDK,
+// failed calls being logged by apiErrorLogHandler instead.
+func parseAPILogLevel(value string) (aws.LogLevelType, error) {
+	switch value {
+	case "", apiLogOff, apiLogError:
+		return aws.LogOff, nil
+	case apiLogDebug:
+		return aws.LogDebug | aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors, nil
+	case apiLogDebugWithBody:
+		return aws.LogDebugWithHTTPBody | aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors, nil
+	}
+	return aws.LogOff, fmt.Errorf("invalid --outscale-api-log-level %q, expected %s, %s, %s or %s", value, apiLogOff, apiLogError, apiLogDebug, apiLogDebugWithBody)
+}
+
+// apiErrorLogHandler logs the API calls that failed once retries are over
+func apiErrorLogHandler(logger aws.Logger) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "outscale.APIErrorLogHandler",
+		Fn: func(r *request.Request) {
+			if r.Error == nil {
+				return
+			}
+			logger.Log(fmt.Sprintf("ERROR: %s/%s failed after %d retries: %v", r.ClientInfo.ServiceName, r.Operation.Name, r.RetryCount, r.Error))
+		},
+	}
+}
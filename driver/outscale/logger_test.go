@@ -0,0 +1,50 @@
+package outscale
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAPILogLevel(t *testing.T) {
+	level, err := parseAPILogLevel("")
+	assert.NoError(t, err)
+	assert.Equal(t, aws.LogOff, level)
+
+	level, err = parseAPILogLevel(apiLogDebug)
+	assert.NoError(t, err)
+	assert.True(t, level.AtLeast(aws.LogDebug))
+	assert.False(t, level.Matches(aws.LogDebugWithHTTPBody))
+
+	level, err = parseAPILogLevel(apiLogDebugWithBody)
+	assert.NoError(t, err)
+	assert.True(t, level.Matches(aws.LogDebugWithHTTPBody))
+
+	_, err = parseAPILogLevel("trace")
+	assert.EqualError(t, err, `invalid --outscale-api-log-level "trace", expected off, error, debug or debug-with-body`)
+}
+
+func TestAPIErrorLogHandler(t *testing.T) {
+	lines := []string{}
+	logger := aws.LoggerFunc(func(args ...interface{}) {
+		lines = append(lines, fmt.Sprint(args...))
+	})
+	handler := apiErrorLogHandler(logger)
+
+	r := &request.Request{
+		ClientInfo: metadata.ClientInfo{ServiceName: "ec2"},
+		Operation:  &request.Operation{Name: "RunInstances"},
+	}
+	handler.Fn(r)
+	assert.Empty(t, lines)
+
+	r.Error = awserr.New("InsufficientInstanceCapacity", "no capacity", nil)
+	r.RetryCount = 2
+	handler.Fn(r)
+	assert.Equal(t, []string{"ERROR: ec2/RunInstances failed after 2 retries: InsufficientInstanceCapacity: no capacity"}, lines)
+}
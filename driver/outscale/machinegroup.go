@@ -0,0 +1,64 @@
+package outscale
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var errorSecurityGroupPerMachineWithExplicit = errors.New("--outscale-security-group-per-machine creates a group, it can't be combined with --outscale-explicit-security-group")
+
+// machineSecurityGroupName is the name of the --outscale-security-group-per-machine
+// group, the machine name
+func (d *Driver) machineSecurityGroupName() string {
+	return d.resourceName()
+}
+
+// isNodesSecurityGroup reports whether group is the managed group getting
+// the Kubernetes node rules: rancher-nodes or --outscale-nodes-security-group,
+// or the machine own group with --outscale-security-group-per-machine
+func (d *Driver) isNodesSecurityGroup(group *ec2.SecurityGroup) bool {
+	name := aws.StringValue(group.GroupName)
+	if name != d.nodesSecurityGroupName() && (!d.SecurityGroupPerMachine || name != d.machineSecurityGroupName()) {
+		return false
+	}
+	return d.isManagedSecurityGroup(group)
+}
+
+// clusterSecurityGroupName is the group the machines of the cluster join
+// next to their own group with --outscale-security-group-per-machine, the
+// source of the rules reserved to the peers
+func (d *Driver) clusterSecurityGroupName() string {
+	return sanitizeName(d.nodesSecurityGroupName()+"-"+d.clusterName(), invalidNameChars, maxTagValueLength)
+}
+
+// isClusterSecurityGroup reports whether group is the cluster group, which
+// only identifies the machines of the cluster and gets no rules of its own
+func (d *Driver) isClusterSecurityGroup(group *ec2.SecurityGroup) bool {
+	if !d.SecurityGroupPerMachine || aws.StringValue(group.GroupName) != d.clusterSecurityGroupName() {
+		return false
+	}
+	return d.isManagedSecurityGroup(group)
+}
+
+// peersGroupId is the source of the rules reserved to the peers in group:
+// the cluster group for the machine own group, when known, group itself
+// otherwise
+func (d *Driver) peersGroupId(group *ec2.SecurityGroup) *string {
+	if d.SecurityGroupPerMachine && d.ClusterSecurityGroupId != "" && aws.StringValue(group.GroupName) == d.machineSecurityGroupName() {
+		return aws.String(d.ClusterSecurityGroupId)
+	}
+	return group.GroupId
+}
+
+// removeMachineSecurityGroup deletes the --outscale-security-group-per-machine
+// group once the instance is gone
+func (d *Driver) removeMachineSecurityGroup(report *removeReport, instanceGone bool) {
+	if !instanceGone {
+		report.kept("security group", d.MachineSecurityGroupId, "used by the kept instance")
+		return
+	}
+
+	report.done("security group", d.MachineSecurityGroupId, d.deleteSecurityGroup(d.MachineSecurityGroupId))
+}
@@ -0,0 +1,70 @@
+package outscale
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityGroupNamesPerMachine(t *testing.T) {
+	driver := NewDriver("cluster-node1", "path")
+	driver.SecurityGroupPerMachine = true
+	assert.Equal(t, []string{"rancher-nodes-cluster", "cluster-node1"}, driver.securityGroupNames())
+
+	driver.SecurityGroupNames = []string{"monitoring"}
+	assert.Equal(t, []string{"rancher-nodes-cluster", "monitoring", "cluster-node1"}, driver.securityGroupNames())
+}
+
+func TestConfigureMachineSecurityGroupPermissions(t *testing.T) {
+	driver := NewTestDriver()
+	driver.MachineName = "cluster-node1"
+	driver.SecurityGroupPerMachine = true
+	driver.ClusterSecurityGroupId = "sg-cluster"
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-node1"),
+		GroupName: aws.String("cluster-node1"),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}
+
+	perms, err := driver.configureSecurityGroupPermissions(group)
+	assert.NoError(t, err)
+	rules := []string{}
+	for _, perm := range perms {
+		rules = append(rules, describePermission(perm))
+	}
+	assert.Contains(t, rules, fmt.Sprintf("%d/tcp from 0.0.0.0/0", kubeApiPort))
+	assert.Contains(t, rules, "8472/udp from sg-cluster")
+	assert.Contains(t, rules, fmt.Sprintf("%d-%d/tcp from sg-cluster", etcdPorts[0], etcdPorts[1]))
+	assert.NotContains(t, rules, "8472/udp from sg-node1")
+
+	// the cluster group only identifies the machines of the cluster
+	perms, err = driver.configureSecurityGroupPermissions(&ec2.SecurityGroup{
+		GroupId:   aws.String("sg-cluster"),
+		GroupName: aws.String("rancher-nodes-cluster"),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, perms)
+}
+
+func TestRemoveMachineSecurityGroup(t *testing.T) {
+	client := &fakeEC2DeleteSecurityGroup{}
+	driver := NewCustomTestDriver(client)
+	driver.SecurityGroupIds = []string{"sg-shared", "sg-node1"}
+	driver.MachineSecurityGroupId = "sg-node1"
+
+	report := driver.remove()
+	assert.Equal(t, []string{"sg-node1"}, client.deleted)
+	assert.Equal(t, []cleanupResult{
+		{Resource: "security group", Id: "sg-shared", Status: cleanupKept, Reason: "shared between machines"},
+		{Resource: "security group", Id: "sg-node1", Status: cleanupDeleted},
+	}, report.Results)
+
+	report = &removeReport{}
+	driver.removeMachineSecurityGroup(report, false)
+	assert.Equal(t, "used by the kept instance", report.Results[0].Reason)
+	assert.Len(t, client.deleted, 1)
+}
@@ -0,0 +1,71 @@
+package outscale
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// machineLogFile is written to the machine directory with --outscale-log-file
+const machineLogFile = "outscale-driver.log"
+
+// openMachineLog tees the driver log output into the machine log file, so
+// that failures can be reviewed after the fact. The file is kept open for
+// the life of the driver process, which only serves one machine.
+func (d *Driver) openMachineLog() {
+	if !d.LogToFile || d.machineLog != nil {
+		return
+	}
+
+	path := d.ResolveStorePath(machineLogFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Warnf("Unable to create the machine log directory: %s", err)
+		return
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Warnf("Unable to open the machine log file: %s", err)
+		return
+	}
+
+	d.machineLog = &redactingWriter{w: file, r: d.redactor()}
+}
+
+// logPhase starts a driver operation: the retry time budget restarts and
+// the start is marked in the machine log if enabled
+func (d *Driver) logPhase(phase string) {
+	d.retryStart = time.Now()
+	d.openMachineLog()
+	d.redactLogs()
+	if d.machineLog != nil {
+		fmt.Fprintf(d.machineLog, "%s ===== %s %s\n", time.Now().UTC().Format(time.RFC3339), phase, d.MachineName)
+	}
+}
+
+// apiSummaryHandler writes one line per API call to the machine log
+func (d *Driver) apiSummaryHandler() request.NamedHandler {
+	return request.NamedHandler{
+		Name: "outscale.APISummaryHandler",
+		Fn: func(r *request.Request) {
+			if d.machineLog == nil {
+				return
+			}
+			status := 0
+			if r.HTTPResponse != nil {
+				status = r.HTTPResponse.StatusCode
+			}
+			line := fmt.Sprintf("%s API %s status=%d retries=%d duration=%s", time.Now().UTC().Format(time.RFC3339), r.Operation.Name, status, r.RetryCount, time.Since(r.Time).Round(time.Millisecond))
+			if r.RequestID != "" {
+				line += " request-id=" + r.RequestID
+			}
+			if r.Error != nil {
+				line += " error=" + r.Error.Error()
+			}
+			fmt.Fprintln(d.machineLog, line)
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package outscale
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// Length limits of the names derived from the machine name. Key pair names
+// get a random suffix appended.
+const (
+	maxTagValueLength = 255
+	maxKeyNameLength  = 255 - len("-abcde")
+	maxHostnameLength = 63
+	nameHashLength    = 8
+)
+
+var (
+	invalidNameChars     = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	invalidHostnameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// sanitizeName replaces the characters Outscale rejects in resource names
+// and truncates the result to maxLength. A name that had to be changed gets
+// a hash of the original appended, so that distinct machine names stay
+// distinct and the same name always gives the same result.
+func sanitizeName(name string, invalid *regexp.Regexp, maxLength int) string {
+	sanitized := strings.Trim(invalid.ReplaceAllString(name, "-"), "-.")
+	if sanitized == name && len(name) <= maxLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:])[:nameHashLength]
+	if len(sanitized) > maxLength-len(suffix) {
+		sanitized = strings.TrimRight(sanitized[:maxLength-len(suffix)], "-.")
+	}
+	return sanitized + suffix
+}
+
+// resourceName is the machine name used in the Name tag
+func (d *Driver) resourceName() string {
+	return sanitizeName(d.MachineName, invalidNameChars, maxTagValueLength)
+}
+
+// keyPairPrefix is the machine name used in the generated key pair names
+func (d *Driver) keyPairPrefix() string {
+	return sanitizeName(d.MachineName, invalidNameChars, maxKeyNameLength)
+}
+
+// machineHostname is the machine name as a valid hostname label, lowercased
+// like Kubernetes node names, used in the DNS record name
+func (d *Driver) machineHostname() string {
+	return sanitizeName(strings.ToLower(d.MachineName), invalidHostnameChars, maxHostnameLength)
+}
+
+// k8sNodeName is the node name Kubernetes gives the instance: the provisioner
+// sets the machine name as the hostname, which the kubelet lowercases
+func (d *Driver) k8sNodeName() string {
+	return strings.ToLower(d.MachineName)
+}
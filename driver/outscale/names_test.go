@@ -0,0 +1,53 @@
+package outscale
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeNameKeepsValidNames(t *testing.T) {
+	assert.Equal(t, "cluster-node1", sanitizeName("cluster-node1", invalidNameChars, maxTagValueLength))
+	assert.Equal(t, "cluster_node.1", sanitizeName("cluster_node.1", invalidNameChars, maxTagValueLength))
+}
+
+func TestSanitizeNameReplacesInvalidCharacters(t *testing.T) {
+	name := sanitizeName("cluster node/1", invalidNameChars, maxTagValueLength)
+
+	assert.True(t, strings.HasPrefix(name, "cluster-node-1-"), name)
+	assert.Len(t, name, len("cluster-node-1-")+nameHashLength)
+	assert.Equal(t, name, sanitizeName("cluster node/1", invalidNameChars, maxTagValueLength))
+	assert.NotEqual(t, name, sanitizeName("cluster node:1", invalidNameChars, maxTagValueLength))
+}
+
+func TestSanitizeNameTruncates(t *testing.T) {
+	long := strings.Repeat("a", 100)
+
+	name := sanitizeName(long, invalidHostnameChars, maxHostnameLength)
+
+	assert.Len(t, name, maxHostnameLength)
+	assert.NotEqual(t, name, sanitizeName(long+"b", invalidHostnameChars, maxHostnameLength))
+}
+
+func TestMachineHostname(t *testing.T) {
+	driver := NewDriver("Cluster_Node1", "path")
+
+	assert.True(t, strings.HasPrefix(driver.machineHostname(), "cluster-node1-"), driver.machineHostname())
+	assert.Equal(t, "machinefoo", NewDriver("machineFoo", "path").machineHostname())
+}
+
+func TestK8sNodeNameTag(t *testing.T) {
+	driver := NewDriver("Pool1-Node1", "path")
+
+	tags, err := driver.instanceTags("")
+	assert.NoError(t, err)
+	assert.Contains(t, tags, &ec2.Tag{Key: aws.String("OscK8sNodeName"), Value: aws.String("pool1-node1")})
+
+	// the provisioner sets the hostname, the user data leaves it
+	c, err := driver.generateCloudInit()
+	assert.NoError(t, err)
+	assert.True(t, c.empty())
+}
@@ -0,0 +1,433 @@
+package outscale
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	defaultNetCIDR      = "10.0.0.0/16"
+	defaultSubnetPrefix = 24
+	// subnetCIDRAttempts bounds the blocks tried when the subnets of other
+	// drivers take the free ones concurrently
+	subnetCIDRAttempts = 5
+)
+
+// parseNetCIDR validates --outscale-net-cidr, which must leave room for
+// subnets
+func parseNetCIDR(value string) (*net.IPNet, error) {
+	_, cidr, err := net.ParseCIDR(value)
+	if err != nil || cidr.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid --outscale-net-cidr %q, expected an IPv4 CIDR like %s", value, defaultNetCIDR)
+	}
+	if ones, _ := cidr.Mask.Size(); ones < 16 || ones > defaultSubnetPrefix {
+		return nil, fmt.Errorf("invalid --outscale-net-cidr %q, expected a prefix length between /16 and /%d", value, defaultSubnetPrefix)
+	}
+	return cidr, nil
+}
+
+// createNet creates, when --outscale-create-net is set and no Net was found,
+// a Net whose traffic is routed through an Internet service. The Net is named
+// "default" so that the following machines pick it up, once it is complete.
+func (d *Driver) createNet() error {
+	if d.VpcId != "" || d.SubnetId != "" || !d.CreateNet {
+		return nil
+	}
+
+	unlock := d.lockNetCreation()
+	defer unlock()
+
+	// the machines created side by side all found no Net
+	vpcId, err := d.findNamedNet(defaultNetName)
+	if err != nil {
+		return err
+	}
+	if vpcId != "" {
+		log.Infof("Using Net %s created by another machine", vpcId)
+		d.VpcId = vpcId
+		return nil
+	}
+
+	log.Infof("Creating Net %s with CIDR %s", defaultNetName, d.NetCIDR)
+	vpc, err := d.getClient().CreateVpc(&ec2.CreateVpcInput{
+		CidrBlock: aws.String(d.NetCIDR),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create Net: %s", err)
+	}
+	vpcId = aws.StringValue(vpc.Vpc.VpcId)
+
+	igwId, err := d.routeNetToInternet(vpcId)
+	if err != nil {
+		d.deleteNet(vpcId, igwId)
+		return err
+	}
+	d.tagNetResource(vpcId, defaultNetName)
+	d.VpcId = vpcId
+	return nil
+}
+
+// lockNetCreation serializes the Net creation of the machines of the store
+// and returns the function releasing the lock
+func (d *Driver) lockNetCreation() func() {
+	path := filepath.Join(d.StorePath, fmt.Sprintf("outscale-net-%s.lock", d.Region))
+	unlock, err := lockFile(path)
+	if err != nil {
+		log.Warnf("Unable to lock the Net creation: %s", err)
+		return func() {}
+	}
+	return unlock
+}
+
+// findNamedNet returns the ID of the Net with the given name, "" if there is
+// none
+func (d *Driver) findNamedNet(name string) (string, error) {
+	vpcs, err := d.describeVpcs(&ec2.DescribeVpcsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:Name"),
+				Values: []*string{aws.String(name)},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to look up Net %s: %s", name, err)
+	}
+	if len(vpcs) == 0 {
+		return "", nil
+	}
+	return aws.StringValue(vpcs[0].VpcId), nil
+}
+
+// routeNetToInternet links a new Internet service to the Net and routes the
+// Net traffic through it. It returns the ID of the Internet service, even on
+// a failure once it was created, for the rollback.
+func (d *Driver) routeNetToInternet(vpcId string) (string, error) {
+	igw, err := d.getClient().CreateInternetGateway(&ec2.CreateInternetGatewayInput{})
+	if err != nil {
+		return "", fmt.Errorf("unable to create Internet service: %s", err)
+	}
+	igwId := aws.StringValue(igw.InternetGateway.InternetGatewayId)
+	d.tagNetResource(igwId, defaultNetName)
+
+	_, err = d.getClient().AttachInternetGateway(&ec2.AttachInternetGatewayInput{
+		InternetGatewayId: aws.String(igwId),
+		VpcId:             aws.String(vpcId),
+	})
+	if err != nil {
+		return igwId, fmt.Errorf("unable to link Internet service %s to Net %s: %s", igwId, vpcId, err)
+	}
+
+	tables, err := d.getClient().DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(vpcId)},
+			},
+			{
+				Name:   aws.String("association.main"),
+				Values: []*string{aws.String("true")},
+			},
+		},
+	})
+	if err != nil {
+		return igwId, fmt.Errorf("unable to find the route table of Net %s: %s", vpcId, err)
+	}
+	if len(tables.RouteTables) == 0 {
+		return igwId, fmt.Errorf("no main route table found for Net %s", vpcId)
+	}
+	tableId := aws.StringValue(tables.RouteTables[0].RouteTableId)
+	d.tagNetResource(tableId, defaultNetName)
+
+	_, err = d.getClient().CreateRoute(&ec2.CreateRouteInput{
+		RouteTableId:         aws.String(tableId),
+		DestinationCidrBlock: aws.String(ipRange),
+		GatewayId:            aws.String(igwId),
+	})
+	if err != nil {
+		return igwId, fmt.Errorf("unable to add the default route to %s: %s", tableId, err)
+	}
+	return igwId, nil
+}
+
+// deleteNet rolls back a Net whose creation failed half way, so that the
+// next attempt doesn't find an unusable Net or leak one per attempt
+func (d *Driver) deleteNet(vpcId, igwId string) {
+	log.Infof("Deleting the incomplete Net %s", vpcId)
+	if igwId != "" {
+		_, err := d.getClient().DetachInternetGateway(&ec2.DetachInternetGatewayInput{
+			InternetGatewayId: aws.String(igwId),
+			VpcId:             aws.String(vpcId),
+		})
+		if err != nil && !strings.HasPrefix(err.Error(), "Gateway.NotAttached") {
+			log.Warnf("Unable to unlink Internet service %s from Net %s: %s", igwId, vpcId, err)
+		}
+		if _, err := d.getClient().DeleteInternetGateway(&ec2.DeleteInternetGatewayInput{
+			InternetGatewayId: aws.String(igwId),
+		}); err != nil {
+			log.Warnf("Unable to delete Internet service %s: %s", igwId, err)
+		}
+	}
+	if _, err := d.getClient().DeleteVpc(&ec2.DeleteVpcInput{VpcId: aws.String(vpcId)}); err != nil {
+		log.Warnf("Unable to delete Net %s: %s", vpcId, err)
+	}
+}
+
+// createSubnet creates a subnet in the zone, in the first free block of the
+// Net, returning its ID. A block taken meanwhile by another driver is
+// skipped for the next free one.
+func (d *Driver) createSubnet(zone string) (string, error) {
+	vpcs, err := d.describeVpcs(&ec2.DescribeVpcsInput{
+		VpcIds: []*string{aws.String(d.VpcId)},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(vpcs) == 0 {
+		return "", fmt.Errorf("Net %s does not exist", d.VpcId)
+	}
+
+	subnets, err := d.describeSubnets(&ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(d.VpcId)},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	used := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		used = append(used, aws.StringValue(subnet.CidrBlock))
+	}
+
+	for attempt := 1; ; attempt++ {
+		cidr, err := freeSubnetCIDR(aws.StringValue(vpcs[0].CidrBlock), used, defaultSubnetPrefix)
+		if err != nil {
+			return "", err
+		}
+
+		log.Infof("Creating subnet %s in %s", cidr, zone)
+		subnet, err := d.getClient().CreateSubnet(&ec2.CreateSubnetInput{
+			VpcId:            aws.String(d.VpcId),
+			CidrBlock:        aws.String(cidr),
+			AvailabilityZone: aws.String(zone),
+		})
+		if err == nil {
+			return aws.StringValue(subnet.Subnet.SubnetId), nil
+		}
+		if !isSubnetConflictError(err) || attempt == subnetCIDRAttempts {
+			return "", fmt.Errorf("unable to create subnet %s: %s", cidr, err)
+		}
+		log.Debugf("subnet %s taken concurrently, trying the next free block: %s", cidr, err)
+		used = append(used, cidr)
+	}
+}
+
+// isSubnetConflictError tells whether a subnet creation failed because its
+// block overlaps an existing subnet
+func isSubnetConflictError(err error) bool {
+	return strings.HasPrefix(err.Error(), "InvalidSubnet.Conflict") ||
+		strings.Contains(strings.ToLower(err.Error()), "overlap")
+}
+
+// subnetInstances lists the instances other than the machine one living in
+// its subnet
+func (d *Driver) subnetInstances() ([]string, error) {
+	instances, err := d.describeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("subnet-id"),
+				Values: []*string{aws.String(d.SubnetId)},
+			},
+			{
+				Name: aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{
+					ec2.InstanceStateNamePending,
+					ec2.InstanceStateNameRunning,
+					ec2.InstanceStateNameStopping,
+					ec2.InstanceStateNameStopped,
+				}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, instance := range instances {
+		if id := aws.StringValue(instance.InstanceId); id != d.InstanceId {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// removeSubnet deletes the subnet created for the machine once its instance
+// is gone, keeping it while other instances use it
+func (d *Driver) removeSubnet(report *removeReport, instanceGone bool) {
+	if !instanceGone {
+		report.kept("subnet", d.SubnetId, "used by the kept instance")
+		return
+	}
+
+	users, err := d.subnetInstances()
+	if err != nil {
+		report.done("subnet", d.SubnetId, err)
+		return
+	}
+	if len(users) > 0 {
+		report.kept("subnet", d.SubnetId, "used by "+strings.Join(users, ", "))
+		return
+	}
+	report.done("subnet", d.SubnetId, d.deleteSubnet())
+}
+
+// deleteSubnet deletes the subnet created for the machine, retrying while
+// the network interfaces of the terminated instance are released
+func (d *Driver) deleteSubnet() error {
+	err := waitFor("deletion of subnet "+d.SubnetId, func() (bool, error) {
+		_, err := d.getClient().DeleteSubnet(&ec2.DeleteSubnetInput{
+			SubnetId: aws.String(d.SubnetId),
+		})
+		if err != nil && !strings.HasPrefix(err.Error(), "InvalidSubnetID.NotFound") {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to delete subnet: %s", err)
+	}
+	return nil
+}
+
+// tagNetResource names a resource shared between the machines of the Net
+func (d *Driver) tagNetResource(id, name string) {
+	_, err := d.getClient().CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(id)},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String(name)},
+			{Key: aws.String(createdByTag), Value: aws.String(userAgentName)},
+		},
+	})
+	if err != nil {
+		log.Warnf("Unable to tag %s: %s", id, err)
+	}
+}
+
+// freeSubnetCIDR returns the first block with the given prefix length in the
+// Net CIDR that overlaps none of the used ones
+func freeSubnetCIDR(netCIDR string, used []string, prefix int) (string, error) {
+	_, network, err := net.ParseCIDR(netCIDR)
+	if err != nil || network.IP.To4() == nil {
+		return "", fmt.Errorf("invalid Net CIDR %q", netCIDR)
+	}
+	ones, _ := network.Mask.Size()
+	if prefix < ones || prefix > 28 {
+		return "", fmt.Errorf("can't carve a /%d subnet out of %s", prefix, netCIDR)
+	}
+
+	usedBlocks := make([]*net.IPNet, 0, len(used))
+	for _, cidr := range used {
+		if _, block, err := net.ParseCIDR(cidr); err == nil {
+			usedBlocks = append(usedBlocks, block)
+		}
+	}
+
+	base := binary.BigEndian.Uint32(network.IP.To4())
+	size := uint32(1) << uint(32-prefix)
+	for i := uint32(0); i < uint32(1)<<uint(prefix-ones); i++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+i*size)
+		candidate := &net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, 32)}
+
+		free := true
+		for _, block := range usedBlocks {
+			if block.Contains(candidate.IP) || candidate.Contains(block.IP) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no free /%d block left in %s", prefix, netCIDR)
+}
+
+// subnetRouteTable returns the route table of the subnet, the main one of the
+// Net when the subnet has none of its own or doesn't exist yet
+func (d *Driver) subnetRouteTable() (*ec2.RouteTable, error) {
+	filters := [][]*ec2.Filter{{
+		{
+			Name:   aws.String("vpc-id"),
+			Values: []*string{aws.String(d.VpcId)},
+		},
+		{
+			Name:   aws.String("association.main"),
+			Values: []*string{aws.String("true")},
+		},
+	}}
+	if d.SubnetId != "" {
+		filters = append([][]*ec2.Filter{{{
+			Name:   aws.String("association.subnet-id"),
+			Values: []*string{aws.String(d.SubnetId)},
+		}}}, filters...)
+	}
+
+	for _, filter := range filters {
+		tables, err := d.getClient().DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: filter})
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the route table of subnet %s: %s", d.SubnetId, err)
+		}
+		if len(tables.RouteTables) > 0 {
+			return tables.RouteTables[0], nil
+		}
+	}
+	return nil, fmt.Errorf("no route table found for subnet %s in Net %s", d.SubnetId, d.VpcId)
+}
+
+// checkNatRoute makes sure a private only instance can reach the Internet,
+// for the package installs and the Rancher agent registration, through a
+// default route to a NAT service or NAT VM. It is opt-in, a proxy or a
+// peering providing the access as well.
+func (d *Driver) checkNatRoute() error {
+	if !d.PrivateIPOnly || !d.CheckNatRoute {
+		return nil
+	}
+
+	table, err := d.subnetRouteTable()
+	if err != nil {
+		return err
+	}
+	tableId := aws.StringValue(table.RouteTableId)
+	for _, route := range table.Routes {
+		if aws.StringValue(route.DestinationCidrBlock) != ipRange || aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+			continue
+		}
+		switch {
+		case aws.StringValue(route.NatGatewayId) != "":
+			log.Debugf("subnet %s reaches the Internet through NAT service %s", d.SubnetId, *route.NatGatewayId)
+			return nil
+		case aws.StringValue(route.InstanceId) != "":
+			log.Debugf("subnet %s reaches the Internet through NAT VM %s", d.SubnetId, *route.InstanceId)
+			return nil
+		case aws.StringValue(route.NetworkInterfaceId) != "":
+			log.Debugf("subnet %s reaches the Internet through network interface %s", d.SubnetId, *route.NetworkInterfaceId)
+			return nil
+		case aws.StringValue(route.GatewayId) != "":
+			return fmt.Errorf("route table %s sends %s to %s, which a private only instance can't use without a public IP: route it to a NAT service or unset --outscale-check-nat-route when going through a proxy", tableId, ipRange, *route.GatewayId)
+		}
+	}
+	return fmt.Errorf("route table %s has no %s route to a NAT service, a private only instance couldn't reach the Internet: add one or unset --outscale-check-nat-route when going through a proxy", tableId, ipRange)
+}
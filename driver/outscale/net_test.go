@@ -0,0 +1,185 @@
+package outscale
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNetCIDR(t *testing.T) {
+	_, err := parseNetCIDR(defaultNetCIDR)
+	assert.NoError(t, err)
+	_, err = parseNetCIDR("10.0.0.0/8")
+	assert.EqualError(t, err, `invalid --outscale-net-cidr "10.0.0.0/8", expected a prefix length between /16 and /24`)
+	_, err = parseNetCIDR("2001:db8::/48")
+	assert.EqualError(t, err, `invalid --outscale-net-cidr "2001:db8::/48", expected an IPv4 CIDR like 10.0.0.0/16`)
+}
+
+func TestFreeSubnetCIDR(t *testing.T) {
+	cidr, err := freeSubnetCIDR("10.0.0.0/16", nil, 24)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/24", cidr)
+
+	cidr, err = freeSubnetCIDR("10.0.0.0/16", []string{"10.0.0.0/24", "10.0.1.128/25", "10.0.3.0/24"}, 24)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.2.0/24", cidr)
+
+	cidr, err = freeSubnetCIDR("10.0.0.0/16", []string{"10.0.0.0/23"}, 24)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.2.0/24", cidr)
+
+	_, err = freeSubnetCIDR("10.0.0.0/24", []string{"10.0.0.0/24"}, 24)
+	assert.EqualError(t, err, "no free /24 block left in 10.0.0.0/24")
+}
+
+func TestCreateNet(t *testing.T) {
+	client := &fakeEC2CreateNet{}
+	driver := NewCustomTestDriver(client)
+	driver.CreateNet = true
+	driver.NetCIDR = defaultNetCIDR
+
+	assert.NoError(t, driver.createNet())
+	assert.Equal(t, "vpc-new", driver.VpcId)
+	assert.Equal(t, []string{
+		"CreateVpc 10.0.0.0/16",
+		"CreateInternetGateway",
+		"CreateTags igw-new",
+		"AttachInternetGateway igw-new vpc-new",
+		"CreateTags rtb-main",
+		"CreateRoute rtb-main 0.0.0.0/0 igw-new",
+		"CreateTags vpc-new",
+	}, client.calls)
+
+	client.calls = nil
+	assert.NoError(t, driver.createNet())
+	assert.Empty(t, client.calls)
+}
+
+func TestCreateNetCreatedConcurrently(t *testing.T) {
+	client := &fakeEC2CreateNet{named: []*ec2.Vpc{{VpcId: aws.String("vpc-other")}}}
+	driver := NewCustomTestDriver(client)
+	driver.CreateNet = true
+	driver.NetCIDR = defaultNetCIDR
+
+	assert.NoError(t, driver.createNet())
+	assert.Equal(t, "vpc-other", driver.VpcId)
+	assert.Empty(t, client.calls)
+}
+
+func TestCreateNetRollback(t *testing.T) {
+	client := &fakeEC2CreateNet{failRoute: true}
+	driver := NewCustomTestDriver(client)
+	driver.CreateNet = true
+	driver.NetCIDR = defaultNetCIDR
+
+	assert.Error(t, driver.createNet())
+	assert.Empty(t, driver.VpcId)
+	assert.Equal(t, []string{
+		"CreateVpc 10.0.0.0/16",
+		"CreateInternetGateway",
+		"CreateTags igw-new",
+		"AttachInternetGateway igw-new vpc-new",
+		"CreateTags rtb-main",
+		"CreateRoute rtb-main 0.0.0.0/0 igw-new",
+		"DetachInternetGateway igw-new vpc-new",
+		"DeleteInternetGateway igw-new",
+		"DeleteVpc vpc-new",
+	}, client.calls)
+}
+
+func TestCreateSubnet(t *testing.T) {
+	client := &fakeEC2CreateNet{subnets: []*ec2.Subnet{{CidrBlock: aws.String("10.0.0.0/24")}}}
+	driver := NewCustomTestDriver(client)
+	driver.Region = "eu-west-2"
+	driver.Zone = "b"
+	driver.VpcId = "vpc-new"
+
+	subnetId, err := driver.createSubnet(driver.getRegionZone())
+	assert.NoError(t, err)
+	assert.Equal(t, "subnet-new", subnetId)
+	assert.Equal(t, []string{"CreateSubnet 10.0.1.0/24 eu-west-2b"}, client.calls)
+
+	client.calls = nil
+	client.taken = []string{"10.0.1.0/24", "10.0.2.0/24"}
+	subnetId, err = driver.createSubnet(driver.getRegionZone())
+	assert.NoError(t, err)
+	assert.Equal(t, "subnet-new", subnetId)
+	assert.Equal(t, []string{
+		"CreateSubnet 10.0.1.0/24 eu-west-2b",
+		"CreateSubnet 10.0.2.0/24 eu-west-2b",
+		"CreateSubnet 10.0.3.0/24 eu-west-2b",
+	}, client.calls)
+
+	client.calls = nil
+	client.taken = []string{"10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24", "10.0.4.0/24", "10.0.5.0/24"}
+	_, err = driver.createSubnet(driver.getRegionZone())
+	assert.Error(t, err)
+	assert.Len(t, client.calls, subnetCIDRAttempts)
+}
+
+func TestRemoveSubnet(t *testing.T) {
+	client := &fakeEC2CreateNet{instances: []*ec2.Instance{
+		{InstanceId: aws.String("i-12345")},
+		{InstanceId: aws.String("i-other")},
+	}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.SubnetId = "subnet-new"
+	driver.SubnetCreated = true
+
+	report := &removeReport{}
+	driver.removeSubnet(report, false)
+	assert.Equal(t, cleanupResult{Resource: "subnet", Id: "subnet-new", Status: cleanupKept, Reason: "used by the kept instance"}, report.Results[0])
+
+	driver.removeSubnet(report, true)
+	assert.Equal(t, cleanupResult{Resource: "subnet", Id: "subnet-new", Status: cleanupKept, Reason: "used by i-other"}, report.Results[1])
+	assert.Empty(t, client.calls)
+
+	client.instances = client.instances[:1]
+	driver.removeSubnet(report, true)
+	assert.Equal(t, cleanupResult{Resource: "subnet", Id: "subnet-new", Status: cleanupDeleted}, report.Results[2])
+	assert.Equal(t, []string{"DeleteSubnet subnet-new"}, client.calls)
+}
+
+func TestCheckNatRoute(t *testing.T) {
+	defaultRoute := func(route *ec2.Route) []*ec2.RouteTable {
+		route.DestinationCidrBlock = aws.String(ipRange)
+		return []*ec2.RouteTable{{
+			RouteTableId: aws.String("rtb-1"),
+			Routes: []*ec2.Route{
+				{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local")},
+				route,
+			},
+		}}
+	}
+	client := &fakeEC2WithRouteTables{mainTables: defaultRoute(&ec2.Route{NatGatewayId: aws.String("nat-1")})}
+	driver := NewCustomTestDriver(client)
+	driver.VpcId = "vpc-1"
+	driver.SubnetId = "subnet-1"
+	driver.PrivateIPOnly = true
+	driver.CheckNatRoute = true
+
+	assert.NoError(t, driver.checkNatRoute())
+
+	client.subnetTables = defaultRoute(&ec2.Route{GatewayId: aws.String("igw-1")})
+	err := driver.checkNatRoute()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "igw-1")
+
+	driver.CheckNatRoute = false
+	assert.NoError(t, driver.checkNatRoute())
+	driver.CheckNatRoute = true
+
+	client.subnetTables = defaultRoute(&ec2.Route{NatGatewayId: aws.String("nat-1"), State: aws.String(ec2.RouteStateBlackhole)})
+	assert.Error(t, driver.checkNatRoute())
+
+	client.subnetTables = defaultRoute(&ec2.Route{InstanceId: aws.String("i-nat")})
+	assert.NoError(t, driver.checkNatRoute())
+
+	driver.PrivateIPOnly = false
+	client.subnetTables = nil
+	client.mainTables = nil
+	assert.NoError(t, driver.checkNatRoute())
+}
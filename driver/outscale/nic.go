@@ -0,0 +1,181 @@
+package outscale
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// extraNIC is an --outscale-extra-nic network interface
+type extraNIC struct {
+	SubnetId         string
+	SecurityGroupIds []string
+	PrivateIP        string
+}
+
+// parseExtraNIC parses an --outscale-extra-nic value of the form
+// subnet=SUBNET-ID[,security-group=SG-ID...][,private-ip=IP]
+func parseExtraNIC(value string) (*extraNIC, error) {
+	nic := &extraNIC{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --outscale-extra-nic %q, expected subnet=SUBNET-ID[,security-group=SG-ID...][,private-ip=IP]", value)
+		}
+		switch key, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]); key {
+		case "subnet":
+			nic.SubnetId = val
+		case "security-group":
+			nic.SecurityGroupIds = append(nic.SecurityGroupIds, val)
+		case "private-ip":
+			if net.ParseIP(val) == nil {
+				return nil, fmt.Errorf("invalid --outscale-extra-nic %q, %s is not an IP address", value, val)
+			}
+			nic.PrivateIP = val
+		default:
+			return nil, fmt.Errorf("invalid --outscale-extra-nic %q, unknown key %s", value, key)
+		}
+	}
+	if nic.SubnetId == "" {
+		return nil, fmt.Errorf("invalid --outscale-extra-nic %q, missing subnet", value)
+	}
+	return nic, nil
+}
+
+// networkInterfaceSpecs describes the network interfaces the instance is
+// launched with, the extra ones being attached in order after the primary
+// one and deleted with the instance
+func (d *Driver) networkInterfaceSpecs() []*ec2.InstanceNetworkInterfaceSpecification {
+	primary := &ec2.InstanceNetworkInterfaceSpecification{
+		DeviceIndex: aws.Int64(0), // eth0
+	}
+	if d.NicId != "" {
+		// the interface keeps its own subnet, security groups and IPs
+		primary.NetworkInterfaceId = aws.String(d.NicId)
+	} else {
+		primary.Groups = makePointerSlice(d.securityGroupIds())
+		primary.SubnetId = &d.SubnetId
+	}
+	// the API refuses a public IP at launch with several or existing network
+	// interfaces, the external IP is associated afterwards anyway
+	if len(d.ExtraNICs) == 0 && d.NicId == "" {
+		primary.AssociatePublicIpAddress = aws.Bool(!d.PrivateIPOnly)
+	}
+	if d.IPv6 {
+		primary.Ipv6AddressCount = aws.Int64(1)
+	}
+	if d.SecondaryPrivateIPCount > 0 {
+		primary.SecondaryPrivateIpAddressCount = aws.Int64(int64(d.SecondaryPrivateIPCount))
+	}
+	for _, ip := range d.SecondaryPrivateIPs {
+		primary.PrivateIpAddresses = append(primary.PrivateIpAddresses, &ec2.PrivateIpAddressSpecification{
+			PrivateIpAddress: aws.String(ip),
+			Primary:          aws.Bool(false),
+		})
+	}
+
+	specs := []*ec2.InstanceNetworkInterfaceSpecification{primary}
+	for i, value := range d.ExtraNICs {
+		nic, _ := parseExtraNIC(value) // validated by SetConfigFromFlags
+		groups := nic.SecurityGroupIds
+		if len(groups) == 0 {
+			groups = d.securityGroupIds()
+		}
+		spec := &ec2.InstanceNetworkInterfaceSpecification{
+			DeviceIndex:         aws.Int64(int64(i + 1)),
+			Groups:              makePointerSlice(groups),
+			SubnetId:            aws.String(nic.SubnetId),
+			DeleteOnTermination: aws.Bool(true),
+		}
+		if nic.PrivateIP != "" {
+			spec.PrivateIpAddress = aws.String(nic.PrivateIP)
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// checkNetworkInterface checks the --outscale-nic-id interface is free and
+// in the zone and Net of the machine, which then uses its subnet
+func (d *Driver) checkNetworkInterface() error {
+	if d.NicId == "" {
+		return nil
+	}
+
+	out, err := d.getClient().DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: []*string{aws.String(d.NicId)},
+	})
+	if err != nil && !strings.HasPrefix(err.Error(), "InvalidNetworkInterfaceID.NotFound") {
+		return fmt.Errorf("Error looking up network interface %s: %s", d.NicId, err)
+	}
+	if err != nil || len(out.NetworkInterfaces) == 0 {
+		return fmt.Errorf("network interface %s does not exist", d.NicId)
+	}
+
+	nic := out.NetworkInterfaces[0]
+	if status := aws.StringValue(nic.Status); status != ec2.NetworkInterfaceStatusAvailable {
+		return fmt.Errorf("network interface %s is %s, expected %s", d.NicId, status, ec2.NetworkInterfaceStatusAvailable)
+	}
+	if zone := aws.StringValue(nic.AvailabilityZone); zone != d.getRegionZone() {
+		return fmt.Errorf("network interface %s is in %s, not in %s", d.NicId, zone, d.getRegionZone())
+	}
+	if d.VpcId != "" && aws.StringValue(nic.VpcId) != d.VpcId {
+		return fmt.Errorf("network interface %s does not belong to VpcId: %s", d.NicId, d.VpcId)
+	}
+	if d.SubnetId != "" && aws.StringValue(nic.SubnetId) != d.SubnetId {
+		return fmt.Errorf("network interface %s does not belong to SubnetId: %s", d.NicId, d.SubnetId)
+	}
+
+	d.SubnetId = aws.StringValue(nic.SubnetId)
+	return nil
+}
+
+// associateAddressInput targets the instance with the external IP, or its
+// primary network interface when it has several as the API then requires
+func (d *Driver) associateAddressInput(allocationId string) (*ec2.AssociateAddressInput, error) {
+	input := &ec2.AssociateAddressInput{AllocationId: aws.String(allocationId)}
+	if d.NicId != "" {
+		input.NetworkInterfaceId = aws.String(d.NicId)
+		return input, nil
+	}
+	if len(d.ExtraNICs) == 0 {
+		input.InstanceId = aws.String(d.InstanceId)
+		return input, nil
+	}
+
+	inst, err := d.getInstance()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range inst.NetworkInterfaces {
+		if iface.Attachment != nil && aws.Int64Value(iface.Attachment.DeviceIndex) == 0 {
+			input.NetworkInterfaceId = iface.NetworkInterfaceId
+			return input, nil
+		}
+	}
+	return nil, fmt.Errorf("no primary network interface found on %s", d.InstanceId)
+}
+
+// validateSecondaryPrivateIPs checks --outscale-secondary-private-ip-count
+// and --outscale-secondary-private-ip, which are mutually exclusive
+func validateSecondaryPrivateIPs(count int, ips []string) error {
+	if count < 0 {
+		return fmt.Errorf("invalid --outscale-secondary-private-ip-count %d, expected a positive number", count)
+	}
+	if count > 0 && len(ips) > 0 {
+		return errorSecondaryPrivateIPCountWithList
+	}
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+			return fmt.Errorf("invalid --outscale-secondary-private-ip %q, expected an IPv4 address", ip)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package outscale
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkInterfaceSpecsSecondaryPrivateIPs(t *testing.T) {
+	driver := NewTestDriver()
+	driver.SubnetId = "subnet-12345"
+
+	specs := driver.networkInterfaceSpecs()
+	assert.Len(t, specs, 1)
+	assert.Nil(t, specs[0].SecondaryPrivateIpAddressCount)
+	assert.Empty(t, specs[0].PrivateIpAddresses)
+
+	driver.SecondaryPrivateIPCount = 2
+	specs = driver.networkInterfaceSpecs()
+	assert.Equal(t, int64(2), *specs[0].SecondaryPrivateIpAddressCount)
+
+	driver.SecondaryPrivateIPCount = 0
+	driver.SecondaryPrivateIPs = []string{"10.0.0.10", "10.0.0.11"}
+	specs = driver.networkInterfaceSpecs()
+	assert.Equal(t, []*ec2.PrivateIpAddressSpecification{
+		{PrivateIpAddress: aws.String("10.0.0.10"), Primary: aws.Bool(false)},
+		{PrivateIpAddress: aws.String("10.0.0.11"), Primary: aws.Bool(false)},
+	}, specs[0].PrivateIpAddresses)
+}
+
+func TestValidateSecondaryPrivateIPs(t *testing.T) {
+	assert.NoError(t, validateSecondaryPrivateIPs(0, nil))
+	assert.NoError(t, validateSecondaryPrivateIPs(2, nil))
+	assert.NoError(t, validateSecondaryPrivateIPs(0, []string{"10.0.0.10"}))
+	assert.EqualError(t, validateSecondaryPrivateIPs(-1, nil), "invalid --outscale-secondary-private-ip-count -1, expected a positive number")
+	assert.Equal(t, errorSecondaryPrivateIPCountWithList, validateSecondaryPrivateIPs(1, []string{"10.0.0.10"}))
+	assert.EqualError(t, validateSecondaryPrivateIPs(0, []string{"2001:db8::1"}), `invalid --outscale-secondary-private-ip "2001:db8::1", expected an IPv4 address`)
+}
+
+func TestParseExtraNIC(t *testing.T) {
+	nic, err := parseExtraNIC("subnet=subnet-222,security-group=sg-1,security-group=sg-2,private-ip=10.0.1.5")
+	assert.NoError(t, err)
+	assert.Equal(t, &extraNIC{SubnetId: "subnet-222", SecurityGroupIds: []string{"sg-1", "sg-2"}, PrivateIP: "10.0.1.5"}, nic)
+
+	_, err = parseExtraNIC("security-group=sg-1")
+	assert.EqualError(t, err, `invalid --outscale-extra-nic "security-group=sg-1", missing subnet`)
+	_, err = parseExtraNIC("subnet=subnet-222,mtu=9000")
+	assert.EqualError(t, err, `invalid --outscale-extra-nic "subnet=subnet-222,mtu=9000", unknown key mtu`)
+	_, err = parseExtraNIC("subnet=subnet-222,private-ip=10.0.1")
+	assert.EqualError(t, err, `invalid --outscale-extra-nic "subnet=subnet-222,private-ip=10.0.1", 10.0.1 is not an IP address`)
+	_, err = parseExtraNIC("subnet-222")
+	assert.Error(t, err)
+}
+
+func TestNetworkInterfaceSpecsExtraNICs(t *testing.T) {
+	driver := NewTestDriver()
+	driver.SubnetId = "subnet-111"
+	driver.SecurityGroupIds = []string{"sg-machine"}
+	driver.ExtraNICs = []string{"subnet=subnet-222,private-ip=10.0.1.5", "subnet=subnet-333,security-group=sg-data"}
+
+	specs := driver.networkInterfaceSpecs()
+	assert.Len(t, specs, 3)
+	assert.Nil(t, specs[0].AssociatePublicIpAddress)
+	assert.Equal(t, int64(1), *specs[1].DeviceIndex)
+	assert.Equal(t, "subnet-222", *specs[1].SubnetId)
+	assert.Equal(t, "10.0.1.5", *specs[1].PrivateIpAddress)
+	assert.Equal(t, []*string{aws.String("sg-machine")}, specs[1].Groups)
+	assert.True(t, *specs[1].DeleteOnTermination)
+	assert.Equal(t, int64(2), *specs[2].DeviceIndex)
+	assert.Equal(t, []*string{aws.String("sg-data")}, specs[2].Groups)
+	assert.Nil(t, specs[2].PrivateIpAddress)
+}
+
+func TestCheckNetworkInterface(t *testing.T) {
+	nic := &ec2.NetworkInterface{
+		NetworkInterfaceId: aws.String("eni-12345"),
+		AvailabilityZone:   aws.String("us-east-1a"),
+		SubnetId:           aws.String("subnet-nic"),
+		VpcId:              aws.String("vpc-12345"),
+		Status:             aws.String(ec2.NetworkInterfaceStatusAvailable),
+	}
+	driver := NewCustomTestDriver(&fakeEC2WithNetworkInterfaces{nics: []*ec2.NetworkInterface{nic}})
+	driver.Region = "us-east-1"
+	driver.Zone = "a"
+	driver.VpcId = "vpc-12345"
+	driver.NicId = "eni-12345"
+
+	assert.NoError(t, driver.checkNetworkInterface())
+	assert.Equal(t, "subnet-nic", driver.SubnetId)
+
+	specs := driver.networkInterfaceSpecs()
+	assert.Equal(t, "eni-12345", *specs[0].NetworkInterfaceId)
+	assert.Nil(t, specs[0].SubnetId)
+	assert.Empty(t, specs[0].Groups)
+	assert.Nil(t, specs[0].AssociatePublicIpAddress)
+
+	input, err := driver.associateAddressInput("eipalloc-12345")
+	assert.NoError(t, err)
+	assert.Equal(t, "eni-12345", *input.NetworkInterfaceId)
+	assert.Nil(t, input.InstanceId)
+
+	driver.SubnetId = "subnet-other"
+	assert.EqualError(t, driver.checkNetworkInterface(), "network interface eni-12345 does not belong to SubnetId: subnet-other")
+
+	nic.Status = aws.String(ec2.NetworkInterfaceStatusInUse)
+	assert.EqualError(t, driver.checkNetworkInterface(), "network interface eni-12345 is in-use, expected available")
+
+	driver.NicId = ""
+	assert.NoError(t, driver.checkNetworkInterface())
+}
@@ -0,0 +1,263 @@
+package outscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/corehandlers"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The oAPI is the native Outscale API, which exposes objects (Vms, Nets,
+// PublicIps...) and features the FCU EC2 compatible API doesn't. It is a JSON
+// API signed like AWS with the oapi service name, so the client is built on
+// the aws-sdk-go requests rather than on osc-sdk-go, sharing the retries,
+// rate limit, timeout, logging and request IDs of the FCU calls.
+
+const (
+	apiFCU  = "fcu"
+	apiOAPI = "oapi"
+
+	oapiService = "oapi"
+
+	// oapiInvalidResource is the error type of calls on missing resources
+	oapiInvalidResource = "InvalidResource"
+	// oapiResourceConflict is the error type of calls creating a resource
+	// that already exists
+	oapiResourceConflict = "ResourceConflict"
+)
+
+// oapiClient calls the oAPI of a region
+type oapiClient struct {
+	endpoint string
+	region   string
+	config   *aws.Config
+	handlers request.Handlers
+	retryer  request.Retryer
+}
+
+// oapiError is the error returned by the oAPI, with the request ID of the
+// failed call
+type oapiError struct {
+	StatusCode int
+	Code       string
+	Type       string
+	Details    string
+	RequestId  string
+}
+
+func (e *oapiError) Error() string {
+	return fmt.Sprintf("%s: %s (code %s, status %d, request id: %s)", e.Type, e.Details, e.Code, e.StatusCode, e.RequestId)
+}
+
+type oapiResponseContext struct {
+	RequestId string `json:"RequestId"`
+}
+
+type oapiErrorResponse struct {
+	Errors []struct {
+		Code    string `json:"Code"`
+		Type    string `json:"Type"`
+		Details string `json:"Details"`
+	} `json:"Errors"`
+	ResponseContext oapiResponseContext `json:"ResponseContext"`
+}
+
+// oapiEndpoint returns the oAPI endpoint of a region
+func oapiEndpoint(region string) string {
+	return "https://api." + region + ".outscale.com/api/v1"
+}
+
+// buildOAPIClient returns an oAPI client whose requests go through the
+// handlers of the FCU session, with the JSON protocol of the oAPI
+func (d *Driver) buildOAPIClient() *oapiClient {
+	endpoint := d.OAPIEndpoint
+	if endpoint == "" {
+		endpoint = oapiEndpoint(d.Region)
+	}
+
+	sess := d.newSession("")
+	handlers := sess.Handlers.Copy()
+	// the parameters are maps, not the SDK input shapes it validates
+	handlers.Validate.Remove(corehandlers.ValidateParametersHandler)
+	handlers.Build.PushBackNamed(oapiBuildHandler)
+	handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	handlers.Unmarshal.PushBackNamed(oapiUnmarshalHandler)
+	// the oAPI error must be decoded before its request ID is logged
+	handlers.UnmarshalError.Remove(requestIDErrorHandler)
+	handlers.UnmarshalError.PushBackNamed(oapiUnmarshalErrorHandler)
+	handlers.UnmarshalError.PushBackNamed(requestIDErrorHandler)
+
+	return &oapiClient{
+		endpoint: endpoint,
+		region:   d.Region,
+		config:   sess.Config,
+		handlers: handlers,
+		retryer:  d.retryer(),
+	}
+}
+
+// call posts input to the action and decodes the response into output
+func (c *oapiClient) call(action string, input, output interface{}) error {
+	req := request.New(*c.config, metadata.ClientInfo{
+		ServiceName:   oapiService,
+		SigningName:   oapiService,
+		SigningRegion: c.region,
+		Endpoint:      strings.TrimRight(c.endpoint, "/"),
+	}, c.handlers, c.retryer, &request.Operation{
+		Name:       action,
+		HTTPMethod: "POST",
+		HTTPPath:   "/" + action,
+	}, input, output)
+	return req.Send()
+}
+
+// oapiBuildHandler sends the parameters of the call as a JSON body
+var oapiBuildHandler = request.NamedHandler{
+	Name: "outscale.OAPIBuildHandler",
+	Fn: func(r *request.Request) {
+		body, err := json.Marshal(r.Params)
+		if err != nil {
+			r.Error = awserr.New(request.ErrCodeSerialization, "failed to encode oAPI request", err)
+			return
+		}
+		r.SetBufferBody(body)
+		r.HTTPRequest.Header.Set("Content-Type", "application/json")
+	},
+}
+
+// oapiUnmarshalHandler decodes the response of the call, keeping its
+// request ID for the API log
+var oapiUnmarshalHandler = request.NamedHandler{
+	Name: "outscale.OAPIUnmarshalHandler",
+	Fn: func(r *request.Request) {
+		defer r.HTTPResponse.Body.Close()
+		data, err := ioutil.ReadAll(r.HTTPResponse.Body)
+		if err != nil {
+			r.Error = awserr.New(request.ErrCodeSerialization, "failed to read oAPI response", err)
+			return
+		}
+		if id := extractRequestID(data); id != "" {
+			r.RequestID = id
+		}
+		if err := json.Unmarshal(data, r.Data); err != nil {
+			r.Error = awserr.New(request.ErrCodeSerialization, "failed to decode oAPI response", err)
+		}
+	},
+}
+
+// oapiUnmarshalErrorHandler turns an error response into an oapiError
+var oapiUnmarshalErrorHandler = request.NamedHandler{
+	Name: "outscale.OAPIUnmarshalErrorHandler",
+	Fn: func(r *request.Request) {
+		defer r.HTTPResponse.Body.Close()
+		apiErr := &oapiError{StatusCode: r.HTTPResponse.StatusCode, Type: http.StatusText(r.HTTPResponse.StatusCode), RequestId: r.RequestID}
+		data, err := ioutil.ReadAll(r.HTTPResponse.Body)
+		errResp := &oapiErrorResponse{}
+		if err == nil && json.Unmarshal(data, errResp) == nil {
+			if errResp.ResponseContext.RequestId != "" {
+				apiErr.RequestId = errResp.ResponseContext.RequestId
+			}
+			if len(errResp.Errors) > 0 {
+				apiErr.Code = errResp.Errors[0].Code
+				apiErr.Type = errResp.Errors[0].Type
+				apiErr.Details = errResp.Errors[0].Details
+			}
+		}
+		r.Error = apiErr
+	},
+}
+
+type oapiTag struct {
+	Key   string `json:"Key"`
+	Value string `json:"Value"`
+}
+
+type oapiVm struct {
+	VmId                string `json:"VmId"`
+	VmType              string `json:"VmType"`
+	State               string `json:"State"`
+	PrivateIp           string `json:"PrivateIp"`
+	PublicIp            string `json:"PublicIp"`
+	PublicDnsName       string `json:"PublicDnsName"`
+	NetId               string `json:"NetId"`
+	SubnetId            string `json:"SubnetId"`
+	KeypairName         string `json:"KeypairName"`
+	RootDeviceName      string `json:"RootDeviceName"`
+	BlockDeviceMappings []struct {
+		DeviceName string `json:"DeviceName"`
+		Bsu        struct {
+			VolumeId           string `json:"VolumeId"`
+			State              string `json:"State"`
+			DeleteOnVmDeletion bool   `json:"DeleteOnVmDeletion"`
+		} `json:"Bsu"`
+	} `json:"BlockDeviceMappings"`
+	SecurityGroups []struct {
+		SecurityGroupId   string `json:"SecurityGroupId"`
+		SecurityGroupName string `json:"SecurityGroupName"`
+	} `json:"SecurityGroups"`
+	Tags []oapiTag `json:"Tags"`
+}
+
+func (c *oapiClient) readVms(ids []string) ([]*oapiVm, error) {
+	output := &struct {
+		Vms []*oapiVm `json:"Vms"`
+	}{}
+	err := c.call("ReadVms", map[string]interface{}{
+		"Filters": map[string]interface{}{"VmIds": ids},
+	}, output)
+	return output.Vms, err
+}
+
+// instance converts the VM to the EC2 instance the rest of the driver works
+// with, the oAPI states being the FCU ones
+func (vm *oapiVm) instance() *ec2.Instance {
+	inst := &ec2.Instance{
+		InstanceId:   aws.String(vm.VmId),
+		InstanceType: aws.String(vm.VmType),
+		State:        &ec2.InstanceState{Name: aws.String(vm.State)},
+		KeyName:      aws.String(vm.KeypairName),
+		VpcId:        aws.String(vm.NetId),
+		SubnetId:     aws.String(vm.SubnetId),
+	}
+	if vm.PrivateIp != "" {
+		inst.PrivateIpAddress = aws.String(vm.PrivateIp)
+	}
+	if vm.PublicIp != "" {
+		inst.PublicIpAddress = aws.String(vm.PublicIp)
+	}
+	if vm.PublicDnsName != "" {
+		inst.PublicDnsName = aws.String(vm.PublicDnsName)
+	}
+	if vm.RootDeviceName != "" {
+		inst.RootDeviceName = aws.String(vm.RootDeviceName)
+	}
+	for _, bdm := range vm.BlockDeviceMappings {
+		inst.BlockDeviceMappings = append(inst.BlockDeviceMappings, &ec2.InstanceBlockDeviceMapping{
+			DeviceName: aws.String(bdm.DeviceName),
+			Ebs: &ec2.EbsInstanceBlockDevice{
+				VolumeId:            aws.String(bdm.Bsu.VolumeId),
+				Status:              aws.String(bdm.Bsu.State),
+				DeleteOnTermination: aws.Bool(bdm.Bsu.DeleteOnVmDeletion),
+			},
+		})
+	}
+	for _, group := range vm.SecurityGroups {
+		inst.SecurityGroups = append(inst.SecurityGroups, &ec2.GroupIdentifier{
+			GroupId:   aws.String(group.SecurityGroupId),
+			GroupName: aws.String(group.SecurityGroupName),
+		})
+	}
+	for _, tag := range vm.Tags {
+		inst.Tags = append(inst.Tags, &ec2.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+	}
+	return inst
+}
@@ -0,0 +1,234 @@
+package outscale
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeOAPI serves canned oAPI responses by action
+func newFakeOAPI(t *testing.T, responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-2/oapi/aws4_request")
+
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.True(t, json.Valid(body))
+
+		action := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+		response, ok := responses[action]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"Errors":[{"Code":"4118","Type":"InvalidAction","Details":"unknown"}],"ResponseContext":{"RequestId":"req-1"}}`))
+			return
+		}
+		w.Write([]byte(response))
+	}))
+}
+
+func newTestOAPIDriver(server *httptest.Server) (*Driver, *oapiClient) {
+	driver := NewTestDriver()
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	client := driver.buildOAPIClient()
+	client.endpoint = server.URL + "/api/v1"
+	return driver, client
+}
+
+func TestOAPIEndpoint(t *testing.T) {
+	assert.Equal(t, "https://api.eu-west-2.outscale.com/api/v1", oapiEndpoint("eu-west-2"))
+}
+
+func TestOAPIReadVms(t *testing.T) {
+	server := newFakeOAPI(t, map[string]string{
+		"ReadVms": `{"Vms":[{"VmId":"i-12345","State":"running","PublicIp":"1.2.3.4","PrivateIp":"10.0.0.4",
+			"RootDeviceName":"/dev/sda1","BlockDeviceMappings":[{"DeviceName":"/dev/sda1","Bsu":{"VolumeId":"vol-1","State":"attached"}}],
+			"SecurityGroups":[{"SecurityGroupId":"sg-1","SecurityGroupName":"rancher-nodes"}]}],
+			"ResponseContext":{"RequestId":"req-0"}}`,
+	})
+	defer server.Close()
+	_, client := newTestOAPIDriver(server)
+
+	vms, err := client.readVms([]string{"i-12345"})
+	assert.NoError(t, err)
+	assert.Len(t, vms, 1)
+
+	inst := vms[0].instance()
+	assert.Equal(t, state.Running, instanceState(inst))
+	assert.Equal(t, "1.2.3.4", *inst.PublicIpAddress)
+	assert.Equal(t, "vol-1", rootVolumeId(inst))
+	assert.Equal(t, "sg-1", *inst.SecurityGroups[0].GroupId)
+}
+
+func TestOAPIError(t *testing.T) {
+	server := newFakeOAPI(t, map[string]string{})
+	defer server.Close()
+	_, client := newTestOAPIDriver(server)
+
+	_, err := client.readVms([]string{"i-12345"})
+
+	apiErr, ok := err.(*oapiError)
+	assert.True(t, ok)
+	assert.Equal(t, "4118", apiErr.Code)
+	assert.Equal(t, "req-1", apiErr.RequestId)
+	assert.EqualError(t, err, "InvalidAction: unknown (code 4118, status 400, request id: req-1)")
+}
+
+func TestOAPILifecycle(t *testing.T) {
+	server := newFakeOAPI(t, map[string]string{
+		"StartVms":  `{"Vms":[{"VmId":"i-12345","CurrentState":"pending","PreviousState":"stopped"}]}`,
+		"StopVms":   `{"Vms":[{"VmId":"i-12345","CurrentState":"stopping","PreviousState":"running"}]}`,
+		"RebootVms": `{}`,
+	})
+	defer server.Close()
+	_, client := newTestOAPIDriver(server)
+	api := &oapiAPI{client: client}
+
+	assert.NoError(t, api.StartInstance("i-12345"))
+	assert.NoError(t, api.StopInstance("i-12345", true))
+	assert.NoError(t, api.RebootInstance("i-12345"))
+
+	err := api.TerminateInstance("i-12345")
+	assert.Error(t, err)
+	assert.False(t, isInstanceNotFound(err))
+	assert.True(t, isInstanceNotFound(&oapiError{Type: oapiInvalidResource}))
+}
+
+func TestOAPICreateRemove(t *testing.T) {
+	server := newFakeOAPI(t, map[string]string{
+		"CreateVms":      `{"Vms":[{"VmId":"i-12345","State":"pending","PrivateIp":"10.0.0.4"}]}`,
+		"CreateTags":     `{}`,
+		"CreatePublicIp": `{"PublicIp":{"PublicIpId":"eipalloc-1","PublicIp":"1.2.3.4"}}`,
+		"LinkPublicIp":   `{"LinkPublicIpId":"eipassoc-1"}`,
+		"DeletePublicIp": `{}`,
+		"CreateKeypair":  `{"Keypair":{"KeypairName":"machineFoo-abcde","KeypairFingerprint":"aa:bb"}}`,
+	})
+	defer server.Close()
+	_, client := newTestOAPIDriver(server)
+	api := &oapiAPI{client: client}
+
+	reservation, err := api.RunInstances(&ec2.RunInstancesInput{
+		ImageId:      aws.String("ami-12345"),
+		InstanceType: aws.String("tinav5.c2r4p2"),
+		MinCount:     aws.Int64(1),
+		MaxCount:     aws.Int64(1),
+		NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{{
+			DeviceIndex: aws.Int64(0),
+			SubnetId:    aws.String("subnet-1"),
+			Groups:      []*string{aws.String("sg-1")},
+		}},
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeInstance),
+			Tags:         []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("machineFoo")}},
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "i-12345", *reservation.Instances[0].InstanceId)
+
+	eip, err := api.AllocateAddress(&ec2.AllocateAddressInput{Domain: aws.String("vpc")})
+	assert.NoError(t, err)
+	assert.Equal(t, "eipalloc-1", *eip.AllocationId)
+	assert.Equal(t, "1.2.3.4", *eip.PublicIp)
+
+	assoc, err := api.AssociateAddress(&ec2.AssociateAddressInput{AllocationId: eip.AllocationId, InstanceId: aws.String("i-12345")})
+	assert.NoError(t, err)
+	assert.Equal(t, "eipassoc-1", *assoc.AssociationId)
+
+	_, err = api.ReleaseAddress(&ec2.ReleaseAddressInput{AllocationId: eip.AllocationId})
+	assert.NoError(t, err)
+
+	keyPair, err := api.ImportKeyPair(&ec2.ImportKeyPairInput{KeyName: aws.String("machineFoo-abcde"), PublicKeyMaterial: []byte("ssh-rsa AAAA")})
+	assert.NoError(t, err)
+	assert.Equal(t, "aa:bb", *keyPair.KeyFingerprint)
+
+	// not faked
+	_, err = api.DeleteVolume(&ec2.DeleteVolumeInput{VolumeId: aws.String("vol-1")})
+	assert.Error(t, err)
+}
+
+func TestOAPIRetries(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"Vms":[{"VmId":"i-12345"}]}`))
+	}))
+	defer server.Close()
+
+	driver := NewTestDriver()
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	driver.RetryCount = 3
+	driver.RetryMaxDelay = "1ms"
+	client := driver.buildOAPIClient()
+	client.endpoint = server.URL + "/api/v1"
+
+	vms, err := client.readVms([]string{"i-12345"})
+	assert.NoError(t, err)
+	assert.Len(t, vms, 1)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestOAPIEndpointOverride(t *testing.T) {
+	driver := NewTestDriver()
+	driver.Region = "eu-west-2"
+	assert.Equal(t, "https://api.eu-west-2.outscale.com/api/v1", driver.buildOAPIClient().endpoint)
+
+	driver.OAPIEndpoint = "https://oapi.example.com/api/v1"
+	assert.Equal(t, "https://oapi.example.com/api/v1", driver.buildOAPIClient().endpoint)
+}
+
+func TestOAPIAuthErrorsAreFatal(t *testing.T) {
+	assert.True(t, isFatal(&oapiError{StatusCode: http.StatusUnauthorized}))
+	assert.False(t, isFatal(&oapiError{StatusCode: http.StatusServiceUnavailable}))
+}
+
+func TestSetConfigFromFlagsOAPIRejectsIamInstanceProfile(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":         []string{ipRange},
+			"name":                          "test",
+			"outscale-region":               "us-east-2",
+			"outscale-zone":                 "us-east-2a",
+			"outscale-api":                  apiOAPI,
+			"outscale-iam-instance-profile": "docker-hosts",
+		},
+	}
+
+	assert.Equal(t, errorIamInstanceProfileWithOAPI, driver.SetConfigFromFlags(options))
+
+	options.Data["outscale-api"] = apiFCU
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+}
+
+func TestSetConfigFromFlagsOAPIRejectsIPv6(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "us-east-2a",
+			"outscale-api":          apiOAPI,
+			"outscale-ipv6":         true,
+		},
+	}
+
+	assert.Equal(t, errorIPv6WithOAPI, driver.SetConfigFromFlags(options))
+
+	options.Data["outscale-api"] = apiFCU
+	assert.NoError(t, driver.SetConfigFromFlags(options))
+}
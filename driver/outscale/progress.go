@@ -0,0 +1,113 @@
+package outscale
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// createProgressFile records, in the machine directory, the resources a
+// Create already created. docker-machine saves the machine config before
+// Create and only saves it again once Create returned, so a Create killed in
+// between leaves them unknown to the saved machine: Remove reads this file to
+// delete them too. The machine config itself is left to docker-machine.
+const createProgressFile = "outscale-create-progress.json"
+
+// createProgress is the part of the driver state an interrupted Create
+// leaves for Remove
+type createProgress struct {
+	KeyPairCreated         bool
+	KeyName                string
+	SecurityGroupIds       []string
+	MachineSecurityGroupId string
+	InstanceId             string
+	AllocationId           string
+	PublicIp               string
+	AssociationId          string
+	SubnetCreated          bool
+	SubnetId               string
+}
+
+func (d *Driver) createProgressPath() string {
+	return d.ResolveStorePath(createProgressFile)
+}
+
+// loadCreateProgress restores the resources recorded by an interrupted
+// Create of the machine, keeping those the saved machine already knows.
+func (d *Driver) loadCreateProgress() {
+	data, err := ioutil.ReadFile(d.createProgressPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Unable to read create progress: %s", err)
+		}
+		return
+	}
+
+	progress := &createProgress{}
+	if err := json.Unmarshal(data, progress); err != nil {
+		log.Warnf("Ignoring invalid create progress: %s", err)
+		return
+	}
+
+	log.Infof("Found the resources of an interrupted create of %s", d.MachineName)
+	if progress.KeyPairCreated && d.KeyName == "" {
+		d.KeyName = progress.KeyName
+	}
+	if len(d.SecurityGroupIds) == 0 {
+		d.SecurityGroupIds = progress.SecurityGroupIds
+	}
+	if d.MachineSecurityGroupId == "" {
+		d.MachineSecurityGroupId = progress.MachineSecurityGroupId
+	}
+	if d.InstanceId == "" {
+		d.InstanceId = progress.InstanceId
+	}
+	if d.AllocationId == "" {
+		d.AllocationId = progress.AllocationId
+		d.PublicIp = progress.PublicIp
+		d.AssociationId = progress.AssociationId
+	}
+	if progress.SubnetCreated && !d.SubnetCreated {
+		d.SubnetId = progress.SubnetId
+		d.SubnetCreated = true
+	}
+}
+
+// saveCreateProgress records the resources created so far after a completed
+// Create step, failing to do so only costs their removal with the machine.
+// Nothing is written before docker-machine created the machine directory.
+func (d *Driver) saveCreateProgress() {
+	data, err := json.MarshalIndent(&createProgress{
+		KeyPairCreated:         d.KeyPairCreated,
+		KeyName:                d.KeyName,
+		SecurityGroupIds:       d.SecurityGroupIds,
+		MachineSecurityGroupId: d.MachineSecurityGroupId,
+		InstanceId:             d.InstanceId,
+		AllocationId:           d.AllocationId,
+		PublicIp:               d.PublicIp,
+		AssociationId:          d.AssociationId,
+		SubnetCreated:          d.SubnetCreated,
+		SubnetId:               d.SubnetId,
+	}, "", "    ")
+	if err != nil {
+		log.Warnf("Unable to encode create progress: %s", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(d.createProgressPath(), data, 0600); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Unable to save create progress: %s", err)
+	}
+}
+
+// clearCreateProgress resets the Create step markers and drops the recorded
+// resources once Create is over, succeeded or rolled back, docker-machine
+// then saving the machine with them.
+func (d *Driver) clearCreateProgress() {
+	d.KeyPairCreated = false
+	d.SecurityGroupsConfigured = false
+	if err := os.Remove(d.createProgressPath()); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Unable to clear create progress: %s", err)
+	}
+}
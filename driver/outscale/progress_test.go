@@ -0,0 +1,103 @@
+package outscale
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateProgressRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscaleprogress")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// nothing is written before docker-machine created the machine directory
+	driver := NewDriver("machineFoo", dir)
+	driver.saveCreateProgress()
+	_, err = os.Stat(driver.createProgressPath())
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "machineFoo"), 0700))
+	driver.KeyPairCreated = true
+	driver.KeyName = "machineFoo-abcde"
+	driver.InstanceId = "i-12345"
+	driver.AllocationId = "eipalloc-12345"
+	driver.saveCreateProgress()
+
+	// the machine config is left to docker-machine
+	_, err = os.Stat(driver.ResolveStorePath("config.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	saved := NewDriver("machineFoo", dir)
+	saved.AllocationId = "eipalloc-67890"
+	saved.loadCreateProgress()
+	assert.Equal(t, "machineFoo-abcde", saved.KeyName)
+	assert.Equal(t, "i-12345", saved.InstanceId)
+	assert.Equal(t, "eipalloc-67890", saved.AllocationId)
+
+	driver.clearCreateProgress()
+	assert.False(t, driver.KeyPairCreated)
+	cleared := NewDriver("machineFoo", dir)
+	cleared.loadCreateProgress()
+	assert.Empty(t, cleared.InstanceId)
+}
+
+func TestRemoveAfterInterruptedCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscaleresume")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "cluster-resume"), 0700))
+
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "cluster-resume",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}
+
+	first := NewDriver("cluster-resume", dir)
+	assert.NoError(t, first.SetConfigFromFlags(options))
+	assert.NoError(t, first.PreCreateCheck())
+	assert.NoError(t, first.Create())
+	_, err = os.Stat(first.createProgressPath())
+	assert.True(t, os.IsNotExist(err))
+
+	// Simulate a create killed right after the external IP was associated,
+	// the machine being saved as it was before Create
+	first.KeyPairCreated = true
+	first.saveCreateProgress()
+
+	saved := NewDriver("cluster-resume", dir)
+	assert.NoError(t, saved.SetConfigFromFlags(options))
+	assert.NoError(t, saved.Remove())
+	assert.Equal(t, first.InstanceId, saved.InstanceId)
+
+	st, err := saved.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Error, st)
+
+	addresses, err := saved.getClient().DescribeAddresses(&ec2.DescribeAddressesInput{})
+	assert.NoError(t, err)
+	for _, address := range addresses.Addresses {
+		assert.NotEqual(t, first.AllocationId, aws.StringValue(address.AllocationId))
+	}
+	keyPairs, err := saved.getClient().DescribeKeyPairs(&ec2.DescribeKeyPairsInput{})
+	assert.NoError(t, err)
+	for _, keyPair := range keyPairs.KeyPairs {
+		assert.NotEqual(t, first.KeyName, aws.StringValue(keyPair.KeyName))
+	}
+}
@@ -0,0 +1,64 @@
+package outscale
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ProviderStateReport is the state of the instance of a machine as the API
+// reports it, next to the coarser libmachine state
+type ProviderStateReport struct {
+	Machine             string `json:"machine"`
+	InstanceId          string `json:"instanceId"`
+	State               string `json:"state"`
+	ProviderState       string `json:"providerState"`
+	ProviderStateReason string `json:"providerStateReason,omitempty"`
+}
+
+// loadMachineConfig reads the config.json of a machine from the
+// docker-machine store into a driver
+func loadMachineConfig(config []byte) (*Driver, error) {
+	host := struct {
+		DriverName string
+		Driver     *Driver
+	}{Driver: NewDriver("", "")}
+	if err := json.Unmarshal(config, &host); err != nil {
+		return nil, fmt.Errorf("invalid machine config: %s", err)
+	}
+	if host.DriverName != driverName {
+		return nil, fmt.Errorf("machine uses the %q driver, not %s", host.DriverName, driverName)
+	}
+	return host.Driver, nil
+}
+
+// NewProviderStateReport reads the config.json of a machine from the
+// docker-machine store and asks the API for the state of its instance with
+// the stored credentials. docker-machine only saves the ProviderState the
+// driver records when it saves the machine after a command, start, stop,
+// restart, kill or provision, while ls and status leave the saved one as is.
+func NewProviderStateReport(config []byte) (*ProviderStateReport, error) {
+	d, err := loadMachineConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if d.InstanceId == "" {
+		return nil, fmt.Errorf("machine %s has no instance", d.MachineName)
+	}
+
+	st, err := d.GetState()
+	if err != nil {
+		return nil, err
+	}
+	return &ProviderStateReport{
+		Machine:             d.MachineName,
+		InstanceId:          d.InstanceId,
+		State:               st.String(),
+		ProviderState:       d.ProviderState,
+		ProviderStateReason: d.ProviderStateReason,
+	}, nil
+}
+
+// JSON renders the report as indented JSON
+func (r *ProviderStateReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
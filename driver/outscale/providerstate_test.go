@@ -0,0 +1,54 @@
+package outscale
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProviderStateReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalestate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	driver := NewDriver("node1", dir)
+	assert.NoError(t, driver.SetConfigFromFlags(&commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr":   []string{ipRange},
+			"name":                    "node1",
+			"outscale-fake-api":       true,
+			"outscale-region":         "us-east-2",
+			"outscale-zone":           "us-east-2a",
+			"outscale-ami":            defaultAmiId,
+			"outscale-instance-type":  defaultInstanceType,
+			"outscale-security-group": []string{defaultSecurityGroup},
+		},
+	}))
+	assert.NoError(t, driver.PreCreateCheck())
+	assert.NoError(t, driver.Create())
+	assert.NoError(t, driver.Stop())
+
+	config, err := json.Marshal(map[string]interface{}{"DriverName": driverName, "Driver": driver})
+	assert.NoError(t, err)
+
+	report, err := NewProviderStateReport(config)
+	assert.NoError(t, err)
+	assert.Equal(t, &ProviderStateReport{
+		Machine:       "node1",
+		InstanceId:    driver.InstanceId,
+		State:         "Stopped",
+		ProviderState: "stopped",
+	}, report)
+}
+
+func TestNewProviderStateReportWithoutInstance(t *testing.T) {
+	_, err := NewProviderStateReport([]byte(`{"DriverName": "outscale", "Driver": {"MachineName": "node1"}}`))
+	assert.EqualError(t, err, "machine node1 has no instance")
+
+	_, err = NewProviderStateReport([]byte(`{"DriverName": "amazonec2", "Driver": {}}`))
+	assert.EqualError(t, err, `machine uses the "amazonec2" driver, not outscale`)
+}
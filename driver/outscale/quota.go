@@ -0,0 +1,101 @@
+package outscale
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// oapiQuota is an account quota, as returned by the oAPI ReadQuotas
+type oapiQuota struct {
+	Name             string `json:"Name"`
+	ShortDescription string `json:"ShortDescription"`
+	MaxValue         int    `json:"MaxValue"`
+	UsedValue        int    `json:"UsedValue"`
+}
+
+func (c *oapiClient) readQuotas() ([]*oapiQuota, error) {
+	output := &struct {
+		QuotaTypes []struct {
+			QuotaType string       `json:"QuotaType"`
+			Quotas    []*oapiQuota `json:"Quotas"`
+		} `json:"QuotaTypes"`
+	}{}
+	if err := c.call("ReadQuotas", map[string]interface{}{}, output); err != nil {
+		return nil, err
+	}
+	quotas := []*oapiQuota{}
+	for _, quotaType := range output.QuotaTypes {
+		quotas = append(quotas, quotaType.Quotas...)
+	}
+	return quotas, nil
+}
+
+// quotaLabels names the quotas checked before creating a machine
+var quotaLabels = map[string]string{
+	"vm_limit":        "VM",
+	"core_limit":      "vCPU",
+	"public_ip_limit": "public IP",
+	"volume_limit":    "volume",
+}
+
+// poolHasFreeAddress tells whether the external IP of the machine can be
+// taken from its pool rather than allocated. It can't when the pool can't be
+// listed, in which case Create reports the error.
+func (d *Driver) poolHasFreeAddress() bool {
+	if d.PublicIpPool == "" {
+		return false
+	}
+	addresses, err := d.freePoolAddresses()
+	return err == nil && len(addresses) > 0
+}
+
+// quotaNeeds returns how much of each quota creating the machine takes
+func (d *Driver) quotaNeeds() map[string]int {
+	needs := map[string]int{"vm_limit": 1, "volume_limit": 1}
+	if info, ok := lookupInstanceType(d.InstanceType); ok {
+		needs["core_limit"] = int(info.VCPUs)
+	}
+	if d.AllocationId == "" && d.ReusePublicIp == "" && !d.PrivateIPOnly && !d.poolHasFreeAddress() {
+		needs["public_ip_limit"] = 1
+	}
+	// validated by SetConfigFromFlags
+	volumes, _ := d.extraVolumes()
+	for _, volume := range volumes {
+		if volume.VirtualName == "" {
+			needs["volume_limit"]++
+		}
+	}
+	return needs
+}
+
+// checkQuotas fails when the account has not enough quota left for the
+// machine, so that Create doesn't stop halfway leaving resources behind.
+// The check is skipped when the quotas can't be read.
+func (d *Driver) checkQuotas() error {
+	if d.FakeAPI {
+		return nil
+	}
+
+	quotas, err := d.buildOAPIClient().readQuotas()
+	if err != nil {
+		log.Warnf("Unable to check the account quotas: %s", err)
+		return nil
+	}
+
+	needs := d.quotaNeeds()
+	exhausted := []string{}
+	for _, quota := range quotas {
+		need, ok := needs[quota.Name]
+		if !ok || quota.UsedValue+need <= quota.MaxValue {
+			continue
+		}
+		exhausted = append(exhausted, fmt.Sprintf("%s quota exhausted (%d/%d)", quotaLabels[quota.Name], quota.UsedValue, quota.MaxValue))
+	}
+	if len(exhausted) > 0 {
+		return errors.New(strings.Join(exhausted, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package outscale
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckQuotas(t *testing.T) {
+	server := newFakeOAPI(t, map[string]string{
+		"ReadQuotas": `{"QuotaTypes":[{"QuotaType":"global","Quotas":[
+			{"Name":"vm_limit","MaxValue":20,"UsedValue":3},
+			{"Name":"public_ip_limit","MaxValue":20,"UsedValue":20},
+			{"Name":"core_limit","MaxValue":40,"UsedValue":39},
+			{"Name":"lbu_limit","MaxValue":5,"UsedValue":5}]}]}`,
+	})
+	defer server.Close()
+	driver, _ := newTestOAPIDriver(server)
+	driver.OAPIEndpoint = server.URL + "/api/v1"
+	driver.InstanceType = "t2.medium"
+
+	assert.EqualError(t, driver.checkQuotas(), "public IP quota exhausted (20/20), vCPU quota exhausted (39/40)")
+
+	driver.AllocationId = "eipalloc-1"
+	driver.InstanceType = "t2.micro"
+	assert.NoError(t, driver.checkQuotas())
+}
+
+func TestCheckQuotasSkippedWhenUnreadable(t *testing.T) {
+	server := newFakeOAPI(t, map[string]string{})
+	defer server.Close()
+	driver, _ := newTestOAPIDriver(server)
+	driver.OAPIEndpoint = server.URL + "/api/v1"
+
+	assert.NoError(t, driver.checkQuotas())
+}
+
+func TestQuotaNeedsPublicIpPool(t *testing.T) {
+	client := &fakeEC2WithInstances{addresses: []*ec2.Address{
+		{AllocationId: aws.String("eipalloc-1"), PublicIp: aws.String("1.2.3.4"), InstanceId: aws.String("i-1")},
+	}}
+	driver := NewCustomTestDriver(client)
+	driver.PublicIpPool = "rancher"
+
+	// every address of the pool is taken, a new one is allocated
+	assert.Equal(t, 1, driver.quotaNeeds()["public_ip_limit"])
+
+	client.addresses = append(client.addresses, &ec2.Address{AllocationId: aws.String("eipalloc-2"), PublicIp: aws.String("5.6.7.8")})
+	_, ok := driver.quotaNeeds()["public_ip_limit"]
+	assert.False(t, ok)
+}
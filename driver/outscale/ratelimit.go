@@ -0,0 +1,91 @@
+package outscale
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// tokenBucket lets rate calls per second through, allowing bursts of up to
+// burst calls. Callers over the limit reserve a token and sleep until it is
+// due, so they are served in order.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) Wait() {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// rateLimiter is the process wide limit applied to every API call, retries
+// included.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int
+	burst  int
+	bucket *tokenBucket
+}
+
+var apiRateLimiter = &rateLimiter{}
+
+// configure sets the limit, a rate of 0 or less disabling it. Configuring the
+// same values again keeps the current bucket state.
+func (l *rateLimiter) configure(rate, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rate == l.rate && burst == l.burst {
+		return
+	}
+	l.rate, l.burst = rate, burst
+	l.bucket = nil
+	if rate > 0 {
+		l.bucket = newTokenBucket(rate, burst)
+	}
+}
+
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	bucket := l.bucket
+	l.mu.Unlock()
+
+	if bucket != nil {
+		bucket.Wait()
+	}
+}
+
+var rateLimitHandler = request.NamedHandler{
+	Name: "outscale.RateLimitHandler",
+	Fn: func(r *request.Request) {
+		apiRateLimiter.wait()
+	},
+}
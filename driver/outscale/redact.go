@@ -0,0 +1,77 @@
+package outscale
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const redactedText = "<REDACTED>"
+
+var (
+	privateKeyPattern = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)
+
+	// sensitiveValuePatterns match the userdata and session token values of
+	// the API requests, in their query, header and JSON forms
+	sensitiveValuePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`((?:UserData|X-Amz-Security-Token)=)[^&\s]+`),
+		regexp.MustCompile(`((?i:X-Amz-Security-Token):\s*)\S+`),
+		regexp.MustCompile(`("UserData"\s*:\s*")[^"]*`),
+	}
+)
+
+// redactor masks the credentials of the driver and the userdata contents in
+// log output
+type redactor struct {
+	secrets []string
+}
+
+func (d *Driver) redactor() *redactor {
+	r := &redactor{}
+	for _, secret := range []string{d.AccessKey, d.SecretKey, d.SessionToken, d.DNSWebhookToken} {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	return r
+}
+
+func (r *redactor) redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, redactedText, -1)
+	}
+	for _, pattern := range sensitiveValuePatterns {
+		s = pattern.ReplaceAllString(s, "${1}"+redactedText)
+	}
+	return privateKeyPattern.ReplaceAllString(s, redactedText)
+}
+
+// redactingWriter writes to w with the secrets masked
+type redactingWriter struct {
+	w io.Writer
+	r *redactor
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactLogs routes the driver log output through the redactor, teeing it
+// into the machine log when enabled. It is called at the start of every
+// operation since the credentials are only known once the flags are read.
+func (d *Driver) redactLogs() {
+	r := d.redactor()
+	var out, errOut io.Writer = os.Stdout, os.Stderr
+	if d.machineLog != nil {
+		out = io.MultiWriter(out, d.machineLog)
+		errOut = io.MultiWriter(errOut, d.machineLog)
+	}
+	log.SetOutWriter(&redactingWriter{w: out, r: r})
+	log.SetErrWriter(&redactingWriter{w: errOut, r: r})
+}
@@ -2,20 +2,39 @@ package outscale
 
 import (
 	"errors"
+	"strings"
 )
 
+const (
+	// regionPlaceholder is replaced by the region in --outscale-endpoint
+	regionPlaceholder = "{region}"
+
+	// defaultEndpoint is the FCU endpoint of a region
+	defaultEndpoint = "https://fcu." + regionPlaceholder + ".outscale.com"
+
+	// customEndpointRegion has no endpoint of its own
+	customEndpointRegion = "custom-endpoint"
+)
+
+// region holds the default image of a region, either an image ID or a name
+// pattern resolving to the newest image published by AMIOwner
 type region struct {
-	AmiId string
+	AmiId    string
+	AMIName  string
+	AMIOwner string
 }
 
 // Ubuntu 16.04 LTS 20180228.1 hvm:ebs-ssd (amd64)
 // See https://cloud-images.ubuntu.com/locator/ec2/
+// cloudgouv-eu-west-1 is the SecNumCloud region, its images IDs differ from
+// the public regions so the Outscale official image is looked up by name.
 var regionDetails map[string]*region = map[string]*region{
-	"eu-west-2":       {"ami-ff46a298"},
-	"us-east-2":       {"ami-b9daeddc"},
-	"us-west-1":       {"ami-264c4646"},
-	"ap-northeast-1":  {"ami-bcb7f6da"},
-	"custom-endpoint": {""},
+	"eu-west-2":           {AmiId: "ami-ff46a298"},
+	"us-east-2":           {AmiId: "ami-b9daeddc"},
+	"us-west-1":           {AmiId: "ami-264c4646"},
+	"ap-northeast-1":      {AmiId: "ami-bcb7f6da"},
+	"cloudgouv-eu-west-1": {AMIName: "Ubuntu-20.04-*", AMIOwner: "Outscale"},
+	customEndpointRegion:  {},
 }
 
 func awsRegionsList() []string {
@@ -37,3 +56,32 @@ func validateAwsRegion(region string) (string, error) {
 
 	return "", errors.New("Invalid region specified")
 }
+
+// expandEndpoint replaces the region placeholder of an endpoint, so that a
+// single endpoint value works for every region
+func expandEndpoint(endpoint, region string) (string, error) {
+	if !strings.Contains(endpoint, regionPlaceholder) {
+		return endpoint, nil
+	}
+	if region == "" {
+		return "", errors.New("--outscale-endpoint contains " + regionPlaceholder + " but no --outscale-region is set")
+	}
+	return strings.Replace(endpoint, regionPlaceholder, region, -1), nil
+}
+
+// regionEndpoint returns the FCU endpoint of an Outscale region
+func regionEndpoint(region string) string {
+	endpoint, _ := expandEndpoint(defaultEndpoint, region)
+	return endpoint
+}
+
+// endpointRegion returns the known region whose endpoint host is the one of
+// endpoint, empty if there is none
+func endpointRegion(endpoint string) string {
+	for region := range regionDetails {
+		if region != customEndpointRegion && strings.Contains(endpoint, "."+region+".outscale.com") {
+			return region
+		}
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package outscale
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+type cleanupStatus string
+
+const (
+	cleanupDeleted cleanupStatus = "deleted"
+	cleanupKept    cleanupStatus = "kept"
+	cleanupFailed  cleanupStatus = "failed"
+)
+
+// cleanupResult is the outcome of the removal of one resource
+type cleanupResult struct {
+	Resource string
+	Id       string
+	Status   cleanupStatus
+	Reason   string
+	Err      error
+}
+
+func (r cleanupResult) String() string {
+	s := fmt.Sprintf("%s %s: %s", r.Resource, r.Id, r.Status)
+	if r.Err != nil {
+		return s + " (" + r.Err.Error() + ")"
+	}
+	if r.Reason != "" {
+		return s + " (" + r.Reason + ")"
+	}
+	return s
+}
+
+// removeReport lists what Remove did with each resource of the machine, so
+// that operators know what is left to clean manually.
+type removeReport struct {
+	Results []cleanupResult
+}
+
+// done records the removal of a resource, failed if err is not nil
+func (r *removeReport) done(resource, id string, err error) {
+	status := cleanupDeleted
+	if err != nil {
+		status = cleanupFailed
+	}
+	r.Results = append(r.Results, cleanupResult{Resource: resource, Id: id, Status: status, Err: err})
+}
+
+// kept records a resource deliberately left in place
+func (r *removeReport) kept(resource, id, reason string) {
+	r.Results = append(r.Results, cleanupResult{Resource: resource, Id: id, Status: cleanupKept, Reason: reason})
+}
+
+func (r *removeReport) failed() []cleanupResult {
+	failed := []cleanupResult{}
+	for _, result := range r.Results {
+		if result.Status == cleanupFailed {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func (r *removeReport) log() {
+	for _, result := range r.Results {
+		switch result.Status {
+		case cleanupFailed:
+			log.Warnf("Remove: %s", result)
+		case cleanupKept:
+			log.Infof("Remove: %s", result)
+		default:
+			log.Debugf("Remove: %s", result)
+		}
+	}
+}
+
+// err returns an error naming every resource that failed to be removed, nil
+// if there is none
+func (r *removeReport) err() error {
+	failed := r.failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(failed))
+	for _, result := range failed {
+		lines = append(lines, result.String())
+	}
+	return fmt.Errorf("unable to remove some resources, clean them manually:\n%s", strings.Join(lines, "\n"))
+}
@@ -0,0 +1,144 @@
+package outscale
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveReport(t *testing.T) {
+	client := &fakeEC2Remove{}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.KeyName = "machineFoo-abcde"
+	driver.AllocationId = "eipalloc-12345"
+	driver.PublicIp = "1.2.3.4"
+	driver.SecurityGroupIds = []string{"sg-12345"}
+
+	report := driver.remove()
+
+	assert.NoError(t, report.err())
+	assert.Equal(t, []string{"machineFoo-abcde"}, client.deletedKeys)
+	assert.Len(t, report.Results, 4)
+	assert.Equal(t, cleanupResult{Resource: "instance", Id: "i-12345", Status: cleanupDeleted}, report.Results[0])
+	assert.Equal(t, cleanupResult{Resource: "external IP", Id: "1.2.3.4", Status: cleanupDeleted}, report.Results[1])
+	assert.Equal(t, []string{"eipalloc-12345"}, client.released)
+	assert.Empty(t, driver.AllocationId)
+	assert.Equal(t, cleanupDeleted, report.Results[2].Status)
+	assert.Equal(t, "security group sg-12345: kept (shared between machines)", report.Results[3].String())
+}
+
+func TestRemoveKeepPublicIp(t *testing.T) {
+	client := &fakeEC2Remove{}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.AllocationId = "eipalloc-12345"
+	driver.PublicIp = "1.2.3.4"
+	driver.KeepPublicIp = true
+
+	report := driver.remove()
+
+	assert.NoError(t, report.err())
+	assert.Empty(t, client.released)
+	assert.Equal(t, "external IP 1.2.3.4: kept (--outscale-keep-public-ip is set)", report.Results[1].String())
+}
+
+func TestRemoveKeepsPublicIpOfKeptInstance(t *testing.T) {
+	client := &fakeEC2Remove{terminateErr: errors.New("RequestLimitExceeded")}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.AllocationId = "eipalloc-12345"
+	driver.PublicIp = "1.2.3.4"
+
+	report := driver.remove()
+
+	assert.Empty(t, client.released)
+	assert.Equal(t, cleanupKept, report.Results[1].Status)
+}
+
+func TestRemoveReportFailure(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2Remove{terminateErr: errors.New("RequestLimitExceeded")})
+	driver.InstanceId = "i-12345"
+	driver.KeyName = "machineFoo-abcde"
+	driver.ExistingKey = true
+
+	err := driver.Remove()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "instance i-12345: failed")
+	assert.NotContains(t, err.Error(), "key pair")
+}
+
+func TestRemoveKeyPairWithoutInstance(t *testing.T) {
+	client := &fakeEC2Remove{}
+	driver := NewCustomTestDriver(client)
+	driver.KeyName = "machineFoo-abcde"
+
+	assert.NoError(t, driver.Remove())
+	assert.Equal(t, []string{"machineFoo-abcde"}, client.deletedKeys)
+}
+
+func TestRemoveSnapshotsRootVolume(t *testing.T) {
+	client := &fakeEC2Remove{}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.SnapshotOnRemove = true
+
+	report := driver.remove()
+
+	assert.NoError(t, report.err())
+	assert.True(t, client.terminated)
+	assert.Equal(t, "snap-12345", driver.RemoveSnapshotId)
+	assert.Equal(t, "snapshot snap-12345: kept (root volume of i-12345)", report.Results[0].String())
+}
+
+func TestRemoveKeepsInstanceWhenSnapshotFails(t *testing.T) {
+	client := &fakeEC2Remove{snapshotErr: errors.New("SnapshotLimitExceeded")}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.SnapshotOnRemove = true
+
+	err := driver.Remove()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "SnapshotLimitExceeded")
+	assert.False(t, client.terminated)
+}
+
+func TestRemoveModeStop(t *testing.T) {
+	client := &fakeEC2Remove{}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.KeyName = "machineFoo-abcde"
+	driver.AllocationId = "eipalloc-12345"
+	driver.RemoveMode = removeModeStop
+
+	report := driver.remove()
+
+	assert.NoError(t, report.err())
+	assert.True(t, client.stopped)
+	assert.False(t, client.terminated)
+	assert.Empty(t, client.deletedKeys)
+	assert.Len(t, report.Results, 3)
+	for _, result := range report.Results {
+		assert.Equal(t, cleanupKept, result.Status)
+	}
+}
+
+func TestRemoveForCleanupIgnoresRemoveModeStop(t *testing.T) {
+	client := &fakeEC2Remove{}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+	driver.KeyName = "machineFoo-abcde"
+	driver.RemoveMode = removeModeStop
+	driver.SnapshotOnRemove = true
+
+	report := driver.removeForCleanup()
+
+	assert.NoError(t, report.err())
+	assert.False(t, client.stopped)
+	assert.True(t, client.terminated)
+	assert.Equal(t, []string{"machineFoo-abcde"}, client.deletedKeys)
+	assert.Empty(t, driver.RemoveSnapshotId)
+}
@@ -0,0 +1,65 @@
+package outscale
+
+import (
+	"bytes"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Outscale returns the request ID in the error body, but not always under the
+// element name the EC2 protocol unmarshaler expects, so it gets lost.
+var requestIDPattern = regexp.MustCompile(`<RequestI[dD]>([^<]+)</RequestI[dD]>|"RequestId"\s*:\s*"([^"]+)"`)
+
+func extractRequestID(body []byte) string {
+	m := requestIDPattern.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	if len(m[1]) > 0 {
+		return string(m[1])
+	}
+	return string(m[2])
+}
+
+// captureRequestIDHandler peeks at the error response body to remember the
+// request ID, leaving the body in place for the protocol unmarshaler.
+var captureRequestIDHandler = request.NamedHandler{
+	Name: "outscale.CaptureRequestIDHandler",
+	Fn: func(r *request.Request) {
+		if r.HTTPResponse == nil || r.HTTPResponse.Body == nil {
+			return
+		}
+		body, err := ioutil.ReadAll(r.HTTPResponse.Body)
+		r.HTTPResponse.Body.Close()
+		r.HTTPResponse.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		if id := extractRequestID(body); id != "" {
+			r.RequestID = id
+		}
+	},
+}
+
+// requestIDErrorHandler makes sure the error returned for a failed call
+// carries the request ID, and logs it.
+var requestIDErrorHandler = request.NamedHandler{
+	Name: "outscale.RequestIDErrorHandler",
+	Fn: func(r *request.Request) {
+		if r.Error == nil || r.RequestID == "" {
+			return
+		}
+		if reqErr, ok := r.Error.(awserr.RequestFailure); ok && reqErr.RequestID() == "" {
+			r.Error = awserr.NewRequestFailure(
+				awserr.New(reqErr.Code(), reqErr.Message(), reqErr.OrigErr()),
+				reqErr.StatusCode(),
+				r.RequestID,
+			)
+		}
+		log.Warnf("Outscale API call %s failed, request id: %s", r.Operation.Name, r.RequestID)
+	},
+}
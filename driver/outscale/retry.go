@@ -0,0 +1,115 @@
+package outscale
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const defaultRetryMaxDelay = "20s"
+
+// Base delays of the retry backoff, doubled at each attempt. Throttled calls
+// start higher since retrying them early only adds to the throttling.
+var (
+	retryBaseDelay    = 200 * time.Millisecond
+	throttleBaseDelay = time.Second
+)
+
+// parseRetryMaxDelay parses --outscale-retry-max-delay, an empty value
+// meaning the default
+func parseRetryMaxDelay(value string) (time.Duration, error) {
+	if value == "" {
+		value = defaultRetryMaxDelay
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay <= 0 {
+		return 0, fmt.Errorf("invalid --outscale-retry-max-delay %q, expected a positive duration like 20s", value)
+	}
+	return delay, nil
+}
+
+// retryer retries failed calls --outscale-retries times with an exponential
+// backoff and full jitter, so that the machines of a cluster hitting the
+// same throttled API spread their retries. Errors that retrying can't fix
+// are not retried, nor any call once maxElapsed has passed since the
+// operation started, so that a degraded region fails the whole operation
+// quickly instead of retrying every call --outscale-retries times.
+type retryer struct {
+	maxRetries int
+	maxDelay   time.Duration
+	maxElapsed time.Duration
+	started    func() time.Time
+}
+
+func (d *Driver) retryer() *retryer {
+	// validated by SetConfigFromFlags
+	maxDelay, _ := parseRetryMaxDelay(d.RetryMaxDelay)
+	maxElapsed, _ := parseRetryMaxElapsed(d.RetryMaxElapsed)
+	return &retryer{
+		maxRetries: d.RetryCount,
+		maxDelay:   maxDelay,
+		maxElapsed: maxElapsed,
+		started: func() time.Time {
+			return d.retryStart
+		},
+	}
+}
+
+func (r *retryer) MaxRetries() int {
+	return r.maxRetries
+}
+
+func (r *retryer) ShouldRetry(req *request.Request) bool {
+	if r.maxRetries <= 0 {
+		return false
+	}
+	if r.maxElapsed > 0 && time.Since(r.started()) >= r.maxElapsed {
+		log.Warnf("Not retrying %s: %s retry time exhausted", req.Operation.Name, r.maxElapsed)
+		return false
+	}
+	if req.Retryable != nil {
+		return *req.Retryable
+	}
+	if isFatal(req.Error) {
+		return false
+	}
+	return req.IsErrorThrottle() || req.IsErrorRetryable()
+}
+
+func (r *retryer) RetryRules(req *request.Request) time.Duration {
+	delay := r.backoff(req.RetryCount, req.IsErrorThrottle())
+	log.Debugf("Retrying %s in %s (attempt %d/%d): %v", req.Operation.Name, delay, req.RetryCount+1, r.maxRetries, req.Error)
+	return delay
+}
+
+// backoff returns a random delay up to the base delay doubled attempt times,
+// capped to maxDelay
+func (r *retryer) backoff(attempt int, throttled bool) time.Duration {
+	ceiling := retryBaseDelay
+	if throttled {
+		ceiling = throttleBaseDelay
+	}
+	for i := 0; i < attempt && ceiling < r.maxDelay; i++ {
+		ceiling *= 2
+	}
+	if ceiling > r.maxDelay {
+		ceiling = r.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// parseRetryMaxElapsed parses --outscale-retry-max-elapsed, an empty value
+// meaning no limit.
+func parseRetryMaxElapsed(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid --outscale-retry-max-elapsed %q, expected a positive duration like 10m", value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,86 @@
+package outscale
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func failedRequest(err error) *request.Request {
+	return &request.Request{Operation: &request.Operation{Name: "DescribeInstances"}, Error: err}
+}
+
+func TestRetryerShouldRetry(t *testing.T) {
+	r := &retryer{maxRetries: 5, maxDelay: 20 * time.Second}
+
+	assert.True(t, r.ShouldRetry(failedRequest(awserr.New("RequestLimitExceeded", "slow down", nil))))
+	assert.True(t, r.ShouldRetry(failedRequest(awserr.New(request.ErrCodeSerialization, "truncated", errors.New("EOF")))))
+	assert.False(t, r.ShouldRetry(failedRequest(awserr.New("AuthFailure", "bad key", nil))))
+	assert.False(t, r.ShouldRetry(failedRequest(awserr.New("InvalidParameterValue", "bad value", nil))))
+
+	disabled := &retryer{maxRetries: -1, maxDelay: 20 * time.Second}
+	assert.False(t, disabled.ShouldRetry(failedRequest(awserr.New("RequestLimitExceeded", "slow down", nil))))
+}
+
+func TestRetryerBackoff(t *testing.T) {
+	r := &retryer{maxRetries: 10, maxDelay: 5 * time.Second}
+
+	for i := 0; i < 20; i++ {
+		assert.True(t, r.backoff(0, false) <= retryBaseDelay)
+		assert.True(t, r.backoff(2, false) <= 4*retryBaseDelay)
+		assert.True(t, r.backoff(0, true) <= throttleBaseDelay)
+		assert.True(t, r.backoff(30, true) <= 5*time.Second)
+	}
+}
+
+func TestParseRetryMaxDelay(t *testing.T) {
+	delay, err := parseRetryMaxDelay("")
+	assert.NoError(t, err)
+	assert.Equal(t, 20*time.Second, delay)
+
+	_, err = parseRetryMaxDelay("0s")
+	assert.EqualError(t, err, `invalid --outscale-retry-max-delay "0s", expected a positive duration like 20s`)
+}
+
+func TestRetryBudget(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	driver := NewTestDriver()
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	driver.RetryCount = 3
+	driver.RetryMaxDelay = "1ms"
+	driver.RetryMaxElapsed = "1m"
+	client := ec2.New(driver.newSession(server.URL))
+
+	_, err := client.DescribeInstances(&ec2.DescribeInstancesInput{})
+	assert.Error(t, err)
+	assert.Equal(t, 4, attempts)
+
+	attempts = 0
+	driver.retryStart = time.Now().Add(-2 * time.Minute)
+	_, err = client.DescribeInstances(&ec2.DescribeInstancesInput{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// the next operation gets a new budget
+	attempts = 0
+	driver.logPhase("remove")
+	_, err = client.DescribeInstances(&ec2.DescribeInstancesInput{})
+	assert.Error(t, err)
+	assert.Equal(t, 4, attempts)
+}
@@ -0,0 +1,93 @@
+package outscale
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+// passwordFlags are rendered as password inputs by the Rancher UI
+var passwordFlags = map[string]bool{
+	"outscale-secret-key":        true,
+	"outscale-session-token":     true,
+	"outscale-dns-webhook-token": true,
+}
+
+// rancherFieldDefault is the default value of a Rancher schema field, only
+// the member matching the field type being set
+type rancherFieldDefault struct {
+	StringValue      string   `json:"stringValue,omitempty"`
+	IntValue         int      `json:"intValue,omitempty"`
+	BoolValue        bool     `json:"boolValue,omitempty"`
+	StringSliceValue []string `json:"stringSliceValue,omitempty"`
+}
+
+// rancherField describes one flag of the machine config in the Rancher node
+// driver schema
+type rancherField struct {
+	Type        string              `json:"type"`
+	Default     rancherFieldDefault `json:"default"`
+	Create      bool                `json:"create"`
+	Update      bool                `json:"update"`
+	Description string              `json:"description"`
+}
+
+// RancherSchema is the machine config schema of the Rancher node driver
+type RancherSchema struct {
+	ResourceFields map[string]rancherField `json:"resourceFields"`
+}
+
+// rancherFieldName returns the name Rancher gives to a flag in the machine
+// config, outscale-access-key becoming accessKey
+func rancherFieldName(flag string) string {
+	parts := strings.Split(strings.TrimPrefix(flag, driverName+"-"), "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// NewRancherSchema derives the Rancher node driver schema from the create
+// flags, so that the Rancher form stays in sync with them. The deprecated
+// amazonec2 aliases are left out.
+func NewRancherSchema(flags []mcnflag.Flag) *RancherSchema {
+	schema := &RancherSchema{ResourceFields: map[string]rancherField{}}
+	for _, flag := range flags {
+		if strings.HasPrefix(flag.String(), amazonec2Prefix) {
+			continue
+		}
+		field := rancherField{Create: true, Update: true}
+		switch f := flag.(type) {
+		case mcnflag.StringFlag:
+			field.Type = "string"
+			if passwordFlags[f.Name] {
+				field.Type = "password"
+			}
+			field.Default.StringValue = f.Value
+			field.Description = f.Usage
+		case mcnflag.IntFlag:
+			field.Type = "int"
+			field.Default.IntValue = f.Value
+			field.Description = f.Usage
+		case mcnflag.BoolFlag:
+			field.Type = "boolean"
+			field.Description = f.Usage
+		case mcnflag.StringSliceFlag:
+			field.Type = "array[string]"
+			field.Default.StringSliceValue = f.Value
+			field.Description = f.Usage
+		default:
+			continue
+		}
+		schema.ResourceFields[rancherFieldName(flag.String())] = field
+	}
+	return schema
+}
+
+// JSON returns the schema indented for review
+func (s *RancherSchema) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
@@ -0,0 +1,42 @@
+package outscale
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRancherFieldName(t *testing.T) {
+	assert.Equal(t, "accessKey", rancherFieldName("outscale-access-key"))
+	assert.Equal(t, "region", rancherFieldName("outscale-region"))
+	assert.Equal(t, "useEbsOptimizedInstance", rancherFieldName("outscale-use-ebs-optimized-instance"))
+}
+
+func TestRancherSchema(t *testing.T) {
+	schema := NewRancherSchema(NewTestDriver().GetCreateFlags())
+
+	assert.Equal(t, "password", schema.ResourceFields["secretKey"].Type)
+	assert.Equal(t, "string", schema.ResourceFields["accessKey"].Type)
+	assert.Equal(t, defaultInstanceType, schema.ResourceFields["instanceType"].Default.StringValue)
+	assert.Equal(t, "int", schema.ResourceFields["rootSize"].Type)
+	assert.Equal(t, defaultRootSize, schema.ResourceFields["rootSize"].Default.IntValue)
+	assert.Equal(t, "array[string]", schema.ResourceFields["openPort"].Type)
+	assert.NotContains(t, schema.ResourceFields, "amazonec2AccessKey")
+
+	data, err := schema.JSON()
+	assert.NoError(t, err)
+	assert.True(t, json.Valid(data))
+}
+
+func TestRancherSchemaSecrets(t *testing.T) {
+	schema := NewRancherSchema(NewTestDriver().GetCreateFlags())
+
+	assert.Equal(t, "password", schema.ResourceFields["dnsWebhookToken"].Type)
+	for name, field := range schema.ResourceFields {
+		if strings.HasSuffix(name, "Token") || strings.HasSuffix(name, "SecretKey") {
+			assert.Equal(t, "password", field.Type, "%s is not a password field", name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package outscale
+
+import "fmt"
+
+// --outscale-security-group-rules presets
+const (
+	securityGroupRulesK8s     = "k8s"
+	securityGroupRulesRKE2    = "rke2"
+	securityGroupRulesK3s     = "k3s"
+	securityGroupRulesMinimal = "minimal"
+	securityGroupRulesNone    = "none"
+)
+
+var (
+	rke2SupervisorPort = 9345
+	rke2EtcdPorts      = []int64{2379, 2381}
+	kubeletPort        = 10250
+	canalHealthPort    = 9099
+	calicoTyphaPort    = 5473
+	wireguardPorts     = []int64{51820, 51821}
+)
+
+// nodeRule is a rule of the managed nodes group, for the nodes having role
+// when set. It is opened to the members of the group for the peers rules,
+// to --outscale-allowed-cidr otherwise.
+type nodeRule struct {
+	protocol string
+	ports    []int64
+	peers    bool
+	role     string
+}
+
+func singlePort(p int) []int64 {
+	return []int64{int64(p), int64(p)}
+}
+
+// nodeRulePresets are the node rules of each preset, in the order they are
+// authorized. minimal and none have none, none also leaving out the SSH and
+// Docker rules.
+var nodeRulePresets = map[string][]nodeRule{
+	securityGroupRulesK8s: {
+		{protocol: "tcp", ports: singlePort(kubeApiPort), role: nodeRoleControlPlane},
+		{protocol: "tcp", ports: singlePort(kubeApiPort), peers: true, role: nodeRoleControlPlane},
+		{protocol: "tcp", ports: etcdPorts, peers: true, role: nodeRoleEtcd},
+		{protocol: "udp", ports: vxlanPorts, peers: true},
+		{protocol: "udp", ports: flannelPorts, peers: true},
+		{protocol: "tcp", ports: otherKubePorts, peers: true},
+		{protocol: "tcp", ports: kubeProxyPorts, peers: true},
+		{protocol: "tcp", ports: singlePort(nodeExporter), peers: true},
+		{protocol: "tcp", ports: nodePorts, role: nodeRoleWorker},
+		{protocol: "udp", ports: nodePorts, role: nodeRoleWorker},
+		{protocol: "tcp", ports: singlePort(httpPort), role: nodeRoleWorker},
+		{protocol: "tcp", ports: singlePort(httpsPort), role: nodeRoleWorker},
+		// calico additional port: https://docs.projectcalico.org/getting-started/openstack/requirements#network-requirements
+		{protocol: "tcp", ports: singlePort(calicoPort), peers: true},
+	},
+	// https://docs.rke2.io/install/requirements#inbound-network-rules
+	securityGroupRulesRKE2: {
+		{protocol: "tcp", ports: singlePort(kubeApiPort), role: nodeRoleControlPlane},
+		{protocol: "tcp", ports: singlePort(kubeApiPort), peers: true, role: nodeRoleControlPlane},
+		{protocol: "tcp", ports: singlePort(rke2SupervisorPort), peers: true},
+		{protocol: "tcp", ports: rke2EtcdPorts, peers: true, role: nodeRoleEtcd},
+		{protocol: "tcp", ports: singlePort(kubeletPort), peers: true},
+		{protocol: "udp", ports: flannelPorts, peers: true},
+		{protocol: "udp", ports: vxlanPorts, peers: true},
+		{protocol: "tcp", ports: singlePort(canalHealthPort), peers: true},
+		{protocol: "tcp", ports: singlePort(calicoPort), peers: true},
+		{protocol: "tcp", ports: singlePort(calicoTyphaPort), peers: true},
+		{protocol: "tcp", ports: nodePorts, role: nodeRoleWorker},
+		{protocol: "udp", ports: nodePorts, role: nodeRoleWorker},
+		{protocol: "tcp", ports: singlePort(httpPort), role: nodeRoleWorker},
+		{protocol: "tcp", ports: singlePort(httpsPort), role: nodeRoleWorker},
+	},
+	// https://docs.k3s.io/installation/requirements#inbound-rules-for-k3s-nodes
+	securityGroupRulesK3s: {
+		{protocol: "tcp", ports: singlePort(kubeApiPort), role: nodeRoleControlPlane},
+		{protocol: "tcp", ports: singlePort(kubeApiPort), peers: true, role: nodeRoleControlPlane},
+		{protocol: "tcp", ports: etcdPorts, peers: true, role: nodeRoleEtcd},
+		{protocol: "tcp", ports: singlePort(kubeletPort), peers: true},
+		{protocol: "udp", ports: flannelPorts, peers: true},
+		{protocol: "udp", ports: wireguardPorts, peers: true},
+		{protocol: "tcp", ports: nodePorts, role: nodeRoleWorker},
+		{protocol: "udp", ports: nodePorts, role: nodeRoleWorker},
+		{protocol: "tcp", ports: singlePort(httpPort), role: nodeRoleWorker},
+		{protocol: "tcp", ports: singlePort(httpsPort), role: nodeRoleWorker},
+	},
+}
+
+// securityGroupRules returns the --outscale-security-group-rules preset,
+// the machines created before it existing getting k8s
+func (d *Driver) securityGroupRules() string {
+	if d.SecurityGroupRules == "" {
+		return securityGroupRulesK8s
+	}
+	return d.SecurityGroupRules
+}
+
+func validateSecurityGroupRules(preset string) error {
+	switch preset {
+	case "", securityGroupRulesK8s, securityGroupRulesRKE2, securityGroupRulesK3s, securityGroupRulesMinimal, securityGroupRulesNone:
+		return nil
+	}
+	return fmt.Errorf("invalid --outscale-security-group-rules %q, expected %s, %s, %s, %s or %s", preset,
+		securityGroupRulesK8s, securityGroupRulesRKE2, securityGroupRulesK3s, securityGroupRulesMinimal, securityGroupRulesNone)
+}
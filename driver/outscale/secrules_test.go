@@ -0,0 +1,48 @@
+package outscale
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureSecurityGroupPermissionsPresets(t *testing.T) {
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-12345"),
+		GroupName: aws.String(defaultSecurityGroup),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}
+	rules := func(preset string) []string {
+		driver := NewTestDriver()
+		driver.SecurityGroupRules = preset
+		perms, err := driver.configureSecurityGroupPermissions(group)
+		assert.NoError(t, err)
+		result := []string{}
+		for _, perm := range perms {
+			result = append(result, describePermission(perm))
+		}
+		return result
+	}
+
+	assert.Len(t, rules(""), 2+len(nodeRulePresets[securityGroupRulesK8s]))
+	assert.Equal(t, []string{"22/tcp from 0.0.0.0/0", "2376/tcp from 0.0.0.0/0"}, rules(securityGroupRulesMinimal))
+	assert.Empty(t, rules(securityGroupRulesNone))
+
+	rke2 := rules(securityGroupRulesRKE2)
+	assert.Contains(t, rke2, "9345/tcp from sg-12345")
+	assert.Contains(t, rke2, "2379-2381/tcp from sg-12345")
+	assert.Contains(t, rke2, fmt.Sprintf("%d/tcp from 0.0.0.0/0", kubeApiPort))
+	assert.Contains(t, rke2, fmt.Sprintf("%d/tcp from sg-12345", kubeApiPort))
+
+	k3s := rules(securityGroupRulesK3s)
+	assert.Contains(t, k3s, "51820-51821/udp from sg-12345")
+	assert.NotContains(t, k3s, "179/tcp from sg-12345")
+}
+
+func TestValidateSecurityGroupRules(t *testing.T) {
+	assert.NoError(t, validateSecurityGroupRules(securityGroupRulesK3s))
+	assert.EqualError(t, validateSecurityGroupRules("rke"), `invalid --outscale-security-group-rules "rke", expected k8s, rke2, k3s, minimal or none`)
+}
@@ -0,0 +1,98 @@
+package outscale
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// concurrentRuleAttempts bounds the retries of a rule change conflicting
+// with the one of another driver
+const concurrentRuleAttempts = 5
+
+// lockSecurityGroups serializes the security group changes of the machines
+// of the store in the same Net, each machine running its own driver process.
+// It returns the function releasing the lock. The lock only spans one store:
+// Rancher gives each node its own store, so its nodes, like the machines of
+// other stores, are handled by tolerating their changes.
+func (d *Driver) lockSecurityGroups() func() {
+	path := filepath.Join(d.StorePath, fmt.Sprintf("outscale-security-groups-%s-%s.lock", d.Region, d.VpcId))
+	unlock, err := lockFile(path)
+	if err != nil {
+		log.Warnf("Unable to lock the security groups of %s: %s", d.VpcId, err)
+		return func() {}
+	}
+	return unlock
+}
+
+// isDuplicateError tells whether a create or authorize call failed because
+// another driver did the same
+func isDuplicateError(err error) bool {
+	if apiErr, ok := err.(*oapiError); ok {
+		return apiErr.StatusCode == http.StatusConflict || apiErr.Type == oapiResourceConflict
+	}
+	return strings.HasPrefix(err.Error(), "InvalidGroup.Duplicate") ||
+		strings.HasPrefix(err.Error(), "InvalidPermission.Duplicate") ||
+		strings.Contains(err.Error(), "already exists")
+}
+
+// isConcurrentRuleError tells whether a rule change failed because another
+// driver changed the rules of the group in the meantime
+func isConcurrentRuleError(err error) bool {
+	return isDuplicateError(err) || strings.HasPrefix(err.Error(), "InvalidPermission.NotFound")
+}
+
+// retryConcurrentRules runs change on group, describing the group again and
+// retrying while another driver changes the same rules. A call rejecting a
+// duplicate rule authorizes none of the others, ignoring the error would
+// leave them missing.
+func (d *Driver) retryConcurrentRules(group *ec2.SecurityGroup, change func(*ec2.SecurityGroup) error) error {
+	for attempt := 1; ; attempt++ {
+		err := change(group)
+		if err == nil || !isConcurrentRuleError(err) || attempt == concurrentRuleAttempts {
+			return err
+		}
+
+		groupId := aws.StringValue(group.GroupId)
+		log.Debugf("rules of security group %s changed concurrently, retrying: %s", groupId, err)
+		groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+			GroupIds: []*string{group.GroupId},
+		})
+		if err != nil {
+			return err
+		}
+		if len(groups) == 0 {
+			return fmt.Errorf("security group %s not found", groupId)
+		}
+		group = groups[0]
+	}
+}
+
+// authorizeMissingRules authorizes the rules group misses and returns them
+func (d *Driver) authorizeMissingRules(group *ec2.SecurityGroup) ([]*ec2.IpPermission, error) {
+	var authorized []*ec2.IpPermission
+	err := d.retryConcurrentRules(group, func(group *ec2.SecurityGroup) error {
+		inboundPerms, err := d.configureSecurityGroupPermissions(group)
+		if err != nil || len(inboundPerms) == 0 {
+			return err
+		}
+
+		log.Debugf("authorizing group %s with inbound permissions: %v", aws.StringValue(group.GroupName), inboundPerms)
+		_, err = d.getClient().AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       group.GroupId,
+			IpPermissions: inboundPerms,
+		})
+		if err != nil {
+			return err
+		}
+		d.recordRequestedRules(group.GroupId, inboundPerms)
+		authorized = inboundPerms
+		return nil
+	})
+	return authorized, err
+}
@@ -0,0 +1,107 @@
+package outscale
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAuthorizeSecurityGroupConcurrentWriter(t *testing.T) {
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-custom"),
+		GroupName: aws.String("custom"),
+	}
+	authorizing := func(ports ...int64) interface{} {
+		return mock.MatchedBy(func(input *ec2.AuthorizeSecurityGroupIngressInput) bool {
+			if len(input.IpPermissions) != len(ports) {
+				return false
+			}
+			for i, perm := range input.IpPermissions {
+				if aws.Int64Value(perm.FromPort) != ports[i] {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	recorder := fakeEC2SecurityGroupTestRecorder{}
+	recorder.On("AuthorizeSecurityGroupIngress", authorizing(testSSHPort, testDockerPort)).Return(
+		nil, errors.New("InvalidPermission.Duplicate: the specified rule already exists")).Once()
+	recorder.On("DescribeSecurityGroups", &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String("sg-custom")},
+	}).Return(&ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []*ec2.SecurityGroup{{
+			GroupId:       aws.String("sg-custom"),
+			GroupName:     aws.String("custom"),
+			IpPermissions: []*ec2.IpPermission{ipPermission(testSSHPort)},
+		}},
+	}, nil)
+	recorder.On("AuthorizeSecurityGroupIngress", authorizing(testDockerPort)).Return(
+		&ec2.AuthorizeSecurityGroupIngressOutput{}, nil).Once()
+
+	driver := NewCustomTestDriver(&recorder)
+	assert.NoError(t, driver.authorizeSecurityGroup(group))
+	recorder.AssertExpectations(t)
+}
+
+func TestAuthorizeSecurityGroupGivesUp(t *testing.T) {
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-custom"),
+		GroupName: aws.String("custom"),
+	}
+
+	recorder := fakeEC2SecurityGroupTestRecorder{}
+	recorder.On("AuthorizeSecurityGroupIngress", mock.Anything).Return(
+		nil, errors.New("InvalidPermission.Duplicate: the specified rule already exists"))
+	recorder.On("DescribeSecurityGroups", mock.Anything).Return(&ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []*ec2.SecurityGroup{group},
+	}, nil)
+
+	driver := NewCustomTestDriver(&recorder)
+	assert.EqualError(t, driver.authorizeSecurityGroup(group), "InvalidPermission.Duplicate: the specified rule already exists")
+	recorder.AssertNumberOfCalls(t, "AuthorizeSecurityGroupIngress", concurrentRuleAttempts)
+}
+
+func TestLockSecurityGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscalelock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	running := int32(0)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			driver := NewDriver("machineFoo", dir)
+			driver.Region = "eu-west-2"
+			driver.VpcId = "vpc-12345"
+			defer driver.lockSecurityGroups()()
+			assert.Equal(t, int32(1), atomic.AddInt32(&running, 1))
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	assert.FileExists(t, filepath.Join(dir, "outscale-security-groups-eu-west-2-vpc-12345.lock"))
+}
+
+func TestIsDuplicateError(t *testing.T) {
+	assert.True(t, isDuplicateError(errors.New("InvalidGroup.Duplicate: The security group 'rancher-nodes' already exists")))
+	assert.True(t, isDuplicateError(errors.New("InvalidPermission.Duplicate: the specified rule already exists")))
+	assert.False(t, isDuplicateError(errors.New("InvalidGroup.NotFound: The security group 'sg-1' does not exist")))
+	assert.True(t, isConcurrentRuleError(errors.New("InvalidPermission.NotFound: the specified rule does not exist")))
+	assert.True(t, isDuplicateError(&oapiError{StatusCode: 409, Code: "9008", Type: "ResourceConflict"}))
+	assert.False(t, isDuplicateError(&oapiError{StatusCode: 400, Code: "5071", Type: oapiInvalidResource, Details: "already exists"}))
+}
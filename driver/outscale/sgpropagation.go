@@ -0,0 +1,94 @@
+package outscale
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const defaultSecurityGroupPropagationTimeout = "60s"
+
+// propagationInterval is the delay between two checks of the rules of a
+// security group
+var propagationInterval = 2 * time.Second
+
+// parsePropagationTimeout parses --outscale-security-group-propagation-timeout,
+// an empty value meaning the default and 0 not to wait
+func parsePropagationTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		value = defaultSecurityGroupPropagationTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid --outscale-security-group-propagation-timeout %q, expected a duration like 60s", value)
+	}
+	return timeout, nil
+}
+
+// recordRequestedRules remembers the rules authorized in groupId, checked by
+// waitForRequestedRules
+func (d *Driver) recordRequestedRules(groupId *string, perms []*ec2.IpPermission) {
+	if d.requestedRules == nil {
+		d.requestedRules = make(map[string]map[string]*ec2.IpPermission)
+	}
+	id := aws.StringValue(groupId)
+	if d.requestedRules[id] == nil {
+		d.requestedRules[id] = make(map[string]*ec2.IpPermission)
+	}
+	for name, rule := range splitPermissions(perms) {
+		d.requestedRules[id][name] = rule
+	}
+}
+
+// waitForRequestedRules waits for the rules authorized by the create to be
+// visible in their groups, the API being eventually consistent, so that the
+// instance doesn't boot before the SSH and kube-api rules exist
+func (d *Driver) waitForRequestedRules() error {
+	timeout, _ := parsePropagationTimeout(d.SecurityGroupPropagationTimeout)
+	if timeout == 0 || len(d.requestedRules) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(d.requestedRules))
+	for id := range d.requestedRules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	log.Debugf("waiting up to %s for the rules of security groups %v", timeout, ids)
+	attempts := int(timeout/propagationInterval) + 1
+	for _, id := range ids {
+		if err := waitForSpecific("rules of security group "+id, d.requestedRulesVisibleFunc(id), attempts, propagationInterval); err != nil {
+			return err
+		}
+	}
+	d.requestedRules = nil
+	return nil
+}
+
+// requestedRulesVisibleFunc reports whether the group describes all the
+// rules requested in it
+func (d *Driver) requestedRulesVisibleFunc(id string) func() (bool, error) {
+	return func() (bool, error) {
+		groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+			GroupIds: []*string{aws.String(id)},
+		})
+		if err != nil {
+			return false, err
+		}
+		if len(groups) == 0 {
+			return false, fmt.Errorf("security group %s not found", id)
+		}
+
+		names, _ := missingPermissions(d.requestedRules[id], splitPermissions(groups[0].IpPermissions))
+		if len(names) > 0 {
+			return false, fmt.Errorf("rules not visible yet: %s", strings.Join(names, ", "))
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,64 @@
+package outscale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForRequestedRules(t *testing.T) {
+	defer func(interval time.Duration) { propagationInterval = interval }(propagationInterval)
+	propagationInterval = time.Millisecond
+
+	client := &fakeEC2EventualRules{
+		group: &ec2.SecurityGroup{
+			GroupId:       aws.String("sg-nodes"),
+			IpPermissions: []*ec2.IpPermission{ipPermission(testSSHPort), ipPermission(testDockerPort)},
+		},
+		visibleAt: 3,
+	}
+	driver := NewCustomTestDriver(client)
+	driver.recordRequestedRules(aws.String("sg-nodes"), []*ec2.IpPermission{ipPermission(testSSHPort)})
+
+	assert.NoError(t, driver.waitForRequestedRules())
+	assert.Equal(t, 3, client.describes)
+	assert.Nil(t, driver.requestedRules)
+}
+
+func TestWaitForRequestedRulesTimeout(t *testing.T) {
+	defer func(interval time.Duration) { propagationInterval = interval }(propagationInterval)
+	propagationInterval = time.Millisecond
+
+	client := &fakeEC2EventualRules{
+		group:     &ec2.SecurityGroup{GroupId: aws.String("sg-nodes")},
+		visibleAt: 100,
+	}
+	driver := NewCustomTestDriver(client)
+	driver.SecurityGroupPropagationTimeout = "5ms"
+	driver.recordRequestedRules(aws.String("sg-nodes"), []*ec2.IpPermission{ipPermission(testSSHPort)})
+
+	assert.EqualError(t, driver.waitForRequestedRules(), "Timed out waiting for rules of security group sg-nodes, last error: rules not visible yet: 22/tcp from 0.0.0.0/0")
+	assert.Equal(t, 6, client.describes)
+}
+
+func TestWaitForRequestedRulesDisabled(t *testing.T) {
+	client := &fakeEC2EventualRules{visibleAt: 100}
+	driver := NewCustomTestDriver(client)
+	driver.SecurityGroupPropagationTimeout = "0"
+	driver.recordRequestedRules(aws.String("sg-nodes"), []*ec2.IpPermission{ipPermission(testSSHPort)})
+
+	assert.NoError(t, driver.waitForRequestedRules())
+	assert.Equal(t, 0, client.describes)
+}
+
+func TestParsePropagationTimeout(t *testing.T) {
+	timeout, err := parsePropagationTimeout("")
+	assert.NoError(t, err)
+	assert.Equal(t, 60*time.Second, timeout)
+
+	_, err = parsePropagationTimeout("-1s")
+	assert.EqualError(t, err, `invalid --outscale-security-group-propagation-timeout "-1s", expected a duration like 60s`)
+}
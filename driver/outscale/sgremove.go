@@ -0,0 +1,95 @@
+package outscale
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// removeSecurityGroup deletes, with --outscale-delete-security-group, a
+// group the driver created once no other instance uses it
+func (d *Driver) removeSecurityGroup(report *removeReport, id string, instanceGone bool) {
+	if !instanceGone {
+		report.kept("security group", id, "used by the kept instance")
+		return
+	}
+
+	groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(id)},
+	})
+	if err != nil {
+		report.done("security group", id, err)
+		return
+	}
+	if len(groups) == 0 {
+		report.kept("security group", id, "does not exist")
+		return
+	}
+	if !d.isManagedSecurityGroup(groups[0]) {
+		report.kept("security group", id, "not created by the driver")
+		return
+	}
+
+	users, err := d.securityGroupInstances(id)
+	if err != nil {
+		report.done("security group", id, err)
+		return
+	}
+	if len(users) > 0 {
+		report.kept("security group", id, "used by "+strings.Join(users, ", "))
+		return
+	}
+	report.done("security group", id, d.deleteSecurityGroup(id))
+}
+
+// securityGroupInstances returns the other instances using the group
+func (d *Driver) securityGroupInstances(id string) ([]string, error) {
+	instances, err := d.describeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance.group-id"),
+				Values: []*string{aws.String(id)},
+			},
+			{
+				Name: aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{
+					ec2.InstanceStateNamePending,
+					ec2.InstanceStateNameRunning,
+					ec2.InstanceStateNameStopping,
+					ec2.InstanceStateNameStopped,
+				}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, instance := range instances {
+		if instanceId := aws.StringValue(instance.InstanceId); instanceId != d.InstanceId {
+			ids = append(ids, instanceId)
+		}
+	}
+	return ids, nil
+}
+
+// deleteSecurityGroup deletes a group, retrying while the network interfaces
+// of the terminated instance are released
+func (d *Driver) deleteSecurityGroup(id string) error {
+	err := waitFor("deletion of security group "+id, func() (bool, error) {
+		_, err := d.getAPI().DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+			GroupId: aws.String(id),
+		})
+		if err != nil && !strings.HasPrefix(err.Error(), "InvalidGroup.NotFound") {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to delete security group: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package outscale
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// generatedRuleDescription prefixes the description marking the sources of
+// the rules the driver generates, the only ones --outscale-sync-security-group
+// revokes from the shared group
+const generatedRuleDescription = "docker-machine-driver-outscale"
+
+// legacyDefaultPorts are the TCP ports the driver versions before
+// --outscale-allowed-cidr opened to the whole Internet, in unmarked rules
+var legacyDefaultPorts = []int64{22, dockerPort, kubeApiPort}
+
+// isSyncedSecurityGroup reports whether --outscale-sync-security-group
+// applies to group, the managed group of the machine being reconciled: its
+// own group with --outscale-security-group-per-machine, the shared nodes
+// group otherwise
+func (d *Driver) isSyncedSecurityGroup(group *ec2.SecurityGroup) bool {
+	if !d.SyncSecurityGroup {
+		return false
+	}
+	name := aws.StringValue(group.GroupName)
+	if d.SecurityGroupPerMachine {
+		if name != d.machineSecurityGroupName() {
+			return false
+		}
+	} else if name != d.nodesSecurityGroupName() {
+		return false
+	}
+	return d.isManagedSecurityGroup(group)
+}
+
+// generatedRuleMarker is the description of the rules the machine
+// generates: generatedRuleDescription and a hash of the options the rules of
+// the shared group derive from. The machines sharing the group with other
+// options don't generate the same rules, the sync of a machine only revokes
+// the stale rules of the machines configured like it.
+func (d *Driver) generatedRuleMarker() string {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s\n%v\n%v\n%v\n%v\n%t", d.securityGroupRules(), d.allowedCidrs(), d.SSHSourceCidrs, d.DockerSourceCidrs, d.OpenPorts, d.IPv6)
+	return generatedRuleDescription + ":" + hex.EncodeToString(sum.Sum(nil))[:nameHashLength]
+}
+
+// markGeneratedPermissions sets marker on the sources of perms
+func markGeneratedPermissions(perms []*ec2.IpPermission, marker string) {
+	for _, perm := range perms {
+		for _, r := range perm.IpRanges {
+			r.Description = aws.String(marker)
+		}
+		for _, r := range perm.Ipv6Ranges {
+			r.Description = aws.String(marker)
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			pair.Description = aws.String(marker)
+		}
+	}
+}
+
+// permissionDescription returns the description of the single source rule
+// of perm, as split by splitPermissions
+func permissionDescription(perm *ec2.IpPermission) string {
+	switch {
+	case len(perm.IpRanges) > 0:
+		return aws.StringValue(perm.IpRanges[0].Description)
+	case len(perm.Ipv6Ranges) > 0:
+		return aws.StringValue(perm.Ipv6Ranges[0].Description)
+	case len(perm.UserIdGroupPairs) > 0:
+		return aws.StringValue(perm.UserIdGroupPairs[0].Description)
+	}
+	return ""
+}
+
+// isLegacyDefaultPermission tells whether the single source rule of perm is
+// one of the unmarked rules opening legacyDefaultPorts to the whole Internet
+func isLegacyDefaultPermission(perm *ec2.IpPermission) bool {
+	if len(perm.IpRanges) == 0 || aws.StringValue(perm.IpRanges[0].CidrIp) != ipRange || permissionDescription(perm) != "" ||
+		aws.StringValue(perm.IpProtocol) != "tcp" || aws.Int64Value(perm.FromPort) != aws.Int64Value(perm.ToPort) {
+		return false
+	}
+	for _, port := range legacyDefaultPorts {
+		if aws.Int64Value(perm.FromPort) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPermissions splits the rules into one rule per source, keyed by their
+// description
+func splitPermissions(perms []*ec2.IpPermission) map[string]*ec2.IpPermission {
+	rules := map[string]*ec2.IpPermission{}
+	for _, perm := range perms {
+		rule := func() *ec2.IpPermission {
+			return &ec2.IpPermission{
+				IpProtocol: perm.IpProtocol,
+				FromPort:   perm.FromPort,
+				ToPort:     perm.ToPort,
+			}
+		}
+		for _, r := range perm.IpRanges {
+			single := rule()
+			single.IpRanges = []*ec2.IpRange{{CidrIp: r.CidrIp, Description: r.Description}}
+			rules[describePermission(single)] = single
+		}
+		for _, r := range perm.Ipv6Ranges {
+			single := rule()
+			single.Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: r.CidrIpv6, Description: r.Description}}
+			rules[describePermission(single)] = single
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			single := rule()
+			single.UserIdGroupPairs = []*ec2.UserIdGroupPair{{GroupId: pair.GroupId, Description: pair.Description}}
+			rules[describePermission(single)] = single
+		}
+	}
+	return rules
+}
+
+// missingSourcePermissions returns perms without the sources group already
+// opens their ports to, the rules being compared per source like
+// splitPermissions does. The wider rules of group the missing ones would not
+// restrict are reported.
+func missingSourcePermissions(group *ec2.SecurityGroup, perms []*ec2.IpPermission) []*ec2.IpPermission {
+	current := splitPermissions(group.IpPermissions)
+	missing := []*ec2.IpPermission{}
+	for _, perm := range perms {
+		rule := func() *ec2.IpPermission {
+			return &ec2.IpPermission{
+				IpProtocol: perm.IpProtocol,
+				FromPort:   perm.FromPort,
+				ToPort:     perm.ToPort,
+			}
+		}
+		isMissing := func(single *ec2.IpPermission) bool {
+			if _, ok := current[describePermission(single)]; ok {
+				return false
+			}
+			warnWiderPermissions(aws.StringValue(group.GroupId), current, single)
+			return true
+		}
+
+		kept := rule()
+		for _, r := range perm.IpRanges {
+			single := rule()
+			single.IpRanges = []*ec2.IpRange{r}
+			if isMissing(single) {
+				kept.IpRanges = append(kept.IpRanges, r)
+			}
+		}
+		for _, r := range perm.Ipv6Ranges {
+			single := rule()
+			single.Ipv6Ranges = []*ec2.Ipv6Range{r}
+			if isMissing(single) {
+				kept.Ipv6Ranges = append(kept.Ipv6Ranges, r)
+			}
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			single := rule()
+			single.UserIdGroupPairs = []*ec2.UserIdGroupPair{pair}
+			if isMissing(single) {
+				kept.UserIdGroupPairs = append(kept.UserIdGroupPairs, pair)
+			}
+		}
+		if len(kept.IpRanges) > 0 || len(kept.Ipv6Ranges) > 0 || len(kept.UserIdGroupPairs) > 0 {
+			missing = append(missing, kept)
+		}
+	}
+	return missing
+}
+
+// permissionCIDR returns the CIDR of a single source rule, nil for a group
+// pair
+func permissionCIDR(perm *ec2.IpPermission) *net.IPNet {
+	var cidr string
+	switch {
+	case len(perm.IpRanges) > 0:
+		cidr = aws.StringValue(perm.IpRanges[0].CidrIp)
+	case len(perm.Ipv6Ranges) > 0:
+		cidr = aws.StringValue(perm.Ipv6Ranges[0].CidrIpv6)
+	default:
+		return nil
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+	return network
+}
+
+// warnWiderPermissions warns about the rules of current opening the ports of
+// the generated single source rule to a CIDR wider than its own, which the
+// generated rule doesn't restrict: they are left to
+// --outscale-sync-security-group or to the user to revoke
+func warnWiderPermissions(groupId string, current map[string]*ec2.IpPermission, generated *ec2.IpPermission) {
+	inner := permissionCIDR(generated)
+	if inner == nil {
+		return
+	}
+	innerOnes, innerBits := inner.Mask.Size()
+
+	names := []string{}
+	for name, perm := range current {
+		if protocol := aws.StringValue(perm.IpProtocol); protocol != "-1" {
+			if protocol != aws.StringValue(generated.IpProtocol) ||
+				aws.Int64Value(perm.FromPort) > aws.Int64Value(generated.FromPort) ||
+				aws.Int64Value(perm.ToPort) < aws.Int64Value(generated.ToPort) {
+				continue
+			}
+		}
+		outer := permissionCIDR(perm)
+		if outer == nil {
+			continue
+		}
+		if outerOnes, outerBits := outer.Mask.Size(); outerBits == innerBits && outerOnes < innerOnes && outer.Contains(inner.IP) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		log.Warnf("Security group %s rule %s is wider than the generated %s, revoke it to restrict the access", groupId, name, describePermission(generated))
+	}
+}
+
+// missingPermissions returns the rules of from which aren't in to, sorted
+// by description
+func missingPermissions(from, to map[string]*ec2.IpPermission) ([]string, []*ec2.IpPermission) {
+	names := []string{}
+	for name := range from {
+		if _, ok := to[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	perms := make([]*ec2.IpPermission, 0, len(names))
+	for _, name := range names {
+		perms = append(perms, from[name])
+	}
+	return names, perms
+}
+
+// syncSecurityGroup reconciles group to exactly the rules the driver
+// generates, revoking the stale ones left by a previous driver version or a
+// manual edit, and returns the revoked and the authorized rules. The shared
+// group also holds the rules of other tools and users, only the stale rules
+// the driver generated are revoked from it
+func (d *Driver) syncSecurityGroup(group *ec2.SecurityGroup) (revoked, authorized []string, err error) {
+	generated, err := d.configureSecurityGroupPermissions(&ec2.SecurityGroup{
+		GroupId:   group.GroupId,
+		GroupName: group.GroupName,
+		VpcId:     group.VpcId,
+		Tags:      group.Tags,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted := splitPermissions(generated)
+	current := splitPermissions(group.IpPermissions)
+	groupId := aws.StringValue(group.GroupId)
+
+	revoked, stale := missingPermissions(current, wanted)
+	if !d.SecurityGroupPerMachine {
+		revoked, stale = d.generatedPermissions(groupId, revoked, stale)
+	}
+	if len(stale) > 0 {
+		_, err := d.getClient().RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       group.GroupId,
+			IpPermissions: stale,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to revoke the stale rules of security group %s: %s", groupId, err)
+		}
+		for _, rule := range revoked {
+			log.Infof("Revoked stale security group rule %s: %s", groupId, rule)
+		}
+	}
+
+	authorized, missing := missingPermissions(wanted, current)
+	if len(missing) > 0 {
+		_, err := d.getClient().AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       group.GroupId,
+			IpPermissions: missing,
+		})
+		if err != nil {
+			return revoked, nil, err
+		}
+		d.recordRequestedRules(group.GroupId, missing)
+		for _, rule := range authorized {
+			log.Infof("Added missing security group rule %s: %s", groupId, rule)
+		}
+	}
+	return revoked, authorized, nil
+}
+
+// generatedPermissions returns the rules of perms, and their names, which the
+// machine generated, along with the legacy defaults with
+// --outscale-prune-default-rules
+func (d *Driver) generatedPermissions(groupId string, names []string, perms []*ec2.IpPermission) ([]string, []*ec2.IpPermission) {
+	marker := d.generatedRuleMarker()
+	keptNames := []string{}
+	kept := []*ec2.IpPermission{}
+	for i, perm := range perms {
+		if permissionDescription(perm) != marker && !(d.PruneDefaultRules && isLegacyDefaultPermission(perm)) {
+			log.Debugf("Keeping security group rule %s: %s, not generated by the machine", groupId, names[i])
+			continue
+		}
+		keptNames = append(keptNames, names[i])
+		kept = append(kept, perm)
+	}
+	return keptNames, kept
+}
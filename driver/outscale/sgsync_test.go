@@ -0,0 +1,178 @@
+package outscale
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSyncSecurityGroup(t *testing.T) {
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-nodes"),
+		GroupName: aws.String("machineFoo"),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+		IpPermissions: []*ec2.IpPermission{
+			ipPermission(testSSHPort),
+			ipPermission(8080),
+			{
+				IpProtocol: aws.String("tcp"),
+				FromPort:   aws.Int64(testDockerPort),
+				ToPort:     aws.Int64(testDockerPort),
+				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("1.2.3.4/32"), Description: aws.String("manual")}},
+			},
+		},
+	}
+
+	recorder := fakeEC2SecurityGroupTestRecorder{}
+	recorder.On("RevokeSecurityGroupIngress", &ec2.RevokeSecurityGroupIngressInput{
+		GroupId: aws.String("sg-nodes"),
+		IpPermissions: []*ec2.IpPermission{
+			{
+				IpProtocol: aws.String("tcp"),
+				FromPort:   aws.Int64(testDockerPort),
+				ToPort:     aws.Int64(testDockerPort),
+				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("1.2.3.4/32"), Description: aws.String("manual")}},
+			},
+			ipPermission(8080),
+		},
+	}).Return(&ec2.RevokeSecurityGroupIngressOutput{}, nil)
+	recorder.On("AuthorizeSecurityGroupIngress", mock.MatchedBy(func(input *ec2.AuthorizeSecurityGroupIngressInput) bool {
+		return *input.GroupId == "sg-nodes"
+	})).Return(&ec2.AuthorizeSecurityGroupIngressOutput{}, nil)
+
+	driver := NewCustomTestDriver(&recorder)
+	driver.SyncSecurityGroup = true
+	driver.SecurityGroupPerMachine = true
+	driver.ClusterSecurityGroupId = "sg-cluster"
+	assert.True(t, driver.isSyncedSecurityGroup(group))
+
+	revoked, authorized, err := driver.syncSecurityGroup(group)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2376/tcp from 1.2.3.4/32", "8080/tcp from 0.0.0.0/0"}, revoked)
+	assert.Contains(t, authorized, "2376/tcp from 0.0.0.0/0")
+	assert.Contains(t, authorized, "8472/udp from sg-cluster")
+	assert.NotContains(t, authorized, "22/tcp from 0.0.0.0/0")
+	recorder.AssertExpectations(t)
+}
+
+func TestSyncSharedSecurityGroup(t *testing.T) {
+	group := &ec2.SecurityGroup{
+		GroupId:   aws.String("sg-nodes"),
+		GroupName: aws.String(defaultSecurityGroup),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+		IpPermissions: []*ec2.IpPermission{
+			ipPermission(testSSHPort),
+			ipPermission(testDockerPort),
+			ipPermission(8080),
+			{
+				IpProtocol: aws.String("tcp"),
+				FromPort:   aws.Int64(9100),
+				ToPort:     aws.Int64(9100),
+				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("1.2.3.4/32"), Description: aws.String("monitoring")}},
+			},
+		},
+	}
+
+	recorder := fakeEC2SecurityGroupTestRecorder{}
+	recorder.On("RevokeSecurityGroupIngress", &ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String("sg-nodes"),
+		IpPermissions: []*ec2.IpPermission{ipPermission(8080)},
+	}).Return(&ec2.RevokeSecurityGroupIngressOutput{}, nil)
+	recorder.On("AuthorizeSecurityGroupIngress", mock.MatchedBy(func(input *ec2.AuthorizeSecurityGroupIngressInput) bool {
+		for _, perm := range input.IpPermissions {
+			if permissionDescription(perm) != NewTestDriver().generatedRuleMarker() {
+				return false
+			}
+		}
+		return *input.GroupId == "sg-nodes"
+	})).Return(&ec2.AuthorizeSecurityGroupIngressOutput{}, nil)
+
+	driver := NewCustomTestDriver(&recorder)
+	driver.SyncSecurityGroup = true
+	assert.True(t, driver.isSyncedSecurityGroup(group))
+
+	revoked, authorized, err := driver.syncSecurityGroup(group)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8080/tcp from 0.0.0.0/0"}, revoked)
+	assert.Contains(t, authorized, fmt.Sprintf("%d/tcp from 0.0.0.0/0", kubeApiPort))
+	recorder.AssertExpectations(t)
+}
+
+func TestSyncSharedSecurityGroupPrunesDefaultRules(t *testing.T) {
+	legacy := &ec2.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int64(testSSHPort),
+		ToPort:     aws.Int64(testSSHPort),
+		IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange)}},
+	}
+	otherMachine := &ec2.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int64(8080),
+		ToPort:     aws.Int64(8080),
+		IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(ipRange), Description: aws.String(generatedRuleDescription + ":0badcafe")}},
+	}
+	group := &ec2.SecurityGroup{
+		GroupId:       aws.String("sg-nodes"),
+		GroupName:     aws.String(defaultSecurityGroup),
+		Tags:          []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+		IpPermissions: []*ec2.IpPermission{legacy, otherMachine},
+	}
+
+	recorder := fakeEC2SecurityGroupTestRecorder{}
+	recorder.On("AuthorizeSecurityGroupIngress", mock.Anything).Return(&ec2.AuthorizeSecurityGroupIngressOutput{}, nil)
+
+	driver := NewCustomTestDriver(&recorder)
+	driver.AllowedCidrs = []string{"10.1.0.0/16"}
+	driver.SyncSecurityGroup = true
+
+	revoked, _, err := driver.syncSecurityGroup(group)
+	assert.NoError(t, err)
+	assert.Empty(t, revoked)
+
+	recorder.On("RevokeSecurityGroupIngress", &ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String("sg-nodes"),
+		IpPermissions: []*ec2.IpPermission{legacy},
+	}).Return(&ec2.RevokeSecurityGroupIngressOutput{}, nil)
+	driver.PruneDefaultRules = true
+
+	revoked, _, err = driver.syncSecurityGroup(group)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"22/tcp from 0.0.0.0/0"}, revoked)
+	recorder.AssertExpectations(t)
+}
+
+func TestGeneratedRuleMarker(t *testing.T) {
+	driver := NewTestDriver()
+	other := NewTestDriver()
+	assert.Equal(t, driver.generatedRuleMarker(), other.generatedRuleMarker())
+
+	other.OpenPorts = []string{"8080"}
+	assert.NotEqual(t, driver.generatedRuleMarker(), other.generatedRuleMarker())
+}
+
+func TestIsSyncedSecurityGroup(t *testing.T) {
+	driver := NewTestDriver()
+	driver.SyncSecurityGroup = true
+
+	assert.True(t, driver.isSyncedSecurityGroup(&ec2.SecurityGroup{
+		GroupName: aws.String(defaultSecurityGroup),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}))
+
+	driver.SecurityGroupPerMachine = true
+	assert.False(t, driver.isSyncedSecurityGroup(&ec2.SecurityGroup{
+		GroupName: aws.String("custom"),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}))
+	assert.False(t, driver.isSyncedSecurityGroup(&ec2.SecurityGroup{
+		GroupName: aws.String(defaultSecurityGroup),
+		Tags:      []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("1.0")}},
+	}))
+	assert.False(t, driver.isSyncedSecurityGroup(&ec2.SecurityGroup{
+		GroupName: aws.String("machineFoo"),
+	}))
+}
@@ -0,0 +1,101 @@
+package outscale
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentPublicKeyFile holds the public key of the ssh-agent identity. It is
+// returned by GetSSHKeyPath so that the external ssh client offers that agent
+// identity, no private key being stored on disk. The native client of
+// docker-machine --native-ssh only loads private key files and can't use it.
+const agentPublicKeyFile = "id_agent.pub"
+
+var (
+	errorNoSSHAgent               = errors.New("--outscale-ssh-agent requires a running ssh-agent, SSH_AUTH_SOCK is not set")
+	errorSSHAgentWithoutSSHClient = errors.New("--outscale-ssh-agent requires the external ssh client, no ssh binary found in PATH, docker-machine falling back to its native client which can't use ssh-agent")
+)
+
+// sshClientPath finds the ssh binary docker-machine runs for the external
+// client
+var sshClientPath = func() (string, error) {
+	return exec.LookPath("ssh")
+}
+
+// checkSSHAgentClient makes sure docker-machine will use the external ssh
+// client, the only one offering the ssh-agent identities
+func (d *Driver) checkSSHAgentClient() error {
+	if !d.SSHAgent {
+		return nil
+	}
+	if _, err := sshClientPath(); err != nil {
+		return errorSSHAgentWithoutSSHClient
+	}
+	return nil
+}
+
+func (d *Driver) GetSSHKeyPath() string {
+	if d.SSHAgent {
+		return d.ResolveStorePath(agentPublicKeyFile)
+	}
+	return d.BaseDriver.GetSSHKeyPath()
+}
+
+// writeAgentPublicKey stores the public key of the selected ssh-agent
+// identity in the machine directory and returns it in authorized_keys format.
+func (d *Driver) writeAgentPublicKey() ([]byte, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errorNoSSHAgent
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to ssh-agent: %s", err)
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list ssh-agent identities: %s", err)
+	}
+
+	key, err := selectAgentKey(keys, d.SSHAgentIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := ssh.MarshalAuthorizedKey(key)
+	if err := ioutil.WriteFile(d.GetSSHKeyPath(), publicKey, 0600); err != nil {
+		return nil, err
+	}
+	return publicKey, nil
+}
+
+// selectAgentKey picks the agent identity whose comment or SHA256
+// fingerprint is identity, or the only identity when none is given.
+func selectAgentKey(keys []*agent.Key, identity string) (*agent.Key, error) {
+	if identity == "" {
+		switch len(keys) {
+		case 0:
+			return nil, errors.New("ssh-agent has no identity, add one with ssh-add")
+		case 1:
+			return keys[0], nil
+		}
+		return nil, errors.New("ssh-agent has several identities, pick one with --outscale-ssh-agent-identity")
+	}
+
+	for _, key := range keys {
+		if key.Comment == identity || ssh.FingerprintSHA256(key) == identity {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no ssh-agent identity matches %s", identity)
+}
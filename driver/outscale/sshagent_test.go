@@ -0,0 +1,117 @@
+package outscale
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newAgentKey(t *testing.T, comment string) *agent.Key {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	key, err := ssh.NewPublicKey(public)
+	assert.NoError(t, err)
+	return &agent.Key{Format: key.Type(), Blob: key.Marshal(), Comment: comment}
+}
+
+func TestSelectAgentKey(t *testing.T) {
+	work := newAgentKey(t, "work")
+	personal := newAgentKey(t, "personal")
+
+	key, err := selectAgentKey([]*agent.Key{work}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, work, key)
+
+	_, err = selectAgentKey([]*agent.Key{work, personal}, "")
+	assert.Error(t, err)
+
+	key, err = selectAgentKey([]*agent.Key{work, personal}, "personal")
+	assert.NoError(t, err)
+	assert.Equal(t, personal, key)
+
+	key, err = selectAgentKey([]*agent.Key{work, personal}, ssh.FingerprintSHA256(work))
+	assert.NoError(t, err)
+	assert.Equal(t, work, key)
+
+	_, err = selectAgentKey([]*agent.Key{work, personal}, "other")
+	assert.Error(t, err)
+}
+
+func TestWriteAgentPublicKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscaleagent")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "machineFoo"), 0700))
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	keyring := agent.NewKeyring()
+	assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: private, Comment: "work"}))
+
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", socket)
+
+	driver := NewTestDriver()
+	driver.StorePath = dir
+	driver.SSHAgent = true
+	driver.SSHAgentIdentity = "work"
+
+	publicKey, err := driver.writeAgentPublicKey()
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "machines", "machineFoo", agentPublicKeyFile), driver.GetSSHKeyPath())
+
+	written, err := ioutil.ReadFile(driver.GetSSHKeyPath())
+	assert.NoError(t, err)
+	assert.Equal(t, publicKey, written)
+}
+
+func TestSSHAgentExcludesKeyPath(t *testing.T) {
+	driver := NewTestDriver()
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-ssh-agent":    true,
+			"outscale-ssh-keypath":  "/home/user/.ssh/id_rsa",
+		},
+	}
+
+	assert.Equal(t, errorSSHAgentWithKeyPath, driver.SetConfigFromFlags(options))
+}
+
+func TestSSHAgentRequiresSSHClient(t *testing.T) {
+	defer func(lookPath func() (string, error)) { sshClientPath = lookPath }(sshClientPath)
+	driver := NewTestDriver()
+	driver.SSHAgent = true
+
+	sshClientPath = func() (string, error) { return "/usr/bin/ssh", nil }
+	assert.NoError(t, driver.checkSSHAgentClient())
+
+	sshClientPath = func() (string, error) { return "", os.ErrNotExist }
+	assert.Equal(t, errorSSHAgentWithoutSSHClient, driver.checkSSHAgentClient())
+
+	driver.SSHAgent = false
+	assert.NoError(t, driver.checkSSHAgentClient())
+}
@@ -2,10 +2,13 @@ package outscale
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -117,6 +120,32 @@ func (f *fakeEC2WithLogin) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.
 	}
 }
 
+type fakeEC2WithInstances struct {
+	*fakeEC2
+	reservations []*ec2.Reservation
+	addresses    []*ec2.Address
+	input        *ec2.DescribeInstancesInput
+}
+
+func (f *fakeEC2WithInstances) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	f.input = input
+	return &ec2.DescribeInstancesOutput{Reservations: f.reservations}, nil
+}
+
+func (f *fakeEC2WithInstances) DescribeAddresses(input *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	return &ec2.DescribeAddressesOutput{Addresses: f.addresses}, nil
+}
+
+type fakeEC2TagRecorder struct {
+	*fakeEC2
+	inputs []*ec2.CreateTagsInput
+}
+
+func (f *fakeEC2TagRecorder) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.inputs = append(f.inputs, input)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
 type fakeEC2SecurityGroupTestRecorder struct {
 	*fakeEC2
 	mock.Mock
@@ -152,6 +181,16 @@ func (f *fakeEC2SecurityGroupTestRecorder) AuthorizeSecurityGroupIngress(input *
 	return value, err
 }
 
+func (f *fakeEC2SecurityGroupTestRecorder) RevokeSecurityGroupIngress(input *ec2.RevokeSecurityGroupIngressInput) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	result := f.Called(input)
+	err := result.Error(1)
+	value, ok := result.Get(0).(*ec2.RevokeSecurityGroupIngressOutput)
+	if !ok && err == nil {
+		return nil, errors.New("Type assertion to RevokeSecurityGroupIngressOutput failed")
+	}
+	return value, err
+}
+
 func (f *fakeEC2SecurityGroupTestRecorder) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
 	result := f.Called(input)
 	err := result.Error(1)
@@ -167,6 +206,7 @@ func NewTestDriver() *Driver {
 	driver.clientFactory = func() Ec2Client {
 		return &fakeEC2{}
 	}
+	driver.AllowedCidrs = []string{ipRange}
 	return driver
 }
 
@@ -175,5 +215,401 @@ func NewCustomTestDriver(ec2Client Ec2Client) *Driver {
 	driver.clientFactory = func() Ec2Client {
 		return ec2Client
 	}
+	driver.AllowedCidrs = []string{ipRange}
 	return driver
 }
+
+type fakeEC2WithImages struct {
+	*fakeEC2
+	images []*ec2.Image
+	calls  int
+	input  *ec2.DescribeImagesInput
+}
+
+func (f *fakeEC2WithImages) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	f.calls++
+	f.input = input
+	return &ec2.DescribeImagesOutput{Images: f.images}, nil
+}
+
+type fakeEC2Remove struct {
+	*fakeEC2
+	terminateErr error
+	snapshotErr  error
+	deletedKeys  []string
+	terminated   bool
+	stopped      bool
+	released     []string
+}
+
+func (f *fakeEC2Remove) DisassociateAddress(input *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+	return &ec2.DisassociateAddressOutput{}, nil
+}
+
+func (f *fakeEC2Remove) ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	f.released = append(f.released, *input.AllocationId)
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+func (f *fakeEC2Remove) CreateSnapshot(input *ec2.CreateSnapshotInput) (*ec2.Snapshot, error) {
+	if f.snapshotErr != nil {
+		return nil, f.snapshotErr
+	}
+	return &ec2.Snapshot{SnapshotId: aws.String("snap-12345"), VolumeId: input.VolumeId}, nil
+}
+
+func (f *fakeEC2Remove) StopInstances(input *ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error) {
+	f.stopped = true
+	return &ec2.StopInstancesOutput{}, nil
+}
+
+func (f *fakeEC2Remove) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *fakeEC2Remove) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	f.terminated = f.terminateErr == nil
+	return &ec2.TerminateInstancesOutput{}, f.terminateErr
+}
+
+func (f *fakeEC2Remove) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:     input.InstanceIds[0],
+			KeyName:        aws.String("machineFoo-abcde"),
+			RootDeviceName: aws.String("/dev/sda1"),
+			BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+				{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root")}},
+			},
+		}},
+	}}}, nil
+}
+
+func (f *fakeEC2Remove) DeleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+	f.deletedKeys = append(f.deletedKeys, *input.KeyName)
+	return &ec2.DeleteKeyPairOutput{}, nil
+}
+
+type fakeEC2WithVpcs struct {
+	*fakeEC2WithDescribe
+	vpcs  []*ec2.Vpc
+	input *ec2.DescribeVpcsInput
+}
+
+func (f *fakeEC2WithVpcs) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	f.input = input
+	return &ec2.DescribeVpcsOutput{Vpcs: f.vpcs}, nil
+}
+
+type fakeEC2InstanceTagRecorder struct {
+	*fakeEC2TagRecorder
+	instance *ec2.Instance
+}
+
+func (f *fakeEC2InstanceTagRecorder) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{f.instance},
+	}}}, nil
+}
+
+type fakeEC2WithSubnets struct {
+	*fakeEC2
+	subnets []*ec2.Subnet
+	input   *ec2.DescribeSubnetsInput
+}
+
+func (f *fakeEC2WithSubnets) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	f.input = input
+	return &ec2.DescribeSubnetsOutput{Subnets: f.subnets}, nil
+}
+
+type fakeLbu struct {
+	registered   []string
+	deregistered []string
+	healthCalls  int
+	drainedAfter int
+	deregErr     error
+}
+
+func (f *fakeLbu) RegisterInstancesWithLoadBalancer(input *elb.RegisterInstancesWithLoadBalancerInput) (*elb.RegisterInstancesWithLoadBalancerOutput, error) {
+	if *input.LoadBalancerName == "lbu-missing" {
+		return nil, awserr.New(elb.ErrCodeAccessPointNotFoundException, "There is no ACTIVE Load Balancer named 'lbu-missing'", nil)
+	}
+	f.registered = append(f.registered, *input.LoadBalancerName)
+	return &elb.RegisterInstancesWithLoadBalancerOutput{}, nil
+}
+
+func (f *fakeLbu) DeregisterInstancesFromLoadBalancer(input *elb.DeregisterInstancesFromLoadBalancerInput) (*elb.DeregisterInstancesFromLoadBalancerOutput, error) {
+	if f.deregErr != nil {
+		return nil, f.deregErr
+	}
+	f.deregistered = append(f.deregistered, *input.LoadBalancerName)
+	return &elb.DeregisterInstancesFromLoadBalancerOutput{}, nil
+}
+
+func (f *fakeLbu) DescribeInstanceHealth(input *elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error) {
+	f.healthCalls++
+	state := "InService"
+	if f.healthCalls > f.drainedAfter {
+		state = "OutOfService"
+	}
+	return &elb.DescribeInstanceHealthOutput{
+		InstanceStates: []*elb.InstanceState{{
+			InstanceId: input.Instances[0].InstanceId,
+			State:      aws.String(state),
+		}},
+	}, nil
+}
+
+type fakeEC2WithSecurityGroupPages struct {
+	*fakeEC2
+	pages  [][]*ec2.SecurityGroup
+	tokens []string
+}
+
+func (f *fakeEC2WithSecurityGroupPages) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	page := 0
+	if input.NextToken != nil {
+		fmt.Sscanf(*input.NextToken, "page-%d", &page)
+	}
+	f.tokens = append(f.tokens, aws.StringValue(input.NextToken))
+
+	output := &ec2.DescribeSecurityGroupsOutput{SecurityGroups: f.pages[page]}
+	if page+1 < len(f.pages) {
+		output.NextToken = aws.String(fmt.Sprintf("page-%d", page+1))
+	}
+	return output, nil
+}
+
+type fakeEC2Reassociate struct {
+	*fakeEC2WithInstances
+	associated []*ec2.AssociateAddressInput
+}
+
+func (f *fakeEC2Reassociate) AssociateAddress(input *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	f.associated = append(f.associated, input)
+	return &ec2.AssociateAddressOutput{AssociationId: aws.String("eipassoc-new")}, nil
+}
+
+type fakeEC2Allocate struct {
+	*fakeEC2Reassociate
+	allocated int
+}
+
+func (f *fakeEC2Allocate) AllocateAddress(input *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	f.allocated++
+	return &ec2.AllocateAddressOutput{AllocationId: aws.String("eipalloc-new"), PublicIp: aws.String("5.6.7.8")}, nil
+}
+
+func (f *fakeEC2Allocate) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+type fakeEC2WithNetworkInterfaces struct {
+	*fakeEC2
+	nics []*ec2.NetworkInterface
+}
+
+func (f *fakeEC2WithNetworkInterfaces) DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	return &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: f.nics}, nil
+}
+
+type fakeEC2CreateNet struct {
+	*fakeEC2
+	calls     []string
+	subnets   []*ec2.Subnet
+	instances []*ec2.Instance
+	// taken lists the blocks other drivers created subnets in meanwhile
+	taken []string
+	// named lists the Nets named default
+	named     []*ec2.Vpc
+	failRoute bool
+}
+
+func (f *fakeEC2CreateNet) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: f.instances}}}, nil
+}
+
+func (f *fakeEC2CreateNet) DeleteSubnet(input *ec2.DeleteSubnetInput) (*ec2.DeleteSubnetOutput, error) {
+	f.calls = append(f.calls, "DeleteSubnet "+*input.SubnetId)
+	return &ec2.DeleteSubnetOutput{}, nil
+}
+
+func (f *fakeEC2CreateNet) CreateVpc(input *ec2.CreateVpcInput) (*ec2.CreateVpcOutput, error) {
+	f.calls = append(f.calls, "CreateVpc "+*input.CidrBlock)
+	return &ec2.CreateVpcOutput{Vpc: &ec2.Vpc{VpcId: aws.String("vpc-new"), CidrBlock: input.CidrBlock}}, nil
+}
+
+func (f *fakeEC2CreateNet) CreateInternetGateway(input *ec2.CreateInternetGatewayInput) (*ec2.CreateInternetGatewayOutput, error) {
+	f.calls = append(f.calls, "CreateInternetGateway")
+	return &ec2.CreateInternetGatewayOutput{InternetGateway: &ec2.InternetGateway{InternetGatewayId: aws.String("igw-new")}}, nil
+}
+
+func (f *fakeEC2CreateNet) AttachInternetGateway(input *ec2.AttachInternetGatewayInput) (*ec2.AttachInternetGatewayOutput, error) {
+	f.calls = append(f.calls, "AttachInternetGateway "+*input.InternetGatewayId+" "+*input.VpcId)
+	return &ec2.AttachInternetGatewayOutput{}, nil
+}
+
+func (f *fakeEC2CreateNet) DescribeRouteTables(input *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	return &ec2.DescribeRouteTablesOutput{RouteTables: []*ec2.RouteTable{{RouteTableId: aws.String("rtb-main")}}}, nil
+}
+
+func (f *fakeEC2CreateNet) CreateRoute(input *ec2.CreateRouteInput) (*ec2.CreateRouteOutput, error) {
+	f.calls = append(f.calls, "CreateRoute "+*input.RouteTableId+" "+*input.DestinationCidrBlock+" "+*input.GatewayId)
+	if f.failRoute {
+		return nil, awserr.New("RouteAlreadyExists", "route already exists", nil)
+	}
+	return &ec2.CreateRouteOutput{Return: aws.Bool(true)}, nil
+}
+
+func (f *fakeEC2CreateNet) DetachInternetGateway(input *ec2.DetachInternetGatewayInput) (*ec2.DetachInternetGatewayOutput, error) {
+	f.calls = append(f.calls, "DetachInternetGateway "+*input.InternetGatewayId+" "+*input.VpcId)
+	return &ec2.DetachInternetGatewayOutput{}, nil
+}
+
+func (f *fakeEC2CreateNet) DeleteInternetGateway(input *ec2.DeleteInternetGatewayInput) (*ec2.DeleteInternetGatewayOutput, error) {
+	f.calls = append(f.calls, "DeleteInternetGateway "+*input.InternetGatewayId)
+	return &ec2.DeleteInternetGatewayOutput{}, nil
+}
+
+func (f *fakeEC2CreateNet) DeleteVpc(input *ec2.DeleteVpcInput) (*ec2.DeleteVpcOutput, error) {
+	f.calls = append(f.calls, "DeleteVpc "+*input.VpcId)
+	return &ec2.DeleteVpcOutput{}, nil
+}
+
+func (f *fakeEC2CreateNet) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	if len(input.Filters) > 0 {
+		return &ec2.DescribeVpcsOutput{Vpcs: f.named}, nil
+	}
+	return &ec2.DescribeVpcsOutput{Vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc-new"), CidrBlock: aws.String(defaultNetCIDR)}}}, nil
+}
+
+func (f *fakeEC2CreateNet) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return &ec2.DescribeSubnetsOutput{Subnets: f.subnets}, nil
+}
+
+func (f *fakeEC2CreateNet) CreateSubnet(input *ec2.CreateSubnetInput) (*ec2.CreateSubnetOutput, error) {
+	f.calls = append(f.calls, "CreateSubnet "+*input.CidrBlock+" "+*input.AvailabilityZone)
+	if stringInSlice(*input.CidrBlock, f.taken) {
+		return nil, awserr.New("InvalidSubnet.Conflict", "The CIDR '"+*input.CidrBlock+"' conflicts with another subnet", nil)
+	}
+	return &ec2.CreateSubnetOutput{Subnet: &ec2.Subnet{SubnetId: aws.String("subnet-new"), CidrBlock: input.CidrBlock}}, nil
+}
+
+func (f *fakeEC2CreateNet) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.calls = append(f.calls, "CreateTags "+*input.Resources[0])
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+type fakeEC2PoolRace struct {
+	*fakeEC2WithInstances
+	taken string
+}
+
+func (f *fakeEC2PoolRace) AssociateAddress(input *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	if aws.StringValue(input.AllocationId) == f.taken {
+		return nil, awserr.New("Resource.AlreadyAssociated", "already associated", nil)
+	}
+	return &ec2.AssociateAddressOutput{AssociationId: aws.String("eipassoc-12345")}, nil
+}
+
+type fakeEC2Unauthorized struct {
+	Ec2Client
+	code string
+}
+
+func (f *fakeEC2Unauthorized) DescribeAccountAttributes(input *ec2.DescribeAccountAttributesInput) (*ec2.DescribeAccountAttributesOutput, error) {
+	return nil, awserr.New(f.code, "denied", nil)
+}
+
+type fakeEC2WithPages struct {
+	*fakeEC2
+	subnets   [][]*ec2.Subnet
+	instances [][]*ec2.Instance
+}
+
+func pageOf(token *string) int {
+	page := 0
+	if token != nil {
+		fmt.Sscanf(*token, "page-%d", &page)
+	}
+	return page
+}
+
+func nextPageToken(page, pages int) *string {
+	if page+1 < pages {
+		return aws.String(fmt.Sprintf("page-%d", page+1))
+	}
+	return nil
+}
+
+func (f *fakeEC2WithPages) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	page := pageOf(input.NextToken)
+	return &ec2.DescribeSubnetsOutput{Subnets: f.subnets[page], NextToken: nextPageToken(page, len(f.subnets))}, nil
+}
+
+func (f *fakeEC2WithPages) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	page := pageOf(input.NextToken)
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: f.instances[page]}},
+		NextToken:    nextPageToken(page, len(f.instances)),
+	}, nil
+}
+
+type fakeEC2Capacity struct {
+	*fakeEC2
+	full     map[string]bool
+	launches []string
+}
+
+func (f *fakeEC2Capacity) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	f.launches = append(f.launches, *input.Placement.AvailabilityZone+"/"+*input.NetworkInterfaces[0].SubnetId)
+	if f.full[*input.Placement.AvailabilityZone] {
+		return nil, awserr.New("InsufficientInstanceCapacity", "insufficient capacity", nil)
+	}
+	return &ec2.Reservation{Instances: []*ec2.Instance{{InstanceId: aws.String("i-12345")}}}, nil
+}
+
+func (f *fakeEC2Capacity) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	zone := *input.Filters[0].Values[0]
+	return &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{{SubnetId: aws.String("subnet-" + zone)}}}, nil
+}
+
+type fakeEC2WithRouteTables struct {
+	*fakeEC2
+	subnetTables []*ec2.RouteTable
+	mainTables   []*ec2.RouteTable
+}
+
+func (f *fakeEC2WithRouteTables) DescribeRouteTables(input *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	if *input.Filters[0].Name == "association.subnet-id" {
+		return &ec2.DescribeRouteTablesOutput{RouteTables: f.subnetTables}, nil
+	}
+	return &ec2.DescribeRouteTablesOutput{RouteTables: f.mainTables}, nil
+}
+
+type fakeEC2DeleteSecurityGroup struct {
+	*fakeEC2
+	deleted []string
+}
+
+func (f *fakeEC2DeleteSecurityGroup) DeleteSecurityGroup(input *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+	f.deleted = append(f.deleted, *input.GroupId)
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+type fakeEC2EventualRules struct {
+	*fakeEC2
+	group     *ec2.SecurityGroup
+	describes int
+	visibleAt int
+}
+
+func (f *fakeEC2EventualRules) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	f.describes++
+	group := *f.group
+	if f.describes < f.visibleAt {
+		group.IpPermissions = nil
+	}
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{&group}}, nil
+}
@@ -0,0 +1,139 @@
+package outscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var invalidTerraformNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// TerraformResource is a resource created by the driver, with the import
+// command handing it over to the Outscale Terraform provider
+type TerraformResource struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Id     string `json:"id"`
+	Import string `json:"import"`
+}
+
+// TerraformExport lists the resources of a machine for a Terraform takeover.
+// The security groups the driver created for all the machines are listed
+// apart, without import commands: imported in the state of one machine, they
+// would be destroyed along with it while the other machines still use them.
+type TerraformExport struct {
+	Machine              string              `json:"machine"`
+	Resources            []TerraformResource `json:"resources"`
+	SharedSecurityGroups []string            `json:"sharedSecurityGroups,omitempty"`
+}
+
+// terraformName turns the machine name into a Terraform resource name,
+// which must start with a letter or an underscore
+func terraformName(machineName string) string {
+	name := invalidTerraformNameChars.ReplaceAllString(machineName, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') || name[0] == '-' {
+		name = "machine_" + name
+	}
+	return name
+}
+
+// terraformResources lists the resources the driver created for the
+// machine alone, the resources given to the driver (adopted VM,
+// --outscale-security-group-id or untagged security groups, existing key
+// pair or external IP, network interface) being left out.
+func (d *Driver) terraformResources() []TerraformResource {
+	name := terraformName(d.MachineName)
+	resources := []TerraformResource{}
+	add := func(resourceType, suffix, id string) {
+		address := resourceType + "." + name + suffix
+		resources = append(resources, TerraformResource{
+			Type:   resourceType,
+			Name:   name + suffix,
+			Id:     id,
+			Import: fmt.Sprintf("terraform import %s %s", address, id),
+		})
+	}
+
+	if d.InstanceId != "" && d.AdoptInstanceId == "" {
+		add("outscale_vm", "", d.InstanceId)
+	}
+	if d.AllocationId != "" && !d.ExistingPublicIp && d.PublicIpPool == "" {
+		add("outscale_public_ip", "", d.AllocationId)
+		if d.AssociationId != "" {
+			add("outscale_public_ip_link", "", d.AssociationId)
+		}
+	}
+	if d.MachineSecurityGroupId != "" && d.AdoptInstanceId == "" {
+		add("outscale_security_group", "", d.MachineSecurityGroupId)
+	}
+	if d.KeyName != "" && !d.ExistingKey {
+		add("outscale_keypair", "", d.KeyName)
+	}
+	if d.SubnetCreated {
+		add("outscale_subnet", "", d.SubnetId)
+	}
+	return resources
+}
+
+// sharedSecurityGroupIds returns the security groups of the machine the
+// driver created for all the machines, the ones carrying the management tag
+func (d *Driver) sharedSecurityGroupIds() ([]string, error) {
+	lookup := []string{}
+	for _, id := range d.securityGroupIds() {
+		if id != "" && id != d.MachineSecurityGroupId {
+			lookup = append(lookup, id)
+		}
+	}
+
+	managed := map[string]bool{}
+	if len(lookup) > 0 {
+		groups, err := d.describeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+			GroupIds: aws.StringSlice(lookup),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe the security groups of the machine: %s", err)
+		}
+		for _, group := range groups {
+			managed[aws.StringValue(group.GroupId)] = d.isManagedSecurityGroup(group)
+		}
+	}
+
+	ids := []string{}
+	for _, id := range lookup {
+		if managed[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// NewTerraformExport reads the config.json of a machine from the
+// docker-machine store and lists its resources, looking up its security
+// groups with the stored credentials
+func NewTerraformExport(config []byte) (*TerraformExport, error) {
+	d, err := loadMachineConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &TerraformExport{
+		Machine:   d.MachineName,
+		Resources: d.terraformResources(),
+	}
+	if d.AdoptInstanceId == "" {
+		shared, err := d.sharedSecurityGroupIds()
+		if err != nil {
+			return nil, err
+		}
+		export.SharedSecurityGroups = shared
+	}
+	return export, nil
+}
+
+// JSON renders the export as indented JSON
+func (e *TerraformExport) JSON() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
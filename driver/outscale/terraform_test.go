@@ -0,0 +1,84 @@
+package outscale
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTerraformName(t *testing.T) {
+	assert.Equal(t, "pool1-worker_1", terraformName("pool1-worker.1"))
+	assert.Equal(t, "machine_1node", terraformName("1node"))
+}
+
+func TestNewTerraformExport(t *testing.T) {
+	config := []byte(`{
+		"DriverName": "outscale",
+		"Driver": {
+			"MachineName": "node1",
+			"InstanceId": "i-12345",
+			"AllocationId": "eipalloc-1",
+			"AssociationId": "eipassoc-1",
+			"SecurityGroupIds": ["sg-1"],
+			"MachineSecurityGroupId": "sg-1",
+			"KeyName": "node1-abcde"
+		}
+	}`)
+
+	export, err := NewTerraformExport(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", export.Machine)
+	assert.Equal(t, []TerraformResource{
+		{Type: "outscale_vm", Name: "node1", Id: "i-12345", Import: "terraform import outscale_vm.node1 i-12345"},
+		{Type: "outscale_public_ip", Name: "node1", Id: "eipalloc-1", Import: "terraform import outscale_public_ip.node1 eipalloc-1"},
+		{Type: "outscale_public_ip_link", Name: "node1", Id: "eipassoc-1", Import: "terraform import outscale_public_ip_link.node1 eipassoc-1"},
+		{Type: "outscale_security_group", Name: "node1", Id: "sg-1", Import: "terraform import outscale_security_group.node1 sg-1"},
+		{Type: "outscale_keypair", Name: "node1", Id: "node1-abcde", Import: "terraform import outscale_keypair.node1 node1-abcde"},
+	}, export.Resources)
+}
+
+func TestNewTerraformExportSkipsExistingResources(t *testing.T) {
+	config := []byte(`{
+		"DriverName": "outscale",
+		"Driver": {
+			"MachineName": "node1",
+			"InstanceId": "i-12345",
+			"AdoptInstanceId": "i-12345",
+			"AllocationId": "eipalloc-1",
+			"ExistingPublicIp": true,
+			"KeyName": "terraform",
+			"ExistingKey": true
+		}
+	}`)
+
+	export, err := NewTerraformExport(config)
+	assert.NoError(t, err)
+	assert.Empty(t, export.Resources)
+}
+
+func TestTerraformResourcesManagedSecurityGroups(t *testing.T) {
+	client := &fakeEC2WithSecurityGroupPages{pages: [][]*ec2.SecurityGroup{{
+		{GroupId: aws.String("sg-nodes"), Tags: []*ec2.Tag{{Key: aws.String(machineTag), Value: aws.String("v1")}}},
+		{GroupId: aws.String("sg-user")},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.MachineName = "node1"
+	driver.SecurityGroupIds = []string{"sg-machine", "sg-nodes", "sg-user"}
+	driver.MachineSecurityGroupId = "sg-machine"
+
+	// only the group of the machine is imported
+	assert.Equal(t, []TerraformResource{
+		{Type: "outscale_security_group", Name: "node1", Id: "sg-machine", Import: "terraform import outscale_security_group.node1 sg-machine"},
+	}, driver.terraformResources())
+
+	shared, err := driver.sharedSecurityGroupIds()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sg-nodes"}, shared)
+}
+
+func TestNewTerraformExportOtherDriver(t *testing.T) {
+	_, err := NewTerraformExport([]byte(`{"DriverName": "amazonec2", "Driver": {}}`))
+	assert.EqualError(t, err, `machine uses the "amazonec2" driver, not outscale`)
+}
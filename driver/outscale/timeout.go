@@ -0,0 +1,56 @@
+package outscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// defaultAPITimeout bounds each API call, retries included, so that a hung
+// Outscale API fails the operation instead of blocking the caller forever
+const defaultAPITimeout = "60s"
+
+// parseAPITimeout parses --outscale-api-timeout, an empty value meaning the
+// default and 0 disabling the timeout
+func parseAPITimeout(value string) (time.Duration, error) {
+	if value == "" {
+		value = defaultAPITimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid --outscale-api-timeout %q, expected a duration like 60s", value)
+	}
+	return timeout, nil
+}
+
+// apiTimeout returns the timeout of an API call, validated by
+// SetConfigFromFlags
+func (d *Driver) apiTimeout() time.Duration {
+	timeout, _ := parseAPITimeout(d.APITimeout)
+	return timeout
+}
+
+// apiContext returns the context of an API call of the driver
+func (d *Driver) apiContext() (context.Context, context.CancelFunc) {
+	if timeout := d.apiTimeout(); timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// apiTimeoutHandler gives every SDK request a context bounded by
+// --outscale-api-timeout, released once the request completes
+func (d *Driver) apiTimeoutHandler() request.NamedHandler {
+	return request.NamedHandler{
+		Name: "outscale.APITimeoutHandler",
+		Fn: func(r *request.Request) {
+			ctx, cancel := d.apiContext()
+			r.SetContext(ctx)
+			r.Handlers.Complete.PushBack(func(*request.Request) {
+				cancel()
+			})
+		},
+	}
+}
@@ -0,0 +1,68 @@
+package outscale
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAPITimeout(t *testing.T) {
+	timeout, err := parseAPITimeout("")
+	assert.NoError(t, err)
+	assert.Equal(t, 60*time.Second, timeout)
+
+	timeout, err = parseAPITimeout("0")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), timeout)
+
+	_, err = parseAPITimeout("soon")
+	assert.EqualError(t, err, `invalid --outscale-api-timeout "soon", expected a duration like 60s`)
+}
+
+// newHungServer never answers before the test ends
+func newHungServer() (*httptest.Server, chan struct{}) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	return server, done
+}
+
+func TestAPITimeoutAbortsHungFCUCall(t *testing.T) {
+	server, done := newHungServer()
+	defer server.Close()
+	defer close(done)
+
+	driver := NewTestDriver()
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	driver.APITimeout = "100ms"
+	driver.RetryCount = 0
+	client := ec2.New(driver.newSession(server.URL))
+
+	start := time.Now()
+	_, err := client.DescribeInstances(&ec2.DescribeInstancesInput{})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestAPITimeoutAbortsHungOAPICall(t *testing.T) {
+	server, done := newHungServer()
+	defer server.Close()
+	defer close(done)
+
+	driver, client := newTestOAPIDriver(server)
+	driver.APITimeout = "100ms"
+	driver.RetryCount = 0
+
+	start := time.Now()
+	_, err := client.readVms([]string{"i-12345"})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
@@ -0,0 +1,116 @@
+package outscale
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const defaultMinTLSVersion = "1.2"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseMinTLSVersion parses --outscale-min-tls-version, an empty value
+// meaning the default
+func parseMinTLSVersion(value string) (uint16, error) {
+	if value == "" {
+		value = defaultMinTLSVersion
+	}
+	version, ok := tlsVersions[value]
+	if !ok {
+		return 0, fmt.Errorf("invalid --outscale-min-tls-version %q, expected 1.0, 1.1, 1.2 or 1.3", value)
+	}
+	return version, nil
+}
+
+// parseProxyURL parses the value of a proxy flag, empty meaning the proxy of
+// the environment
+func parseProxyURL(flag, value string) (*url.URL, error) {
+	if value == "" {
+		return nil, nil
+	}
+	proxy, err := url.Parse(value)
+	if err != nil || proxy.Host == "" || (proxy.Scheme != "http" && proxy.Scheme != "https") {
+		return nil, fmt.Errorf("invalid --%s %q, expected a URL like http://proxy.example.com:3128", flag, value)
+	}
+	return proxy, nil
+}
+
+// loadCABundle returns the system certificate pool with the certificates of
+// the --outscale-ca-bundle PEM file added, nil when there is none
+func loadCABundle(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --outscale-ca-bundle: %s", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("invalid --outscale-ca-bundle %q, no PEM certificate found", path)
+	}
+	return pool, nil
+}
+
+// validateTransport checks the TLS, proxy and CA bundle settings
+func (d *Driver) validateTransport() error {
+	if _, err := parseMinTLSVersion(d.MinTLSVersion); err != nil {
+		return err
+	}
+	if _, err := parseProxyURL("outscale-http-proxy", d.HTTPProxy); err != nil {
+		return err
+	}
+	if _, err := parseProxyURL("outscale-https-proxy", d.HTTPSProxy); err != nil {
+		return err
+	}
+	_, err := loadCABundle(d.CABundle)
+	return err
+}
+
+// httpTransport returns the transport of the API clients, enforcing the
+// minimum TLS version, going through the configured proxies, the ones of the
+// environment otherwise, and trusting the CA bundle of a TLS intercepting
+// proxy
+func (d *Driver) httpTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// validated by SetConfigFromFlags
+	httpProxy, _ := parseProxyURL("outscale-http-proxy", d.HTTPProxy)
+	httpsProxy, _ := parseProxyURL("outscale-https-proxy", d.HTTPSProxy)
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if req.URL.Scheme == "https" && httpsProxy != nil {
+			return httpsProxy, nil
+		}
+		if req.URL.Scheme == "http" && httpProxy != nil {
+			return httpProxy, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+
+	minVersion, _ := parseMinTLSVersion(d.MinTLSVersion)
+	transport.TLSClientConfig = &tls.Config{MinVersion: minVersion}
+	if pool, err := loadCABundle(d.CABundle); err != nil {
+		log.Warnf("Ignoring the CA bundle: %s", err)
+	} else {
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	return transport
+}
+
+func (d *Driver) httpClient() *http.Client {
+	return &http.Client{Transport: d.httpTransport()}
+}
@@ -0,0 +1,79 @@
+package outscale
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProxyURL(t *testing.T) {
+	proxy, err := parseProxyURL("outscale-https-proxy", "")
+	assert.NoError(t, err)
+	assert.Nil(t, proxy)
+
+	proxy, err = parseProxyURL("outscale-https-proxy", "http://proxy.example.com:3128")
+	assert.NoError(t, err)
+	assert.Equal(t, "proxy.example.com:3128", proxy.Host)
+
+	_, err = parseProxyURL("outscale-https-proxy", "proxy.example.com")
+	assert.EqualError(t, err, `invalid --outscale-https-proxy "proxy.example.com", expected a URL like http://proxy.example.com:3128`)
+}
+
+func TestHTTPTransportProxy(t *testing.T) {
+	driver := NewTestDriver()
+	driver.HTTPSProxy = "http://proxy.example.com:3128"
+	transport := driver.httpTransport()
+
+	req, _ := http.NewRequest("POST", "https://fcu.eu-west-2.outscale.com", nil)
+	proxy, err := transport.Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com:3128", proxy.String())
+}
+
+func TestHTTPClientTrustsCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "outscaleca")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	driver := NewTestDriver()
+	_, err = driver.httpClient().Get(server.URL)
+	assert.Error(t, err)
+
+	driver.CABundle = bundle
+	assert.NoError(t, driver.validateTransport())
+	resp, err := driver.httpClient().Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
+
+func TestValidateTransportRejectsInvalidCABundle(t *testing.T) {
+	driver := NewTestDriver()
+	driver.CABundle = "/nonexistent/ca.pem"
+	assert.Error(t, driver.validateTransport())
+}
+
+func TestParseMinTLSVersion(t *testing.T) {
+	version, err := parseMinTLSVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+
+	_, err = parseMinTLSVersion("1.4")
+	assert.EqualError(t, err, `invalid --outscale-min-tls-version "1.4", expected 1.0, 1.1, 1.2 or 1.3`)
+
+	driver := NewTestDriver()
+	driver.MinTLSVersion = "1.3"
+	assert.Equal(t, uint16(tls.VersionTLS13), driver.httpTransport().TLSClientConfig.MinVersion)
+}
@@ -0,0 +1,278 @@
+package outscale
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	userDataBoundary = "outscale-driver-boundary"
+	registryCAPath   = "/var/lib/outscale/registry-ca.crt"
+	daemonConfigPath = "/etc/docker/daemon.outscale.json"
+)
+
+// Options docker-machine already passes to dockerd as flags, dockerd refuses
+// to start when they are also set in daemon.json
+var provisionerDaemonFlags = map[string]string{
+	"hosts":     "--engine-opt",
+	"labels":    "--engine-label",
+	"tls":       "--engine-opt",
+	"tlscacert": "--engine-opt",
+	"tlscert":   "--engine-opt",
+	"tlskey":    "--engine-opt",
+	"tlsverify": "--engine-opt",
+}
+
+// registryHostnamePattern matches the DNS name of a registry, whose labels
+// are made of letters, digits and hyphens
+var registryHostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// storage-driver is always passed to dockerd by the provisioner, it is kept
+// out of daemon.json and has to match --engine-storage-driver
+const daemonStorageDriver = "storage-driver"
+
+// cloudInit collects the cloud-init configuration generated by the driver,
+// which is merged with the user provided --outscale-userdata.
+type cloudInit struct {
+	files    []cloudInitFile
+	commands []string
+}
+
+type cloudInitFile struct {
+	path        string
+	permissions string
+	content     []byte
+}
+
+func (c *cloudInit) addFile(path, permissions string, content []byte) {
+	c.files = append(c.files, cloudInitFile{path: path, permissions: permissions, content: content})
+}
+
+func (c *cloudInit) addCommand(command string) {
+	c.commands = append(c.commands, command)
+}
+
+func (c *cloudInit) empty() bool {
+	return len(c.files) == 0 && len(c.commands) == 0
+}
+
+// render outputs the #cloud-config document, quoting every value so that no
+// YAML library is needed.
+func (c *cloudInit) render() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#cloud-config\n")
+	if len(c.files) > 0 {
+		buf.WriteString("write_files:\n")
+		for _, f := range c.files {
+			fmt.Fprintf(&buf, "- path: %s\n", strconv.Quote(f.path))
+			fmt.Fprintf(&buf, "  permissions: %s\n", strconv.Quote(f.permissions))
+			buf.WriteString("  encoding: b64\n")
+			fmt.Fprintf(&buf, "  content: %s\n", base64.StdEncoding.EncodeToString(f.content))
+		}
+	}
+	if len(c.commands) > 0 {
+		buf.WriteString("runcmd:\n")
+		for _, command := range c.commands {
+			fmt.Fprintf(&buf, "- %s\n", strconv.Quote(command))
+		}
+	}
+	return buf.Bytes()
+}
+
+// userDataContentType guesses the cloud-init part type from the first line,
+// the same way cloud-init does for non multipart user data.
+func userDataContentType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("#cloud-config")):
+		return "text/cloud-config"
+	case bytes.HasPrefix(data, []byte("#!")):
+		return "text/x-shellscript"
+	case bytes.HasPrefix(data, []byte("#cloud-boothook")):
+		return "text/cloud-boothook"
+	case bytes.HasPrefix(data, []byte("#include")):
+		return "text/x-include-url"
+	default:
+		return "text/plain"
+	}
+}
+
+// mergeUserData combines the user data with the generated cloud-config in a
+// MIME multipart document. The generated part is merged last, appending to
+// the user lists rather than replacing them.
+func mergeUserData(user []byte, generated []byte) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.SetBoundary(userDataBoundary); err != nil {
+		return nil, err
+	}
+
+	userPart, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {userDataContentType(user) + `; charset="utf-8"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	userPart.Write(user)
+
+	generatedPart, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {`text/cloud-config; charset="utf-8"`},
+		"Merge-Type":   {"list(append)+dict(recurse_array)+str()"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	generatedPart.Write(generated)
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\n", userDataBoundary)
+	buf.WriteString("MIME-Version: 1.0\n\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// generateCloudInit builds the cloud-init configuration needed by the
+// driver options.
+func (d *Driver) generateCloudInit() (*cloudInit, error) {
+	c := &cloudInit{}
+
+	if err := d.configureRegistryCA(c); err != nil {
+		return nil, err
+	}
+
+	if err := d.configureDockerDaemon(c); err != nil {
+		return nil, err
+	}
+
+	if err := d.configureVolumes(c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// configureRegistryCA installs the private registry CA in the system trust
+// store, whichever of the Debian or RedHat layout the image uses, and in the
+// Docker certs.d directory of the registry.
+func (d *Driver) configureRegistryCA(c *cloudInit) error {
+	if d.RegistryCAFile == "" {
+		return nil
+	}
+
+	ca, err := ioutil.ReadFile(d.RegistryCAFile)
+	if err != nil {
+		return fmt.Errorf("unable to read --outscale-registry-ca-file %q: %s", d.RegistryCAFile, err)
+	}
+
+	c.addFile(registryCAPath, "0644", ca)
+	if d.RegistryHost != "" {
+		c.addFile("/etc/docker/certs.d/"+d.RegistryHost+"/ca.crt", "0644", ca)
+	}
+	c.addCommand(strings.Join([]string{
+		"if command -v update-ca-certificates >/dev/null 2>&1; then",
+		"cp " + registryCAPath + " /usr/local/share/ca-certificates/outscale-registry-ca.crt && update-ca-certificates;",
+		"elif command -v update-ca-trust >/dev/null 2>&1; then",
+		"cp " + registryCAPath + " /etc/pki/ca-trust/source/anchors/outscale-registry-ca.crt && update-ca-trust extract;",
+		"fi",
+	}, " "))
+
+	return nil
+}
+
+// validateRegistryHost checks that --outscale-registry-host is a host[:port],
+// since it names the certs.d directory the registry CA is written to
+func validateRegistryHost(host string) error {
+	if host == "" {
+		return nil
+	}
+
+	name := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid --outscale-registry-host %q, expected host[:port]", host)
+		}
+		name = h
+	}
+	if net.ParseIP(name) == nil && !registryHostnamePattern.MatchString(name) {
+		return fmt.Errorf("invalid --outscale-registry-host %q, expected host[:port]", host)
+	}
+	return nil
+}
+
+// validateDockerDaemonConfig checks that the --outscale-docker-daemon-config
+// snippet is a JSON object which does not conflict with the dockerd flags
+// set by docker-machine.
+func validateDockerDaemonConfig(config string, engineStorageDriver string) error {
+	if config == "" {
+		return nil
+	}
+
+	var options map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &options); err != nil {
+		return fmt.Errorf("invalid --outscale-docker-daemon-config, a JSON object is expected: %s", err)
+	}
+
+	if storageDriver, ok := options[daemonStorageDriver]; ok {
+		if name, _ := storageDriver.(string); name == "" || name != engineStorageDriver {
+			return fmt.Errorf("--outscale-docker-daemon-config storage-driver %v is passed to dockerd by docker-machine, create the machine with --engine-storage-driver %v", storageDriver, storageDriver)
+		}
+	}
+
+	conflicts := []string{}
+	for key := range options {
+		if flag, ok := provisionerDaemonFlags[key]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("%s (use %s)", key, flag))
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("--outscale-docker-daemon-config can't set options docker-machine passes to dockerd: %s", strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// configureDockerDaemon writes the daemon.json snippet, merging it into an
+// existing daemon.json when the image ships one. The existing file is left
+// untouched when python3 is not available to merge it.
+func (d *Driver) configureDockerDaemon(c *cloudInit) error {
+	if d.DockerDaemonConfig == "" {
+		return nil
+	}
+
+	config := []byte(d.DockerDaemonConfig)
+	var options map[string]interface{}
+	if err := json.Unmarshal(config, &options); err != nil {
+		return fmt.Errorf("invalid --outscale-docker-daemon-config, a JSON object is expected: %s", err)
+	}
+	if _, ok := options[daemonStorageDriver]; ok {
+		delete(options, daemonStorageDriver)
+		config, _ = json.Marshal(options)
+	}
+
+	c.addFile(daemonConfigPath, "0644", config)
+	c.addCommand(strings.Join([]string{
+		"mkdir -p /etc/docker;",
+		"if [ ! -s /etc/docker/daemon.json ]; then",
+		"cp " + daemonConfigPath + " /etc/docker/daemon.json;",
+		"elif command -v python3 >/dev/null 2>&1; then",
+		"python3 -c 'import json; c = json.load(open(\"/etc/docker/daemon.json\")); c.update(json.load(open(\"" + daemonConfigPath + "\"))); json.dump(c, open(\"/etc/docker/daemon.json\", \"w\"), indent=2)';",
+		"else echo \"python3 not found, " + daemonConfigPath + " not merged into the existing /etc/docker/daemon.json\" >&2;",
+		"fi",
+	}, " "))
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package outscale
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudInitRender(t *testing.T) {
+	c := &cloudInit{}
+	c.addFile("/etc/foo", "0600", []byte("bar"))
+	c.addCommand(`echo "done"`)
+
+	expected := "#cloud-config\n" +
+		"write_files:\n" +
+		"- path: \"/etc/foo\"\n" +
+		"  permissions: \"0600\"\n" +
+		"  encoding: b64\n" +
+		"  content: YmFy\n" +
+		"runcmd:\n" +
+		"- \"echo \\\"done\\\"\"\n"
+
+	assert.Equal(t, expected, string(c.render()))
+}
+
+func TestUserDataContentType(t *testing.T) {
+	assert.Equal(t, "text/cloud-config", userDataContentType([]byte("#cloud-config\nhostname: foo\n")))
+	assert.Equal(t, "text/x-shellscript", userDataContentType([]byte("#!/bin/sh\necho foo\n")))
+	assert.Equal(t, "text/plain", userDataContentType([]byte("foo")))
+}
+
+func TestMergeUserData(t *testing.T) {
+	merged, err := mergeUserData([]byte("#!/bin/sh\necho foo\n"), []byte("#cloud-config\nruncmd:\n- \"echo bar\"\n"))
+
+	assert.NoError(t, err)
+	content := string(merged)
+	assert.True(t, strings.HasPrefix(content, "Content-Type: multipart/mixed; boundary=\""+userDataBoundary+"\""))
+	assert.Contains(t, content, "Content-Type: text/x-shellscript")
+	assert.Contains(t, content, "echo foo")
+	assert.Contains(t, content, "Merge-Type: list(append)+dict(recurse_array)+str()")
+	assert.Contains(t, content, "echo bar")
+}
+
+func TestBase64UserDataWithRegistryCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outscaleuserdata")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caPath := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(caPath, []byte("CERTIFICATE"), 0644))
+
+	driver := NewTestDriver()
+	driver.RegistryCAFile = caPath
+	driver.RegistryHost = "registry.example.com:5000"
+
+	userdata, err := driver.Base64UserData()
+	assert.NoError(t, err)
+
+	decoded, err := base64.StdEncoding.DecodeString(userdata)
+	assert.NoError(t, err)
+	content := string(decoded)
+	assert.True(t, strings.HasPrefix(content, "#cloud-config\n"))
+	assert.Contains(t, content, registryCAPath)
+	assert.Contains(t, content, "/etc/docker/certs.d/registry.example.com:5000/ca.crt")
+	assert.Contains(t, content, base64.StdEncoding.EncodeToString([]byte("CERTIFICATE")))
+	assert.Contains(t, content, "update-ca-certificates")
+}
+
+func TestBase64UserDataWithMissingRegistryCA(t *testing.T) {
+	driver := NewTestDriver()
+	driver.RegistryCAFile = "/does/not/exist.pem"
+
+	_, err := driver.Base64UserData()
+
+	assert.Error(t, err)
+}
+
+func TestValidateRegistryHost(t *testing.T) {
+	assert.NoError(t, validateRegistryHost(""))
+	assert.NoError(t, validateRegistryHost("registry.example.com"))
+	assert.NoError(t, validateRegistryHost("registry.example.com:5000"))
+	assert.NoError(t, validateRegistryHost("10.0.0.4:5000"))
+	assert.NoError(t, validateRegistryHost("[2001:db8::4]:5000"))
+
+	assert.EqualError(t, validateRegistryHost("../../etc"), `invalid --outscale-registry-host "../../etc", expected host[:port]`)
+	assert.Error(t, validateRegistryHost("registry.example.com/ca"))
+	assert.Error(t, validateRegistryHost("registry.example.com:port"))
+	assert.Error(t, validateRegistryHost("registry..example.com"))
+}
+
+func TestValidateDockerDaemonConfig(t *testing.T) {
+	assert.NoError(t, validateDockerDaemonConfig("", ""))
+	assert.NoError(t, validateDockerDaemonConfig(`{"log-driver": "journald", "live-restore": true}`, ""))
+	assert.Error(t, validateDockerDaemonConfig(`["log-driver"]`, ""))
+	assert.Error(t, validateDockerDaemonConfig(`{"log-driver": `, ""))
+
+	err := validateDockerDaemonConfig(`{"labels": ["a=b"], "log-driver": "journald"}`, "")
+	assert.EqualError(t, err, "--outscale-docker-daemon-config can't set options docker-machine passes to dockerd: labels (use --engine-label)")
+
+	assert.NoError(t, validateDockerDaemonConfig(`{"storage-driver": "overlay2", "log-driver": "journald"}`, "overlay2"))
+	err = validateDockerDaemonConfig(`{"storage-driver": "overlay2", "log-driver": "journald"}`, "")
+	assert.EqualError(t, err, "--outscale-docker-daemon-config storage-driver overlay2 is passed to dockerd by docker-machine, create the machine with --engine-storage-driver overlay2")
+}
+
+func TestConfigureDockerDaemon(t *testing.T) {
+	driver := NewTestDriver()
+	driver.DockerDaemonConfig = `{"storage-driver": "overlay2", "log-driver": "journald"}`
+
+	c := &cloudInit{}
+	assert.NoError(t, driver.configureDockerDaemon(c))
+	assert.Equal(t, `{"log-driver":"journald"}`, string(c.files[0].content))
+	assert.Contains(t, c.commands[0], "if [ ! -s /etc/docker/daemon.json ]; then cp "+daemonConfigPath)
+	assert.Contains(t, c.commands[0], "elif command -v python3")
+	assert.NotContains(t, c.commands[0], "else cp")
+}
+
+func TestBase64UserDataWithDockerDaemonConfig(t *testing.T) {
+	driver := NewTestDriver()
+	driver.DockerDaemonConfig = `{"log-driver": "journald"}`
+
+	userdata, err := driver.Base64UserData()
+	assert.NoError(t, err)
+
+	decoded, err := base64.StdEncoding.DecodeString(userdata)
+	assert.NoError(t, err)
+	assert.Contains(t, string(decoded), daemonConfigPath)
+	assert.Contains(t, string(decoded), base64.StdEncoding.EncodeToString([]byte(driver.DockerDaemonConfig)))
+}
@@ -0,0 +1,7 @@
+package outscale
+
+// Version of the driver, can be overridden at build time with
+// -ldflags "-X github.com/acabrele/docker-machine-driver-outscale/driver/outscale.Version=x.y.z"
+var Version = "0.0.8"
+
+const userAgentName = "docker-machine-driver-outscale"
@@ -0,0 +1,333 @@
+package outscale
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// volumeTypeIO1 is the volume type with provisioned IOPS
+const volumeTypeIO1 = "io1"
+
+// BSU volume types
+var volumeTypes = map[string]bool{
+	"standard":    true,
+	"gp2":         true,
+	volumeTypeIO1: true,
+}
+
+// Filesystems the driver knows how to create on a data volume
+var volumeFilesystems = map[string]bool{
+	"ext4": true,
+	"xfs":  true,
+}
+
+var (
+	deviceNamePattern  = regexp.MustCompile(`^/dev/[a-z0-9]+$`)
+	virtualNamePattern = regexp.MustCompile(`^ephemeral[0-9]+$`)
+	// the mountpoint ends up in a root shell command
+	mountpointPattern = regexp.MustCompile(`^/[A-Za-z0-9/_.-]+$`)
+)
+
+// volumeSpec is a data volume attached at create time, declared with
+// --outscale-extra-volume device=/dev/xvdb,size=100[,type=gp2][,iops=1000][,snapshot=snap-...][,delete-on-termination=false][,fs=ext4,mount=/data]
+// or an instance store device, declared with
+// --outscale-ephemeral-device device=/dev/xvdc,name=ephemeral0[,fs=ext4,mount=/scratch].
+// OSDevice is the device seen by the instance when it is not named as in
+// the mapping, like local NVMe disks. KeepOnTermination keeps the volume
+// when the instance is terminated outside of the driver, Remove deleting it
+// anyway.
+type volumeSpec struct {
+	Device            string
+	VirtualName       string
+	OSDevice          string
+	Size              int64
+	Type              string
+	Iops              int64
+	SnapshotId        string
+	KeepOnTermination bool
+	Filesystem        string
+	Mountpoint        string
+}
+
+func parseVolumeSpec(value string) (*volumeSpec, error) {
+	return parseDeviceSpec("--outscale-extra-volume", value, false)
+}
+
+func parseEphemeralSpec(value string) (*volumeSpec, error) {
+	return parseDeviceSpec("--outscale-ephemeral-device", value, true)
+}
+
+func parseDeviceSpec(flag, value string, ephemeral bool) (*volumeSpec, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid %s %q, %s", flag, value, reason)
+	}
+
+	spec := &volumeSpec{}
+	expected := "expected device=DEVICE,size=GIB[,type=TYPE][,iops=IOPS][,snapshot=SNAPSHOT][,delete-on-termination=BOOL][,os-device=DEVICE][,fs=FS,mount=PATH]"
+	if ephemeral {
+		expected = "expected device=DEVICE,name=ephemeralN[,os-device=DEVICE][,fs=FS,mount=PATH]"
+	} else {
+		spec.Type = defaultVolumeType
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, invalid(expected)
+		}
+		switch {
+		case parts[0] == "device":
+			spec.Device = parts[1]
+		case parts[0] == "os-device":
+			spec.OSDevice = parts[1]
+		case parts[0] == "name" && ephemeral:
+			spec.VirtualName = parts[1]
+		case parts[0] == "size" && !ephemeral:
+			size, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || size <= 0 {
+				return nil, invalid("size must be a positive number of GiB")
+			}
+			spec.Size = size
+		case parts[0] == "type" && !ephemeral:
+			spec.Type = parts[1]
+		case parts[0] == "iops" && !ephemeral:
+			iops, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || iops <= 0 {
+				return nil, invalid("iops must be a positive number")
+			}
+			spec.Iops = iops
+		case parts[0] == "snapshot" && !ephemeral:
+			if !strings.HasPrefix(parts[1], "snap-") {
+				return nil, invalid("snapshot must be like snap-12345678")
+			}
+			spec.SnapshotId = parts[1]
+		case parts[0] == "delete-on-termination" && !ephemeral:
+			deleteOnTermination, err := strconv.ParseBool(parts[1])
+			if err != nil {
+				return nil, invalid("delete-on-termination must be true or false")
+			}
+			spec.KeepOnTermination = !deleteOnTermination
+		case parts[0] == "fs":
+			spec.Filesystem = parts[1]
+		case parts[0] == "mount":
+			spec.Mountpoint = parts[1]
+		default:
+			return nil, invalid("unknown key " + parts[0])
+		}
+	}
+
+	if !deviceNamePattern.MatchString(spec.Device) {
+		return nil, invalid("device must be like /dev/xvdb")
+	}
+	if spec.OSDevice == "" {
+		spec.OSDevice = spec.Device
+	} else if !deviceNamePattern.MatchString(spec.OSDevice) {
+		return nil, invalid("os-device must be like /dev/nvme1n1")
+	}
+	if ephemeral && !virtualNamePattern.MatchString(spec.VirtualName) {
+		return nil, invalid("name must be like ephemeral0")
+	}
+	if !ephemeral && spec.Size == 0 && spec.SnapshotId == "" {
+		return nil, invalid("size is required without snapshot")
+	}
+	if !ephemeral && !volumeTypes[spec.Type] {
+		return nil, invalid("type must be standard, gp2 or " + volumeTypeIO1)
+	}
+	if spec.Iops > 0 && spec.Type != volumeTypeIO1 {
+		return nil, invalid("iops requires type=" + volumeTypeIO1)
+	}
+	if spec.Mountpoint != "" {
+		if spec.Filesystem == "" {
+			spec.Filesystem = "ext4"
+		}
+		if !mountpointPattern.MatchString(spec.Mountpoint) {
+			return nil, invalid("mount must be an absolute path of letters, digits, /, _, . and -")
+		}
+	} else if spec.Filesystem != "" {
+		return nil, invalid("fs requires mount")
+	}
+	if spec.Filesystem != "" && !volumeFilesystems[spec.Filesystem] {
+		return nil, invalid("fs must be ext4 or xfs")
+	}
+
+	return spec, nil
+}
+
+// extraVolumes returns the data volumes followed by the instance store
+// devices
+func (d *Driver) extraVolumes() ([]*volumeSpec, error) {
+	specs := []*volumeSpec{}
+	devices := map[string]bool{}
+	add := func(flag string, values []string, parse func(string) (*volumeSpec, error)) error {
+		for _, value := range values {
+			spec, err := parse(value)
+			if err != nil {
+				return err
+			}
+			if devices[spec.Device] || spec.Device == d.DeviceName {
+				return fmt.Errorf("invalid %s %q, device %s is already used", flag, value, spec.Device)
+			}
+			if spec.Type == volumeTypeIO1 && spec.Iops == 0 {
+				spec.Iops = d.VolumeIops
+			}
+			devices[spec.Device] = true
+			specs = append(specs, spec)
+		}
+		return nil
+	}
+
+	if err := add("--outscale-extra-volume", d.ExtraVolumes, parseVolumeSpec); err != nil {
+		return nil, err
+	}
+	if err := add("--outscale-ephemeral-device", d.EphemeralDevices, parseEphemeralSpec); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// validateVolumeIops rejects an --outscale-volume-iops no io1 volume uses
+func (d *Driver) validateVolumeIops() error {
+	if d.VolumeIops == 0 {
+		return nil
+	}
+	if d.VolumeIops < 0 {
+		return fmt.Errorf("invalid --outscale-volume-iops %d, expected a positive number", d.VolumeIops)
+	}
+	if d.VolumeType == volumeTypeIO1 {
+		return nil
+	}
+	// validated by SetConfigFromFlags
+	specs, _ := d.extraVolumes()
+	for _, spec := range specs {
+		if spec.Type == volumeTypeIO1 {
+			return nil
+		}
+	}
+	return fmt.Errorf("--outscale-volume-iops requires --outscale-volume-type %s or an %s extra volume", volumeTypeIO1, volumeTypeIO1)
+}
+
+func (s *volumeSpec) blockDeviceMapping() *ec2.BlockDeviceMapping {
+	if s.VirtualName != "" {
+		return &ec2.BlockDeviceMapping{
+			DeviceName:  aws.String(s.Device),
+			VirtualName: aws.String(s.VirtualName),
+		}
+	}
+	ebs := &ec2.EbsBlockDevice{
+		VolumeType:          aws.String(s.Type),
+		DeleteOnTermination: aws.Bool(!s.KeepOnTermination),
+	}
+	// the size of the snapshot unless given
+	if s.Size > 0 {
+		ebs.VolumeSize = aws.Int64(s.Size)
+	}
+	if s.Iops > 0 {
+		ebs.Iops = aws.Int64(s.Iops)
+	}
+	if s.SnapshotId != "" {
+		ebs.SnapshotId = aws.String(s.SnapshotId)
+	}
+	return &ec2.BlockDeviceMapping{
+		DeviceName: aws.String(s.Device),
+		Ebs:        ebs,
+	}
+}
+
+// recordExtraVolumes records in ExtraVolumeIds the IDs of the data volumes
+// mapped at launch, for Remove to delete them, in RetainedVolumeIds those
+// kept on termination, and tags them like the root volume
+func (d *Driver) recordExtraVolumes() {
+	specs, _ := d.extraVolumes()
+	devices := map[string]bool{}
+	for _, spec := range specs {
+		if spec.VirtualName == "" {
+			devices[spec.Device] = true
+		}
+	}
+	if len(devices) == 0 {
+		return
+	}
+
+	inst, err := d.getInstance()
+	if err != nil {
+		log.Warnf("Unable to look up the data volumes of %s: %s", d.InstanceId, err)
+		return
+	}
+	for _, bdm := range inst.BlockDeviceMappings {
+		if !devices[aws.StringValue(bdm.DeviceName)] || bdm.Ebs == nil {
+			continue
+		}
+		volumeId := aws.StringValue(bdm.Ebs.VolumeId)
+		if aws.BoolValue(bdm.Ebs.DeleteOnTermination) {
+			d.ExtraVolumeIds = appendUnique(d.ExtraVolumeIds, volumeId)
+		} else {
+			d.RetainedVolumeIds = appendUnique(d.RetainedVolumeIds, volumeId)
+		}
+		d.tagResource(volumeId)
+	}
+}
+
+// removeExtraVolumes deletes the data volumes once the instance is gone,
+// those deleted on termination being already gone, and keeps the ones
+// created with delete-on-termination=false
+func (d *Driver) removeExtraVolumes(report *removeReport, instanceGone bool) {
+	for _, id := range d.RetainedVolumeIds {
+		report.kept("volume", id, "delete-on-termination is false")
+	}
+	for _, id := range d.ExtraVolumeIds {
+		if !instanceGone {
+			report.kept("volume", id, "attached to the kept instance")
+			continue
+		}
+		report.done("volume", id, d.deleteVolume(id))
+	}
+}
+
+// deleteVolume deletes the volume, waiting for the terminated instance to
+// release it
+func (d *Driver) deleteVolume(id string) error {
+	err := waitFor("deletion of volume "+id, func() (bool, error) {
+		_, err := d.getAPI().DeleteVolume(&ec2.DeleteVolumeInput{
+			VolumeId: aws.String(id),
+		})
+		if err != nil && !strings.HasPrefix(err.Error(), "InvalidVolume.NotFound") {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to delete volume: %s", err)
+	}
+	return nil
+}
+
+// configureVolumes formats the volumes that have a mountpoint and adds them
+// to fstab. A volume that already has a filesystem is not formatted again,
+// and nofail keeps the instance booting without it, which matters for
+// instance store devices that come back blank after a stop.
+func (d *Driver) configureVolumes(c *cloudInit) error {
+	specs, err := d.extraVolumes()
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		if spec.Mountpoint == "" {
+			continue
+		}
+		device := spec.OSDevice
+		fstab := fmt.Sprintf("%s %s %s defaults,nofail 0 2", device, spec.Mountpoint, spec.Filesystem)
+		c.addCommand(strings.Join([]string{
+			"blkid " + device + " >/dev/null 2>&1 || mkfs -t " + spec.Filesystem + " " + device + ";",
+			"mkdir -p " + spec.Mountpoint + ";",
+			"grep -q '^" + device + " ' /etc/fstab || echo '" + fstab + "' >> /etc/fstab;",
+			"mount " + spec.Mountpoint,
+		}, " "))
+	}
+	return nil
+}
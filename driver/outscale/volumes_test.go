@@ -0,0 +1,181 @@
+package outscale
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVolumeSpec(t *testing.T) {
+	spec, err := parseVolumeSpec("device=/dev/xvdb,size=100")
+	assert.NoError(t, err)
+	assert.Equal(t, &volumeSpec{Device: "/dev/xvdb", OSDevice: "/dev/xvdb", Size: 100, Type: defaultVolumeType}, spec)
+
+	spec, err = parseVolumeSpec("device=/dev/xvdc,size=50,type=io1,mount=/var/lib/containerd")
+	assert.NoError(t, err)
+	assert.Equal(t, "io1", spec.Type)
+	assert.Equal(t, "ext4", spec.Filesystem)
+	assert.Equal(t, "/var/lib/containerd", spec.Mountpoint)
+
+	for _, value := range []string{
+		"size=100",
+		"device=/dev/xvdb",
+		"device=/dev/xvdb,size=0",
+		"device=xvdb,size=10",
+		"device=/dev/xvdb,size=10,fs=xfs",
+		"device=/dev/xvdb,size=10,fs=ntfs,mount=/data",
+		"device=/dev/xvdb,size=10,mount=data",
+		"device=/dev/xvdb,size=10,mount=/data;reboot",
+		"device=/dev/xvdb,size=10,mount=/$(id)",
+		"device=/dev/xvdb,size=10,type=ssd",
+		"device=/dev/xvdb,size=10,iops=100",
+		"device=/dev/xvdb,snapshot=vol-12345",
+		"device=/dev/xvdb,size=10,delete-on-termination=maybe",
+	} {
+		_, err := parseVolumeSpec(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestExtraVolumesFromSnapshot(t *testing.T) {
+	driver := NewTestDriver()
+	driver.ExtraVolumes = []string{"device=/dev/xvdb,snapshot=snap-12345,delete-on-termination=false"}
+
+	bdmList := driver.updateBDMList()
+
+	assert.Len(t, bdmList, 1)
+	assert.Equal(t, "snap-12345", *bdmList[0].Ebs.SnapshotId)
+	assert.Nil(t, bdmList[0].Ebs.VolumeSize)
+	assert.False(t, *bdmList[0].Ebs.DeleteOnTermination)
+}
+
+func TestRemoveExtraVolumesKeptWithInstance(t *testing.T) {
+	driver := NewTestDriver()
+	driver.ExtraVolumeIds = []string{"vol-12345"}
+
+	report := &removeReport{}
+	driver.removeExtraVolumes(report, false)
+	assert.Equal(t, []cleanupResult{{Resource: "volume", Id: "vol-12345", Status: cleanupKept, Reason: "attached to the kept instance"}}, report.Results)
+}
+
+func TestRemoveExtraVolumesKeepsRetained(t *testing.T) {
+	driver := NewTestDriver()
+	driver.RetainedVolumeIds = []string{"vol-retained"}
+
+	report := &removeReport{}
+	driver.removeExtraVolumes(report, true)
+	assert.Equal(t, []cleanupResult{{Resource: "volume", Id: "vol-retained", Status: cleanupKept, Reason: "delete-on-termination is false"}}, report.Results)
+}
+
+func TestExtraVolumesRejectsDuplicateDevices(t *testing.T) {
+	driver := NewTestDriver()
+	driver.DeviceName = "/dev/sda1"
+	driver.ExtraVolumes = []string{"device=/dev/xvdb,size=10", "device=/dev/xvdb,size=20"}
+
+	_, err := driver.extraVolumes()
+	assert.EqualError(t, err, `invalid --outscale-extra-volume "device=/dev/xvdb,size=20", device /dev/xvdb is already used`)
+}
+
+func TestExtraVolumesBlockDeviceMappings(t *testing.T) {
+	driver := NewTestDriver()
+	driver.ExtraVolumes = []string{"device=/dev/xvdb,size=10,type=io1"}
+
+	bdmList := driver.updateBDMList()
+
+	assert.Len(t, bdmList, 1)
+	assert.Equal(t, "/dev/xvdb", *bdmList[0].DeviceName)
+	assert.Equal(t, int64(10), *bdmList[0].Ebs.VolumeSize)
+	assert.Equal(t, "io1", *bdmList[0].Ebs.VolumeType)
+	assert.True(t, *bdmList[0].Ebs.DeleteOnTermination)
+}
+
+func TestConfigureVolumes(t *testing.T) {
+	driver := NewTestDriver()
+	driver.ExtraVolumes = []string{
+		"device=/dev/xvdb,size=10",
+		"device=/dev/xvdc,size=100,fs=xfs,mount=/var/lib/containerd",
+	}
+
+	c := &cloudInit{}
+	assert.NoError(t, driver.configureVolumes(c))
+
+	assert.Equal(t, []string{
+		"blkid /dev/xvdc >/dev/null 2>&1 || mkfs -t xfs /dev/xvdc; " +
+			"mkdir -p /var/lib/containerd; " +
+			"grep -q '^/dev/xvdc ' /etc/fstab || echo '/dev/xvdc /var/lib/containerd xfs defaults,nofail 0 2' >> /etc/fstab; " +
+			"mount /var/lib/containerd",
+	}, c.commands)
+}
+
+func TestParseEphemeralSpec(t *testing.T) {
+	spec, err := parseEphemeralSpec("device=/dev/xvdc,name=ephemeral0,os-device=/dev/nvme1n1,mount=/scratch")
+	assert.NoError(t, err)
+	assert.Equal(t, &volumeSpec{Device: "/dev/xvdc", VirtualName: "ephemeral0", OSDevice: "/dev/nvme1n1", Filesystem: "ext4", Mountpoint: "/scratch"}, spec)
+
+	for _, value := range []string{
+		"device=/dev/xvdc",
+		"device=/dev/xvdc,name=scratch",
+		"device=/dev/xvdc,name=ephemeral0,size=10",
+	} {
+		_, err := parseEphemeralSpec(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestEphemeralDevices(t *testing.T) {
+	driver := NewTestDriver()
+	driver.ExtraVolumes = []string{"device=/dev/xvdb,size=10"}
+	driver.EphemeralDevices = []string{"device=/dev/xvdc,name=ephemeral0,os-device=/dev/nvme1n1,fs=xfs,mount=/scratch"}
+
+	bdmList := driver.updateBDMList()
+	assert.Len(t, bdmList, 2)
+	assert.Equal(t, "/dev/xvdc", *bdmList[1].DeviceName)
+	assert.Equal(t, "ephemeral0", *bdmList[1].VirtualName)
+	assert.Nil(t, bdmList[1].Ebs)
+
+	c := &cloudInit{}
+	assert.NoError(t, driver.configureVolumes(c))
+	assert.Len(t, c.commands, 1)
+	assert.Contains(t, c.commands[0], "mkfs -t xfs /dev/nvme1n1;")
+
+	driver.EphemeralDevices = []string{"device=/dev/xvdb,name=ephemeral0"}
+	_, err := driver.extraVolumes()
+	assert.EqualError(t, err, `invalid --outscale-ephemeral-device "device=/dev/xvdb,name=ephemeral0", device /dev/xvdb is already used`)
+}
+
+func TestVolumeIops(t *testing.T) {
+	driver := NewTestDriver()
+	driver.DeviceName = "/dev/sda1"
+	driver.VolumeType = volumeTypeIO1
+	driver.VolumeIops = 3000
+	driver.bdmList = []*ec2.BlockDeviceMapping{{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2.EbsBlockDevice{}}}
+	driver.ExtraVolumes = []string{
+		"device=/dev/xvdb,size=100,type=io1",
+		"device=/dev/xvdc,size=100,type=io1,iops=5000",
+		"device=/dev/xvdd,size=10",
+	}
+	assert.NoError(t, driver.validateVolumeIops())
+
+	bdmList := driver.updateBDMList()
+
+	assert.Len(t, bdmList, 4)
+	assert.Equal(t, int64(3000), *bdmList[0].Ebs.Iops)
+	assert.Equal(t, int64(3000), *bdmList[1].Ebs.Iops)
+	assert.Equal(t, int64(5000), *bdmList[2].Ebs.Iops)
+	assert.Nil(t, bdmList[3].Ebs.Iops)
+}
+
+func TestValidateVolumeIops(t *testing.T) {
+	driver := NewTestDriver()
+	driver.VolumeType = defaultVolumeType
+	driver.VolumeIops = 3000
+	assert.EqualError(t, driver.validateVolumeIops(), "--outscale-volume-iops requires --outscale-volume-type io1 or an io1 extra volume")
+
+	driver.ExtraVolumes = []string{"device=/dev/xvdb,size=100,type=io1"}
+	assert.NoError(t, driver.validateVolumeIops())
+
+	driver.VolumeIops = -1
+	assert.EqualError(t, driver.validateVolumeIops(), "invalid --outscale-volume-iops -1, expected a positive number")
+}
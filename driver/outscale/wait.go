@@ -0,0 +1,95 @@
+package outscale
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Same defaults as mcnutils.WaitFor
+const (
+	waitAttempts = 60
+	waitInterval = 3 * time.Second
+)
+
+// fatalAPIErrorCodes are the API errors that waiting longer can't fix
+var fatalAPIErrorCodes = map[string]bool{
+	"AuthFailure":                 true,
+	"UnauthorizedOperation":       true,
+	"OptInRequired":               true,
+	"InvalidInstanceID.Malformed": true,
+	"InvalidGroupId.Malformed":    true,
+	"InvalidParameterValue":       true,
+	"InvalidParameterCombination": true,
+	"SignatureDoesNotMatch":       true,
+	"InvalidClientTokenId":        true,
+	"MissingAuthenticationToken":  true,
+	"IncompleteSignature":         true,
+	"InvalidAccessKeyId":          true,
+	"RequestExpired":              true,
+	"UnsupportedOperation":        true,
+	"InvalidAction":               true,
+}
+
+// waitError is returned by a wait condition to abort the wait right away
+type waitError struct {
+	err error
+}
+
+func (e *waitError) Error() string {
+	return e.err.Error()
+}
+
+// fatal marks err as not worth waiting for
+func fatal(err error) error {
+	return &waitError{err: err}
+}
+
+func isFatal(err error) bool {
+	if _, ok := err.(*waitError); ok {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return fatalAPIErrorCodes[awsErr.Code()]
+	}
+	if apiErr, ok := err.(*oapiError); ok {
+		return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// waitFor calls condition until it is done, like mcnutils.WaitFor, but
+// aborts on fatal errors and reports the last error on timeout instead of
+// a generic message. A condition returning an error that is not fatal is
+// retried.
+func waitFor(what string, condition func() (bool, error)) error {
+	return waitForSpecific(what, condition, waitAttempts, waitInterval)
+}
+
+func waitForSpecific(what string, condition func() (bool, error), attempts int, interval time.Duration) error {
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		done, err := condition()
+		if done {
+			return nil
+		}
+		if err != nil {
+			if isFatal(err) {
+				return fmt.Errorf("Error waiting for %s: %s", what, err)
+			}
+			log.Debugf("waiting for %s: %s", what, err)
+			lastErr = err
+		}
+		if i < attempts-1 {
+			time.Sleep(interval)
+		}
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("Timed out waiting for %s, last error: %s", what, lastErr)
+	}
+	return fmt.Errorf("Timed out waiting for %s after %d attempts", what, attempts)
+}
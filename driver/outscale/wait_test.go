@@ -0,0 +1,64 @@
+package outscale
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForRetriesTransientErrors(t *testing.T) {
+	calls := 0
+	err := waitForSpecific("test", func() (bool, error) {
+		calls++
+		if calls < 3 {
+			return false, errors.New("not found yet")
+		}
+		return true, nil
+	}, 5, time.Millisecond)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestWaitForAbortsOnFatalErrors(t *testing.T) {
+	calls := 0
+	err := waitForSpecific("test", func() (bool, error) {
+		calls++
+		return false, awserr.New("AuthFailure", "bad credentials", nil)
+	}, 5, time.Millisecond)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad credentials")
+	assert.Equal(t, 1, calls)
+}
+
+func TestWaitForReportsLastError(t *testing.T) {
+	err := waitForSpecific("test", func() (bool, error) {
+		return false, errors.New("still pending")
+	}, 2, time.Millisecond)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "still pending")
+}
+
+func TestInstanceIsRunningFailsOnTerminated(t *testing.T) {
+	client := &fakeEC2WithInstances{reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:  aws.String("i-12345"),
+			State:       &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameTerminated)},
+			StateReason: &ec2.StateReason{Message: aws.String("Server.InsufficientInstanceCapacity")},
+		}},
+	}}}
+	driver := NewCustomTestDriver(client)
+	driver.InstanceId = "i-12345"
+
+	running, err := driver.instanceIsRunning()
+	assert.False(t, running)
+	assert.True(t, isFatal(err))
+	assert.Contains(t, err.Error(), "InsufficientInstanceCapacity")
+}
@@ -0,0 +1,76 @@
+package outscale
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// parseZones splits the comma separated --outscale-zones list, dropping
+// blanks and duplicates
+func parseZones(value string) (zones []string) {
+	for _, zone := range strings.Split(value, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = appendUnique(zones, zone)
+		}
+	}
+	return
+}
+
+// isInsufficientCapacity reports whether a launch failed because the zone
+// has run out of capacity for the instance type
+func isInsufficientCapacity(err error) bool {
+	return strings.HasPrefix(err.Error(), "InsufficientInstanceCapacity") ||
+		strings.HasPrefix(err.Error(), "InsufficientCapacity")
+}
+
+// failoverZones lists the zones to try the launch in, --outscale-zone first
+// then the --outscale-zones ones
+func (d *Driver) failoverZones() []string {
+	zones := []string{d.Zone}
+	for _, zone := range d.Zones {
+		zones = appendUnique(zones, zone)
+	}
+	return zones
+}
+
+// moveToZone switches the machine to another zone, along with the subnet it
+// launches in
+func (d *Driver) moveToZone(zone string) error {
+	d.Zone = zone
+	d.SubnetId = ""
+	if d.SubnetMap != "" {
+		subnetId, err := d.subnetForZone(zone)
+		if err != nil {
+			return err
+		}
+		d.SubnetId = subnetId
+	}
+	return d.checkSubnet()
+}
+
+// runInstance launches the instance, moving on to the next --outscale-zones
+// zone while the current one is out of capacity
+func (d *Driver) runInstance(input func() *ec2.RunInstancesInput) (*ec2.Instance, error) {
+	zones := d.failoverZones()
+	for i := range zones {
+		if zones[i] != d.Zone {
+			if err := d.moveToZone(zones[i]); err != nil {
+				return nil, fmt.Errorf("unable to launch in zone %s: %s", zones[i], err)
+			}
+			log.Infof("Retrying the launch in zone %s, subnet %s", d.getRegionZone(), d.SubnetId)
+		}
+
+		reservation, err := d.getAPI().RunInstances(input())
+		if err == nil {
+			return reservation.Instances[0], nil
+		}
+		if !isInsufficientCapacity(err) || i == len(zones)-1 {
+			return nil, err
+		}
+		log.Warnf("Zone %s is out of capacity for %s: %s", d.getRegionZone(), d.InstanceType, err)
+	}
+	return nil, fmt.Errorf("no zone to launch the instance in")
+}
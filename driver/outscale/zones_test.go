@@ -0,0 +1,75 @@
+package outscale
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/docker/machine/commands/commandstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseZones(t *testing.T) {
+	assert.Equal(t, []string{"b", "c"}, parseZones(" b,,c,b "))
+	assert.Empty(t, parseZones(""))
+}
+
+func TestRunInstanceFailsOverZones(t *testing.T) {
+	client := &fakeEC2Capacity{full: map[string]bool{"us-east-2a": true, "us-east-2b": true}}
+	driver := NewCustomTestDriver(client)
+	driver.Region = "us-east-2"
+	driver.Zone = "a"
+	driver.Zones = []string{"b", "c"}
+	driver.VpcId = "vpc-1"
+	driver.SubnetId = "subnet-us-east-2a"
+
+	inst, err := driver.runInstance(func() *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			Placement:         &ec2.Placement{AvailabilityZone: aws.String(driver.getRegionZone())},
+			NetworkInterfaces: driver.networkInterfaceSpecs(),
+		}
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "i-12345", *inst.InstanceId)
+	assert.Equal(t, []string{"us-east-2a/subnet-us-east-2a", "us-east-2b/subnet-us-east-2b", "us-east-2c/subnet-us-east-2c"}, client.launches)
+	assert.Equal(t, "c", driver.Zone)
+	assert.Equal(t, "subnet-us-east-2c", driver.SubnetId)
+}
+
+func TestRunInstanceOutOfZones(t *testing.T) {
+	client := &fakeEC2Capacity{full: map[string]bool{"us-east-2a": true, "us-east-2b": true}}
+	driver := NewCustomTestDriver(client)
+	driver.Region = "us-east-2"
+	driver.Zone = "a"
+	driver.Zones = []string{"b"}
+	driver.VpcId = "vpc-1"
+	driver.SubnetMap = "a=subnet-111,b=subnet-222"
+	driver.SubnetId = "subnet-111"
+
+	_, err := driver.runInstance(func() *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			Placement:         &ec2.Placement{AvailabilityZone: aws.String(driver.getRegionZone())},
+			NetworkInterfaces: driver.networkInterfaceSpecs(),
+		}
+	})
+	assert.Error(t, err)
+	assert.True(t, isInsufficientCapacity(err))
+	assert.Equal(t, []string{"us-east-2a/subnet-111", "us-east-2b/subnet-222"}, client.launches)
+}
+
+func TestSetConfigFromFlagsZonesWithSubnetId(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"outscale-allowed-cidr": []string{ipRange},
+			"name":                  "test",
+			"outscale-region":       "us-east-2",
+			"outscale-zone":         "a",
+			"outscale-zones":        "b,c",
+			"outscale-subnet-id":    "subnet-1",
+		},
+	}
+
+	assert.Equal(t, errorZonesWithSubnet, driver.SetConfigFromFlags(options))
+}